@@ -0,0 +1,113 @@
+// Copyright (c) OpenFaaS Author(s) 2026. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// openAccessLog gives the writer for access_log, which is either "stdout",
+// "stderr" or the path of a file to append to.
+func openAccessLog(target string) (io.Writer, error) {
+	switch target {
+	case "stdout":
+		return os.Stdout, nil
+	case "stderr":
+		return os.Stderr, nil
+	}
+
+	return os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+}
+
+// makeAccessLogHandler writes a line in the Apache Combined Log Format for
+// each request, followed by the duration of the request in seconds.
+func makeAccessLogHandler(out io.Writer, next http.Handler) http.Handler {
+	var lock sync.Mutex
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &accessLogRecorder{ResponseWriter: w, status: http.StatusOK}
+
+		next.ServeHTTP(rec, r)
+
+		line := formatAccessLog(r, rec.status, rec.bytes, start, time.Since(start))
+
+		lock.Lock()
+		io.WriteString(out, line)
+		lock.Unlock()
+	})
+}
+
+// formatAccessLog gives a Combined Log Format line i.e.
+// 10.0.0.1 - - [10/Oct/2026:13:55:36 +0000] "POST / HTTP/1.1" 200 2326 "-" "curl/8.0" 0.012345
+func formatAccessLog(r *http.Request, status int, bytes int64, start time.Time, duration time.Duration) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	user := "-"
+	if username, _, ok := r.BasicAuth(); ok && len(username) > 0 {
+		user = username
+	}
+
+	size := "-"
+	if bytes > 0 {
+		size = strconv.FormatInt(bytes, 10)
+	}
+
+	return fmt.Sprintf("%s - %s [%s] %s %d %s %s %s %.6f\n",
+		orDash(host),
+		user,
+		start.Format("02/Jan/2006:15:04:05 -0700"),
+		strconv.Quote(r.Method+" "+r.RequestURI+" "+r.Proto),
+		status,
+		size,
+		strconv.Quote(orDash(r.Referer())),
+		strconv.Quote(orDash(r.UserAgent())),
+		duration.Seconds())
+}
+
+func orDash(value string) string {
+	if len(value) == 0 {
+		return "-"
+	}
+	return value
+}
+
+// accessLogRecorder captures the status code and the number of bytes
+// written by a handler
+type accessLogRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	bytes       int64
+}
+
+func (a *accessLogRecorder) WriteHeader(status int) {
+	if !a.wroteHeader {
+		a.status = status
+		a.wroteHeader = true
+	}
+	a.ResponseWriter.WriteHeader(status)
+}
+
+func (a *accessLogRecorder) Write(b []byte) (int, error) {
+	a.wroteHeader = true
+	n, err := a.ResponseWriter.Write(b)
+	a.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap allows http.ResponseController to reach the underlying writer
+func (a *accessLogRecorder) Unwrap() http.ResponseWriter {
+	return a.ResponseWriter
+}
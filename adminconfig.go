@@ -0,0 +1,124 @@
+// Copyright (c) OpenFaaS Author(s) 2026. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/openfaas/classic-watchdog/logging"
+)
+
+// configField is one field of the effective configuration
+type configField struct {
+	name  string
+	value interface{}
+}
+
+// configFields gives the fields of the configuration in the order they are
+// declared, with credentials replaced by redactedValue
+func configFields(config *WatchdogConfig) []configField {
+	v := reflect.ValueOf(config).Elem()
+	t := v.Type()
+
+	fields := make([]configField, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		name := t.Field(i).Name
+		value := plainValue(v.Field(i))
+
+		if s, ok := value.(string); ok && len(s) > 0 && isCredentialField(name) {
+			value = redactedValue
+		} else if ok && (strings.HasSuffix(name, "URL") || name == "auditLog") {
+			value = redactURLUserinfo(s)
+		}
+		fields = append(fields, configField{name: name, value: value})
+	}
+	return fields
+}
+
+func isCredentialField(name string) bool {
+	return strings.HasSuffix(name, "BasicAuth") || strings.HasSuffix(name, "BearerToken") || strings.HasSuffix(name, "Password")
+}
+
+// redactURLUserinfo hides the user and password of URLs such as nats_url
+func redactURLUserinfo(value string) string {
+	u, err := url.Parse(value)
+	if err != nil || u.User == nil {
+		return value
+	}
+	u.User = url.User("redacted")
+	return u.String()
+}
+
+// plainValue converts a field to basic types. Unexported fields cannot be
+// converted back to an interface, so named types with a String method are
+// handled here.
+func plainValue(v reflect.Value) interface{} {
+	switch v.Type() {
+	case reflect.TypeOf(time.Duration(0)):
+		return time.Duration(v.Int()).String()
+	case reflect.TypeOf(logging.Level(0)):
+		return logging.Level(v.Int()).String()
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		return v.String()
+	case reflect.Bool:
+		return v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return v.Uint()
+	case reflect.Float32, reflect.Float64:
+		return v.Float()
+	case reflect.Slice:
+		items := make([]interface{}, v.Len())
+		for i := range items {
+			items[i] = plainValue(v.Index(i))
+		}
+		return items
+	case reflect.Map:
+		entries := make(map[string]interface{}, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			entries[fmt.Sprint(plainValue(iter.Key()))] = plainValue(iter.Value())
+		}
+		return entries
+	case reflect.Struct:
+		fields := make(map[string]interface{}, v.NumField())
+		for i := 0; i < v.NumField(); i++ {
+			fields[v.Type().Field(i).Name] = plainValue(v.Field(i))
+		}
+		return fields
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+// makeConfigHandler returns the effective configuration as JSON
+func makeConfigHandler(config *WatchdogConfig) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		configLock.RLock()
+		values := make(map[string]interface{})
+		for _, field := range configFields(config) {
+			values[field.name] = field.value
+		}
+		configLock.RUnlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(values)
+	})
+}
@@ -0,0 +1,260 @@
+// Copyright (c) OpenFaaS Author(s) 2026. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/openfaas/classic-watchdog/logging"
+)
+
+const (
+	callbackURLHeader = "X-Callback-Url"
+
+	// functionStatusHeader gives the status of the invocation to the
+	// callback, as the OpenFaaS queue-worker does
+	functionStatusHeader = "X-Function-Status"
+
+	asyncCallbackTimeout = 30 * time.Second
+	asyncMaxRedirects    = 10
+)
+
+// asyncInvoker accepts requests with an X-Callback-Url with a 202, then
+// runs them in the background and POSTs the result to the callback. Other
+// requests are passed to next as they are. Once maxJobs are running,
+// further requests are rejected with a 429.
+//
+// Callbacks may only go to the hosts in allow. With "*" any host may be
+// used, but only at public addresses, so that callers can not have the
+// results sent to loopback, private or link-local addresses such as a
+// cloud metadata endpoint.
+type asyncInvoker struct {
+	next    http.Handler
+	client  *http.Client
+	allow   []string
+	maxBody int64
+	maxJobs int64
+
+	jobs    sync.WaitGroup
+	running int64
+}
+
+func newAsyncInvoker(next http.Handler, allow []string, maxBody int64, maxJobs int) *asyncInvoker {
+	a := &asyncInvoker{
+		next:    next,
+		allow:   allow,
+		maxBody: maxBody,
+		maxJobs: int64(maxJobs),
+	}
+	a.client = &http.Client{
+		Timeout:       asyncCallbackTimeout,
+		CheckRedirect: a.checkRedirect,
+		Transport:     &http.Transport{DialContext: a.dialContext},
+	}
+	return a
+}
+
+func (a *asyncInvoker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	callback := r.Header.Get(callbackURLHeader)
+	if len(callback) == 0 {
+		a.next.ServeHTTP(w, r)
+		return
+	}
+
+	u, err := url.Parse(callback)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "Invalid %s: %s\n", callbackURLHeader, callback)
+		return
+	}
+	if !a.allowed(u.Hostname()) {
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprintf(w, "Callback host not allowed: %s\n", u.Hostname())
+		return
+	}
+
+	// A slot is taken before the body is read, so that the bodies of
+	// rejected requests are not buffered
+	if running := atomic.AddInt64(&a.running, 1); a.maxJobs > 0 && running > a.maxJobs {
+		atomic.AddInt64(&a.running, -1)
+		w.WriteHeader(http.StatusTooManyRequests)
+		fmt.Fprintf(w, "Too many async invocations running: %d\n", a.maxJobs)
+		return
+	}
+
+	// The body must be read before the response, as it is closed afterwards
+	if !limitRequestBody(w, r, a.maxBody) {
+		atomic.AddInt64(&a.running, -1)
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		atomic.AddInt64(&a.running, -1)
+		w.WriteHeader(bodyErrorStatus(err))
+		fmt.Fprintf(w, "%s\n", err.Error())
+		return
+	}
+
+	callID := r.Header.Get(callIDHeader)
+	if len(callID) == 0 {
+		callID = newCallID()
+	}
+
+	req := r.Clone(context.Background())
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	req.ContentLength = int64(len(body))
+	req.Header.Del(callbackURLHeader)
+	req.Header.Set(callIDHeader, callID)
+
+	a.jobs.Add(1)
+	go a.run(req, callback, callID)
+
+	w.Header().Set(callIDHeader, callID)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// allowed checks the callback's host against async_callback_allow, where
+// no host is allowed when the list is empty. A host which is only allowed
+// by "*" must not be a loopback, private or link-local address.
+func (a *asyncInvoker) allowed(host string) bool {
+	if a.named(host) {
+		return true
+	}
+	if !slices.Contains(a.allow, "*") {
+		return false
+	}
+	if ip, err := netip.ParseAddr(host); err == nil {
+		return publicAddr(ip)
+	}
+	return true
+}
+
+// named is true when host is listed in async_callback_allow by name, so
+// that it may resolve to any address
+func (a *asyncInvoker) named(host string) bool {
+	for _, allowed := range a.allow {
+		if strings.EqualFold(allowed, host) {
+			return true
+		}
+	}
+	return false
+}
+
+// dialContext checks the address which a host allowed by "*" resolved to,
+// so that a name which resolves to an internal address is not reached
+func (a *asyncInvoker) dialContext(ctx context.Context, network string, address string) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: asyncCallbackTimeout}
+	if host, _, err := net.SplitHostPort(address); err != nil || !a.named(host) {
+		dialer.Control = func(network string, address string, _ syscall.RawConn) error {
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return err
+			}
+			if !publicAddr(addrPort.Addr()) {
+				return fmt.Errorf("callback address not allowed: %s", addrPort.Addr())
+			}
+			return nil
+		}
+	}
+	return dialer.DialContext(ctx, network, address)
+}
+
+// publicAddr is false for loopback, private, link-local, multicast and
+// unspecified addresses
+func publicAddr(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	return !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsLinkLocalUnicast() && !ip.IsLinkLocalMulticast() &&
+		!ip.IsInterfaceLocalMulticast() && !ip.IsMulticast() && !ip.IsUnspecified()
+}
+
+// checkRedirect applies async_callback_allow to each redirect, so that an
+// allowed host cannot send the result on to one which is not
+func (a *asyncInvoker) checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= asyncMaxRedirects {
+		return errors.New("too many redirects")
+	}
+	if !a.allowed(req.URL.Hostname()) {
+		return fmt.Errorf("redirect to a host which is not allowed: %s", req.URL.Hostname())
+	}
+	return nil
+}
+
+func (a *asyncInvoker) run(req *http.Request, callback string, callID string) {
+	defer a.jobs.Done()
+	defer atomic.AddInt64(&a.running, -1)
+
+	res := &responseBuffer{header: http.Header{}}
+	a.next.ServeHTTP(res, req)
+	if res.status == 0 {
+		res.status = http.StatusOK
+	}
+
+	if err := a.post(callback, callID, res); err != nil {
+		logging.Errorf("Unable to post result to callback: %s, %s: %s", err.Error(), callIDHeader, callID)
+		return
+	}
+	logging.Infof("Posted result to callback, status: %d - %s: %s", res.status, callIDHeader, callID)
+}
+
+func (a *asyncInvoker) post(callback string, callID string, res *responseBuffer) error {
+	req, err := http.NewRequest(http.MethodPost, callback, bytes.NewReader(res.body.Bytes()))
+	if err != nil {
+		return err
+	}
+
+	for name, values := range res.header {
+		req.Header[name] = values
+	}
+	req.Header.Set(callIDHeader, callID)
+	req.Header.Set(functionStatusHeader, strconv.Itoa(res.status))
+
+	callbackRes, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer callbackRes.Body.Close()
+	io.Copy(io.Discard, callbackRes.Body)
+
+	if callbackRes.StatusCode < 200 || callbackRes.StatusCode > 299 {
+		return fmt.Errorf("callback gave status %d", callbackRes.StatusCode)
+	}
+	return nil
+}
+
+// Wait gives the invocations running in the background up to timeout to
+// complete on shutdown
+func (a *asyncInvoker) Wait(timeout time.Duration) {
+	running := atomic.LoadInt64(&a.running)
+	if running == 0 {
+		return
+	}
+	logging.Infof("Waiting for %d async invocations", running)
+
+	done := make(chan struct{})
+	go func() {
+		a.jobs.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		logging.Warnf("Exiting with %d async invocations running", atomic.LoadInt64(&a.running))
+	}
+}
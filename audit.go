@@ -0,0 +1,361 @@
+// Copyright (c) OpenFaaS Author(s) 2026. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/openfaas/classic-watchdog/logging"
+)
+
+// auditGenesisHash is the previous hash of the first record of a log
+var auditGenesisHash = strings.Repeat("0", 64)
+
+const (
+	auditQueueSize   = 1024
+	auditPostTries   = 3
+	auditPostTimeout = 10 * time.Second
+)
+
+// auditRecord is written as one line of JSON per invocation. Hash is the
+// SHA-256 of PrevHash followed by the record's JSON without Hash, so that
+// changing, removing or reordering a record breaks the chain after it.
+type auditRecord struct {
+	Seq             uint64  `json:"seq"`
+	Time            string  `json:"time"`
+	CallID          string  `json:"call_id"`
+	Method          string  `json:"method"`
+	Path            string  `json:"path"`
+	RemoteAddr      string  `json:"remote_addr"`
+	Subject         string  `json:"subject,omitempty"`
+	Issuer          string  `json:"issuer,omitempty"`
+	Status          int     `json:"status"`
+	DurationSeconds float64 `json:"duration_seconds"`
+	RequestSHA256   string  `json:"request_sha256"`
+	ResponseSHA256  string  `json:"response_sha256"`
+	PrevHash        string  `json:"prev_hash"`
+	Hash            string  `json:"hash,omitempty"`
+}
+
+// seal sets Hash from the other fields
+func (a *auditRecord) seal() error {
+	a.Hash = ""
+	data, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(append([]byte(a.PrevHash), data...))
+	a.Hash = hex.EncodeToString(sum[:])
+	return nil
+}
+
+// auditLog appends a hash chained record for each invocation to a file,
+// stdout or stderr, or POSTs each to an http(s) endpoint in order.
+type auditLog struct {
+	lock     sync.Mutex
+	out      io.Writer
+	seq      uint64
+	prevHash string
+
+	endpoint string
+	client   *http.Client
+	queue    chan []byte
+}
+
+// openAuditLog continues the chain from the last record of an existing file
+func openAuditLog(target string) (*auditLog, error) {
+	a := &auditLog{prevHash: auditGenesisHash}
+
+	if strings.HasPrefix(target, "http://") || strings.HasPrefix(target, "https://") {
+		a.endpoint = target
+		a.client = &http.Client{Timeout: auditPostTimeout}
+		a.queue = make(chan []byte, auditQueueSize)
+		go a.post()
+		return a, nil
+	}
+
+	if target != "stdout" && target != "stderr" {
+		last, err := lastAuditRecord(target)
+		if err != nil {
+			return nil, err
+		}
+		if last != nil {
+			a.seq = last.Seq
+			a.prevHash = last.Hash
+		}
+	}
+
+	out, err := openAccessLog(target)
+	if err != nil {
+		return nil, err
+	}
+	a.out = out
+	return a, nil
+}
+
+func lastAuditRecord(path string) (*auditRecord, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var last []byte
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if line := bytes.TrimSpace(scanner.Bytes()); len(line) > 0 {
+			last = append(last[:0], line...)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if last == nil {
+		return nil, nil
+	}
+
+	record := &auditRecord{}
+	if err := json.Unmarshal(last, record); err != nil {
+		return nil, fmt.Errorf("unable to continue the audit log from %s: %w", path, err)
+	}
+	return record, nil
+}
+
+// Handler records each request once its response has been written
+func (a *auditLog) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		requestHash := sha256.New()
+		if r.Body != nil {
+			r.Body = &hashingReader{ReadCloser: r.Body, hash: requestHash}
+		}
+		rec := &auditRecorder{ResponseWriter: w, status: http.StatusOK, hash: sha256.New()}
+
+		// The caller is only known once the authentication behind this
+		// handler has checked its credentials, a request which failed is
+		// recorded with its 401 and no caller
+		r, caller := trackCaller(r)
+		next.ServeHTTP(rec, r)
+
+		record := &auditRecord{
+			Time:            start.UTC().Format(time.RFC3339Nano),
+			CallID:          firstNonEmpty(rec.Header().Get(callIDHeader), r.Header.Get(callIDHeader)),
+			Method:          r.Method,
+			Path:            r.URL.Path,
+			RemoteAddr:      auditRemoteAddr(r),
+			Status:          rec.status,
+			DurationSeconds: time.Since(start).Seconds(),
+			RequestSHA256:   hex.EncodeToString(requestHash.Sum(nil)),
+			ResponseSHA256:  hex.EncodeToString(rec.hash.Sum(nil)),
+		}
+		record.Subject, record.Issuer = caller.subject, caller.issuer
+
+		if err := a.write(record); err != nil {
+			logging.Errorf("Unable to write audit record: %s - %s: %s", err.Error(), callIDHeader, record.CallID)
+		}
+	})
+}
+
+func (a *auditLog) write(record *auditRecord) error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	record.Seq = a.seq + 1
+	record.PrevHash = a.prevHash
+	if err := record.seal(); err != nil {
+		return err
+	}
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	if a.queue != nil {
+		select {
+		case a.queue <- line:
+		default:
+			return fmt.Errorf("the queue for %s is full", a.endpoint)
+		}
+	} else if _, err := a.out.Write(line); err != nil {
+		return err
+	}
+
+	a.seq = record.Seq
+	a.prevHash = record.Hash
+	return nil
+}
+
+// post sends queued records one at a time, so that they arrive in order
+func (a *auditLog) post() {
+	for line := range a.queue {
+		var err error
+		for try := 1; try <= auditPostTries; try++ {
+			if err = a.postRecord(line); err == nil {
+				break
+			}
+			time.Sleep(time.Duration(try) * time.Second)
+		}
+		if err != nil {
+			logging.Errorf("Unable to post audit record to %s: %s", a.endpoint, err.Error())
+		}
+	}
+}
+
+func (a *auditLog) postRecord(line []byte) error {
+	res, err := a.client.Post(a.endpoint, "application/json", bytes.NewReader(line))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, res.Body)
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("status %d", res.StatusCode)
+	}
+	return nil
+}
+
+func auditRemoteAddr(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if len(value) > 0 {
+			return value
+		}
+	}
+	return ""
+}
+
+// hashingReader hashes the request body as the function reads it
+type hashingReader struct {
+	io.ReadCloser
+	hash hash.Hash
+}
+
+func (h *hashingReader) Read(p []byte) (int, error) {
+	n, err := h.ReadCloser.Read(p)
+	h.hash.Write(p[:n])
+	return n, err
+}
+
+// auditRecorder captures the status and hashes the body of a response
+type auditRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	hash        hash.Hash
+}
+
+func (a *auditRecorder) WriteHeader(status int) {
+	if !a.wroteHeader {
+		a.status = status
+		a.wroteHeader = true
+	}
+	a.ResponseWriter.WriteHeader(status)
+}
+
+func (a *auditRecorder) Write(b []byte) (int, error) {
+	a.wroteHeader = true
+	a.hash.Write(b)
+	return a.ResponseWriter.Write(b)
+}
+
+// Unwrap allows http.ResponseController to reach the underlying writer
+func (a *auditRecorder) Unwrap() http.ResponseWriter {
+	return a.ResponseWriter
+}
+
+// verifyAuditLog checks the sequence and hash chain of an audit log,
+// giving the number of records and the first problem found
+func verifyAuditLog(in io.Reader) (int, error) {
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	count := 0
+	prevHash := ""
+	var seq uint64
+	for line := 1; scanner.Scan(); line++ {
+		data := bytes.TrimSpace(scanner.Bytes())
+		if len(data) == 0 {
+			continue
+		}
+
+		record := auditRecord{}
+		if err := json.Unmarshal(data, &record); err != nil {
+			return count, fmt.Errorf("line %d: %w", line, err)
+		}
+		// A log with its first records cut off does not start the chain
+		if count == 0 && (record.Seq != 1 || record.PrevHash != auditGenesisHash) {
+			return count, fmt.Errorf("line %d: want the first record with seq 1, got: %d", line, record.Seq)
+		}
+		if count > 0 {
+			if record.PrevHash != prevHash {
+				return count, fmt.Errorf("line %d: prev_hash does not match the record before it", line)
+			}
+			if record.Seq != seq+1 {
+				return count, fmt.Errorf("line %d: want seq %d, got: %d", line, seq+1, record.Seq)
+			}
+		}
+
+		stored := record.Hash
+		if err := record.seal(); err != nil {
+			return count, err
+		}
+		if record.Hash != stored {
+			return count, fmt.Errorf("line %d: hash does not match the record", line)
+		}
+
+		prevHash = record.Hash
+		seq = record.Seq
+		count++
+	}
+	return count, scanner.Err()
+}
+
+// runVerifyAudit checks the audit log at path, giving the exit code
+func runVerifyAudit(args []string, out io.Writer) int {
+	if len(args) != 1 {
+		fmt.Fprintf(out, "Usage: fwatchdog verify-audit <file>\n")
+		return 2
+	}
+
+	f, err := os.Open(args[0])
+	if err != nil {
+		fmt.Fprintf(out, "%s\n", err.Error())
+		return 1
+	}
+	defer f.Close()
+
+	count, err := verifyAuditLog(f)
+	if err != nil {
+		fmt.Fprintf(out, "Audit log is not intact after %d records: %s\n", count, err.Error())
+		return 1
+	}
+	fmt.Fprintf(out, "Audit log is intact, %d records.\n", count)
+	return 0
+}
@@ -0,0 +1,151 @@
+// Copyright (c) OpenFaaS Author(s) 2026. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+)
+
+// makeBasicAuthHandler requires HTTP basic authentication with the
+// credentials in the basic-auth-user and basic-auth-password secrets.
+func makeBasicAuthHandler(config WatchdogConfig, next http.Handler) (http.Handler, error) {
+	user, err := readSecretValue("", filepath.Join(config.secretMountPath, "basic-auth-user"))
+	if err != nil {
+		return nil, err
+	}
+	password, err := readSecretValue("", filepath.Join(config.secretMountPath, "basic-auth-password"))
+	if err != nil {
+		return nil, err
+	}
+	if len(user) == 0 || len(password) == 0 {
+		return nil, fmt.Errorf("basic-auth-user and basic-auth-password must not be empty")
+	}
+
+	return basicAuthHandler(user, password, next), nil
+}
+
+func basicAuthHandler(user string, password string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		givenUser, givenPassword, ok := r.BasicAuth()
+
+		// Both are compared so that the time taken does not reveal
+		// which of the two was wrong
+		userMatch := subtle.ConstantTimeCompare([]byte(givenUser), []byte(user))
+		passwordMatch := subtle.ConstantTimeCompare([]byte(givenPassword), []byte(password))
+
+		if !ok || userMatch&passwordMatch != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="Restricted"`)
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, withCaller(r, givenUser, ""))
+	})
+}
+
+// makeAPIKeyHandler requires a key from one of the api_key_secrets in the
+// api_key_header. Each secret may hold several keys, one per line, so that
+// keys can be rotated without downtime.
+func makeAPIKeyHandler(config WatchdogConfig, next http.Handler) (http.Handler, error) {
+	var keys []string
+	for _, name := range config.apiKeySecrets {
+		value, err := readSecretValue("", filepath.Join(config.secretMountPath, name))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, key := range strings.Split(value, "\n") {
+			if key = strings.TrimSpace(key); len(key) > 0 {
+				keys = append(keys, key)
+			}
+		}
+	}
+
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no API keys found in secrets: %s", strings.Join(config.apiKeySecrets, ", "))
+	}
+
+	return apiKeyHandler(config.apiKeyHeader, keys, next), nil
+}
+
+func apiKeyHandler(header string, keys []string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		given := []byte(r.Header.Get(header))
+
+		// Every key is compared so that the time taken does not reveal
+		// which key was closest
+		match := 0
+		for _, key := range keys {
+			match |= subtle.ConstantTimeCompare(given, []byte(key))
+		}
+
+		if len(given) == 0 || match != 1 {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+
+		// The key is not needed by the function, so is not passed on
+		r.Header.Del(header)
+		next.ServeHTTP(w, withCaller(r, apiKeySubject(string(given)), ""))
+	})
+}
+
+// makeHMACHandler verifies a HMAC-SHA256 signature of the request body in
+// hmac_header, i.e. X-Hub-Signature-256: sha256=<hex>, against the secret
+// in hmac_secret before the function is invoked.
+func makeHMACHandler(config WatchdogConfig, next http.Handler) (http.Handler, error) {
+	secret, err := readSecretValue("", filepath.Join(config.secretMountPath, config.hmacSecret))
+	if err != nil {
+		return nil, err
+	}
+	if len(secret) == 0 {
+		return nil, fmt.Errorf("secret %s must not be empty", config.hmacSecret)
+	}
+
+	return hmacHandler(config.hmacHeader, []byte(secret), config.maxRequestBytes, next), nil
+}
+
+func hmacHandler(header string, secret []byte, maxRequestBytes int64, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		signature := strings.TrimPrefix(r.Header.Get(header), "sha256=")
+		given, err := hex.DecodeString(signature)
+		if err != nil || len(given) == 0 {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+
+		if r.Body == nil {
+			r.Body = http.NoBody
+		}
+		if maxRequestBytes > 0 {
+			r.Body = http.MaxBytesReader(w, r.Body, maxRequestBytes)
+		}
+
+		body, err := io.ReadAll(r.Body)
+		r.Body.Close()
+		if err != nil {
+			http.Error(w, err.Error(), bodyErrorStatus(err))
+			return
+		}
+
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(body)
+		if !hmac.Equal(given, mac.Sum(nil)) {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,74 @@
+// Copyright (c) OpenFaaS Author(s) 2026. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+)
+
+// writeBodyFile streams the request body to a file in dir, or the default
+// temporary directory when dir is empty, so that large bodies are not held
+// in memory and tools which only read files can be used
+func writeBodyFile(config *WatchdogConfig, r *http.Request, dir string) (string, error) {
+	f, err := os.CreateTemp(dir, "fwatchdog-body-")
+	if err != nil {
+		return "", err
+	}
+
+	_, err = io.Copy(f, r.Body)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && config.execCredential != nil {
+		err = config.execCredential.chownTree(f.Name())
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// makeResponseFile creates an empty file for the function to write its
+// response to, owned by exec_user when set
+func makeResponseFile(config *WatchdogConfig, dir string) (string, error) {
+	f, err := os.CreateTemp(dir, "fwatchdog-response-")
+	if err != nil {
+		return "", err
+	}
+	f.Close()
+
+	if config.execCredential != nil {
+		if err := config.execCredential.chownTree(f.Name()); err != nil {
+			os.Remove(f.Name())
+			return "", err
+		}
+	}
+	return f.Name(), nil
+}
+
+// readResponseFile reads the response written by the function, up to
+// response_file_max_bytes
+func readResponseFile(config *WatchdogConfig, path string) ([]byte, error) {
+	f, err := openResponseFile(config, path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if config.responseFileMaxBytes <= 0 {
+		return io.ReadAll(f)
+	}
+	data, err := io.ReadAll(io.LimitReader(f, config.responseFileMaxBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > config.responseFileMaxBytes {
+		return nil, fmt.Errorf("the response file exceeds the limit of %d bytes", config.responseFileMaxBytes)
+	}
+	return data, nil
+}
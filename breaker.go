@@ -0,0 +1,68 @@
+// Copyright (c) OpenFaaS Author(s) 2026. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/openfaas/classic-watchdog/logging"
+)
+
+// circuitBreaker stops running the function for a cool-down period after
+// a number of consecutive failures, so that a crash-looping function does
+// not keep hitting the resources it depends on. After the cool-down, one
+// more failure opens it again, and a success closes it.
+type circuitBreaker struct {
+	failures int
+	coolDown time.Duration
+
+	lock        sync.Mutex
+	consecutive int
+	openUntil   time.Time
+	now         func() time.Time
+}
+
+func newCircuitBreaker(failures int, coolDown time.Duration) *circuitBreaker {
+	return &circuitBreaker{
+		failures: failures,
+		coolDown: coolDown,
+		now:      time.Now,
+	}
+}
+
+// allow returns false with the time left while the breaker is open
+func (b *circuitBreaker) allow() (bool, time.Duration) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if left := b.openUntil.Sub(b.now()); left > 0 {
+		return false, left
+	}
+	return true, 0
+}
+
+// record counts an exec error or non-zero exit as a failure
+func (b *circuitBreaker) record(success bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if success {
+		b.consecutive = 0
+		return
+	}
+
+	b.consecutive++
+	if b.consecutive < b.failures || b.now().Before(b.openUntil) {
+		return
+	}
+
+	b.openUntil = b.now().Add(b.coolDown)
+	b.consecutive = b.failures - 1
+
+	logging.Warnf("Circuit breaker open for %s after %d consecutive failures", b.coolDown, b.failures)
+	if execMetrics != nil {
+		execMetrics.CircuitBreakerTrips.Inc()
+	}
+}
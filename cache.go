@@ -0,0 +1,268 @@
+// Copyright (c) OpenFaaS Author(s) 2026. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/openfaas/classic-watchdog/logging"
+)
+
+const cacheStatusHeader = "X-Cache"
+
+// responseCache keeps 200 responses in memory for ttl, keyed by the method,
+// path and query, a hash of the body, the Accept and Accept-Encoding
+// headers so that negotiated and compressed responses are kept apart, and
+// the verified caller so that one caller is never given another's response.
+// The least recently used entries are evicted to stay within maxBytes.
+type responseCache struct {
+	ttl      time.Duration
+	maxBytes int64
+	maxBody  int64
+
+	lock    sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+	size    int64
+}
+
+type cacheEntry struct {
+	key     string
+	header  http.Header
+	body    []byte
+	expires time.Time
+
+	// vary holds the values of the request headers named by the
+	// response's Vary header, which a request must match to be served
+	vary []string
+}
+
+func newResponseCache(ttl time.Duration, maxBytes int64, maxBody int64) *responseCache {
+	return &responseCache{
+		ttl:      ttl,
+		maxBytes: maxBytes,
+		maxBody:  maxBody,
+		entries:  make(map[string]*list.Element),
+		lru:      list.New(),
+	}
+}
+
+// Handler answers from the cache without forking the function, and stores
+// the responses to requests which missed
+func (c *responseCache) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// WebSocket upgrades are streamed and never cached
+		if len(r.Header.Get("Upgrade")) > 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Credentials which were not checked by the authentication, such
+		// as cookies, may change the function's output for each caller
+		caller := callerOf(r)
+		if len(r.Header.Get("Cookie")) > 0 || (len(r.Header.Get("Authorization")) > 0 && !caller.verified()) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if !limitRequestBody(w, r, c.maxBody) {
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(bodyErrorStatus(err))
+			w.Write([]byte(err.Error()))
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		key := cacheKey(r, caller, body)
+		noCache := strings.Contains(strings.ToLower(r.Header.Get("Cache-Control")), "no-cache")
+		if entry := c.get(key); entry != nil && !noCache && slices.Equal(entry.vary, varyValues(r, entry.header)) {
+			c.serve(w, r, entry)
+			return
+		}
+
+		w.Header().Set(cacheStatusHeader, "MISS")
+		recorder := &cacheRecorder{ResponseWriter: w, limit: c.maxBytes}
+		next.ServeHTTP(recorder, r)
+
+		if recorder.cacheable() {
+			c.put(key, recorder.header, recorder.body.Bytes(), varyValues(r, recorder.header))
+		}
+	})
+}
+
+func cacheKey(r *http.Request, caller verifiedCaller, body []byte) string {
+	h := sha256.New()
+	for _, part := range []string{
+		r.Method,
+		r.URL.RequestURI(),
+		r.Header.Get("Accept"),
+		r.Header.Get("Accept-Encoding"),
+		caller.key(),
+	} {
+		io.WriteString(h, part)
+		h.Write([]byte{0})
+	}
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// varyValues gives the values of the request headers named by the Vary
+// header of a response
+func varyValues(r *http.Request, header http.Header) []string {
+	var values []string
+	for _, name := range varyNames(header) {
+		values = append(values, strings.Join(r.Header.Values(name), ","))
+	}
+	return values
+}
+
+func varyNames(header http.Header) []string {
+	var names []string
+	for _, value := range header.Values("Vary") {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); len(name) > 0 {
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+func (c *responseCache) serve(w http.ResponseWriter, r *http.Request, entry *cacheEntry) {
+	header := w.Header()
+	for name, values := range entry.header {
+		// The call's own ID is kept rather than the one which was cached
+		if name == callIDHeader {
+			continue
+		}
+		header[name] = values
+	}
+	header.Set(cacheStatusHeader, "HIT")
+
+	if notModified(r, header.Get("ETag")) {
+		header.Del("Content-Length")
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if r.Method != http.MethodHead {
+		w.Write(entry.body)
+	}
+	logging.Debugf("Served from cache - %s: %s", callIDHeader, r.Header.Get(callIDHeader))
+}
+
+func (c *responseCache) get(key string) *cacheEntry {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	element, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+	entry := element.Value.(*cacheEntry)
+	if time.Now().After(entry.expires) {
+		c.remove(element)
+		return nil
+	}
+	c.lru.MoveToFront(element)
+	return entry
+}
+
+func (c *responseCache) put(key string, header http.Header, body []byte, vary []string) {
+	entry := &cacheEntry{
+		key:     key,
+		header:  header,
+		body:    bytes.Clone(body),
+		expires: time.Now().Add(c.ttl),
+		vary:    vary,
+	}
+	size := int64(len(entry.body))
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if element, ok := c.entries[key]; ok {
+		c.remove(element)
+	}
+	for c.size+size > c.maxBytes && c.lru.Len() > 0 {
+		c.remove(c.lru.Back())
+	}
+	c.entries[key] = c.lru.PushFront(entry)
+	c.size += size
+}
+
+// remove must be called with the lock held
+func (c *responseCache) remove(element *list.Element) {
+	entry := c.lru.Remove(element).(*cacheEntry)
+	delete(c.entries, entry.key)
+	c.size -= int64(len(entry.body))
+}
+
+// cacheRecorder copies a response as it is written, giving up once the
+// body is larger than limit
+type cacheRecorder struct {
+	http.ResponseWriter
+	limit int64
+
+	status   int
+	header   http.Header
+	body     bytes.Buffer
+	overflow bool
+}
+
+func (c *cacheRecorder) WriteHeader(status int) {
+	if c.status == 0 {
+		c.status = status
+		c.header = c.ResponseWriter.Header().Clone()
+	}
+	c.ResponseWriter.WriteHeader(status)
+}
+
+func (c *cacheRecorder) Write(b []byte) (int, error) {
+	if c.status == 0 {
+		c.WriteHeader(http.StatusOK)
+	}
+	if !c.overflow {
+		if int64(c.body.Len()+len(b)) > c.limit {
+			c.overflow = true
+			c.body.Reset()
+		} else {
+			c.body.Write(b)
+		}
+	}
+	return c.ResponseWriter.Write(b)
+}
+
+// Unwrap allows http.ResponseController to reach the underlying writer
+func (c *cacheRecorder) Unwrap() http.ResponseWriter {
+	return c.ResponseWriter
+}
+
+// cacheable excludes streamed, private and per-user responses
+func (c *cacheRecorder) cacheable() bool {
+	if c.status != http.StatusOK || c.overflow {
+		return false
+	}
+	if slices.Contains(varyNames(c.header), "*") {
+		return false
+	}
+	if len(c.header.Get("Set-Cookie")) > 0 || c.header.Get("Content-Type") == "text/event-stream" {
+		return false
+	}
+	cacheControl := strings.ToLower(c.header.Get("Cache-Control"))
+	return !strings.Contains(cacheControl, "no-store") && !strings.Contains(cacheControl, "private")
+}
@@ -0,0 +1,66 @@
+// Copyright (c) OpenFaaS Author(s) 2026. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+)
+
+// callerKey stores the verifiedCaller of a request on its context
+type callerKey struct{}
+
+// verifiedCaller is who made a request, as checked by basic auth, an API
+// key or a JWT. It is empty when no authentication is configured or the
+// request failed it.
+type verifiedCaller struct {
+	subject string
+	issuer  string
+}
+
+// trackCaller makes room for the caller on r, so that a handler in front of
+// the authentication can read it once the request is done with
+func trackCaller(r *http.Request) (*http.Request, *verifiedCaller) {
+	if caller, ok := r.Context().Value(callerKey{}).(*verifiedCaller); ok {
+		return r, caller
+	}
+	caller := &verifiedCaller{}
+	return r.WithContext(context.WithValue(r.Context(), callerKey{}, caller)), caller
+}
+
+// withCaller records the caller of r once its credentials were checked
+func withCaller(r *http.Request, subject string, issuer string) *http.Request {
+	r, caller := trackCaller(r)
+	caller.subject = subject
+	caller.issuer = issuer
+	return r
+}
+
+// callerOf gives the caller recorded with withCaller
+func callerOf(r *http.Request) verifiedCaller {
+	if caller, ok := r.Context().Value(callerKey{}).(*verifiedCaller); ok {
+		return *caller
+	}
+	return verifiedCaller{}
+}
+
+// verified is true once a caller has been recorded
+func (c verifiedCaller) verified() bool {
+	return len(c.subject) > 0 || len(c.issuer) > 0
+}
+
+// key identifies the caller in the keys of the response cache and the
+// idempotency store
+func (c verifiedCaller) key() string {
+	return c.issuer + "\x00" + c.subject
+}
+
+// apiKeySubject names an API key by a short hash, so that callers with
+// different keys are told apart without recording the key
+func apiKeySubject(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return "api-key:" + hex.EncodeToString(sum[:6])
+}
@@ -0,0 +1,41 @@
+// Copyright (c) OpenFaaS Author(s) 2026. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"net/http"
+)
+
+// callIDHeader is set by the OpenFaaS gateway to correlate invocations
+const callIDHeader = "X-Call-Id"
+
+// makeCallIDHandler ensures every request has an X-Call-Id, generating one
+// when the caller did not send it, and echoes it in the response headers.
+// The ID is stored on the request so that it is passed to the function
+// as Http_X_Call_Id along with the other headers.
+func makeCallIDHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		callID := r.Header.Get(callIDHeader)
+		if len(callID) == 0 {
+			callID = newCallID()
+			r.Header.Set(callIDHeader, callID)
+		}
+
+		w.Header().Set(callIDHeader, callID)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// newCallID returns a random (version 4) UUID
+func newCallID() string {
+	var b [16]byte
+	rand.Read(b[:])
+
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
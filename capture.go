@@ -0,0 +1,123 @@
+// Copyright (c) OpenFaaS Author(s) 2026. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/openfaas/classic-watchdog/logging"
+)
+
+// makeCaptureHandler writes each request, with its headers and body, to a
+// file in dir in the HTTP/1.1 wire format, which "fwatchdog replay" reads.
+// Headers matching redact are written as redactedValue.
+func makeCaptureHandler(dir string, redact []string, maxBody int64, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if maxBody > 0 {
+			r.Body = http.MaxBytesReader(w, r.Body, maxBody)
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(bodyErrorStatus(err))
+			w.Write([]byte(err.Error()))
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		path, err := writeCapture(dir, r, body, redact)
+		if err != nil {
+			logging.Errorf("Unable to capture request: %s", err.Error())
+		} else {
+			logging.Debugf("Captured request to: %s", path)
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func writeCapture(dir string, r *http.Request, body []byte, redact []string) (string, error) {
+	capture := r.Clone(r.Context())
+	capture.Body = io.NopCloser(bytes.NewReader(body))
+	for name := range capture.Header {
+		if matchHeaderPattern(redact, name) {
+			capture.Header.Set(name, redactedValue)
+		}
+	}
+	// The body has been read, so it is written with its length rather
+	// than as it arrived
+	capture.TransferEncoding = nil
+	capture.ContentLength = int64(len(body))
+	capture.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	if len(capture.RequestURI) == 0 {
+		capture.RequestURI = r.URL.RequestURI()
+	}
+
+	data, err := httputil.DumpRequest(capture, true)
+	if err != nil {
+		return "", err
+	}
+
+	callID := strings.Map(func(c rune) rune {
+		if c == '-' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' {
+			return c
+		}
+		return -1
+	}, r.Header.Get(callIDHeader))
+	path := filepath.Join(dir, fmt.Sprintf("%d-%s.http", time.Now().UnixNano(), callID))
+
+	// Captures hold request bodies, so they are only readable by the owner
+	return path, os.WriteFile(path, data, 0600)
+}
+
+// readCapture reads a request written by makeCaptureHandler
+func readCapture(path string) (*http.Request, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(data)))
+	if err != nil {
+		return nil, fmt.Errorf("unable to read a request from %s: %w", path, err)
+	}
+
+	// ReadRequest gives a server request, which must be turned into a
+	// client one before it is handled again
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		return nil, err
+	}
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	req.RequestURI = ""
+	req.URL.Scheme = "http"
+	req.URL.Host = "127.0.0.1"
+	req.RemoteAddr = "127.0.0.1:0"
+	return req, nil
+}
+
+// runReplay runs the function with a captured request and prints the
+// response as self-test does
+func runReplay(config *WatchdogConfig, args []string, out io.Writer) int {
+	if len(args) != 1 {
+		fmt.Fprintf(out, "Usage: fwatchdog replay <file>\n")
+		return 2
+	}
+
+	req, err := readCapture(args[0])
+	if err != nil {
+		fmt.Fprintf(out, "%s\n", err.Error())
+		return 2
+	}
+	return runOnce(config, req, out)
+}
@@ -0,0 +1,134 @@
+// Copyright (c) OpenFaaS Author(s) 2026. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/openfaas/classic-watchdog/logging"
+)
+
+// cgroupCPUPeriod is the period in microseconds for cpu.max
+const cgroupCPUPeriod = 100000
+
+// invocationCgroups starts each process in a cgroup of its own with memory
+// and CPU limits, so that one invocation cannot starve the others running in
+// the container. It needs cgroup v2 and a delegated, writable cgroup.
+type invocationCgroups struct {
+	dir         string
+	memoryLimit int64
+	cpuLimit    float64
+	next        atomic.Uint64
+}
+
+// newInvocationCgroups moves the processes in the watchdog's cgroup into a
+// "watchdog" child, since controllers can only be enabled for the children
+// of a cgroup which has no processes of its own.
+func newInvocationCgroups(root string, memoryLimit int64, cpuLimit float64) (*invocationCgroups, error) {
+	path, err := ownCgroupPath("/proc/self/cgroup")
+	if err != nil {
+		return nil, err
+	}
+
+	dir := filepath.Join(root, path)
+	if _, err := os.Stat(filepath.Join(dir, "cgroup.controllers")); err != nil {
+		return nil, fmt.Errorf("cgroup v2 is needed at %s: %w", dir, err)
+	}
+
+	leaf := filepath.Join(dir, "watchdog")
+	if err := os.Mkdir(leaf, 0755); err != nil && !os.IsExist(err) {
+		return nil, err
+	}
+
+	procs, err := os.ReadFile(filepath.Join(dir, "cgroup.procs"))
+	if err != nil {
+		return nil, err
+	}
+	for _, pid := range strings.Fields(string(procs)) {
+		if err := os.WriteFile(filepath.Join(leaf, "cgroup.procs"), []byte(pid), 0644); err != nil {
+			return nil, fmt.Errorf("unable to move process %s to %s: %w", pid, leaf, err)
+		}
+	}
+
+	var controllers []string
+	if memoryLimit > 0 {
+		controllers = append(controllers, "+memory")
+	}
+	if cpuLimit > 0 {
+		controllers = append(controllers, "+cpu")
+	}
+	if err := os.WriteFile(filepath.Join(dir, "cgroup.subtree_control"), []byte(strings.Join(controllers, " ")), 0644); err != nil {
+		return nil, fmt.Errorf("unable to enable %s in %s: %w", strings.Join(controllers, " "), dir, err)
+	}
+
+	return &invocationCgroups{
+		dir:         dir,
+		memoryLimit: memoryLimit,
+		cpuLimit:    cpuLimit,
+	}, nil
+}
+
+// apply creates a cgroup for cmd, which it is started in directly with
+// CLONE_INTO_CGROUP. The function returned removes the cgroup once the
+// process has exited, killing anything it left behind.
+func (c *invocationCgroups) apply(cmd *exec.Cmd) (func(), error) {
+	dir := filepath.Join(c.dir, "fprocess-"+strconv.FormatUint(c.next.Add(1), 10))
+	if err := os.Mkdir(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	err := c.writeLimits(dir)
+	var f *os.File
+	if err == nil {
+		f, err = os.Open(dir)
+	}
+	if err != nil {
+		os.Remove(dir)
+		return nil, err
+	}
+
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.UseCgroupFD = true
+	cmd.SysProcAttr.CgroupFD = int(f.Fd())
+
+	return func() {
+		f.Close()
+
+		if c.memoryLimit > 0 {
+			if kills, err := readCgroupStat(filepath.Join(dir, "memory.events"), "oom_kill"); err == nil && kills > 0 {
+				logging.Errorf("fprocess was killed for exceeding exec_memory_limit of %d bytes", c.memoryLimit)
+			}
+		}
+
+		os.WriteFile(filepath.Join(dir, "cgroup.kill"), []byte("1"), 0644)
+		if err := os.Remove(dir); err != nil {
+			logging.Errorf("Unable to remove cgroup %s: %s", dir, err.Error())
+		}
+	}, nil
+}
+
+func (c *invocationCgroups) writeLimits(dir string) error {
+	if c.memoryLimit > 0 {
+		if err := os.WriteFile(filepath.Join(dir, "memory.max"), []byte(strconv.FormatInt(c.memoryLimit, 10)), 0644); err != nil {
+			return err
+		}
+	}
+	if c.cpuLimit > 0 {
+		// The kernel's smallest quota is 1ms
+		quota := max(int64(c.cpuLimit*cgroupCPUPeriod), 1000)
+		if err := os.WriteFile(filepath.Join(dir, "cpu.max"), []byte(fmt.Sprintf("%d %d", quota, cgroupCPUPeriod)), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
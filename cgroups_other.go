@@ -0,0 +1,23 @@
+// Copyright (c) OpenFaaS Author(s) 2026. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+//go:build !linux
+
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"runtime"
+)
+
+// invocationCgroups is not supported on this platform
+type invocationCgroups struct{}
+
+func newInvocationCgroups(root string, memoryLimit int64, cpuLimit float64) (*invocationCgroups, error) {
+	return nil, fmt.Errorf("exec_memory_limit and exec_cpu_limit are not supported on %s", runtime.GOOS)
+}
+
+func (c *invocationCgroups) apply(cmd *exec.Cmd) (func(), error) {
+	return func() {}, nil
+}
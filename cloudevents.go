@@ -0,0 +1,168 @@
+// Copyright (c) OpenFaaS Author(s) 2026. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	cloudEventsSpecVersion = "1.0"
+
+	cloudEventsContentType      = "application/cloudevents+json"
+	cloudEventsBatchContentType = "application/cloudevents-batch+json"
+
+	// cloudEventsHeaderPrefix is used for attributes in binary mode
+	cloudEventsHeaderPrefix = "Ce-"
+)
+
+// cloudEventsRequired are the attributes every event must have
+var cloudEventsRequired = []string{"specversion", "id", "source", "type"}
+
+// readCloudEvent reads an event in either structured or binary mode, giving
+// a request with the event's data as the body and its content type, and the
+// attributes passed to the function as CE_<NAME> i.e. CE_TYPE.
+func readCloudEvent(r *http.Request) (*http.Request, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	var attributes map[string]string
+	switch {
+	case mediaType == cloudEventsBatchContentType:
+		return nil, fmt.Errorf("batched CloudEvents are not supported")
+	case mediaType == cloudEventsContentType:
+		event, data, err := readStructuredCloudEvent(r.Body)
+		if err != nil {
+			return nil, err
+		}
+		attributes = event
+
+		r = r.Clone(r.Context())
+		r.Body = io.NopCloser(bytes.NewReader(data))
+		r.ContentLength = int64(len(data))
+		r.Header.Set("Content-Type", attributes["datacontenttype"])
+		r.Header.Set("Content-Length", fmt.Sprintf("%d", len(data)))
+	case len(r.Header.Get(cloudEventsHeaderPrefix+"Specversion")) > 0:
+		attributes = make(map[string]string)
+		for name, values := range r.Header {
+			if attribute, ok := strings.CutPrefix(name, cloudEventsHeaderPrefix); ok && len(values) > 0 {
+				value, err := url.PathUnescape(values[0])
+				if err != nil {
+					value = values[0]
+				}
+				attributes[strings.ToLower(attribute)] = value
+			}
+		}
+		if contentType := r.Header.Get("Content-Type"); len(contentType) > 0 {
+			attributes["datacontenttype"] = contentType
+		}
+	default:
+		return nil, fmt.Errorf("the request is not a CloudEvent")
+	}
+
+	for _, name := range cloudEventsRequired {
+		if len(attributes[name]) == 0 {
+			return nil, fmt.Errorf("the CloudEvent has no %s attribute", name)
+		}
+	}
+	if major, _, _ := strings.Cut(attributes["specversion"], "."); major != "1" {
+		return nil, fmt.Errorf("unsupported CloudEvents specversion: %s", attributes["specversion"])
+	}
+
+	envs := make([]string, 0, len(attributes))
+	for name, value := range attributes {
+		envs = append(envs, "CE_"+strings.ToUpper(name)+"="+value)
+	}
+	return withFunctionEnvs(r, envs...), nil
+}
+
+// readStructuredCloudEvent gives the attributes of a JSON event as strings,
+// and its data from data_base64, or data where a JSON string is unquoted
+// unless the data is JSON
+func readStructuredCloudEvent(body io.Reader) (map[string]string, []byte, error) {
+	var event map[string]json.RawMessage
+	if err := json.NewDecoder(body).Decode(&event); err != nil {
+		return nil, nil, fmt.Errorf("invalid CloudEvent: %w", err)
+	}
+
+	attributes := make(map[string]string, len(event))
+	for name, raw := range event {
+		if name == "data" || name == "data_base64" {
+			continue
+		}
+		var value string
+		if err := json.Unmarshal(raw, &value); err != nil {
+			value = string(raw)
+		}
+		attributes[name] = value
+	}
+	if len(attributes["datacontenttype"]) == 0 {
+		attributes["datacontenttype"] = "application/json"
+	}
+
+	if raw, ok := event["data_base64"]; ok {
+		var encoded string
+		if err := json.Unmarshal(raw, &encoded); err != nil {
+			return nil, nil, fmt.Errorf("invalid data_base64: %w", err)
+		}
+		data, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid data_base64: %w", err)
+		}
+		return attributes, data, nil
+	}
+
+	data := []byte(event["data"])
+	mediaType, _, _ := mime.ParseMediaType(attributes["datacontenttype"])
+	isJSON := mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+	if !isJSON {
+		var text string
+		if err := json.Unmarshal(data, &text); err == nil {
+			data = []byte(text)
+		}
+	}
+	return attributes, data, nil
+}
+
+// cloudEventResponseWriter turns a successful response into a CloudEvent in
+// binary mode, where the body is the event's data
+type cloudEventResponseWriter struct {
+	http.ResponseWriter
+	eventType   string
+	source      string
+	wroteHeader bool
+}
+
+func (c *cloudEventResponseWriter) WriteHeader(status int) {
+	if !c.wroteHeader && status >= 200 && status < 300 {
+		header := c.ResponseWriter.Header()
+		header.Set(cloudEventsHeaderPrefix+"Specversion", cloudEventsSpecVersion)
+		header.Set(cloudEventsHeaderPrefix+"Id", newCallID())
+		header.Set(cloudEventsHeaderPrefix+"Source", c.source)
+		header.Set(cloudEventsHeaderPrefix+"Type", c.eventType)
+		header.Set(cloudEventsHeaderPrefix+"Time", time.Now().UTC().Format(time.RFC3339))
+	}
+	c.wroteHeader = true
+	c.ResponseWriter.WriteHeader(status)
+}
+
+func (c *cloudEventResponseWriter) Write(b []byte) (int, error) {
+	if !c.wroteHeader {
+		c.WriteHeader(http.StatusOK)
+	}
+	return c.ResponseWriter.Write(b)
+}
+
+// Unwrap allows http.ResponseController to reach the underlying writer
+func (c *cloudEventResponseWriter) Unwrap() http.ResponseWriter {
+	return c.ResponseWriter
+}
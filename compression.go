@@ -0,0 +1,105 @@
+// Copyright (c) OpenFaaS Author(s) 2026. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// zstdEncoder is shared between requests, EncodeAll is safe for concurrent use.
+var zstdEncoder, _ = zstd.NewWriter(nil)
+
+// acceptedEncodings parses an Accept-Encoding header into the q-value
+// given for each encoding, including "*" and any refused with q=0.
+func acceptedEncodings(header string) map[string]float64 {
+	accepted := map[string]float64{}
+
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		name := strings.ToLower(strings.TrimSpace(fields[0]))
+		if len(name) == 0 {
+			continue
+		}
+
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.ToLower(strings.TrimSpace(param))
+			if strings.HasPrefix(param, "q=") {
+				if v, err := strconv.ParseFloat(param[2:], 64); err == nil && v >= 0 && v <= 1 {
+					q = v
+				}
+			}
+		}
+
+		accepted[name] = q
+	}
+
+	return accepted
+}
+
+// encodingQuality gives the q-value of name, falling back to "*", and
+// whether the header gave either of them.
+func encodingQuality(accepted map[string]float64, name string) (float64, bool) {
+	if q, ok := accepted[name]; ok {
+		return q, true
+	}
+	q, ok := accepted["*"]
+	return q, ok
+}
+
+// negotiateEncoding picks zstd or gzip, whichever has the highest q-value,
+// preferring zstd on a tie. An empty string means the response is sent
+// as-is, because identity was given a higher q-value or nothing else is
+// acceptable. It also reports whether identity was refused with q=0.
+func negotiateEncoding(header string) (string, bool) {
+	accepted := acceptedEncodings(header)
+
+	encoding, best := "", 0.0
+	for _, name := range []string{"zstd", "gzip"} {
+		if q, _ := encodingQuality(accepted, name); q > best {
+			encoding, best = name, q
+		}
+	}
+
+	// Identity is acceptable when it is not named, but only as a fallback
+	identity, given := encodingQuality(accepted, "identity")
+	if given && identity > best {
+		return "", false
+	}
+	return encoding, given && identity == 0
+}
+
+// compressResponse encodes out with the encoding negotiated from the
+// caller's Accept-Encoding header when out is at least compressMinBytes in
+// length, or at any length when the caller refuses identity. The encoding
+// applied is returned, or an empty string if out was left unchanged.
+func compressResponse(config *WatchdogConfig, r *http.Request, out []byte) ([]byte, string, error) {
+	encoding, identityRefused := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+	if len(out) < config.compressMinBytes && !identityRefused {
+		return out, "", nil
+	}
+
+	switch encoding {
+	case "zstd":
+		return zstdEncoder.EncodeAll(out, make([]byte, 0, len(out))), "zstd", nil
+	case "gzip":
+		var b bytes.Buffer
+		gw := gzip.NewWriter(&b)
+		if _, err := gw.Write(out); err != nil {
+			return nil, "", err
+		}
+		if err := gw.Close(); err != nil {
+			return nil, "", err
+		}
+		return b.Bytes(), "gzip", nil
+	}
+
+	return out, "", nil
+}
@@ -0,0 +1,302 @@
+// Copyright (c) OpenFaaS Author(s) 2026. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// layeredEnv reads configuration from command-line flags, then environment
+// variables, then a config file, so that the file can hold the defaults
+// for an image and env or flags can override them for a deployment.
+type layeredEnv struct {
+	flags map[string]string
+	env   HasEnv
+	file  map[string]string
+}
+
+func (e layeredEnv) Getenv(key string) string {
+	if value, ok := e.flags[key]; ok {
+		return value
+	}
+	if value := e.env.Getenv(key); len(value) > 0 {
+		return value
+	}
+	return e.file[key]
+}
+
+// readConfigFile reads options from a flat YAML or TOML file, depending on
+// its extension, where the keys are the names of the environment variables.
+// Lists are joined with commas and multi-line strings keep their new-lines,
+// i.e. for routes.
+func readConfigFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var values map[string]string
+	switch filepath.Ext(path) {
+	case ".toml":
+		values, err = parseTOMLConfig(string(data))
+	default:
+		values, err = parseYAMLConfig(string(data))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return values, nil
+}
+
+// parseYAMLConfig reads a mapping of keys to scalars, sequences, or block
+// scalars with | or >. Nested mappings are not supported.
+func parseYAMLConfig(data string) (map[string]string, error) {
+	values := make(map[string]string)
+	lines := strings.Split(strings.ReplaceAll(data, "\r\n", "\n"), "\n")
+
+	for i := 0; i < len(lines); i++ {
+		line := stripComment(lines[i])
+		if len(strings.TrimSpace(line)) == 0 || line == "---" {
+			continue
+		}
+		if line[0] == ' ' || line[0] == '\t' {
+			return nil, fmt.Errorf("line %d: unexpected indentation", i+1)
+		}
+
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected key: value", i+1)
+		}
+		key = strings.TrimSpace(key)
+		value = strings.TrimSpace(value)
+
+		// Indented lines which follow belong to this key
+		var block []string
+		for i+1 < len(lines) && (len(strings.TrimSpace(lines[i+1])) == 0 || lines[i+1][0] == ' ' || lines[i+1][0] == '\t') {
+			i++
+			block = append(block, lines[i])
+		}
+
+		switch {
+		case value == "|" || value == "|-" || value == ">" || value == ">-":
+			text := yamlBlockScalar(block)
+			if value[0] == '>' {
+				text = strings.Join(strings.Split(text, "\n"), " ")
+			}
+			values[key] = text
+		case len(value) == 0:
+			items, err := yamlSequence(block)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", i+1, err)
+			}
+			values[key] = strings.Join(items, ",")
+		case strings.HasPrefix(value, "["):
+			items, err := splitFlowList(strings.TrimSuffix(strings.TrimPrefix(value, "["), "]"))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", i+1, err)
+			}
+			values[key] = strings.Join(items, ",")
+		case strings.HasPrefix(value, "{"):
+			return nil, fmt.Errorf("line %d: nested mappings are not supported for %s", i+1, key)
+		default:
+			scalar, err := unquoteConfigValue(value)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", i+1, err)
+			}
+			values[key] = scalar
+		}
+	}
+
+	return values, nil
+}
+
+// stripComment removes a # comment which is not inside quotes
+func stripComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return strings.TrimRight(line[:i], " \t")
+		}
+	}
+	return strings.TrimRight(line, " \t")
+}
+
+// yamlBlockScalar removes the indentation of the first line from each line
+func yamlBlockScalar(block []string) string {
+	indent := -1
+	var lines []string
+	for _, line := range block {
+		if len(strings.TrimSpace(line)) == 0 {
+			lines = append(lines, "")
+			continue
+		}
+		if indent < 0 {
+			indent = len(line) - len(strings.TrimLeft(line, " \t"))
+		}
+		if len(line) >= indent {
+			line = line[indent:]
+		}
+		lines = append(lines, line)
+	}
+	return strings.TrimRight(strings.Join(lines, "\n"), "\n")
+}
+
+func yamlSequence(block []string) ([]string, error) {
+	var items []string
+	for _, line := range block {
+		line = strings.TrimSpace(stripComment(line))
+		if len(line) == 0 {
+			continue
+		}
+		item, ok := strings.CutPrefix(line, "-")
+		if !ok {
+			return nil, fmt.Errorf("nested mappings are not supported")
+		}
+		value, err := unquoteConfigValue(strings.TrimSpace(item))
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, value)
+	}
+	return items, nil
+}
+
+// parseTOMLConfig reads key = value pairs of strings, numbers, booleans
+// and arrays. Tables are not supported.
+func parseTOMLConfig(data string) (map[string]string, error) {
+	values := make(map[string]string)
+	lines := strings.Split(strings.ReplaceAll(data, "\r\n", "\n"), "\n")
+
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimSpace(lines[i])
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			return nil, fmt.Errorf("line %d: tables are not supported", i+1)
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected key = value", i+1)
+		}
+		key = strings.Trim(strings.TrimSpace(key), `"`)
+		value = strings.TrimSpace(value)
+		start := i + 1
+
+		switch {
+		case strings.HasPrefix(value, `"""`) || strings.HasPrefix(value, `'''`):
+			delim := value[:3]
+			text := value[3:]
+			for !strings.Contains(text, delim) {
+				if i+1 >= len(lines) {
+					return nil, fmt.Errorf("line %d: unterminated multi-line string", start)
+				}
+				i++
+				text += "\n" + lines[i]
+			}
+			text = text[:strings.Index(text, delim)]
+			values[key] = strings.TrimRight(strings.TrimPrefix(text, "\n"), "\n")
+		case strings.HasPrefix(value, "["):
+			for !strings.Contains(stripComment(value), "]") {
+				if i+1 >= len(lines) {
+					return nil, fmt.Errorf("line %d: unterminated array", start)
+				}
+				i++
+				value += " " + strings.TrimSpace(stripComment(lines[i]))
+			}
+			value = stripComment(value)
+			items, err := splitFlowList(value[1:strings.LastIndex(value, "]")])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", start, err)
+			}
+			values[key] = strings.Join(items, ",")
+		default:
+			scalar, err := unquoteConfigValue(stripComment(value))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", start, err)
+			}
+			values[key] = scalar
+		}
+	}
+
+	return values, nil
+}
+
+// splitFlowList splits a comma-separated list of optionally quoted items
+func splitFlowList(list string) ([]string, error) {
+	var items []string
+	var current strings.Builder
+	var quote byte
+
+	flush := func() error {
+		item := strings.TrimSpace(current.String())
+		current.Reset()
+		if len(item) == 0 {
+			return nil
+		}
+		value, err := unquoteConfigValue(item)
+		if err != nil {
+			return err
+		}
+		items = append(items, value)
+		return nil
+	}
+
+	for i := 0; i < len(list); i++ {
+		c := list[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote == '"' && i+1 < len(list) {
+				current.WriteByte(c)
+				i++
+				c = list[i]
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == ',':
+			if err := flush(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		current.WriteByte(c)
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated string in list")
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// unquoteConfigValue unquotes a double-quoted string with escapes, or a
+// single-quoted string, other values are used as they are
+func unquoteConfigValue(value string) (string, error) {
+	switch {
+	case len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"':
+		unquoted, err := strconv.Unquote(value)
+		if err != nil {
+			return "", fmt.Errorf("invalid string %s", value)
+		}
+		return unquoted, nil
+	case len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'':
+		return strings.ReplaceAll(value[1:len(value)-1], "''", "'"), nil
+	}
+	return value, nil
+}
@@ -0,0 +1,52 @@
+// Copyright (c) OpenFaaS Author(s) 2026. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// consumerGroup runs the workers which invoke the function from a queue,
+// so that they stop taking work as soon as shutdown starts and the
+// invocations which they have in progress can complete before the watchdog
+// exits
+type consumerGroup struct {
+	stop     chan bool
+	stopOnce sync.Once
+	running  sync.WaitGroup
+}
+
+func newConsumerGroup() *consumerGroup {
+	return &consumerGroup{stop: make(chan bool)}
+}
+
+// Go runs a worker until Stop is called
+func (c *consumerGroup) Go(run func(cancel <-chan bool)) {
+	c.running.Add(1)
+	go func() {
+		defer c.running.Done()
+		run(c.stop)
+	}()
+}
+
+func (c *consumerGroup) Stop() {
+	c.stopOnce.Do(func() { close(c.stop) })
+}
+
+// Wait gives false when ctx is done before the workers have returned
+func (c *consumerGroup) Wait(ctx context.Context) bool {
+	done := make(chan struct{})
+	go func() {
+		c.running.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,64 @@
+// Copyright (c) OpenFaaS Author(s) 2026. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// makeCORSHandler adds CORS headers for allowed origins and answers
+// preflight requests directly, without invoking next.
+func makeCORSHandler(config *WatchdogConfig, next http.Handler) http.Handler {
+	allowMethods := config.allowedMethods
+	if len(allowMethods) == 0 {
+		allowMethods = defaultAllowedMethods
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if len(origin) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Origin")
+
+		allowOrigin, ok := corsAllowOrigin(config.corsAllowOrigins, origin)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Access-Control-Allow-Origin", allowOrigin)
+
+		if r.Method == http.MethodOptions && len(r.Header.Get("Access-Control-Request-Method")) > 0 {
+			w.Header().Set("Access-Control-Allow-Methods", strings.Join(allowMethods, ", "))
+
+			if len(config.corsAllowHeaders) > 0 {
+				w.Header().Set("Access-Control-Allow-Headers", strings.Join(config.corsAllowHeaders, ", "))
+			} else if requested := r.Header.Get("Access-Control-Request-Headers"); len(requested) > 0 {
+				w.Header().Set("Access-Control-Allow-Headers", requested)
+			}
+
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// corsAllowOrigin gives the value for Access-Control-Allow-Origin when
+// origin is in the allowed list.
+func corsAllowOrigin(allowOrigins []string, origin string) (string, bool) {
+	for _, allowed := range allowOrigins {
+		if allowed == "*" {
+			return "*", true
+		}
+		if strings.EqualFold(allowed, origin) {
+			return origin, true
+		}
+	}
+	return "", false
+}
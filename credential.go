@@ -0,0 +1,81 @@
+// Copyright (c) OpenFaaS Author(s) 2026. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+)
+
+// processCredential is the user and group which fprocess runs as, resolved
+// once at startup from exec_user and exec_group
+type processCredential struct {
+	uid    uint32
+	gid    uint32
+	groups []uint32
+}
+
+// lookupCredential accepts names or numeric IDs. Without a group the
+// user's primary and supplementary groups are used, otherwise only the group
+// given, so that the groups of the watchdog's user are never inherited.
+func lookupCredential(userName, groupName string) (*processCredential, error) {
+	u, err := user.Lookup(userName)
+	if err != nil {
+		if u, err = user.LookupId(userName); err != nil {
+			return nil, fmt.Errorf("exec_user: %w", err)
+		}
+	}
+
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("exec_user: %s has a non-numeric uid: %s", userName, u.Uid)
+	}
+
+	cred := &processCredential{uid: uint32(uid)}
+
+	if len(groupName) > 0 {
+		g, err := user.LookupGroup(groupName)
+		if err != nil {
+			if g, err = user.LookupGroupId(groupName); err != nil {
+				return nil, fmt.Errorf("exec_group: %w", err)
+			}
+		}
+		gid, err := strconv.ParseUint(g.Gid, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("exec_group: %s has a non-numeric gid: %s", groupName, g.Gid)
+		}
+		cred.gid = uint32(gid)
+		return cred, nil
+	}
+
+	gid, err := strconv.ParseUint(u.Gid, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("exec_user: %s has a non-numeric gid: %s", userName, u.Gid)
+	}
+	cred.gid = uint32(gid)
+
+	if ids, err := u.GroupIds(); err == nil {
+		for _, id := range ids {
+			if n, err := strconv.ParseUint(id, 10, 32); err == nil && uint32(n) != cred.gid {
+				cred.groups = append(cred.groups, uint32(n))
+			}
+		}
+	}
+	return cred, nil
+}
+
+// chownTree gives files written by the watchdog for the function, such as
+// uploads, to the user which it runs as
+func (c *processCredential) chownTree(root string) error {
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		return os.Chown(path, int(c.uid), int(c.gid))
+	})
+}
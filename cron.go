@@ -0,0 +1,255 @@
+// Copyright (c) OpenFaaS Author(s) 2026. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/openfaas/classic-watchdog/logging"
+)
+
+// cronSchedule is a standard five field cron expression of minute, hour,
+// day of month, month and day of week, or @every <duration>. As in Vixie
+// cron, when both days are restricted a time matches either of them.
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	domStar, dowStar              bool
+
+	every time.Duration
+}
+
+var cronShortcuts = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var cronMonths = []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}
+var cronDays = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+func parseCronSchedule(spec string) (*cronSchedule, error) {
+	spec = strings.TrimSpace(spec)
+	if every, ok := strings.CutPrefix(spec, "@every "); ok {
+		d, err := time.ParseDuration(strings.TrimSpace(every))
+		if err != nil || d < time.Second {
+			return nil, fmt.Errorf("invalid @every duration: %q", every)
+		}
+		return &cronSchedule{every: d}, nil
+	}
+	if expanded, ok := cronShortcuts[strings.ToLower(spec)]; ok {
+		spec = expanded
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("want five fields of minute, hour, day of month, month and day of week, got: %q", spec)
+	}
+
+	s := &cronSchedule{
+		domStar: fields[2] == "*" || fields[2] == "?",
+		dowStar: fields[4] == "*" || fields[4] == "?",
+	}
+	var err error
+	if s.minute, err = parseCronField(fields[0], 0, 59, nil); err != nil {
+		return nil, fmt.Errorf("minute: %w", err)
+	}
+	if s.hour, err = parseCronField(fields[1], 0, 23, nil); err != nil {
+		return nil, fmt.Errorf("hour: %w", err)
+	}
+	if s.dom, err = parseCronField(fields[2], 1, 31, nil); err != nil {
+		return nil, fmt.Errorf("day of month: %w", err)
+	}
+	if s.month, err = parseCronField(fields[3], 1, 12, cronMonths); err != nil {
+		return nil, fmt.Errorf("month: %w", err)
+	}
+	if s.dow, err = parseCronField(fields[4], 0, 7, cronDays); err != nil {
+		return nil, fmt.Errorf("day of week: %w", err)
+	}
+	// 7 is another name for Sunday
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	return s, nil
+}
+
+// parseCronField gives a bit for each value matched by a list of *, n, n-m
+// and any of these with a /step. names, when given, start at min.
+func parseCronField(field string, min int, max int, names []string) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		valueRange, stepValue, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepValue); err != nil || step < 1 {
+				return 0, fmt.Errorf("invalid step: %q", part)
+			}
+		}
+
+		low, high := min, max
+		if valueRange != "*" && valueRange != "?" {
+			first, last, isRange := strings.Cut(valueRange, "-")
+			var err error
+			if low, err = parseCronValue(first, min, names); err != nil {
+				return 0, err
+			}
+			high = low
+			if isRange {
+				if high, err = parseCronValue(last, min, names); err != nil {
+					return 0, err
+				}
+			} else if hasStep {
+				high = max
+			}
+		}
+		if low < min || high > max || low > high {
+			return 0, fmt.Errorf("out of range %d-%d: %q", min, max, part)
+		}
+
+		for v := low; v <= high; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+func parseCronValue(value string, min int, names []string) (int, error) {
+	for i, name := range names {
+		if strings.EqualFold(value, name) {
+			return min + i, nil
+		}
+	}
+	v, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value: %q", value)
+	}
+	return v, nil
+}
+
+// next gives the first time after t which matches, in t's location. A
+// schedule which can never match, such as 30 February, gives a zero time.
+func (s *cronSchedule) next(t time.Time) time.Time {
+	if s.every > 0 {
+		return t.Add(s.every)
+	}
+
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if s.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !s.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if s.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if s.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+func (s *cronSchedule) dayMatches(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	if !s.domStar && !s.dowStar {
+		return dom || dow
+	}
+	return dom && dow
+}
+
+// cronRunner invokes the function on a schedule with a POST of payload. A
+// run is skipped while the previous one is still going.
+type cronRunner struct {
+	schedule *cronSchedule
+	spec     string
+	payload  string
+	handler  http.Handler
+	running  int32
+	invoking sync.WaitGroup
+}
+
+func newCronRunner(schedule *cronSchedule, spec string, payload string, handler http.Handler) *cronRunner {
+	return &cronRunner{
+		schedule: schedule,
+		spec:     spec,
+		payload:  payload,
+		handler:  handler,
+	}
+}
+
+// Run waits for each scheduled time until cancel is closed, then for the
+// invocation in progress
+func (c *cronRunner) Run(cancel <-chan bool) {
+	logging.Infof("Invoking the function on schedule: %s", c.spec)
+
+	for {
+		next := c.schedule.next(time.Now())
+		if next.IsZero() {
+			logging.Errorf("cron_schedule %q never matches", c.spec)
+			return
+		}
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-timer.C:
+		case <-cancel:
+			timer.Stop()
+			c.invoking.Wait()
+			return
+		}
+
+		if !atomic.CompareAndSwapInt32(&c.running, 0, 1) {
+			logging.Warnf("Skipping scheduled invocation at %s, the previous one is still running", next.Format(time.RFC3339))
+			continue
+		}
+		c.invoking.Add(1)
+		go func() {
+			defer c.invoking.Done()
+			defer atomic.StoreInt32(&c.running, 0)
+			c.invoke(next)
+		}()
+	}
+}
+
+func (c *cronRunner) invoke(scheduled time.Time) {
+	req, err := http.NewRequest(http.MethodPost, "http://127.0.0.1/", strings.NewReader(c.payload))
+	if err != nil {
+		logging.Errorf("Unable to create scheduled invocation: %s", err.Error())
+		return
+	}
+	req.RemoteAddr = "127.0.0.1:0"
+	req.Header.Set("X-Cron-Schedule", c.spec)
+	req.Header.Set("X-Cron-Time", scheduled.Format(time.RFC3339))
+
+	res := &responseBuffer{header: http.Header{}}
+	c.handler.ServeHTTP(res, req)
+	if res.status == 0 {
+		res.status = http.StatusOK
+	}
+
+	if res.status < 200 || res.status > 299 {
+		logging.Errorf("Scheduled invocation gave status %d: %s", res.status, strings.TrimSpace(res.body.String()))
+		return
+	}
+	logging.Infof("Scheduled invocation complete, status: %d - %s: %s", res.status, callIDHeader, req.Header.Get(callIDHeader))
+}
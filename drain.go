@@ -0,0 +1,49 @@
+// Copyright (c) OpenFaaS Author(s) 2026. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"sync/atomic"
+
+	"github.com/openfaas/classic-watchdog/logging"
+)
+
+// draining is set by /_/drain, new invocations are rejected while it is 1
+var draining int32
+
+// makeDrainHandler takes the replica out of rotation on a POST, by removing
+// the lock file and rejecting new invocations, without stopping the
+// process. A DELETE puts it back, and a GET reports whether it is drained.
+func makeDrainHandler(suppressLock bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			if atomic.SwapInt32(&draining, 1) == 0 {
+				logging.Warnf("Draining: new invocations will be rejected")
+				if err := markUnhealthy(); err != nil && !os.IsNotExist(err) {
+					logging.Errorf("Unable to mark server as unhealthy: %s", err.Error())
+				}
+			}
+		case http.MethodDelete:
+			if atomic.SwapInt32(&draining, 0) == 1 {
+				logging.Infof("No longer draining")
+				if suppressLock {
+					atomic.StoreInt32(&acceptingConnections, 1)
+				} else if path, err := createLockFile(); err != nil {
+					logging.Errorf("Unable to write %s: %s", path, err.Error())
+				}
+			}
+		case http.MethodGet:
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, "{\"draining\":%t}\n", atomic.LoadInt32(&draining) == 1)
+	})
+}
@@ -0,0 +1,105 @@
+// Copyright (c) OpenFaaS Author(s) 2026. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/openfaas/classic-watchdog/logging"
+)
+
+// envFile holds the variables from an env_file, which are read again when
+// the file's modification time or size changes, so that an agent can rotate
+// credentials without a restart.
+type envFile struct {
+	path string
+
+	lock    sync.Mutex
+	modTime time.Time
+	size    int64
+	envs    []string
+}
+
+func newEnvFile(path string) *envFile {
+	return &envFile{path: path}
+}
+
+// Envs gives the variables from the file, reading it only when it has changed
+func (f *envFile) Envs() ([]string, error) {
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return nil, err
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if f.envs != nil && info.ModTime().Equal(f.modTime) && info.Size() == f.size {
+		return f.envs, nil
+	}
+
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		return nil, err
+	}
+	envs, err := parseEnvFile(string(data))
+	if err != nil {
+		return nil, err
+	}
+
+	if f.envs != nil {
+		logging.Infof("Reloaded env_file: %s", f.path)
+	}
+	f.envs = envs
+	f.modTime = info.ModTime()
+	f.size = info.Size()
+	return f.envs, nil
+}
+
+// Handler passes the variables to the function, or fails the request when
+// the file cannot be read
+func (f *envFile) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		envs, err := f.Envs()
+		if err != nil {
+			logging.Errorf("Unable to read env_file: %s", err.Error())
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Unable to read env_file"))
+			return
+		}
+
+		next.ServeHTTP(w, withFunctionEnvs(r, envs...))
+	})
+}
+
+// parseEnvFile reads KEY=VALUE lines, skipping blank lines and # comments.
+// An "export " prefix and quotes around the value are removed, as in the
+// files written for docker --env-file and shell scripts.
+func parseEnvFile(data string) ([]string, error) {
+	envs := []string{}
+	for i, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		name, value, ok := strings.Cut(strings.TrimPrefix(line, "export "), "=")
+		name = strings.TrimSpace(name)
+		if !ok || len(name) == 0 {
+			return nil, fmt.Errorf("line %d: want KEY=VALUE", i+1)
+		}
+
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		envs = append(envs, name+"="+value)
+	}
+	return envs, nil
+}
@@ -0,0 +1,42 @@
+// Copyright (c) OpenFaaS Author(s) 2026. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// responseETag gives a strong ETag over the bytes of a response, which is
+// computed after compression so that each encoding has its own tag.
+func responseETag(out []byte) string {
+	sum := sha256.Sum256(out)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// notModified reports whether r's If-None-Match matches etag, using the
+// weak comparison which RFC 9110 gives for If-None-Match. Only GET and HEAD
+// requests can be answered with a 304.
+func notModified(r *http.Request, etag string) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	ifNoneMatch := strings.TrimSpace(r.Header.Get("If-None-Match"))
+	if len(ifNoneMatch) == 0 || len(etag) == 0 {
+		return false
+	}
+	if ifNoneMatch == "*" {
+		return true
+	}
+
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(candidate), "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,226 @@
+// Copyright (c) OpenFaaS Author(s) 2026. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/openfaas/classic-watchdog/metrics"
+)
+
+// execResult is the output of a process along with the timings of each
+// stage of its execution.
+type execResult struct {
+	// stdout holds stdout, and stderr when the output is combined
+	stdout []byte
+
+	// stderr is only populated when the output is not combined
+	stderr []byte
+
+	// started is when the fork was requested
+	started time.Time
+
+	// forked is when the process had been started
+	forked time.Time
+
+	// firstByte is when the first byte of output was read, or the
+	// zero value if there was no output
+	firstByte time.Time
+
+	// outputClosed is when the process closed its output
+	outputClosed time.Time
+
+	// exited is when the process had exited and been reaped
+	exited time.Time
+
+	// userTime and systemTime are the CPU time used by the process
+	userTime   time.Duration
+	systemTime time.Duration
+
+	// maxRSS is the peak resident set size of the process in bytes, or 0
+	// where it is not available
+	maxRSS int64
+
+	// exitCode is the exit code of the process, or 128 plus the signal
+	// number when it was killed by a signal as reported by a shell
+	exitCode int
+
+	// fileSizeExceeded is set when the process was killed for exceeding
+	// exec_rlimit_fsize
+	fileSizeExceeded bool
+}
+
+// rusageHeader gives the value for X-Exec-Rusage i.e.
+// maxrss=1884160;utime=0.004000;stime=0.002000
+func (e execResult) rusageHeader() string {
+	return fmt.Sprintf("maxrss=%d;utime=%f;stime=%f", e.maxRSS, e.userTime.Seconds(), e.systemTime.Seconds())
+}
+
+// observeExec records the timings of res, stages which did not complete
+// are skipped.
+func observeExec(m *metrics.Exec, res execResult) {
+	if !res.forked.IsZero() {
+		m.ForkDuration.Observe(res.forked.Sub(res.started).Seconds())
+	}
+	if !res.exited.IsZero() {
+		m.ExecDuration.Observe(res.exited.Sub(res.forked).Seconds())
+	}
+	if !res.firstByte.IsZero() {
+		m.StdoutCopyDuration.Observe(res.outputClosed.Sub(res.firstByte).Seconds())
+	}
+
+	if res.forked.IsZero() {
+		m.ExecErrors.Inc()
+	}
+
+	if !res.exited.IsZero() {
+		m.Exits.WithLabelValues(strconv.Itoa(res.exitCode)).Inc()
+		m.CPUUserSeconds.Observe(res.userTime.Seconds())
+		m.CPUSystemSeconds.Observe(res.systemTime.Seconds())
+		if res.maxRSS > 0 {
+			m.MaxRSSBytes.Observe(float64(res.maxRSS))
+		}
+		if res.fileSizeExceeded {
+			m.RlimitExceeded.WithLabelValues("fsize").Inc()
+		}
+	}
+}
+
+// exitCode gives the exit code of a process, when it was killed by a
+// signal this is 128 plus the signal number i.e. 137 for SIGKILL.
+func exitCode(state *os.ProcessState) int {
+	if status, ok := state.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return 128 + int(status.Signal())
+	}
+	return state.ExitCode()
+}
+
+// runProcess starts cmd, collects its output and waits for it to exit.
+// Stdin must already have been configured by the caller. When stderrOut is
+// not nil and the output is not combined, stderr is written to it as the
+// process runs instead of being collected. The output goes through the file
+// given by openOutput, which is read from the first file returned, such as
+// a pipe or a pseudo-terminal. When stdoutOut is set, stdout is written to
+// it as it is read instead of being kept in the result.
+func runProcess(cmd *exec.Cmd, combineOutput bool, stderrOut io.Writer, openOutput func() (*os.File, *os.File, error), stdoutOut io.Writer) (execResult, error) {
+	res := execResult{}
+
+	pr, pw, err := openOutput()
+	if err != nil {
+		return res, err
+	}
+	defer pr.Close()
+
+	var stderr bytes.Buffer
+	cmd.Stdout = pw
+	if combineOutput {
+		cmd.Stderr = pw
+	} else if stderrOut != nil {
+		cmd.Stderr = stderrOut
+	} else {
+		cmd.Stderr = &stderr
+	}
+
+	res.started = time.Now()
+	if err := cmd.Start(); err != nil {
+		pw.Close()
+		return res, err
+	}
+	res.forked = time.Now()
+
+	// The child holds its own copy of the write end, closing ours means
+	// that reading will end when the child exits or closes its output.
+	pw.Close()
+
+	var stdout bytes.Buffer
+	var out io.Writer = &stdout
+	if stdoutOut != nil {
+		out = stdoutOut
+	}
+	buf := make([]byte, 32*1024)
+	for {
+		n, readErr := pr.Read(buf)
+		if n > 0 {
+			if res.firstByte.IsZero() {
+				res.firstByte = time.Now()
+			}
+			out.Write(buf[:n])
+		}
+		// A pseudo-terminal gives EIO once the process has closed it
+		if readErr == io.EOF || errors.Is(readErr, syscall.EIO) {
+			break
+		}
+		if readErr != nil {
+			err = readErr
+			break
+		}
+	}
+	res.outputClosed = time.Now()
+
+	waitErr := cmd.Wait()
+	res.exited = time.Now()
+
+	if cmd.ProcessState != nil {
+		res.userTime = cmd.ProcessState.UserTime()
+		res.systemTime = cmd.ProcessState.SystemTime()
+		res.maxRSS, _ = maxRSSBytes(cmd.ProcessState)
+		res.exitCode = exitCode(cmd.ProcessState)
+		res.fileSizeExceeded = exceededFileSize(cmd.ProcessState)
+	}
+
+	res.stdout = stdout.Bytes()
+	res.stderr = stderr.Bytes()
+
+	if waitErr != nil {
+		return res, waitErr
+	}
+	return res, err
+}
+
+// execRetryBackoff is the delay before the first retry, doubled for each
+// retry after that
+const execRetryBackoff = 100 * time.Millisecond
+
+// shouldRetryExec checks a failed run against exec_retry_on, where a
+// process which could not be started is an exec error
+func shouldRetryExec(config *WatchdogConfig, res execResult, err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return res.forked.IsZero() && config.execRetryExecError
+	}
+
+	for _, code := range config.execRetryExitCodes {
+		if code == exitErr.ExitCode() {
+			return true
+		}
+	}
+	return false
+}
+
+// retryCommand copies cmd to run it again, with the request body given as
+// stdin, or /dev/null when it is nil. The process is killed when ctx is done.
+func retryCommand(ctx context.Context, cmd *exec.Cmd, stdin []byte) *exec.Cmd {
+	retry := exec.CommandContext(ctx, cmd.Path)
+	retry.Args = cmd.Args
+	retry.Env = cmd.Env
+	retry.Dir = cmd.Dir
+	retry.SysProcAttr = cmd.SysProcAttr
+	if stdin != nil {
+		retry.Stdin = bytes.NewReader(stdin)
+	}
+	return retry
+}
@@ -0,0 +1,296 @@
+// Copyright (c) OpenFaaS Author(s) 2026. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/openfaas/classic-watchdog/logging"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// grpcInvokePath is the method of the service below, where each message is
+// a google.protobuf.BytesValue:
+//
+//	service Watchdog {
+//	  rpc Invoke(stream google.protobuf.BytesValue) returns (stream google.protobuf.BytesValue);
+//	}
+const grpcInvokePath = "/openfaas.watchdog.v1.Watchdog/Invoke"
+
+// grpcMaxMessageBytes is the default limit of gRPC clients and servers
+const grpcMaxMessageBytes = 4 << 20
+
+// gRPC status codes
+const (
+	grpcOK                = 0
+	grpcUnknown           = 2
+	grpcInvalidArgument   = 3
+	grpcDeadlineExceeded  = 4
+	grpcNotFound          = 5
+	grpcPermissionDenied  = 7
+	grpcResourceExhausted = 8
+	grpcUnimplemented     = 12
+	grpcUnavailable       = 14
+	grpcUnauthenticated   = 16
+)
+
+// grpcServer serves invocations over gRPC on a port of its own, with the
+// TLS configuration of the main listener when it has one, or otherwise
+// with prior knowledge HTTP/2 as used by gRPC clients without TLS
+type grpcServer struct {
+	s *http.Server
+}
+
+func newGRPCServer(config *WatchdogConfig, tlsConfig *tls.Config, next http.Handler) *grpcServer {
+	var protocols http.Protocols
+	if tlsConfig != nil {
+		protocols.SetHTTP2(true)
+	} else {
+		protocols.SetUnencryptedHTTP2(true)
+	}
+
+	return &grpcServer{
+		s: &http.Server{
+			Addr:              net.JoinHostPort(config.listenAddr, strconv.Itoa(config.grpcPort)),
+			Handler:           makeGRPCHandler(next),
+			IdleTimeout:       config.idleTimeout,
+			ReadHeaderTimeout: config.readHeaderTimeout,
+			MaxHeaderBytes:    config.maxHeaderBytes,
+			Protocols:         &protocols,
+			TLSConfig:         tlsConfig,
+		},
+	}
+}
+
+// Serve runs until Shutdown is called
+func (g *grpcServer) Serve() {
+	logging.Infof("gRPC listening on: %s", g.s.Addr)
+
+	go func() {
+		var err error
+		if g.s.TLSConfig != nil {
+			err = g.s.ListenAndServeTLS("", "")
+		} else {
+			err = g.s.ListenAndServe()
+		}
+		if err != http.ErrServerClosed {
+			logging.Fatalf("gRPC error ListenAndServe: %v", err)
+		}
+	}()
+}
+
+// Shutdown stops accepting calls and waits for those in progress, until
+// ctx is done
+func (g *grpcServer) Shutdown(ctx context.Context) error {
+	return g.s.Shutdown(ctx)
+}
+
+// makeGRPCHandler maps the Invoke method onto next, so that the same
+// authentication, limits and metrics apply as for HTTP. The request
+// messages are joined into the body and each write of the function's
+// output is sent as a message. The function is run once the whole body has
+// been read and its output is written once it exits, as for HTTP, so
+// messages are not exchanged while it runs. A response status of 400 or
+// above gives a gRPC status with the body as its message.
+func makeGRPCHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/grpc")
+		if r.Method != http.MethodPost || r.URL.Path != grpcInvokePath {
+			writeGRPCStatus(w, grpcUnimplemented, "unknown method: "+r.URL.Path)
+			return
+		}
+
+		req := r.Clone(r.Context())
+		req.URL.Path = "/"
+		req.URL.RawPath = ""
+		req.RequestURI = "/"
+		req.Body = &grpcMessageReader{r: r.Body}
+		req.ContentLength = -1
+		req.Header.Set("Content-Type", "application/octet-stream")
+		req.Header.Del("Content-Length")
+
+		rw := &grpcResponseWriter{w: w, header: http.Header{}}
+		next.ServeHTTP(rw, req)
+
+		if rw.status == 0 {
+			rw.WriteHeader(http.StatusOK)
+		}
+		if rw.status >= http.StatusBadRequest {
+			writeGRPCStatus(w, grpcStatusCode(rw.status), strings.TrimSpace(rw.message.String()))
+			return
+		}
+		writeGRPCStatus(w, grpcOK, "")
+	})
+}
+
+// writeGRPCStatus sends the status as trailers, or as headers when nothing
+// has been written
+func writeGRPCStatus(w http.ResponseWriter, code int, message string) {
+	w.Header().Set(http.TrailerPrefix+"Grpc-Status", strconv.Itoa(code))
+	if len(message) > 0 {
+		w.Header().Set(http.TrailerPrefix+"Grpc-Message", url.PathEscape(message))
+	}
+}
+
+// grpcStatusCode maps the status of a failed invocation, following the
+// gRPC guidance for HTTP status codes where the watchdog's meaning differs
+func grpcStatusCode(status int) int {
+	switch status {
+	case http.StatusBadRequest:
+		return grpcInvalidArgument
+	case http.StatusUnauthorized:
+		return grpcUnauthenticated
+	case http.StatusForbidden:
+		return grpcPermissionDenied
+	case http.StatusNotFound:
+		return grpcNotFound
+	case http.StatusRequestTimeout, http.StatusGatewayTimeout:
+		return grpcDeadlineExceeded
+	case http.StatusRequestEntityTooLarge, http.StatusTooManyRequests:
+		return grpcResourceExhausted
+	case http.StatusBadGateway, http.StatusServiceUnavailable:
+		return grpcUnavailable
+	}
+	return grpcUnknown
+}
+
+// grpcMessageReader gives the values of length-prefixed BytesValue messages
+type grpcMessageReader struct {
+	r       io.ReadCloser
+	pending []byte
+}
+
+func (g *grpcMessageReader) Read(p []byte) (int, error) {
+	for len(g.pending) == 0 {
+		var prefix [5]byte
+		if _, err := io.ReadFull(g.r, prefix[:]); err != nil {
+			if err == io.ErrUnexpectedEOF {
+				return 0, fmt.Errorf("truncated gRPC message")
+			}
+			return 0, err
+		}
+		if prefix[0] != 0 {
+			return 0, fmt.Errorf("compressed gRPC messages are not supported")
+		}
+		size := binary.BigEndian.Uint32(prefix[1:])
+		if size > grpcMaxMessageBytes {
+			return 0, &http.MaxBytesError{Limit: grpcMaxMessageBytes}
+		}
+
+		message := make([]byte, size)
+		if _, err := io.ReadFull(g.r, message); err != nil {
+			return 0, fmt.Errorf("truncated gRPC message")
+		}
+		value, err := decodeBytesValue(message)
+		if err != nil {
+			return 0, err
+		}
+		g.pending = value
+	}
+
+	n := copy(p, g.pending)
+	g.pending = g.pending[n:]
+	return n, nil
+}
+
+func (g *grpcMessageReader) Close() error {
+	return g.r.Close()
+}
+
+// decodeBytesValue gives field 1 of a google.protobuf.BytesValue, unknown
+// fields are skipped
+func decodeBytesValue(message []byte) ([]byte, error) {
+	var value []byte
+	for len(message) > 0 {
+		num, typ, n := protowire.ConsumeTag(message)
+		if n < 0 {
+			return nil, fmt.Errorf("invalid BytesValue: %w", protowire.ParseError(n))
+		}
+		message = message[n:]
+
+		if num == 1 && typ == protowire.BytesType {
+			value, n = protowire.ConsumeBytes(message)
+		} else {
+			n = protowire.ConsumeFieldValue(num, typ, message)
+		}
+		if n < 0 {
+			return nil, fmt.Errorf("invalid BytesValue: %w", protowire.ParseError(n))
+		}
+		message = message[n:]
+	}
+	return value, nil
+}
+
+// grpcResponseWriter frames the output of a successful invocation as
+// messages, and keeps the body of a failed one for the status message
+type grpcResponseWriter struct {
+	w       http.ResponseWriter
+	header  http.Header
+	status  int
+	message strings.Builder
+}
+
+func (g *grpcResponseWriter) Header() http.Header {
+	return g.header
+}
+
+// WriteHeader passes the function's headers on as response metadata
+func (g *grpcResponseWriter) WriteHeader(status int) {
+	if g.status != 0 {
+		return
+	}
+	g.status = status
+	if status >= http.StatusBadRequest {
+		return
+	}
+
+	for name, values := range g.header {
+		switch http.CanonicalHeaderKey(name) {
+		case "Content-Type", "Content-Length", "Transfer-Encoding":
+			continue
+		}
+		g.w.Header()[name] = values
+	}
+	g.w.WriteHeader(http.StatusOK)
+}
+
+func (g *grpcResponseWriter) Write(b []byte) (int, error) {
+	if g.status == 0 {
+		g.WriteHeader(http.StatusOK)
+	}
+	if g.status >= http.StatusBadRequest {
+		if g.message.Len() < 1024 {
+			g.message.Write(b)
+		}
+		return len(b), nil
+	}
+
+	message := protowire.AppendBytes(protowire.AppendTag(nil, 1, protowire.BytesType), b)
+	frame := make([]byte, 5, 5+len(message))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(message)))
+	if _, err := g.w.Write(append(frame, message...)); err != nil {
+		return 0, err
+	}
+	g.Flush()
+	return len(b), nil
+}
+
+// Flush sends each message as it is written, for streamed output
+func (g *grpcResponseWriter) Flush() {
+	http.NewResponseController(g.w).Flush()
+}
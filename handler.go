@@ -5,20 +5,23 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
-	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
-	limiter "github.com/openfaas/faas-middleware/concurrency-limiter"
-
+	"github.com/openfaas/classic-watchdog/logging"
 	"github.com/openfaas/classic-watchdog/types"
 )
 
@@ -38,12 +41,18 @@ func buildFunctionInput(config *WatchdogConfig, r *http.Request) ([]byte, error)
 	defer r.Body.Close()
 
 	if err != nil {
-		log.Println(err)
+		logging.Errorf("%s", err)
 		return res, err
 	}
 
 	requestBytes, err = ioutil.ReadAll(r.Body)
-	if config.marshalRequest {
+	if err != nil {
+		return res, err
+	}
+
+	if config.marshalRequestV2 {
+		res, err = types.MarshalRequestV2(requestBytes, r.Method, r.URL, r.Header)
+	} else if config.marshalRequest {
 		marshalRes, marshalErr := types.MarshalRequest(requestBytes, &r.Header)
 		err = marshalErr
 		res = marshalRes
@@ -54,51 +63,245 @@ func buildFunctionInput(config *WatchdogConfig, r *http.Request) ([]byte, error)
 	return res, err
 }
 
-// debugHeaders prints HTTP headers as key/value pairs
-func debugHeaders(source *http.Header, direction string) {
+// bodyErrorStatus gives the HTTP status for an error encountered whilst
+// reading the request body.
+func bodyErrorStatus(err error) int {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) || errors.Is(err, errFormTooLarge) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
+
+// limitRequestBody caps the body of r at limit bytes when limit is set. It
+// gives false once it has rejected the request with a 413, which is done on
+// Content-Length before the body is read so that callers sending
+// "Expect: 100-continue" never transmit the body.
+func limitRequestBody(w http.ResponseWriter, r *http.Request, limit int64) bool {
+	if limit <= 0 {
+		return true
+	}
+	if r.ContentLength > limit {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		fmt.Fprintf(w, "Request body exceeds the limit of %d bytes\n", limit)
+		return false
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, limit)
+	return true
+}
+
+// exitStatusCode maps the exit code of a failed fprocess onto a HTTP status
+// using exit_code_map, any other failure gives a 500.
+func exitStatusCode(config *WatchdogConfig, err error) int {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		if status, ok := config.exitCodeMap[exitErr.ExitCode()]; ok {
+			return status
+		}
+	}
+	return http.StatusInternalServerError
+}
+
+// redactedValue replaces the value of headers matched by log_redact_headers
+const redactedValue = "[redacted]"
+
+// debugHeaders prints HTTP headers as key/value pairs, the values of
+// headers matching one of the redact patterns are hidden.
+func debugHeaders(source *http.Header, direction string, redact []string) {
 	for k, vv := range *source {
-		fmt.Printf("[%s] %s=%s\n", direction, k, vv)
+		if matchHeaderPattern(redact, k) {
+			logging.Debugf("[%s] %s=[%s]", direction, k, redactedValue)
+			continue
+		}
+		logging.Debugf("[%s] %s=%s", direction, k, vv)
+	}
+}
+
+// shellPath runs the process when fprocess_shell is set
+const shellPath = "/bin/sh"
+
+// processArgs splits process on spaces, or passes it whole to the shell
+// with fprocess_shell so that pipes, redirects and globs work
+func processArgs(config *WatchdogConfig, process string) []string {
+	if config.fprocessShell {
+		return []string{shellPath, "-c", process}
 	}
+	return strings.Split(process, " ")
 }
 
 func pipeRequest(config *WatchdogConfig, w http.ResponseWriter, r *http.Request, method string) {
 	startTime := time.Now()
 
-	parts := strings.Split(config.faasProcess, " ")
+	process := resolveProcess(config, r.URL.Path)
+	if len(process) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "No process configured for path: %s\n", r.URL.Path)
+		return
+	}
 
 	ri := &requestInfo{}
 
-	if config.debugHeaders {
-		debugHeaders(&r.Header, "in")
+	var trace *invocationTrace
+	if tracer != nil {
+		trace = newInvocationTrace(r)
+	}
+
+	if logging.Enabled(logging.LevelDebug) {
+		debugHeaders(&r.Header, "in", config.logRedactHeaders)
 	}
 
-	log.Println("Forking fprocess.")
+	logging.Infof("Forking fprocess.")
 
-	targetCmd := exec.Command(parts[0], parts[1:]...)
+	targetCmd, releaseCgroup, attrErr := newProcessCommand(config, process, config.execPTY)
+	if attrErr != nil {
+		logging.Errorf("Unable to start fprocess: %s", attrErr.Error())
+		ri.headerWritten = true
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(attrErr.Error()))
+		return
+	}
+	defer releaseCgroup()
+	openOutput := os.Pipe
+	if config.execPTY {
+		openOutput = openPTY
+	}
 
 	envs := getAdditionalEnvs(config, r, method)
+
+	if config.multipartForm && isMultipartForm(r) {
+		formDir, formErr := os.MkdirTemp("", "fwatchdog-form-")
+		var formEnvs []string
+		if formErr == nil {
+			defer os.RemoveAll(formDir)
+			formEnvs, formErr = writeMultipartForm(r, formDir)
+			if formErr == nil && config.execCredential != nil {
+				formErr = config.execCredential.chownTree(formDir)
+			}
+		}
+
+		if formErr != nil {
+			logging.Errorf("Unable to read multipart form: %s", formErr.Error())
+			ri.headerWritten = true
+			w.WriteHeader(bodyErrorStatus(formErr))
+			w.Write([]byte(formErr.Error()))
+			return
+		}
+
+		envs = appendEnvs(envs, formEnvs)
+	}
+
+	if config.secretEnv {
+		secretEnvs, secretErr := readSecretEnvs(config.secretMountPath, config.secretEnvAllow)
+		if secretErr != nil {
+			logging.Errorf("Unable to read secrets: %s", secretErr.Error())
+			ri.headerWritten = true
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Unable to read secrets"))
+			return
+		}
+		envs = appendEnvs(envs, secretEnvs)
+	}
+
+	var tmpDir string
+	if config.execTmpdir {
+		var tmpErr error
+		if tmpDir, tmpErr = makeInvocationTempDir(config, r); tmpErr != nil {
+			logging.Errorf("Unable to create TMPDIR: %s", tmpErr.Error())
+			ri.headerWritten = true
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Unable to create TMPDIR"))
+			return
+		}
+		envs = appendEnvs(envs, []string{"TMPDIR=" + tmpDir})
+	}
+
+	// With body_via_file stdin is left empty, a file in TMPDIR is removed
+	// along with it
+	if config.bodyViaFile {
+		bodyFile, bodyErr := writeBodyFile(config, r, tmpDir)
+		if bodyErr != nil {
+			logging.Errorf("Unable to write the request body to a file: %s", bodyErr.Error())
+			if len(tmpDir) > 0 {
+				removeInvocationTempDir(config, tmpDir, false)
+			}
+			ri.headerWritten = true
+			w.WriteHeader(bodyErrorStatus(bodyErr))
+			w.Write([]byte(bodyErr.Error()))
+			return
+		}
+		if len(tmpDir) == 0 {
+			defer os.Remove(bodyFile)
+		}
+		envs = appendEnvs(envs, []string{"Http_Body_File=" + bodyFile})
+	}
+
+	var responseFile string
+	if config.responseFromFile {
+		var fileErr error
+		if responseFile, fileErr = makeResponseFile(config, tmpDir); fileErr != nil {
+			logging.Errorf("Unable to create the response file: %s", fileErr.Error())
+			if len(tmpDir) > 0 {
+				removeInvocationTempDir(config, tmpDir, false)
+			}
+			ri.headerWritten = true
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Unable to create the response file"))
+			return
+		}
+		if len(tmpDir) == 0 {
+			defer os.Remove(responseFile)
+		}
+		envs = appendEnvs(envs, []string{"Http_Response_File=" + responseFile})
+	}
+
+	envs = appendEnvs(envs, invocationEnvs(w, r, trace))
+
 	if len(envs) > 0 {
 		targetCmd.Env = envs
 	}
 
-	writer, _ := targetCmd.StdinPipe()
+	// With stdin_mode=none the process reads from /dev/null
+	var writer io.WriteCloser
+	if config.stdinMode != stdinModeNone {
+		writer, _ = targetCmd.StdinPipe()
+	}
 
 	var out []byte
 	var err error
 	var requestBody []byte
+	var res execResult
+	var writeStart time.Time
+
+	if trace != nil {
+		defer func() {
+			trace.record(tracer, r, process, startTime, res, writeStart, err)
+		}()
+	}
+
+	if len(tmpDir) > 0 {
+		defer func() {
+			removeInvocationTempDir(config, tmpDir, err != nil)
+		}()
+	}
 
 	var wg sync.WaitGroup
 
 	wgCount := 2
 
+	// With sse_output the output is not kept, so that it can be streamed
+	var sse *sseWriter
+	var stdoutOut io.Writer
+	if config.sseOutput {
+		sse = newSSEWriter(w)
+		stdoutOut = sse
+	}
+
 	var buildInputErr error
 	requestBody, buildInputErr = buildFunctionInput(config, r)
 	if buildInputErr != nil {
-		if config.writeDebug == true {
-			log.Printf("Error=%s, ReadLen=%d\n", buildInputErr.Error(), len(requestBody))
-		}
+		logging.Debugf("Error=%s, ReadLen=%d", buildInputErr.Error(), len(requestBody))
 		ri.headerWritten = true
-		w.WriteHeader(http.StatusBadRequest)
+		w.WriteHeader(bodyErrorStatus(buildInputErr))
 		// I.e. "exit code 1"
 		w.Write([]byte(buildInputErr.Error()))
 
@@ -113,18 +316,30 @@ func pipeRequest(config *WatchdogConfig, w http.ResponseWriter, r *http.Request,
 
 	var timer *time.Timer
 
+	// Retries are stopped by the exec timeout or the caller going away
+	retryCtx, cancelRetry := context.WithCancel(r.Context())
+	defer cancelRetry()
+
 	if config.execTimeout > 0*time.Second {
 		timer = time.AfterFunc(config.execTimeout, func() {
-			log.Printf("Killing process: %s\n", config.faasProcess)
+			cancelRetry()
+			logging.Warnf("Killing process: %s", process)
+			if execMetrics != nil {
+				execMetrics.Timeouts.Inc()
+			}
 			if targetCmd != nil && targetCmd.Process != nil {
 				ri.headerWritten = true
-				w.WriteHeader(http.StatusRequestTimeout)
+				if sse != nil {
+					sse.fail("Killed process.")
+				} else {
+					w.WriteHeader(http.StatusRequestTimeout)
 
-				w.Write([]byte("Killed process.\n"))
+					w.Write([]byte("Killed process.\n"))
+				}
 
 				val := targetCmd.Process.Kill()
 				if val != nil {
-					log.Printf("Killed process: %s - error %s\n", config.faasProcess, val.Error())
+					logging.Errorf("Killed process: %s - error %s", process, val.Error())
 				}
 			}
 		})
@@ -133,45 +348,109 @@ func pipeRequest(config *WatchdogConfig, w http.ResponseWriter, r *http.Request,
 	// Write to pipe in separate go-routine to prevent blocking
 	go func() {
 		defer wg.Done()
+		if writer == nil {
+			return
+		}
 		writer.Write(requestBody)
-		writer.Close()
+
+		// With stdin_mode=keep the pipe is closed once the process exits
+		if config.stdinMode != stdinModeKeep {
+			writer.Close()
+		}
 	}()
 
-	if config.combineOutput {
-		// Read the output from stdout/stderr and combine into one variable for output.
-		go func() {
-			defer wg.Done()
+	go func() {
+		defer wg.Done()
 
-			out, err = targetCmd.CombinedOutput()
-		}()
-	} else {
-		go func() {
-			var b bytes.Buffer
-			targetCmd.Stderr = &b
+		var stderrOut io.Writer
+		var stream *stderrLineWriter
+		var capped *cappedBuffer
+		if config.combineOutput == false {
+			if config.stderrStream {
+				stream = &stderrLineWriter{prefix: stderrPrefix(config, r), max: config.maxLogBytes}
+				stderrOut = stream
+			} else if config.maxLogBytes > 0 {
+				capped = &cappedBuffer{max: config.maxLogBytes}
+				stderrOut = capped
+			}
+		}
+
+		res, err = runProcess(targetCmd, config.combineOutput, stderrOut, openOutput, stdoutOut)
+		for attempt := 1; attempt <= config.execRetries && shouldRetryExec(config, res, err); attempt++ {
+			// Output which has been streamed can not be taken back
+			if sse != nil && sse.Started() {
+				break
+			}
 
-			defer wg.Done()
+			backoff := execRetryBackoff << (attempt - 1)
+			logging.Warnf("Retrying fprocess in %s, attempt %d of %d, error: %s", backoff, attempt, config.execRetries, err.Error())
 
-			out, err = targetCmd.Output()
-			if b.Len() > 0 {
-				log.Printf("stderr: %s", b.Bytes())
+			select {
+			case <-time.After(backoff):
+			case <-retryCtx.Done():
 			}
-			b.Reset()
-		}()
-	}
+			if retryCtx.Err() != nil {
+				break
+			}
+
+			if execMetrics != nil {
+				execMetrics.Retries.Inc()
+			}
+			retryStdin := requestBody
+			if writer == nil {
+				retryStdin = nil
+			}
+			res, err = runProcess(retryCommand(retryCtx, targetCmd, retryStdin), config.combineOutput, stderrOut, openOutput, stdoutOut)
+		}
+		if stream != nil {
+			stream.Flush()
+		}
+		if capped != nil {
+			res.stderr = capped.Bytes()
+		}
+
+		out = res.stdout
+		if len(res.stderr) > 0 {
+			logStderr(stderrPrefix(config, r), res.stderr)
+		}
+	}()
 
 	wg.Wait()
 	if timer != nil {
 		timer.Stop()
 	}
 
+	if execMetrics != nil {
+		observeExec(execMetrics, res)
+	}
+
+	if breaker != nil {
+		breaker.record(err == nil)
+	}
+
+	if result := execResultFrom(r); result != nil {
+		*result = res
+	}
+
+	if sse != nil && sse.finish(err) {
+		logging.Infof("Streamed %d events - Duration: %fs - %s: %s", sse.events, time.Since(startTime).Seconds(), callIDHeader, r.Header.Get(callIDHeader))
+		return
+	}
+
+	if config.execRusageHeader && ri.headerWritten == false && !res.exited.IsZero() {
+		w.Header().Set("X-Exec-Rusage", res.rusageHeader())
+	}
+
+	if len(config.stderrResponse) > 0 && ri.headerWritten == false {
+		setStderrResponseHeader(config, w, res.stderr)
+	}
+
 	if err != nil {
-		if config.writeDebug == true {
-			log.Printf("Success=%t, Error=%s\n", targetCmd.ProcessState.Success(), err.Error())
-			log.Printf("Out=%s\n", out)
-		}
+		logging.Debugf("Success=%t, Error=%s", targetCmd.ProcessState != nil && targetCmd.ProcessState.Success(), err.Error())
+		logging.Debugf("Out=%s", out)
 
 		if ri.headerWritten == false {
-			w.WriteHeader(http.StatusInternalServerError)
+			w.WriteHeader(exitStatusCode(config, err))
 			response := bytes.NewBufferString(err.Error())
 			w.Write(response.Bytes())
 			w.Write([]byte("\n"))
@@ -183,16 +462,37 @@ func pipeRequest(config *WatchdogConfig, w http.ResponseWriter, r *http.Request,
 		return
 	}
 
+	// With response_from_file stdout is only logged
+	if len(responseFile) > 0 {
+		data, readErr := readResponseFile(config, responseFile)
+		if readErr != nil {
+			logging.Errorf("Unable to read the response file: %s", readErr.Error())
+			if ri.headerWritten == false {
+				ri.headerWritten = true
+				w.WriteHeader(http.StatusInternalServerError)
+				w.Write([]byte("Unable to read the response file"))
+			}
+			return
+		}
+		if len(out) > 0 {
+			logging.Infof("stdout: %s", truncateLog(out, config.maxLogBytes))
+		}
+		out = data
+	}
+
+	// The type is sniffed once any headers from the output have been read
+	sniffContentType := len(config.contentType) == 0 && (config.contentTypeSniff || len(responseFile) > 0)
+
 	var bytesWritten string
-	if config.writeDebug == true {
-		os.Stdout.Write(out)
+	if logging.Enabled(logging.LevelDebug) {
+		os.Stdout.Write(truncateLog(out, config.maxLogBytes))
 	} else {
 		bytesWritten = fmt.Sprintf("Wrote %d Bytes", len(out))
 	}
 
 	if len(config.contentType) > 0 {
 		w.Header().Set("Content-Type", config.contentType)
-	} else {
+	} else if !sniffContentType {
 
 		// Match content-type of caller if no override specified.
 		clientContentType := r.Header.Get("Content-Type")
@@ -201,23 +501,104 @@ func pipeRequest(config *WatchdogConfig, w http.ResponseWriter, r *http.Request,
 		}
 	}
 
+	status := http.StatusOK
+	if config.parseOutputHeaders {
+		header, headerStatus, body, parseErr := parseOutputHeaders(out)
+		if parseErr != nil {
+			logging.Errorf("%s", parseErr.Error())
+			if ri.headerWritten == false {
+				ri.headerWritten = true
+				w.WriteHeader(http.StatusInternalServerError)
+				w.Write([]byte(parseErr.Error()))
+			}
+			return
+		}
+
+		for k, v := range header {
+			w.Header()[k] = v
+		}
+		status = headerStatus
+		out = body
+	}
+
+	if config.marshalResponse {
+		envelope, body, unmarshalErr := types.UnmarshalResponseV2(out)
+		if unmarshalErr != nil {
+			logging.Errorf("Unable to read the response envelope: %s", unmarshalErr.Error())
+			if ri.headerWritten == false {
+				ri.headerWritten = true
+				w.WriteHeader(http.StatusInternalServerError)
+				w.Write([]byte("Unable to read the response envelope from the function"))
+			}
+			return
+		}
+
+		for k, v := range envelope.Header {
+			w.Header()[http.CanonicalHeaderKey(k)] = v
+		}
+		if envelope.StatusCode >= 100 && envelope.StatusCode <= 599 {
+			status = envelope.StatusCode
+		}
+		out = body
+	}
+
+	if sniffContentType && len(w.Header().Get("Content-Type")) == 0 {
+		w.Header().Set("Content-Type", http.DetectContentType(out))
+	}
+
 	execDuration := time.Since(startTime).Seconds()
 	if ri.headerWritten == false {
 		w.Header().Set("X-Duration-Seconds", fmt.Sprintf("%f", execDuration))
+
+		if config.compressResponse && len(w.Header().Get("Content-Encoding")) == 0 {
+			compressed, encoding, compressErr := compressResponse(config, r, out)
+			if compressErr != nil {
+				logging.Errorf("Unable to compress response: %s", compressErr.Error())
+			} else if len(encoding) > 0 {
+				w.Header().Set("Content-Encoding", encoding)
+				out = compressed
+			}
+			w.Header().Add("Vary", "Accept-Encoding")
+		}
+
+		// A tag set by the function is kept, and is also honoured
+		if config.etag && status == http.StatusOK {
+			etag := w.Header().Get("ETag")
+			if len(etag) == 0 {
+				etag = responseETag(out)
+				w.Header().Set("ETag", etag)
+			}
+			if notModified(r, etag) {
+				w.Header().Del("Content-Length")
+				status = http.StatusNotModified
+				out = nil
+			}
+		}
+
 		ri.headerWritten = true
-		w.WriteHeader(200)
+		writeStart = time.Now()
+		w.WriteHeader(status)
 		w.Write(out)
 	}
 
-	if config.debugHeaders {
+	if logging.Enabled(logging.LevelDebug) {
 		header := w.Header()
-		debugHeaders(&header, "out")
+		debugHeaders(&header, "out", config.logRedactHeaders)
 	}
 
-	if len(bytesWritten) > 0 {
-		log.Printf("%s - Duration: %fs", bytesWritten, execDuration)
+	callID := r.Header.Get(callIDHeader)
+	if logging.JSON() {
+		logging.Info("Invocation complete",
+			"call_id", callID,
+			"method", method,
+			"path", r.URL.Path,
+			"status", status,
+			"bytes", len(out),
+			"duration_seconds", execDuration)
+	} else if len(bytesWritten) > 0 {
+		logging.Infof("%s - Duration: %fs - %s: %s", bytesWritten, execDuration, callIDHeader, callID)
 	} else {
-		log.Printf("Duration: %fs", execDuration)
+		logging.Infof("Duration: %fs - %s: %s", execDuration, callIDHeader, callID)
 	}
 }
 
@@ -228,6 +609,10 @@ func getAdditionalEnvs(config *WatchdogConfig, r *http.Request, method string) [
 		envs = os.Environ()
 
 		for k, v := range r.Header {
+			if !cgiHeaderAllowed(config, k) {
+				continue
+			}
+
 			kv := fmt.Sprintf("Http_%s=%s", strings.Replace(k, "-", "_", -1), v[0])
 			envs = append(envs, kv)
 		}
@@ -241,18 +626,18 @@ func getAdditionalEnvs(config *WatchdogConfig, r *http.Request, method string) [
 			envs = append(envs, fmt.Sprintf("Http_Transfer_Encoding=%s", r.TransferEncoding[0]))
 		}
 
-		if config.writeDebug {
-			log.Println("Query ", r.URL.RawQuery)
-		}
+		logging.Debugf("Query %s", r.URL.RawQuery)
 
 		if len(r.URL.RawQuery) > 0 {
 			envs = append(envs, fmt.Sprintf("Http_Query=%s", r.URL.RawQuery))
-		}
 
-		if config.writeDebug {
-			log.Println("Path ", r.URL.Path)
+			if config.cgiQueryParams {
+				envs = append(envs, getQueryEnvs(config, r.URL.RawQuery)...)
+			}
 		}
 
+		logging.Debugf("Path %s", r.URL.Path)
+
 		if len(r.URL.Path) > 0 {
 			envs = append(envs, fmt.Sprintf("Http_Path=%s", r.URL.Path))
 		}
@@ -266,8 +651,133 @@ func getAdditionalEnvs(config *WatchdogConfig, r *http.Request, method string) [
 	return envs
 }
 
+// appendEnvs adds extra variables to the environment for the process,
+// starting from the watchdog's own environment if none has been built.
+// newProcessCommand prepares process with exec_cwd and the process
+// attributes, sandbox and cgroup of an invocation, release must be called
+// once it has exited
+func newProcessCommand(config *WatchdogConfig, process string, pty bool) (*exec.Cmd, func(), error) {
+	parts := processArgs(config, process)
+	cmd := exec.Command(parts[0], parts[1:]...)
+	cmd.Dir = config.execCwd
+
+	err := applyProcessAttrs(config, cmd)
+	if err == nil && pty {
+		err = applyPTYAttrs(cmd)
+	}
+	if err == nil {
+		err = applySandbox(config, cmd)
+	}
+	release := func() {}
+	if err == nil && execCgroups != nil {
+		release, err = execCgroups.apply(cmd)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return cmd, release, nil
+}
+
+// invocationEnvs gives the variables which follow those of the request:
+// its trace context, client certificate and those added by middleware
+func invocationEnvs(w http.ResponseWriter, r *http.Request, trace *invocationTrace) []string {
+	envs := propagateTraceHeaders(w, r)
+	envs = append(envs, getClientCertEnvs(r)...)
+	envs = append(envs, functionEnvs(r)...)
+	if trace != nil {
+		envs = append(envs, trace.traceparent())
+	}
+	return envs
+}
+
+func appendEnvs(envs []string, extra []string) []string {
+	if len(extra) == 0 {
+		return envs
+	}
+	if len(envs) == 0 {
+		envs = os.Environ()
+	}
+	return append(envs, extra...)
+}
+
+// cgiHeaderAllowed applies cgi_headers_allow and cgi_headers_deny to a
+// header name, patterns ending in * match any header with that prefix.
+func cgiHeaderAllowed(config *WatchdogConfig, name string) bool {
+	if len(config.cgiHeadersAllow) > 0 && !matchHeaderPattern(config.cgiHeadersAllow, name) {
+		return false
+	}
+	return !matchHeaderPattern(config.cgiHeadersDeny, name)
+}
+
+func matchHeaderPattern(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+			if len(name) >= len(prefix) && strings.EqualFold(name[:len(prefix)], prefix) {
+				return true
+			}
+		} else if strings.EqualFold(pattern, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// getQueryEnvs gives one variable per query string parameter, with the
+// name prefixed by cgiQueryPrefix and repeated values joined by commas.
+func getQueryEnvs(config *WatchdogConfig, rawQuery string) []string {
+	var envs []string
+
+	for _, pair := range strings.Split(rawQuery, "&") {
+		if len(pair) == 0 {
+			continue
+		}
+
+		key, value, _ := strings.Cut(pair, "=")
+		if unescaped, err := url.QueryUnescape(key); err == nil {
+			key = unescaped
+		}
+
+		if !config.cgiQueryRaw {
+			if unescaped, err := url.QueryUnescape(value); err == nil {
+				value = unescaped
+			}
+		}
+
+		name := config.cgiQueryPrefix + envName(key)
+		if i := indexOfEnv(envs, name); i >= 0 {
+			envs[i] = envs[i] + "," + value
+			continue
+		}
+		envs = append(envs, name+"="+value)
+	}
+
+	return envs
+}
+
+// indexOfEnv finds the position of a variable by name in a list of
+// KEY=VALUE pairs, or -1 when it is not present.
+func indexOfEnv(envs []string, name string) int {
+	for i, env := range envs {
+		if strings.HasPrefix(env, name+"=") {
+			return i
+		}
+	}
+	return -1
+}
+
+// lockFilePath is set from lock_file_path, when it is empty the lock file
+// is .lock in the temporary directory
+var lockFilePath string
+
+func lockFile() string {
+	if len(lockFilePath) > 0 {
+		return lockFilePath
+	}
+	return filepath.Join(os.TempDir(), ".lock")
+}
+
 func lockFilePresent() bool {
-	path := filepath.Join(os.TempDir(), ".lock")
+	path := lockFile()
 	if _, err := os.Stat(path); os.IsNotExist(err) {
 		return false
 	}
@@ -275,8 +785,8 @@ func lockFilePresent() bool {
 }
 
 func createLockFile() (string, error) {
-	path := filepath.Join(os.TempDir(), ".lock")
-	log.Printf("Writing lock-file to: %s\n", path)
+	path := lockFile()
+	logging.Infof("Writing lock-file to: %s", path)
 	writeErr := ioutil.WriteFile(path, []byte{}, 0660)
 
 	atomic.StoreInt32(&acceptingConnections, 1)
@@ -303,21 +813,163 @@ func makeHealthHandler() func(http.ResponseWriter, *http.Request) {
 	}
 }
 
+// makeReadyHandler serves /_/ready, which checks the same as /_/health and
+// also fails while all of the max_inflight slots are taken, so that a
+// readiness probe sends traffic to other replicas until one is free. It
+// does not restart the function.
+func makeReadyHandler(inflight *inflightLimiter) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		if atomic.LoadInt32(&acceptingConnections) == 0 || lockFilePresent() == false {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if inflight != nil && inflight.busy() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprintf(w, "Busy, %d waiting\n", inflight.queued())
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("OK"))
+	}
+}
+
+// defaultAllowedMethods are accepted when allowed_methods is not set
+var defaultAllowedMethods = []string{
+	http.MethodPost,
+	http.MethodPut,
+	http.MethodPatch,
+	http.MethodDelete,
+	http.MethodGet,
+}
+
 func makeRequestHandler(config *WatchdogConfig) http.Handler {
+	handler, _ := newRequestHandler(config)
+	return handler
+}
+
+// newRequestHandler also returns the concurrency limiter so that its limit
+// can be changed when the configuration is reloaded
+func newRequestHandler(config *WatchdogConfig) (http.Handler, *inflightLimiter) {
+	allowedMethods := config.allowedMethods
+	if len(allowedMethods) == 0 {
+		allowedMethods = defaultAllowedMethods
+	}
+	allowHeader := strings.Join(allowedMethods, ", ")
+
 	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		switch r.Method {
-		case
-			http.MethodPost,
-			http.MethodPut,
-			http.MethodPatch,
-			http.MethodDelete,
-			http.MethodGet:
-			pipeRequest(config, w, r, r.Method)
-			break
-		default:
+		if atomic.LoadInt32(&draining) == 1 {
+			w.Header().Set("Connection", "close")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprintf(w, "Draining, not accepting new invocations\n")
+			return
+		}
+
+		if !methodAllowed(allowedMethods, r.Method) {
+			w.Header().Set("Allow", allowHeader)
 			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
 
+		if breaker != nil {
+			if ok, left := breaker.allow(); !ok {
+				w.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds(left)))
+				w.WriteHeader(http.StatusServiceUnavailable)
+				fmt.Fprintf(w, "Circuit breaker open after consecutive failures\n")
+				return
+			}
 		}
+
+		// The settings which can be reloaded are read once per request
+		configLock.RLock()
+		config := *config
+		configLock.RUnlock()
+
+		if !limitRequestBody(w, r, config.maxRequestBytes) {
+			return
+		}
+
+		if config.websocket && isWebSocketUpgrade(r) {
+			serveWebSocket(&config, w, r)
+			return
+		}
+
+		if config.cloudEvents {
+			event, eventErr := readCloudEvent(r)
+			if eventErr != nil {
+				w.WriteHeader(bodyErrorStatus(eventErr))
+				fmt.Fprintf(w, "%s\n", eventErr.Error())
+				return
+			}
+			r = event
+
+			if len(config.cloudEventsResponseType) > 0 {
+				w = &cloudEventResponseWriter{
+					ResponseWriter: w,
+					eventType:      config.cloudEventsResponseType,
+					source:         config.cloudEventsResponseSource,
+				}
+			}
+		}
+
+		if isContentTypeMapping(config.contentType) {
+			mappings, mappingErr := parseContentTypeMapping(config.contentType)
+			if mappingErr != nil {
+				logging.Errorf("Invalid content_type: %s", mappingErr.Error())
+				w.WriteHeader(http.StatusInternalServerError)
+				fmt.Fprintf(w, "Invalid content_type\n")
+				return
+			}
+
+			w.Header().Add("Vary", "Accept")
+			mapping, ok := negotiateContentType(mappings, r.Header.Get("Accept"))
+			if !ok {
+				w.WriteHeader(http.StatusNotAcceptable)
+				fmt.Fprintf(w, "Acceptable media types: %s\n", mappedMediaTypes(mappings))
+				return
+			}
+			config.contentType = mapping.contentType
+			r = withFunctionEnvs(r, negotiatedTypeEnv+"="+mapping.mediaType)
+		}
+
+		pipeRequest(&config, w, r, r.Method)
 	})
-	return limiter.NewConcurrencyLimiter(handler, config.maxInflight)
+
+	inflight := newInflightLimiter(handler, config.maxInflight, config.maxInflightQueue, config.maxQueueWait)
+	if len(config.priorityHeader) > 0 {
+		inflight.priorityHeader = config.priorityHeader
+		inflight.priorityClasses = config.priorityClasses
+	}
+	inflight.retryAfter = config.maxInflightRetryAfter
+	inflight.retryAfterAuto = config.maxInflightRetryAfterAuto
+	if len(config.maxInflightBody) > 0 {
+		inflight.setRejectBody(config.maxInflightBody)
+	}
+
+	var next http.Handler = inflight
+	if len(config.envFile) > 0 {
+		next = newEnvFile(config.envFile).Handler(next)
+	}
+	if config.cacheTTL > 0 {
+		next = newResponseCache(config.cacheTTL, config.cacheMaxBytes, config.maxRequestBytes).Handler(next)
+	}
+	if config.idempotencyTTL > 0 {
+		next = newIdempotencyStore(config.idempotencyTTL, config.idempotencyMaxBytes, config.maxRequestBytes).Handler(next)
+	}
+
+	return makeCallIDHandler(next), inflight
+}
+
+func methodAllowed(allowedMethods []string, method string) bool {
+	for _, allowed := range allowedMethods {
+		if allowed == method {
+			return true
+		}
+	}
+	return false
 }
@@ -0,0 +1,111 @@
+// Copyright (c) OpenFaaS Author(s) 2026. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/openfaas/classic-watchdog/logging"
+)
+
+// healthCommandTimeout is how long healthcheck_command can run for before
+// it is killed and counted as a failure
+const healthCommandTimeout = 10 * time.Second
+
+// healthCommand runs healthcheck_command to check the function's own
+// dependencies. While it fails the lock file is removed so that health
+// checks fail, and the lock file is written again once it passes, unless
+// the replica is draining or shutting down.
+type healthCommand struct {
+	args         []string
+	suppressLock bool
+
+	lock    sync.Mutex
+	removed bool
+}
+
+func newHealthCommand(config *WatchdogConfig) *healthCommand {
+	return &healthCommand{
+		args:         processArgs(config, config.healthcheckCommand),
+		suppressLock: config.suppressLock,
+	}
+}
+
+func (h *healthCommand) check() error {
+	ctx, cancel := context.WithTimeout(context.Background(), healthCommandTimeout)
+	defer cancel()
+
+	out, err := exec.CommandContext(ctx, h.args[0], h.args[1:]...).CombinedOutput()
+	if ctx.Err() != nil {
+		return fmt.Errorf("timed out after %s", healthCommandTimeout)
+	}
+	if err != nil {
+		if output := strings.TrimSpace(string(out)); len(output) > 0 {
+			return fmt.Errorf("%s: %s", err.Error(), output)
+		}
+		return err
+	}
+	return nil
+}
+
+// update runs the command once and marks the replica unhealthy or healthy
+func (h *healthCommand) update() {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if err := h.check(); err != nil {
+		if atomic.LoadInt32(&acceptingConnections) == 1 || lockFilePresent() {
+			logging.Errorf("healthcheck_command failed, marking unhealthy: %s", err.Error())
+			if removeErr := markUnhealthy(); removeErr != nil && !os.IsNotExist(removeErr) {
+				logging.Errorf("Unable to mark server as unhealthy: %s", removeErr.Error())
+			}
+			h.removed = true
+		}
+		return
+	}
+
+	if h.removed && atomic.LoadInt32(&draining) == 0 && atomic.LoadInt32(&shuttingDown) == 0 {
+		logging.Infof("healthcheck_command passed, marking healthy")
+		h.removed = false
+		if h.suppressLock {
+			atomic.StoreInt32(&acceptingConnections, 1)
+		} else if path, err := createLockFile(); err != nil {
+			logging.Errorf("Unable to write %s: %s", path, err.Error())
+		}
+	}
+}
+
+// Run checks every interval until cancel is closed
+func (h *healthCommand) Run(interval time.Duration, cancel <-chan bool) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		h.update()
+		select {
+		case <-ticker.C:
+		case <-cancel:
+			return
+		}
+	}
+}
+
+// Handler runs the command before each check of the health endpoint, for
+// when healthcheck_command_interval is 0
+func (h *healthCommand) Handler(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			h.update()
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,204 @@
+// Copyright (c) OpenFaaS Author(s) 2026. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/openfaas/classic-watchdog/logging"
+)
+
+const (
+	idempotencyKeyHeader = "Idempotency-Key"
+
+	// idempotentReplayedHeader marks a response which was given to an
+	// earlier request with the same key
+	idempotentReplayedHeader = "Idempotent-Replayed"
+)
+
+// idempotencyStore runs the function once for each Idempotency-Key and
+// gives its result to any other request with the same key for ttl. A
+// request which arrives while the first is running waits for its result.
+// Reusing a key for a different request is rejected with a 422. Results
+// are not kept once their bodies would take more than maxBytes in total.
+type idempotencyStore struct {
+	ttl      time.Duration
+	maxBytes int64
+	maxBody  int64
+
+	lock    sync.Mutex
+	entries map[string]*idempotentCall
+	// kept holds the finished calls in the order that they expire
+	kept *list.List
+	size int64
+}
+
+type idempotentCall struct {
+	key         string
+	fingerprint string
+	done        chan struct{}
+	element     *list.Element
+
+	// set once done is closed, status is 0 when the result was not kept
+	status  int
+	header  http.Header
+	body    []byte
+	expires time.Time
+}
+
+func newIdempotencyStore(ttl time.Duration, maxBytes int64, maxBody int64) *idempotencyStore {
+	return &idempotencyStore{
+		ttl:      ttl,
+		maxBytes: maxBytes,
+		maxBody:  maxBody,
+		entries:  make(map[string]*idempotentCall),
+		kept:     list.New(),
+	}
+}
+
+func (s *idempotencyStore) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(idempotencyKeyHeader)
+		if len(key) == 0 || len(r.Header.Get("Upgrade")) > 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if !limitRequestBody(w, r, s.maxBody) {
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(bodyErrorStatus(err))
+			w.Write([]byte(err.Error()))
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		// The key is compared on the method, path and query and the body
+		fingerprint := cacheKey(&http.Request{Method: r.Method, URL: r.URL, Header: http.Header{}}, verifiedCaller{}, body)
+
+		// Each caller has keys of its own, so that a key which another
+		// caller used does not give its result away
+		call, first := s.start(callerOf(r).key()+"\x00"+key, fingerprint)
+		if call.fingerprint != fingerprint {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			fmt.Fprintf(w, "%s was used for a different request\n", idempotencyKeyHeader)
+			return
+		}
+
+		if first {
+			// Waiting requests are released even if next panics
+			recorder := &cacheRecorder{ResponseWriter: w, limit: s.maxBytes}
+			completed := false
+			defer func() {
+				s.finish(call, recorder, completed)
+			}()
+			next.ServeHTTP(recorder, r)
+			completed = true
+			return
+		}
+
+		select {
+		case <-call.done:
+		case <-r.Context().Done():
+			return
+		}
+		if call.status == 0 {
+			// The first request's result was not kept, as it was rejected
+			// before the function ran or was too large, so this one is run
+			// in its place
+			s.Handler(next).ServeHTTP(w, r)
+			return
+		}
+		s.replay(w, r, call)
+	})
+}
+
+// start gives the call for key, which is new when first is true
+func (s *idempotencyStore) start(key string, fingerprint string) (*idempotentCall, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	now := time.Now()
+	if call, ok := s.entries[key]; ok {
+		select {
+		case <-call.done:
+			if now.Before(call.expires) {
+				return call, false
+			}
+		default:
+			return call, false
+		}
+	}
+
+	// Expired results are removed as new keys arrive
+	for element := s.kept.Front(); element != nil && now.After(element.Value.(*idempotentCall).expires); element = s.kept.Front() {
+		s.remove(element.Value.(*idempotentCall))
+	}
+
+	call := &idempotentCall{key: key, fingerprint: fingerprint, done: make(chan struct{})}
+	s.entries[key] = call
+	return call, true
+}
+
+// finish keeps the result of a call which completed, other than a 429 or
+// 503 from a concurrency limit or circuit breaker, which does not mean that
+// the function ran, or a result which is too large to keep
+func (s *idempotencyStore) finish(call *idempotentCall, recorder *cacheRecorder, completed bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	defer close(call.done)
+
+	status := recorder.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	size := int64(recorder.body.Len())
+	if !completed || status == http.StatusTooManyRequests || status == http.StatusServiceUnavailable ||
+		recorder.overflow || s.size+size > s.maxBytes {
+		delete(s.entries, call.key)
+		return
+	}
+
+	call.status = status
+	call.header = recorder.header
+	call.body = bytes.Clone(recorder.body.Bytes())
+	call.expires = time.Now().Add(s.ttl)
+	call.element = s.kept.PushBack(call)
+	s.size += size
+}
+
+// remove must be called with the lock held
+func (s *idempotencyStore) remove(call *idempotentCall) {
+	s.kept.Remove(call.element)
+	if s.entries[call.key] == call {
+		delete(s.entries, call.key)
+	}
+	s.size -= int64(len(call.body))
+}
+
+func (s *idempotencyStore) replay(w http.ResponseWriter, r *http.Request, call *idempotentCall) {
+	header := w.Header()
+	for name, values := range call.header {
+		if name == callIDHeader {
+			continue
+		}
+		header[name] = values
+	}
+	header.Set(idempotentReplayedHeader, "true")
+
+	w.WriteHeader(call.status)
+	if r.Method != http.MethodHead {
+		w.Write(call.body)
+	}
+	logging.Infof("Replayed the result for %s: %s - %s: %s", idempotencyKeyHeader, r.Header.Get(idempotencyKeyHeader),
+		callIDHeader, r.Header.Get(callIDHeader))
+}
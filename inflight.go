@@ -0,0 +1,299 @@
+// Copyright (c) OpenFaaS Author(s) 2026. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// execAverageWeight is the weight given to the latest request in the
+// moving average of the time taken to run the function
+const execAverageWeight = 0.2
+
+// inflightLimiter limits the number of requests running the function at
+// once. Requests over the limit wait in a bounded queue for a free slot,
+// or are rejected with a 429 when the queue is full or the wait is too
+// long. When priorityHeader is set, waiting requests are admitted in order
+// of their priority class, then the order they arrived, and a request which
+// finds the queue full takes the place of the newest request with a lower
+// priority.
+type inflightLimiter struct {
+	next      http.Handler
+	max       int
+	queue     int
+	queueWait time.Duration
+
+	// priorityHeader holds one of priorityClasses, highest first. Requests
+	// without the header or with an unknown value have the lowest priority.
+	priorityHeader  string
+	priorityClasses []string
+
+	// retryAfter is sent as the Retry-After header of a 429, unless
+	// retryAfterAuto is set, where the average time taken by the function
+	// is sent instead
+	retryAfter     time.Duration
+	retryAfterAuto bool
+
+	// rejectBody replaces the text body of a 429, with a JSON content type
+	// when it is valid JSON
+	rejectBody        []byte
+	rejectContentType string
+
+	queueLock sync.Mutex
+	running   int
+	waiting   int
+	waiters   map[int][]*inflightWaiter
+
+	lock    sync.Mutex
+	average time.Duration
+}
+
+// inflightWaiter receives true when it is given a slot, or false when its
+// place in the queue is taken by a request with a higher priority
+type inflightWaiter struct {
+	ready    chan bool
+	priority int
+}
+
+func newInflightLimiter(next http.Handler, max int, queue int, queueWait time.Duration) *inflightLimiter {
+	return &inflightLimiter{
+		next:      next,
+		max:       max,
+		queue:     queue,
+		queueWait: queueWait,
+		waiters:   make(map[int][]*inflightWaiter),
+	}
+}
+
+func (l *inflightLimiter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !l.acquire(r) {
+		if r.Context().Err() == nil {
+			l.reject(w)
+		}
+		return
+	}
+	defer l.release()
+
+	if l.retryAfterAuto {
+		start := time.Now()
+		defer func() { l.observe(time.Since(start)) }()
+	}
+
+	l.next.ServeHTTP(w, r)
+}
+
+// priority is the index of the request's class, where 0 is the highest
+func (l *inflightLimiter) priority(r *http.Request) int {
+	if len(l.priorityHeader) == 0 {
+		return 0
+	}
+
+	value := r.Header.Get(l.priorityHeader)
+	for i, class := range l.priorityClasses {
+		if strings.EqualFold(class, value) {
+			return i
+		}
+	}
+	return len(l.priorityClasses) - 1
+}
+
+func (l *inflightLimiter) acquire(r *http.Request) bool {
+	priority := l.priority(r)
+
+	l.queueLock.Lock()
+	if l.max <= 0 || l.running < l.max {
+		l.running++
+		l.queueLock.Unlock()
+		return true
+	}
+
+	if l.waiting >= l.queue {
+		evicted := l.evict(priority)
+		if evicted == nil {
+			l.queueLock.Unlock()
+			return false
+		}
+		evicted.ready <- false
+	}
+
+	waiter := &inflightWaiter{ready: make(chan bool, 1), priority: priority}
+	l.waiters[priority] = append(l.waiters[priority], waiter)
+	l.waiting++
+	l.queueLock.Unlock()
+
+	var timeout <-chan time.Time
+	if l.queueWait > 0 {
+		timer := time.NewTimer(l.queueWait)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case ok := <-waiter.ready:
+		return ok
+	case <-timeout:
+	case <-r.Context().Done():
+	}
+
+	l.queueLock.Lock()
+	removed := l.remove(waiter)
+	l.queueLock.Unlock()
+
+	// The waiter was given a slot or evicted at the same time as it gave up
+	if !removed {
+		if <-waiter.ready {
+			l.release()
+		}
+	}
+	return false
+}
+
+// release hands the slot to the next waiter, or frees it
+func (l *inflightLimiter) release() {
+	l.queueLock.Lock()
+	defer l.queueLock.Unlock()
+
+	if (l.max <= 0 || l.running <= l.max) && l.admitNext() {
+		return
+	}
+	l.running--
+}
+
+// admitNext gives a slot to the waiter with the highest priority which
+// arrived first
+func (l *inflightLimiter) admitNext() bool {
+	for priority := 0; priority < max(1, len(l.priorityClasses)); priority++ {
+		if waiters := l.waiters[priority]; len(waiters) > 0 {
+			l.waiters[priority] = waiters[1:]
+			l.waiting--
+			waiters[0].ready <- true
+			return true
+		}
+	}
+	return false
+}
+
+// evict removes the newest waiter with a lower priority than priority
+func (l *inflightLimiter) evict(priority int) *inflightWaiter {
+	for lower := len(l.priorityClasses) - 1; lower > priority; lower-- {
+		if waiters := l.waiters[lower]; len(waiters) > 0 {
+			l.waiters[lower] = waiters[:len(waiters)-1]
+			l.waiting--
+			return waiters[len(waiters)-1]
+		}
+	}
+	return nil
+}
+
+func (l *inflightLimiter) remove(waiter *inflightWaiter) bool {
+	waiters := l.waiters[waiter.priority]
+	for i, w := range waiters {
+		if w == waiter {
+			l.waiters[waiter.priority] = append(waiters[:i:i], waiters[i+1:]...)
+			l.waiting--
+			return true
+		}
+	}
+	return false
+}
+
+// setMax changes the limit at runtime, waiting requests are admitted when
+// it is raised
+func (l *inflightLimiter) setMax(max int) {
+	l.queueLock.Lock()
+	defer l.queueLock.Unlock()
+
+	l.max = max
+	for l.max <= 0 || l.running < l.max {
+		if !l.admitNext() {
+			return
+		}
+		l.running++
+	}
+}
+
+// queued is the number of requests waiting for a slot
+func (l *inflightLimiter) queued() int {
+	l.queueLock.Lock()
+	defer l.queueLock.Unlock()
+	return l.waiting
+}
+
+// busy reports whether every slot is taken, so that a new request would
+// have to wait or be rejected
+func (l *inflightLimiter) busy() bool {
+	l.queueLock.Lock()
+	defer l.queueLock.Unlock()
+	return l.max > 0 && l.running >= l.max
+}
+
+// setRejectBody sets the body of a 429, i.e. an error in the format the
+// caller expects
+func (l *inflightLimiter) setRejectBody(body string) {
+	l.rejectBody = []byte(body)
+	l.rejectContentType = "text/plain"
+	if json.Valid(l.rejectBody) {
+		l.rejectContentType = "application/json"
+	}
+}
+
+func (l *inflightLimiter) observe(d time.Duration) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.average == 0 {
+		l.average = d
+		return
+	}
+	l.average = time.Duration(execAverageWeight*float64(d) + (1-execAverageWeight)*float64(l.average))
+}
+
+// retryAfterSeconds is at least 1, or 0 when no Retry-After is configured
+func (l *inflightLimiter) retryAfterSeconds() int {
+	retryAfter := l.retryAfter
+	if l.retryAfterAuto {
+		l.lock.Lock()
+		retryAfter = l.average
+		l.lock.Unlock()
+	} else if retryAfter <= 0 {
+		return 0
+	}
+
+	return retryAfterSeconds(retryAfter)
+}
+
+// retryAfterSeconds rounds up a Retry-After, to at least 1
+func retryAfterSeconds(d time.Duration) int {
+	return int(math.Max(1, math.Ceil(d.Seconds())))
+}
+
+func (l *inflightLimiter) reject(w http.ResponseWriter) {
+	if seconds := l.retryAfterSeconds(); seconds > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	}
+	w.Header().Add("X-OpenFaaS-Internal", "faas-middleware")
+
+	if len(l.rejectBody) > 0 {
+		w.Header().Add("Content-Type", l.rejectContentType)
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write(l.rejectBody)
+		return
+	}
+
+	l.queueLock.Lock()
+	max := l.max
+	l.queueLock.Unlock()
+
+	w.Header().Add("Content-Type", "text/plain")
+	w.WriteHeader(http.StatusTooManyRequests)
+
+	fmt.Fprintf(w, "Concurrent request limit exceeded. Max concurrent requests: %d\n", max)
+}
@@ -0,0 +1,87 @@
+// Copyright (c) OpenFaaS Author(s) 2026. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/openfaas/classic-watchdog/logging"
+)
+
+// makeIPFilterHandler rejects requests with a 403 when the source address
+// is in one of denyCIDRs, or when allowCIDRs is set and the address is in
+// none of them. Only the address of the connection is checked, headers
+// such as X-Forwarded-For are not trusted.
+func makeIPFilterHandler(allowCIDRs []string, denyCIDRs []string, next http.Handler) (http.Handler, error) {
+	allow, err := parsePrefixes(allowCIDRs)
+	if err != nil {
+		return nil, fmt.Errorf("allow_cidrs: %w", err)
+	}
+	deny, err := parsePrefixes(denyCIDRs)
+	if err != nil {
+		return nil, fmt.Errorf("deny_cidrs: %w", err)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !ipAllowed(r.RemoteAddr, allow, deny) {
+			logging.Debugf("%s %s - %d FORBIDDEN - %s", r.Method, r.URL.Path, http.StatusForbidden, r.RemoteAddr)
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}), nil
+}
+
+// parsePrefixes parses CIDRs, a single address is treated as a /32 or /128
+func parsePrefixes(values []string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, value := range values {
+		if !strings.Contains(value, "/") {
+			addr, err := netip.ParseAddr(value)
+			if err != nil {
+				return nil, err
+			}
+			addr = addr.Unmap()
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// ipAllowed checks remoteAddr, an address which cannot be parsed, such as
+// from a Unix socket, is only allowed when there is no allow list.
+func ipAllowed(remoteAddr string, allow []netip.Prefix, deny []netip.Prefix) bool {
+	addrPort, err := netip.ParseAddrPort(remoteAddr)
+	if err != nil {
+		return len(allow) == 0
+	}
+	addr := addrPort.Addr().Unmap()
+
+	for _, prefix := range deny {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+
+	if len(allow) == 0 {
+		return true
+	}
+	for _, prefix := range allow {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
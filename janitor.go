@@ -0,0 +1,134 @@
+// Copyright (c) OpenFaaS Author(s) 2026. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package main
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/openfaas/classic-watchdog/logging"
+	"github.com/openfaas/classic-watchdog/metrics"
+)
+
+// janitor removes the oldest entries of a scratch directory used by the
+// function once they pass an age, or while the directory is over a size, so
+// that a long-lived replica does not fill its writable layer.
+type janitor struct {
+	dir      string
+	maxAge   time.Duration
+	maxBytes int64
+	metrics  *metrics.Janitor
+	now      func() time.Time
+}
+
+// scratchEntry is a top-level file or directory, where a directory is as
+// old as the newest file within it
+type scratchEntry struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+func newJanitor(dir string, maxAge time.Duration, maxBytes int64, m *metrics.Janitor) *janitor {
+	return &janitor{
+		dir:      dir,
+		maxAge:   maxAge,
+		maxBytes: maxBytes,
+		metrics:  m,
+		now:      time.Now,
+	}
+}
+
+func (j *janitor) Run(interval time.Duration, cancel <-chan bool) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-cancel:
+			return
+		}
+
+		if err := j.sweep(); err != nil {
+			logging.Errorf("Unable to clean scratch_dir: %s", err.Error())
+		}
+	}
+}
+
+func (j *janitor) sweep() error {
+	dirEntries, err := os.ReadDir(j.dir)
+	if err != nil {
+		return err
+	}
+
+	now := j.now()
+	var entries []scratchEntry
+	var total int64
+	for _, dirEntry := range dirEntries {
+		entry := scanScratchEntry(filepath.Join(j.dir, dirEntry.Name()))
+		if j.maxAge > 0 && now.Sub(entry.modTime) > j.maxAge {
+			j.remove(entry, "age")
+			continue
+		}
+		entries = append(entries, entry)
+		total += entry.size
+	}
+
+	if j.maxBytes <= 0 || total <= j.maxBytes {
+		return nil
+	}
+
+	sort.Slice(entries, func(a, b int) bool {
+		return entries[a].modTime.Before(entries[b].modTime)
+	})
+	for _, entry := range entries {
+		if total <= j.maxBytes {
+			break
+		}
+		if j.remove(entry, "size") {
+			total -= entry.size
+		}
+	}
+	return nil
+}
+
+func (j *janitor) remove(entry scratchEntry, reason string) bool {
+	if err := os.RemoveAll(entry.path); err != nil {
+		logging.Errorf("Unable to remove %s from scratch_dir: %s", entry.path, err.Error())
+		return false
+	}
+
+	logging.Debugf("Removed %s from scratch_dir for its %s, reclaiming %d bytes", entry.path, reason, entry.size)
+	if j.metrics != nil {
+		j.metrics.ReclaimedBytes.Add(float64(entry.size))
+		j.metrics.RemovedEntries.WithLabelValues(reason).Inc()
+	}
+	return true
+}
+
+// scanScratchEntry skips files which are removed while it walks the tree
+func scanScratchEntry(path string) scratchEntry {
+	entry := scratchEntry{path: path}
+	filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		if info.Mode().IsRegular() {
+			entry.size += info.Size()
+		}
+		if info.ModTime().After(entry.modTime) {
+			entry.modTime = info.ModTime()
+		}
+		return nil
+	})
+	return entry
+}
@@ -0,0 +1,125 @@
+// Copyright (c) OpenFaaS Author(s) 2026. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// functionEnvsKey stores environmental variables for the function on the
+// request context, for middleware which runs before pipeRequest
+type functionEnvsKey struct{}
+
+// withFunctionEnvs adds envs to those passed to the function for r
+func withFunctionEnvs(r *http.Request, envs ...string) *http.Request {
+	existing := functionEnvs(r)
+	combined := make([]string, 0, len(existing)+len(envs))
+	combined = append(append(combined, existing...), envs...)
+
+	return r.WithContext(context.WithValue(r.Context(), functionEnvsKey{}, combined))
+}
+
+// functionEnvs gives the variables added with withFunctionEnvs
+func functionEnvs(r *http.Request) []string {
+	envs, _ := r.Context().Value(functionEnvsKey{}).([]string)
+	return envs
+}
+
+// makeJWTClaimsHandler passes the listed claims of the bearer token to the
+// function as Jwt_<claim>. It must only be used behind the JWT middleware,
+// since the token's signature has already been verified there.
+func makeJWTClaimsHandler(claims []string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		values, err := decodeJWTClaims(token)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		var envs []string
+		for _, claim := range claims {
+			if value, ok := values[claim]; ok {
+				envs = append(envs, fmt.Sprintf("Jwt_%s=%s", envName(claim), claimValue(value)))
+			}
+		}
+
+		next.ServeHTTP(w, withFunctionEnvs(r, envs...))
+	})
+}
+
+// makeJWTCallerHandler records the sub and iss claims of the bearer token
+// as the caller. Like makeJWTClaimsHandler, it must only be used behind the
+// JWT middleware.
+func makeJWTCallerHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		values, err := decodeJWTClaims(token)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		subject, _ := values["sub"].(string)
+		issuer, _ := values["iss"].(string)
+		next.ServeHTTP(w, withCaller(r, subject, issuer))
+	})
+}
+
+// decodeJWTClaims reads the payload of a JWT without verifying it
+func decodeJWTClaims(token string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed token")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, err
+	}
+
+	values := map[string]interface{}{}
+	if err := json.Unmarshal(payload, &values); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// claimValue formats a claim, lists of strings i.e. aud are joined with
+// commas, and other values are given as JSON.
+func claimValue(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case []interface{}:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				data, _ := json.Marshal(v)
+				return string(data)
+			}
+			items = append(items, s)
+		}
+		return strings.Join(items, ",")
+	}
+
+	data, _ := json.Marshal(value)
+	return string(data)
+}
@@ -0,0 +1,350 @@
+// Copyright (c) OpenFaaS Author(s) 2026. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/openfaas/classic-watchdog/logging"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"github.com/twmb/franz-go/pkg/sasl/plain"
+	"github.com/twmb/franz-go/pkg/sasl/scram"
+)
+
+const (
+	kafkaClientID   = "fwatchdog"
+	kafkaMaxBackoff = 30 * time.Second
+
+	// kafkaCommitTimeout bounds a commit or a dead letter, which are not
+	// cancelled on shutdown so that a record which was processed is not
+	// processed again
+	kafkaCommitTimeout = 30 * time.Second
+)
+
+// kafkaConsumer invokes the function with each record of a topic, in order
+// per partition, as a member of a consumer group so that the partitions are
+// shared between the replicas. A record's offset is committed once the
+// function succeeds. A failure is retried with a backoff up to maxRetries
+// times, then the record is sent to deadLetterTopic or skipped when
+// skipFailed is set, so that it does not hold up the rest of the partition.
+// Otherwise it is retried until it succeeds and is never committed before.
+type kafkaConsumer struct {
+	brokers []string
+	topic   string
+	group   string
+	handler http.Handler
+
+	maxRetries      int
+	deadLetterTopic string
+	skipFailed      bool
+	opts            []kgo.Opt
+
+	// retryBackoff is the wait before the first retry, which doubles for
+	// each one after it
+	retryBackoff time.Duration
+
+	// produce sends a record to deadLetterTopic
+	produce func(ctx context.Context, record *kgo.Record) error
+
+	// allowRebalance lets a rebalance which is waiting on the records in
+	// progress go ahead while a failure is retried, so that the group's
+	// rebalance timeout is not exceeded
+	allowRebalance func()
+
+	// revoked holds the count of revokes when each partition was last
+	// taken away, a record of a partition taken away while it was retried
+	// is left to the partition's new owner
+	revokeLock sync.Mutex
+	revokes    uint64
+	revoked    map[int32]uint64
+}
+
+func newKafkaConsumer(config *WatchdogConfig, handler http.Handler) (*kafkaConsumer, error) {
+	opts, err := kafkaClientOpts(config)
+	if err != nil {
+		return nil, err
+	}
+	return &kafkaConsumer{
+		brokers:         config.kafkaBrokers,
+		topic:           config.kafkaTopic,
+		group:           config.kafkaGroup,
+		handler:         handler,
+		maxRetries:      config.kafkaMaxRetries,
+		deadLetterTopic: config.kafkaDeadLetterTopic,
+		skipFailed:      config.kafkaSkipFailed,
+		opts:            opts,
+		retryBackoff:    time.Second,
+		revoked:         make(map[int32]uint64),
+	}, nil
+}
+
+// kafkaClientOpts gives the options for kafka_tls and kafka_sasl_mechanism
+func kafkaClientOpts(config *WatchdogConfig) ([]kgo.Opt, error) {
+	var opts []kgo.Opt
+	if config.kafkaTLS {
+		opts = append(opts, kgo.DialTLSConfig(&tls.Config{MinVersion: tls.VersionTLS12}))
+	}
+
+	if len(config.kafkaSASLMechanism) == 0 {
+		return opts, nil
+	}
+	password, err := readSecretValue(config.kafkaSASLPassword, config.kafkaSASLPasswordFile)
+	if err != nil {
+		return nil, fmt.Errorf("kafka_sasl_password_file: %w", err)
+	}
+
+	switch strings.ToUpper(config.kafkaSASLMechanism) {
+	case "PLAIN":
+		opts = append(opts, kgo.SASL(plain.Auth{User: config.kafkaSASLUsername, Pass: password}.AsMechanism()))
+	case "SCRAM-SHA-256":
+		opts = append(opts, kgo.SASL(scram.Auth{User: config.kafkaSASLUsername, Pass: password}.AsSha256Mechanism()))
+	case "SCRAM-SHA-512":
+		opts = append(opts, kgo.SASL(scram.Auth{User: config.kafkaSASLUsername, Pass: password}.AsSha512Mechanism()))
+	default:
+		return nil, fmt.Errorf("kafka_sasl_mechanism must be PLAIN, SCRAM-SHA-256 or SCRAM-SHA-512, got: %s", config.kafkaSASLMechanism)
+	}
+	return opts, nil
+}
+
+// Run consumes until cancel is closed, then leaves the group once the
+// record in progress is done with
+func (k *kafkaConsumer) Run(cancel <-chan bool) {
+	stop, stopped := context.WithCancel(context.Background())
+	defer stopped()
+	go func() {
+		select {
+		case <-cancel:
+			stopped()
+		case <-stop.Done():
+		}
+	}()
+
+	opts := append([]kgo.Opt{
+		kgo.SeedBrokers(k.brokers...),
+		kgo.ClientID(kafkaClientID),
+		kgo.ConsumerGroup(k.group),
+		kgo.ConsumeTopics(k.topic),
+		// A new group starts from the latest offset
+		kgo.ConsumeResetOffset(kgo.NewOffset().AtEnd()),
+		kgo.DisableAutoCommit(),
+		// Partitions are not taken away while their records are processed,
+		// other than while a failure is retried
+		kgo.BlockRebalanceOnPoll(),
+		kgo.OnPartitionsRevoked(k.onRevoked),
+		kgo.OnPartitionsLost(k.onRevoked),
+	}, k.opts...)
+	client, err := kgo.NewClient(opts...)
+	if err != nil {
+		logging.Errorf("Unable to create the Kafka client: %s", err.Error())
+		return
+	}
+	defer client.CloseAllowingRebalance()
+	if k.produce == nil {
+		k.produce = func(ctx context.Context, record *kgo.Record) error {
+			return client.ProduceSync(ctx, record).FirstErr()
+		}
+	}
+	k.allowRebalance = client.AllowRebalance
+
+	logging.Infof("Consuming from Kafka topic: %s group: %s", k.topic, k.group)
+
+	for {
+		fetches := client.PollFetches(stop)
+		if stop.Err() != nil || fetches.IsClientClosed() {
+			return
+		}
+		fetches.EachError(func(topic string, partition int32, err error) {
+			logging.Errorf("Kafka error from %s/%d: %s", topic, partition, err.Error())
+		})
+
+		fetches.EachPartition(func(p kgo.FetchTopicPartition) {
+			for _, record := range p.Records {
+				if !k.process(stop, record) {
+					return
+				}
+				k.commit(client, record)
+			}
+		})
+		client.AllowRebalance()
+	}
+}
+
+// onRevoked notes the partitions which were taken away from this member
+func (k *kafkaConsumer) onRevoked(_ context.Context, _ *kgo.Client, partitions map[string][]int32) {
+	k.revokeLock.Lock()
+	defer k.revokeLock.Unlock()
+
+	k.revokes++
+	for _, partition := range partitions[k.topic] {
+		k.revoked[partition] = k.revokes
+	}
+}
+
+// revokeCount gives the number of revokes so far, to compare with
+// revokedSince
+func (k *kafkaConsumer) revokeCount() uint64 {
+	k.revokeLock.Lock()
+	defer k.revokeLock.Unlock()
+	return k.revokes
+}
+
+func (k *kafkaConsumer) revokedSince(partition int32, revokes uint64) bool {
+	k.revokeLock.Lock()
+	defer k.revokeLock.Unlock()
+	return k.revoked[partition] > revokes
+}
+
+// process invokes the function with record, retrying a failure with a
+// backoff. It gives false when stopped, or when the record's partition was
+// taken away, before the record was done with, so that its offset is not
+// committed.
+func (k *kafkaConsumer) process(stop context.Context, record *kgo.Record) bool {
+	revokes := k.revokeCount()
+	backoff := k.retryBackoff
+	wait := func() bool {
+		if k.allowRebalance != nil {
+			k.allowRebalance()
+		}
+		select {
+		case <-time.After(backoff):
+		case <-stop.Done():
+			return false
+		}
+		if backoff *= 2; backoff > kafkaMaxBackoff {
+			backoff = kafkaMaxBackoff
+		}
+		if k.revokedSince(record.Partition, revokes) {
+			logging.Warnf("Kafka record %s/%d@%d was not done with before its partition was reassigned", record.Topic, record.Partition, record.Offset)
+			return false
+		}
+		return true
+	}
+
+	// Without somewhere to put a record which keeps failing, it is retried
+	// until it succeeds rather than lost
+	passOver := len(k.deadLetterTopic) > 0 || k.skipFailed
+
+	var status int
+	for retries := 0; ; retries++ {
+		if stop.Err() != nil {
+			return false
+		}
+		if status = k.invoke(record); status >= 200 && status <= 299 {
+			return true
+		}
+		if retries >= k.maxRetries && passOver {
+			break
+		}
+
+		logging.Warnf("Kafka record %s/%d@%d failed with status %d, retrying in %s", record.Topic, record.Partition, record.Offset, status, backoff)
+		if !wait() {
+			return false
+		}
+	}
+
+	if len(k.deadLetterTopic) == 0 {
+		logging.Errorf("Kafka record %s/%d@%d failed with status %d after %d retries, skipping it", record.Topic, record.Partition, record.Offset, status, k.maxRetries)
+		return true
+	}
+
+	// The record is only passed over once it is in the dead letter topic
+	backoff = k.retryBackoff
+	for {
+		err := k.deadLetter(record, status)
+		if err == nil {
+			logging.Errorf("Kafka record %s/%d@%d failed with status %d after %d retries, sent to %s", record.Topic, record.Partition, record.Offset, status, k.maxRetries, k.deadLetterTopic)
+			return true
+		}
+
+		logging.Errorf("Unable to send Kafka record %s/%d@%d to %s, retrying in %s: %s", record.Topic, record.Partition, record.Offset, k.deadLetterTopic, backoff, err.Error())
+		if !wait() {
+			return false
+		}
+	}
+}
+
+func (k *kafkaConsumer) invoke(record *kgo.Record) int {
+	req, err := http.NewRequest(http.MethodPost, "http://127.0.0.1/", bytes.NewReader(record.Value))
+	if err != nil {
+		return http.StatusInternalServerError
+	}
+	req.RemoteAddr = "127.0.0.1:0"
+	for _, header := range record.Headers {
+		req.Header.Add(header.Key, string(header.Value))
+	}
+	req.Header.Set("X-Kafka-Topic", record.Topic)
+	req.Header.Set("X-Kafka-Partition", strconv.Itoa(int(record.Partition)))
+	req.Header.Set("X-Kafka-Offset", strconv.FormatInt(record.Offset, 10))
+	if record.Key != nil {
+		if utf8.Valid(record.Key) && !bytes.ContainsAny(record.Key, "\r\n\x00") {
+			req.Header.Set("X-Kafka-Key", string(record.Key))
+		} else {
+			req.Header.Set("X-Kafka-Key-Base64", base64.StdEncoding.EncodeToString(record.Key))
+		}
+	}
+
+	res := &responseBuffer{header: http.Header{}}
+	k.handler.ServeHTTP(res, req)
+	if res.status == 0 {
+		res.status = http.StatusOK
+	}
+
+	logging.Infof("Kafka record %s/%d@%d, status: %d - %s: %s", record.Topic, record.Partition, record.Offset, res.status, callIDHeader, req.Header.Get(callIDHeader))
+	return res.status
+}
+
+// deadLetter sends record to deadLetterTopic with its headers, and where it
+// came from and the status of its last invocation as further headers
+func (k *kafkaConsumer) deadLetter(record *kgo.Record, status int) error {
+	headers := append([]kgo.RecordHeader{}, record.Headers...)
+	headers = append(headers,
+		kgo.RecordHeader{Key: "X-Kafka-Topic", Value: []byte(record.Topic)},
+		kgo.RecordHeader{Key: "X-Kafka-Partition", Value: []byte(strconv.Itoa(int(record.Partition)))},
+		kgo.RecordHeader{Key: "X-Kafka-Offset", Value: []byte(strconv.FormatInt(record.Offset, 10))},
+		kgo.RecordHeader{Key: functionStatusHeader, Value: []byte(strconv.Itoa(status))},
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), kafkaCommitTimeout)
+	defer cancel()
+	return k.produce(ctx, &kgo.Record{
+		Topic:   k.deadLetterTopic,
+		Key:     record.Key,
+		Value:   record.Value,
+		Headers: headers,
+	})
+}
+
+// commit stores the offset after record, a record which is not committed
+// is consumed again after a rebalance
+func (k *kafkaConsumer) commit(client *kgo.Client, record *kgo.Record) {
+	ctx, cancel := context.WithTimeout(context.Background(), kafkaCommitTimeout)
+	defer cancel()
+	if err := client.CommitRecords(ctx, record); err != nil {
+		logging.Errorf("Unable to commit Kafka record %s/%d@%d: %s", record.Topic, record.Partition, record.Offset, err.Error())
+	}
+}
+
+// parseKafkaBrokers accepts host:port pairs, with the default port of 9092
+func parseKafkaBrokers(val string) []string {
+	var brokers []string
+	for _, broker := range parseListValue(val) {
+		broker = strings.TrimPrefix(broker, "kafka://")
+		if _, _, err := net.SplitHostPort(broker); err != nil {
+			broker = net.JoinHostPort(broker, "9092")
+		}
+		brokers = append(brokers, broker)
+	}
+	return brokers
+}
@@ -0,0 +1,26 @@
+// Copyright (c) OpenFaaS Author(s) 2026. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package main
+
+import "net/http"
+
+// knativeProbeHeaders are sent by the kubelet and Knative's networking
+// layer on readiness probes to the function's own port
+var knativeProbeHeaders = []string{"K-Kubelet-Probe", "K-Network-Probe"}
+
+// makeKnativeProbeHandler answers probes as /_/health does, ahead of any
+// authentication, instead of invoking the function
+func makeKnativeProbeHandler(next http.Handler) http.Handler {
+	health := makeHealthHandler()
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, name := range knativeProbeHeaders {
+			if len(r.Header.Get(name)) > 0 {
+				health(w, r)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,107 @@
+// Copyright (c) OpenFaaS Author(s) 2026. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// lastStderrBytes is how much of stderr is kept for each invocation
+const lastStderrBytes = 1024
+
+// invocationSummary is one entry of /_/last
+type invocationSummary struct {
+	Time            string  `json:"time"`
+	CallID          string  `json:"call_id"`
+	Method          string  `json:"method"`
+	Path            string  `json:"path"`
+	Status          int     `json:"status"`
+	DurationSeconds float64 `json:"duration_seconds"`
+	ExitCode        *int    `json:"exit_code,omitempty"`
+	Stderr          string  `json:"stderr,omitempty"`
+}
+
+// invocationRing keeps the last invocations in memory for triage, the
+// oldest is overwritten once size have been kept
+type invocationRing struct {
+	lock    sync.Mutex
+	entries []invocationSummary
+	next    int
+	full    bool
+}
+
+func newInvocationRing(size int) *invocationRing {
+	return &invocationRing{entries: make([]invocationSummary, size)}
+}
+
+func (l *invocationRing) add(entry invocationSummary) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.entries[l.next] = entry
+	if l.next = (l.next + 1) % len(l.entries); l.next == 0 {
+		l.full = true
+	}
+}
+
+// list gives the invocations, newest first
+func (l *invocationRing) list() []invocationSummary {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	count := l.next
+	if l.full {
+		count = len(l.entries)
+	}
+	list := make([]invocationSummary, 0, count)
+	for i := 1; i <= count; i++ {
+		list = append(list, l.entries[(l.next-i+len(l.entries))%len(l.entries)])
+	}
+	return list
+}
+
+// Record adds each request which reaches next, along with the exit code
+// and stderr of its process when one was run
+func (l *invocationRing) Record(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		res := &execResult{}
+		rec := &accessLogRecorder{ResponseWriter: w, status: http.StatusOK}
+
+		next.ServeHTTP(rec, withExecResult(r, res))
+
+		entry := invocationSummary{
+			Time:            start.UTC().Format(time.RFC3339Nano),
+			CallID:          firstNonEmpty(rec.Header().Get(callIDHeader), r.Header.Get(callIDHeader)),
+			Method:          r.Method,
+			Path:            r.URL.Path,
+			Status:          rec.status,
+			DurationSeconds: time.Since(start).Seconds(),
+		}
+		if !res.exited.IsZero() {
+			exitCode := res.exitCode
+			entry.ExitCode = &exitCode
+			entry.Stderr = string(truncateLog(res.stderr, lastStderrBytes))
+		}
+		l.add(entry)
+	})
+}
+
+// Handler gives the invocations as JSON for /_/last
+func (l *invocationRing) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(l.list())
+	})
+}
@@ -0,0 +1,94 @@
+// Copyright (c) OpenFaaS Author(s) 2026. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// shutdownRequests starts a graceful shutdown in the same way as SIGTERM,
+// with the reason given
+var shutdownRequests = make(chan string, 1)
+
+// requestShutdown does not block when a shutdown has already been requested
+func requestShutdown(reason string) {
+	select {
+	case shutdownRequests <- reason:
+	default:
+	}
+}
+
+// makeMaxRequestsHandler shuts down the watchdog after it has served max
+// requests, so that the orchestrator replaces it before slow leaks in the
+// function's runtime build up.
+func makeMaxRequestsHandler(max int64, next http.Handler) http.Handler {
+	var served int64
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r)
+
+		if atomic.AddInt64(&served, 1) == max {
+			requestShutdown(fmt.Sprintf("max_requests of %d served", max))
+		}
+	})
+}
+
+// idleTracker shuts down the watchdog when no requests have arrived for a
+// period, so that an autoscaler can reclaim the replica without an external
+// idler.
+type idleTracker struct {
+	inflight int64
+	last     atomic.Int64
+	now      func() time.Time
+}
+
+func newIdleTracker() *idleTracker {
+	t := &idleTracker{now: time.Now}
+	t.last.Store(t.now().UnixNano())
+	return t
+}
+
+func (t *idleTracker) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&t.inflight, 1)
+		defer func() {
+			t.last.Store(t.now().UnixNano())
+			atomic.AddInt64(&t.inflight, -1)
+		}()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// idle is true when no request is running and the last one finished
+// longer than timeout ago
+func (t *idleTracker) idle(timeout time.Duration) bool {
+	if atomic.LoadInt64(&t.inflight) > 0 {
+		return false
+	}
+	return t.now().Sub(time.Unix(0, t.last.Load())) >= timeout
+}
+
+// Run checks for idleness until it requests a shutdown, or cancel is closed
+func (t *idleTracker) Run(timeout time.Duration, cancel <-chan bool) {
+	interval := min(time.Second, timeout)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-cancel:
+			return
+		}
+
+		if t.idle(timeout) {
+			requestShutdown(fmt.Sprintf("idle for %s", timeout))
+			return
+		}
+	}
+}
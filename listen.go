@@ -0,0 +1,118 @@
+// Copyright (c) OpenFaaS Author(s) 2026. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"strconv"
+
+	"github.com/openfaas/classic-watchdog/logging"
+)
+
+// listenFDsStart is the first file descriptor passed by systemd
+const listenFDsStart = 3
+
+// openListeners creates the listeners for the main HTTP server. When the
+// watchdog was started by systemd socket activation the inherited sockets
+// are used, otherwise there is a TCP listener on port unless listen_tcp is
+// false, and a Unix domain socket when listen_socket is set.
+func openListeners(config *WatchdogConfig) ([]net.Listener, error) {
+	if config.listenFDs > 0 {
+		return activationListeners(config.listenFDs)
+	}
+
+	var listeners []net.Listener
+
+	if config.listenTCP {
+		var lc net.ListenConfig
+		if config.listenReusePort {
+			lc.Control = reusePortControl
+		}
+
+		ln, err := lc.Listen(context.Background(), "tcp", listenAddress(config))
+		if err != nil {
+			return nil, err
+		}
+		logging.Infof("Listening on: %s", ln.Addr())
+		listeners = append(listeners, ln)
+	}
+
+	if len(config.listenSocket) > 0 {
+		ln, err := listenUnixSocket(config.listenSocket)
+		if err != nil {
+			closeListeners(listeners)
+			return nil, err
+		}
+		logging.Infof("Listening on socket: %s", config.listenSocket)
+		listeners = append(listeners, ln)
+	}
+
+	if len(listeners) == 0 {
+		return nil, fmt.Errorf("no listeners configured, set listen_socket when listen_tcp is false")
+	}
+
+	return listeners, nil
+}
+
+// listenAddress gives the address to bind the TCP listener to, i.e.
+// 127.0.0.1:8080 or [::1]:8080, or all interfaces when listen_addr is empty
+func listenAddress(config *WatchdogConfig) string {
+	return net.JoinHostPort(config.listenAddr, strconv.Itoa(config.port))
+}
+
+// activationListeners wraps the sockets passed by systemd, see
+// sd_listen_fds(3). The variables are removed so that they are not passed
+// on to the function.
+func activationListeners(count int) ([]net.Listener, error) {
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	var listeners []net.Listener
+	for fd := listenFDsStart; fd < listenFDsStart+count; fd++ {
+		f := os.NewFile(uintptr(fd), fmt.Sprintf("LISTEN_FD_%d", fd))
+
+		// FileListener duplicates the descriptor, closing the original
+		// means that it is not inherited by the function's process.
+		ln, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			closeListeners(listeners)
+			return nil, fmt.Errorf("socket activation fd %d: %w", fd, err)
+		}
+
+		logging.Infof("Listening on socket from systemd: %s", ln.Addr())
+		listeners = append(listeners, ln)
+	}
+
+	return listeners, nil
+}
+
+// listenUnixSocket removes any socket left from a previous run before
+// listening on path
+func listenUnixSocket(path string) (net.Listener, error) {
+	if info, err := os.Stat(path); err == nil {
+		if info.Mode()&fs.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	return net.Listen("unix", path)
+}
+
+func closeListeners(listeners []net.Listener) {
+	for _, ln := range listeners {
+		ln.Close()
+	}
+}
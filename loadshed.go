@@ -0,0 +1,257 @@
+// Copyright (c) OpenFaaS Author(s) 2026. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/openfaas/classic-watchdog/logging"
+)
+
+const cgroupRoot = "/sys/fs/cgroup"
+
+// cgroupUsage is a sample of the memory and CPU used by the container
+type cgroupUsage struct {
+	// memory is the working set in bytes, which excludes inactive page
+	// cache that the kernel can reclaim
+	memory      int64
+	memoryLimit int64
+
+	// cpu is the total CPU time used, and cpuLimit the number of CPUs the
+	// container may use
+	cpu      time.Duration
+	cpuLimit float64
+}
+
+// loadShedder rejects new requests with a 503 while the memory or CPU
+// used by the container's cgroup is over a percentage of its limit.
+type loadShedder struct {
+	root          string
+	v2            bool
+	memoryPercent float64
+	cpuPercent    float64
+
+	shedding atomic.Bool
+	last     cgroupUsage
+	lastTime time.Time
+}
+
+func newLoadShedder(root string, memoryPercent float64, cpuPercent float64) (*loadShedder, error) {
+	l := &loadShedder{
+		root:          root,
+		memoryPercent: memoryPercent,
+		cpuPercent:    cpuPercent,
+	}
+
+	if _, err := os.Stat(filepath.Join(root, "cgroup.controllers")); err == nil {
+		l.v2 = true
+	}
+
+	usage, err := l.read()
+	if err != nil {
+		return nil, fmt.Errorf("unable to read cgroup usage from %s: %w", root, err)
+	}
+	l.last = usage
+	l.lastTime = time.Now()
+
+	return l, nil
+}
+
+// Run samples the usage every interval until cancel is closed
+func (l *loadShedder) Run(interval time.Duration, cancel <-chan bool) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-cancel:
+			return
+		}
+
+		usage, err := l.read()
+		if err != nil {
+			logging.Errorf("Unable to read cgroup usage: %s", err.Error())
+			continue
+		}
+		l.update(usage, time.Now())
+	}
+}
+
+// update compares a sample with the limits, CPU is averaged since the
+// previous sample
+func (l *loadShedder) update(usage cgroupUsage, now time.Time) {
+	var memoryPercent, cpuPercent float64
+	if usage.memoryLimit > 0 {
+		memoryPercent = float64(usage.memory) / float64(usage.memoryLimit) * 100
+	}
+	if elapsed := now.Sub(l.lastTime); elapsed > 0 && usage.cpuLimit > 0 {
+		cpuPercent = float64(usage.cpu-l.last.cpu) / float64(elapsed) / usage.cpuLimit * 100
+	}
+	l.last = usage
+	l.lastTime = now
+
+	over := (l.memoryPercent > 0 && memoryPercent >= l.memoryPercent) ||
+		(l.cpuPercent > 0 && cpuPercent >= l.cpuPercent)
+
+	if l.shedding.Swap(over) != over {
+		if over {
+			logging.Warnf("Shedding load, memory: %.0f%%, CPU: %.0f%%", memoryPercent, cpuPercent)
+		} else {
+			logging.Infof("Stopped shedding load, memory: %.0f%%, CPU: %.0f%%", memoryPercent, cpuPercent)
+		}
+	}
+}
+
+// Handler responds with a 503 while the container is under pressure
+func (l *loadShedder) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if l.shedding.Load() {
+			w.Header().Set("Content-Type", "text/plain")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprintf(w, "Function is overloaded\n")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (l *loadShedder) read() (cgroupUsage, error) {
+	if l.v2 {
+		return l.readV2()
+	}
+	return l.readV1()
+}
+
+func (l *loadShedder) readV2() (cgroupUsage, error) {
+	var usage cgroupUsage
+
+	current, err := readCgroupInt(filepath.Join(l.root, "memory.current"))
+	if err != nil {
+		return usage, err
+	}
+	inactive, _ := readCgroupStat(filepath.Join(l.root, "memory.stat"), "inactive_file")
+	usage.memory = current - inactive
+	usage.memoryLimit, err = readCgroupInt(filepath.Join(l.root, "memory.max"))
+	if err != nil {
+		return usage, err
+	}
+
+	usec, err := readCgroupStat(filepath.Join(l.root, "cpu.stat"), "usage_usec")
+	if err != nil {
+		return usage, err
+	}
+	usage.cpu = time.Duration(usec) * time.Microsecond
+
+	usage.cpuLimit = float64(runtime.NumCPU())
+	if data, err := os.ReadFile(filepath.Join(l.root, "cpu.max")); err == nil {
+		if quota, period, ok := strings.Cut(strings.TrimSpace(string(data)), " "); ok && quota != "max" {
+			q, _ := strconv.ParseFloat(quota, 64)
+			p, _ := strconv.ParseFloat(period, 64)
+			if q > 0 && p > 0 {
+				usage.cpuLimit = q / p
+			}
+		}
+	}
+
+	return usage, nil
+}
+
+func (l *loadShedder) readV1() (cgroupUsage, error) {
+	var usage cgroupUsage
+
+	current, err := readCgroupInt(filepath.Join(l.root, "memory", "memory.usage_in_bytes"))
+	if err != nil {
+		return usage, err
+	}
+	inactive, _ := readCgroupStat(filepath.Join(l.root, "memory", "memory.stat"), "total_inactive_file")
+	usage.memory = current - inactive
+	usage.memoryLimit, err = readCgroupInt(filepath.Join(l.root, "memory", "memory.limit_in_bytes"))
+	if err != nil {
+		return usage, err
+	}
+
+	nsec, err := readCgroupInt(filepath.Join(l.root, "cpuacct", "cpuacct.usage"))
+	if err != nil {
+		return usage, err
+	}
+	usage.cpu = time.Duration(nsec)
+
+	usage.cpuLimit = float64(runtime.NumCPU())
+	quota, _ := readCgroupInt(filepath.Join(l.root, "cpu", "cpu.cfs_quota_us"))
+	period, _ := readCgroupInt(filepath.Join(l.root, "cpu", "cpu.cfs_period_us"))
+	if quota > 0 && period > 0 {
+		usage.cpuLimit = float64(quota) / float64(period)
+	}
+
+	return usage, nil
+}
+
+// readCgroupInt reads a single value, "max" or an unlimited value is 0
+func readCgroupInt(path string) (int64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+
+	value := strings.TrimSpace(string(data))
+	if value == "max" {
+		return 0, nil
+	}
+
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", path, err)
+	}
+	// cgroup v1 reports no memory limit as the largest page-aligned value
+	if n >= 1<<62 {
+		return 0, nil
+	}
+	return n, nil
+}
+
+// readCgroupStat reads a key from a flat keyed file such as memory.stat
+func readCgroupStat(path string, key string) (int64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		name, value, ok := strings.Cut(scanner.Text(), " ")
+		if ok && name == key {
+			return strconv.ParseInt(value, 10, 64)
+		}
+	}
+	return 0, fmt.Errorf("%s not found in %s", key, path)
+}
+
+// ownCgroupPath reads the cgroup v2 path of the watchdog, which is "/" in
+// a container with its own cgroup namespace
+func ownCgroupPath(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		if cgroup, ok := strings.CutPrefix(scanner.Text(), "0::"); ok {
+			return cgroup, nil
+		}
+	}
+	return "", fmt.Errorf("no cgroup v2 entry in %s", path)
+}
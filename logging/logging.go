@@ -0,0 +1,176 @@
+// Package logging provides a small leveled logger for the watchdog. Text
+// output is written via the standard log package, and JSON output via
+// log/slog, so that lines from both formats share the same levels.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+// Level is the severity of a log line
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var (
+	level      atomic.Int32
+	jsonFormat atomic.Bool
+	slogLevel  = new(slog.LevelVar)
+
+	output io.Writer = os.Stderr
+)
+
+func init() {
+	SetLevel(LevelInfo)
+}
+
+// ParseLevel reads a level from one of: debug, info, warn or error
+func ParseLevel(value string) (Level, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "debug":
+		return LevelDebug, nil
+	case "info":
+		return LevelInfo, nil
+	case "warn", "warning":
+		return LevelWarn, nil
+	case "error":
+		return LevelError, nil
+	}
+	return LevelInfo, fmt.Errorf("unknown log level: %q", value)
+}
+
+// String gives the name of the level
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "debug"
+	case LevelWarn:
+		return "warn"
+	case LevelError:
+		return "error"
+	}
+	return "info"
+}
+
+func (l Level) slogLevel() slog.Level {
+	switch l {
+	case LevelDebug:
+		return slog.LevelDebug
+	case LevelWarn:
+		return slog.LevelWarn
+	case LevelError:
+		return slog.LevelError
+	}
+	return slog.LevelInfo
+}
+
+// Configure sets the output format, "text" or "json", and the minimum
+// level to write.
+func Configure(format string, l Level) {
+	SetLevel(l)
+
+	jsonFormat.Store(format == "json")
+	if JSON() {
+		slog.SetDefault(slog.New(slog.NewJSONHandler(output, &slog.HandlerOptions{Level: slogLevel})))
+	}
+}
+
+// SetOutput changes where logs are written, the default is stderr. It
+// must be called before Configure.
+func SetOutput(w io.Writer) {
+	output = w
+	log.SetOutput(w)
+}
+
+// SetLevel changes the minimum level at runtime
+func SetLevel(l Level) {
+	level.Store(int32(l))
+	slogLevel.Set(l.slogLevel())
+}
+
+// GetLevel returns the current minimum level
+func GetLevel() Level {
+	return Level(level.Load())
+}
+
+// Enabled is true when lines at l will be written
+func Enabled(l Level) bool {
+	return l >= GetLevel()
+}
+
+// JSON is true when structured JSON output is configured
+func JSON() bool {
+	return jsonFormat.Load()
+}
+
+// Debugf writes a line at the debug level
+func Debugf(format string, args ...interface{}) {
+	logf(LevelDebug, format, args...)
+}
+
+// Infof writes a line at the info level
+func Infof(format string, args ...interface{}) {
+	logf(LevelInfo, format, args...)
+}
+
+// Warnf writes a line at the warn level
+func Warnf(format string, args ...interface{}) {
+	logf(LevelWarn, format, args...)
+}
+
+// Errorf writes a line at the error level
+func Errorf(format string, args ...interface{}) {
+	logf(LevelError, format, args...)
+}
+
+// Fatalf writes a line at the error level then exits with a non-zero code
+func Fatalf(format string, args ...interface{}) {
+	logf(LevelError, format, args...)
+	os.Exit(1)
+}
+
+// Info writes a structured line at the info level, in the text format
+// the attributes are written as key=value pairs after the message.
+func Info(msg string, attrs ...interface{}) {
+	if !Enabled(LevelInfo) {
+		return
+	}
+
+	if JSON() {
+		slog.Info(msg, attrs...)
+		return
+	}
+
+	var b strings.Builder
+	b.WriteString(msg)
+	for i := 0; i+1 < len(attrs); i += 2 {
+		fmt.Fprintf(&b, " %v=%v", attrs[i], attrs[i+1])
+	}
+	log.Print(b.String())
+}
+
+func logf(l Level, format string, args ...interface{}) {
+	if !Enabled(l) {
+		return
+	}
+
+	msg := strings.TrimSuffix(fmt.Sprintf(format, args...), "\n")
+	if JSON() {
+		slog.Log(context.Background(), l.slogLevel(), msg)
+		return
+	}
+
+	log.Print(msg)
+}
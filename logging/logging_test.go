@@ -0,0 +1,72 @@
+package logging
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"strings"
+	"testing"
+)
+
+func Test_ParseLevel(t *testing.T) {
+	cases := map[string]Level{
+		"debug":   LevelDebug,
+		"INFO":    LevelInfo,
+		"warn":    LevelWarn,
+		"warning": LevelWarn,
+		" error ": LevelError,
+	}
+
+	for value, want := range cases {
+		got, err := ParseLevel(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("ParseLevel(%q) want: %s, got: %s", value, want, got)
+		}
+	}
+
+	if _, err := ParseLevel("verbose"); err == nil {
+		t.Errorf("ParseLevel should reject unknown levels")
+	}
+}
+
+func Test_Logf_FiltersBelowLevel(t *testing.T) {
+	var b bytes.Buffer
+	log.SetOutput(&b)
+	defer log.SetOutput(os.Stderr)
+
+	SetLevel(LevelWarn)
+	defer SetLevel(LevelInfo)
+
+	Debugf("debug line")
+	Infof("info line")
+	Warnf("warn line")
+	Errorf("error line")
+
+	out := b.String()
+	for _, skipped := range []string{"debug line", "info line"} {
+		if strings.Contains(out, skipped) {
+			t.Errorf("output should not contain %q, got: %s", skipped, out)
+		}
+	}
+	for _, want := range []string{"warn line", "error line"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output should contain %q, got: %s", want, out)
+		}
+	}
+}
+
+func Test_Info_TextFormatAppendsAttributes(t *testing.T) {
+	var b bytes.Buffer
+	log.SetOutput(&b)
+	defer log.SetOutput(os.Stderr)
+
+	Info("Invocation complete", "status", 200, "call_id", "abc")
+
+	want := "Invocation complete status=200 call_id=abc"
+	if !strings.Contains(b.String(), want) {
+		t.Errorf("want: %q, got: %q", want, b.String())
+	}
+}
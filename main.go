@@ -8,18 +8,23 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"flag"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
-	"path/filepath"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"syscall"
 	"time"
 
+	"github.com/openfaas/classic-watchdog/logging"
 	"github.com/openfaas/classic-watchdog/metrics"
+	"github.com/openfaas/classic-watchdog/tracing"
 	"github.com/openfaas/classic-watchdog/types"
 	"github.com/openfaas/faas-middleware/auth"
 	"github.com/prometheus/client_golang/prometheus/testutil"
@@ -27,13 +32,40 @@ import (
 
 var (
 	acceptingConnections int32
+
+	// shuttingDown is set once a graceful shutdown has started
+	shuttingDown int32
+
+	// execMetrics records the timings of each process when set
+	execMetrics *metrics.Exec
+
+	// breaker rejects requests after consecutive failures when set
+	breaker *circuitBreaker
+
+	// execCgroups limits the resources of each process when set
+	execCgroups *invocationCgroups
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == sandboxCommand {
+		os.Exit(runSandbox(os.Args[2:], os.Stderr))
+	}
+
 	var runHealthcheck bool
 	var versionFlag bool
+	var configFile string
+	setFlags := map[string]string{}
 
 	flag.BoolVar(&versionFlag, "version", false, "Print the version and exit")
+	flag.StringVar(&configFile, "config-file", "", "A YAML or TOML file of options, overridden by environment variables")
+	flag.Func("set", "Set an option as name=value, overriding the environment and config file. May be repeated", func(value string) error {
+		name, optionValue, ok := strings.Cut(value, "=")
+		if !ok {
+			return fmt.Errorf("want name=value")
+		}
+		setFlags[name] = optionValue
+		return nil
+	})
 	flag.BoolVar(&runHealthcheck,
 		"run-healthcheck",
 		false,
@@ -41,6 +73,26 @@ func main() {
 
 	flag.Parse()
 
+	if len(configFile) == 0 {
+		configFile = os.Getenv("config_file")
+	} else {
+		setFlags["config_file"] = configFile
+	}
+
+	var fileValues map[string]string
+	if len(configFile) > 0 {
+		var err error
+		fileValues, err = readConfigFile(configFile)
+		if err != nil {
+			logging.Fatalf("Unable to read config_file: %s", err.Error())
+		}
+	}
+
+	env := layeredEnv{flags: setFlags, env: types.OsEnv{}, file: fileValues}
+	readConfig := ReadConfig{}
+	config := readConfig.Read(env)
+	lockFilePath = config.lockFilePath
+
 	if runHealthcheck {
 		if lockFilePresent() {
 			os.Exit(0)
@@ -50,124 +102,554 @@ func main() {
 		os.Exit(1)
 	}
 
+	logging.Configure(config.logFormat, config.logLevel)
+
 	printVersion()
 
 	if versionFlag {
 		return
 	}
 
+	if flag.Arg(0) == "validate" {
+		var names []string
+		for _, kv := range os.Environ() {
+			name, _, _ := strings.Cut(kv, "=")
+			names = append(names, name)
+		}
+		for name := range fileValues {
+			names = append(names, name)
+		}
+		for name := range setFlags {
+			names = append(names, name)
+		}
+		os.Exit(runValidate(env, names, os.Stdout))
+	}
+
+	if flag.Arg(0) == "verify-audit" {
+		os.Exit(runVerifyAudit(flag.Args()[1:], os.Stdout))
+	}
+
 	atomic.StoreInt32(&acceptingConnections, 0)
 
-	osEnv := types.OsEnv{}
-	readConfig := ReadConfig{}
-	config := readConfig.Read(osEnv)
+	if len(config.routesFile) > 0 {
+		routes, err := readRoutesFile(config.routesFile)
+		if err != nil {
+			logging.Fatalf("Unable to read routes_file: %s", err.Error())
+		}
+		config.routes = append(config.routes, routes...)
+	}
 
-	if len(config.faasProcess) == 0 {
+	if len(config.faasProcess) == 0 && len(config.routes) == 0 {
 		log.Panicln("Provide a valid process via fprocess environmental variable.")
 		return
 	}
 
+	if err := validateProcesses(&config); err != nil {
+		logging.Fatalf("Unable to run the function: %s", err.Error())
+	}
+
+	if len(config.execUser) > 0 {
+		cred, err := lookupCredential(config.execUser, config.execGroup)
+		if err != nil {
+			logging.Fatalf("Unable to run the function: %s", err.Error())
+		}
+		config.execCredential = cred
+		logging.Infof("Running fprocess as uid: %d gid: %d", cred.uid, cred.gid)
+	} else if len(config.execGroup) > 0 {
+		logging.Fatalf("exec_group needs exec_user")
+	}
+
+	if err := validateSandbox(&config); err != nil {
+		logging.Fatalf("Unable to run the function: %s", err.Error())
+	}
+
+	if flag.NArg() > 0 {
+		switch flag.Arg(0) {
+		case "self-test":
+			os.Exit(runSelfTest(&config, flag.Args()[1:], os.Stdout))
+		case "replay":
+			os.Exit(runReplay(&config, flag.Args()[1:], os.Stdout))
+		default:
+			logging.Fatalf("Unknown command: %s", flag.Arg(0))
+		}
+	}
+
 	readTimeout := config.readTimeout
 	writeTimeout := config.writeTimeout
 	healthcheckInterval := config.healthcheckInterval
 
 	s := &http.Server{
-		Addr:           fmt.Sprintf(":%d", config.port),
-		ReadTimeout:    readTimeout,
-		WriteTimeout:   writeTimeout,
-		MaxHeaderBytes: 1 << 20, // Max header of 1MB
+		Addr:              listenAddress(&config),
+		ReadTimeout:       readTimeout,
+		WriteTimeout:      writeTimeout,
+		IdleTimeout:       config.idleTimeout,
+		ReadHeaderTimeout: config.readHeaderTimeout,
+		MaxHeaderBytes:    config.maxHeaderBytes,
 	}
 
-	httpMetrics := metrics.NewHttp()
+	cancel := make(chan bool)
+
+	if config.h2c {
+		s.Protocols = serverProtocols(true)
+	}
+
+	if len(config.tlsCert) > 0 || len(config.tlsKey) > 0 {
+		reloader, err := newCertReloader(config.tlsCert, config.tlsKey)
+		if err != nil {
+			logging.Fatalf("Unable to load TLS certificate: %s", err.Error())
+		}
+		go reloader.watch(config.tlsReloadInterval, cancel)
+
+		s.TLSConfig = &tls.Config{
+			MinVersion:     tls.VersionTLS12,
+			GetCertificate: reloader.GetCertificate,
+		}
 
-	log.Printf("Timeouts: read: %s write: %s hard: %s health: %s.\n",
+		if len(config.tlsClientCA) > 0 {
+			clientCAs, err := loadClientCAs(config.tlsClientCA)
+			if err != nil {
+				logging.Fatalf("Unable to load tls_client_ca: %s", err.Error())
+			}
+			clientAuth, err := clientAuthType(config.tlsClientAuth)
+			if err != nil {
+				logging.Fatalf("%s", err.Error())
+			}
+
+			s.TLSConfig.ClientCAs = clientCAs
+			s.TLSConfig.ClientAuth = clientAuth
+		}
+		logging.Infof("Serving TLS with certificate: %s", config.tlsCert)
+	}
+
+	httpMetrics := metrics.NewHttp(config.metricsBuckets)
+	processMetrics := metrics.NewExec(config.metricsBuckets)
+	execMetrics = &processMetrics
+	metrics.RegisterBuildInfo(BuildVersion(), GitCommit)
+
+	logging.Infof("Timeouts: read: %s write: %s hard: %s health: %s.\n",
 		readTimeout,
 		writeTimeout,
 		config.execTimeout,
 		healthcheckInterval)
-	log.Printf("Listening on port: %d\n", config.port)
 
-	requestHandler := makeRequestHandler(&config)
-	if config.jwtAuthentication {
+	if config.execMemoryLimit > 0 || config.execCPULimit > 0 {
+		cgroups, err := newInvocationCgroups(cgroupRoot, config.execMemoryLimit, config.execCPULimit)
+		if err != nil {
+			logging.Fatalf("Unable to create cgroups for exec_memory_limit and exec_cpu_limit: %s", err.Error())
+		}
+		execCgroups = cgroups
+	}
+
+	if len(config.scratchDir) > 0 && (config.scratchMaxAge > 0 || config.scratchMaxBytes > 0) {
+		janitorMetrics := metrics.NewJanitor()
+		logging.Infof("Cleaning scratch_dir: %s every %s", config.scratchDir, config.scratchInterval)
+		go newJanitor(config.scratchDir, config.scratchMaxAge, config.scratchMaxBytes, &janitorMetrics).Run(config.scratchInterval, cancel)
+	}
+
+	if config.circuitBreakerFailures > 0 {
+		breaker = newCircuitBreaker(config.circuitBreakerFailures, config.circuitBreakerCoolDown)
+	}
+
+	requestHandler, inflight := newRequestHandler(&config)
+	var gate *readyGate
+	if config.readyAfterFirstSuccess {
+		gate = newReadyGate()
+		requestHandler = gate.Handler(requestHandler)
+	}
+	if len(config.captureDir) > 0 {
+		if err := os.MkdirAll(config.captureDir, 0700); err != nil {
+			logging.Fatalf("Unable to create capture_dir: %s", err.Error())
+		}
+		logging.Warnf("Capturing requests to: %s", config.captureDir)
+		requestHandler = makeCaptureHandler(config.captureDir, config.logRedactHeaders, config.maxRequestBytes, requestHandler)
+	}
+
+	var lastInvocations *invocationRing
+	if config.lastInvocations > 0 {
+		lastInvocations = newInvocationRing(config.lastInvocations)
+		requestHandler = lastInvocations.Record(requestHandler)
+	}
+
+	consumers := newConsumerGroup()
+	if len(config.natsURL) > 0 {
+		if len(config.natsStream) == 0 || len(config.natsConsumer) == 0 {
+			logging.Fatalf("nats_url needs nats_stream and nats_consumer")
+		}
+		consumers.Go(newNATSWorker(config.natsURL, config.natsStream, config.natsConsumer, requestHandler).Run)
+	}
+
+	if len(config.cronSchedule) > 0 {
+		schedule, err := parseCronSchedule(config.cronSchedule)
+		if err != nil {
+			logging.Fatalf("Invalid cron_schedule: %s", err.Error())
+		}
+		consumers.Go(newCronRunner(schedule, config.cronSchedule, config.cronPayload, requestHandler).Run)
+	}
+
+	if len(config.kafkaBrokers) > 0 {
+		if len(config.kafkaTopic) == 0 || len(config.kafkaGroup) == 0 {
+			logging.Fatalf("kafka_brokers needs kafka_topic and kafka_group")
+		}
+		kafka, err := newKafkaConsumer(&config, requestHandler)
+		if err != nil {
+			logging.Fatalf("Unable to configure kafka_brokers: %s", err.Error())
+		}
+		consumers.Go(kafka.Run)
+	}
+
+	var async *asyncInvoker
+	if config.asyncCallback {
+		if len(config.asyncCallbackAllow) == 0 {
+			logging.Warnf("async_callback needs async_callback_allow, callbacks will be refused")
+		}
+		async = newAsyncInvoker(requestHandler, config.asyncCallbackAllow, config.maxRequestBytes, config.asyncMaxJobs)
+		requestHandler = async
+	}
+
+	reloader := newConfigReloader(&config, inflight, func() (WatchdogConfig, error) {
+		fileValues := fileValues
+		if len(configFile) > 0 {
+			var err error
+			if fileValues, err = readConfigFile(configFile); err != nil {
+				return WatchdogConfig{}, err
+			}
+		}
+		return readConfig.Read(layeredEnv{flags: setFlags, env: types.OsEnv{}, file: fileValues}), nil
+	})
+	go reloader.Run(cancel)
+
+	if config.maxRequests > 0 {
+		requestHandler = makeMaxRequestsHandler(config.maxRequests, requestHandler)
+	}
+	if config.idleShutdown > 0 {
+		idle := newIdleTracker()
+		go idle.Run(config.idleShutdown, cancel)
+		requestHandler = idle.Handler(requestHandler)
+	}
+	if len(config.jwtIssuer) > 0 {
+		requestHandler = makeJWTClaimsHandler(config.jwtClaims, requestHandler)
+
+		oidc, err := newOIDCAuth(config.jwtIssuer, config.jwtJWKSURL, config.jwtAudience)
+		if err != nil {
+			logging.Fatalf("Error creating JWT authentication for %s: %s", config.jwtIssuer, err.Error())
+		}
+		go oidc.Run(config.jwtJWKSRefresh, cancel)
+
+		logging.Infof("Validating JWTs from issuer: %s", config.jwtIssuer)
+		requestHandler = oidc.Handler(requestHandler)
+	} else if config.jwtAuthentication {
+		if len(config.jwtClaims) > 0 {
+			requestHandler = makeJWTClaimsHandler(config.jwtClaims, requestHandler)
+		}
+
 		handler, err := makeJWTAuthHandler(config, requestHandler)
 		if err != nil {
-			log.Fatalf("Error creating JWTAuthMiddleware: %s", err.Error())
+			logging.Fatalf("Error creating JWTAuthMiddleware: %s", err.Error())
 		}
 		requestHandler = handler
 
 	}
 
-	http.HandleFunc("/_/health", makeHealthHandler())
-	http.HandleFunc("/", metrics.InstrumentHandler(requestHandler, httpMetrics))
+	if config.basicAuth {
+		handler, err := makeBasicAuthHandler(config, requestHandler)
+		if err != nil {
+			logging.Fatalf("Error creating basic auth: %s", err.Error())
+		}
+		requestHandler = handler
+	}
 
-	metricsServer := metrics.MetricsServer{}
-	metricsServer.Register(config.metricsPort)
+	if config.apiKeyAuth {
+		handler, err := makeAPIKeyHandler(config, requestHandler)
+		if err != nil {
+			logging.Fatalf("Error creating API key auth: %s", err.Error())
+		}
+		requestHandler = handler
+	}
 
-	cancel := make(chan bool)
+	if config.hmacAuth {
+		handler, err := makeHMACHandler(config, requestHandler)
+		if err != nil {
+			logging.Fatalf("Error creating HMAC verification: %s", err.Error())
+		}
+		requestHandler = handler
+	}
+
+	if len(config.corsAllowOrigins) > 0 {
+		requestHandler = makeCORSHandler(&config, requestHandler)
+	}
+
+	if config.shedMemoryPercent > 0 || config.shedCPUPercent > 0 {
+		shedder, err := newLoadShedder(cgroupRoot, float64(config.shedMemoryPercent), float64(config.shedCPUPercent))
+		if err != nil {
+			logging.Fatalf("Error creating load shedding: %s", err.Error())
+		}
+		go shedder.Run(config.shedInterval, cancel)
+		requestHandler = shedder.Handler(requestHandler)
+	}
+
+	if config.rateLimit > 0 {
+		requestHandler = newRateLimiter(config.rateLimit, config.rateLimitBurst, config.rateLimitHeader).Handler(requestHandler)
+	}
+
+	if len(config.allowCIDRs) > 0 || len(config.denyCIDRs) > 0 {
+		handler, err := makeIPFilterHandler(config.allowCIDRs, config.denyCIDRs, requestHandler)
+		if err != nil {
+			logging.Fatalf("Error creating IP filter: %s", err.Error())
+		}
+		requestHandler = handler
+	}
+
+	if len(config.statsdAddr) > 0 {
+		statsd, err := metrics.NewStatsD(config.statsdAddr, config.statsdPrefix, config.statsdDogStatsD)
+		if err != nil {
+			logging.Fatalf("Error creating StatsD client: %s", err.Error())
+		}
+		logging.Infof("Sending StatsD metrics to: %s", config.statsdAddr)
+		requestHandler = statsd.InstrumentHandler(requestHandler)
+	}
+
+	if len(config.auditLog) > 0 {
+		audit, err := openAuditLog(config.auditLog)
+		if err != nil {
+			logging.Fatalf("Unable to open audit_log: %s", err.Error())
+		}
+		requestHandler = audit.Handler(requestHandler)
+	}
+
+	if len(config.accessLog) > 0 {
+		out, err := openAccessLog(config.accessLog)
+		if err != nil {
+			logging.Fatalf("Unable to open access_log: %s", err.Error())
+		}
+		requestHandler = makeAccessLogHandler(out, requestHandler)
+	}
+
+	healthHandler := makeHealthHandler()
+	readyHandler := makeReadyHandler(inflight)
+	if len(config.healthcheckCommand) > 0 {
+		check := newHealthCommand(&config)
+		if config.healthcheckCommandInterval > 0 {
+			go check.Run(config.healthcheckCommandInterval, cancel)
+		} else {
+			healthHandler = check.Handler(healthHandler)
+			readyHandler = check.Handler(readyHandler)
+		}
+	}
+	http.HandleFunc("/_/health", healthHandler)
+	http.HandleFunc("/_/ready", readyHandler)
+	if config.knative {
+		http.Handle("/", makeKnativeProbeHandler(metrics.InstrumentHandler(requestHandler, httpMetrics)))
+	} else {
+		http.HandleFunc("/", metrics.InstrumentHandler(requestHandler, httpMetrics))
+	}
+
+	var grpc *grpcServer
+	if config.grpcPort > 0 {
+		var tlsConfig *tls.Config
+		if s.TLSConfig != nil {
+			tlsConfig = s.TLSConfig.Clone()
+		}
+		grpc = newGRPCServer(&config, tlsConfig, metrics.InstrumentHandler(requestHandler, httpMetrics))
+		grpc.Serve()
+	}
+
+	if config.metricsEnabled {
+		metricsServer := metrics.MetricsServer{}
+		metricsServer.RegisterPath(config.metricsPort, config.metricsPath)
+		if config.metricsPprof {
+			metricsServer.EnablePprof()
+		}
+		basicAuth, err := readSecretValue(config.metricsBasicAuth, config.metricsBasicAuthFile)
+		if err != nil {
+			logging.Fatalf("Unable to read metrics_basic_auth_file: %s", err.Error())
+		}
+		bearerToken, err := readSecretValue(config.metricsBearerToken, config.metricsBearerTokenFile)
+		if err != nil {
+			logging.Fatalf("Unable to read metrics_bearer_token_file: %s", err.Error())
+		}
+		metricsServer.RequireAuth(basicAuth, bearerToken)
+
+		// Draining stops the function serving traffic, the configuration
+		// shows its settings, a reload changes them and the last
+		// invocations show paths and stderr, so these are only available
+		// when the metrics port needs credentials
+		if len(basicAuth) > 0 || len(bearerToken) > 0 {
+			metricsServer.Handle("/_/config", makeConfigHandler(&config))
+			metricsServer.Handle("/_/reload", reloader.Handler())
+			metricsServer.Handle("/_/drain", makeDrainHandler(config.suppressLock))
+			if lastInvocations != nil {
+				metricsServer.Handle("/_/last", lastInvocations.Handler())
+			}
+		} else if lastInvocations != nil {
+			logging.Warnf("last_invocations needs metrics_basic_auth or metrics_bearer_token to serve /_/last")
+		}
+
+		go metricsServer.Serve(cancel)
+	}
 
-	go metricsServer.Serve(cancel)
+	var pusher *metrics.Pusher
+	if len(config.pushgatewayURL) > 0 && config.pushgatewayInterval > 0 {
+		instance, _ := os.Hostname()
+		logging.Infof("Pushing metrics to: %s every %s", config.pushgatewayURL, config.pushgatewayInterval)
+		pusher = metrics.NewPusher(config.pushgatewayURL, config.pushgatewayJob, instance)
+		go pusher.Run(config.pushgatewayInterval, cancel)
+	}
+
+	if len(config.otlpTracesEndpoint) > 0 {
+		logging.Infof("Exporting traces to: %s", config.otlpTracesEndpoint)
+		tracer = tracing.NewExporter(config.otlpTracesEndpoint, config.otelServiceName)
+		go tracer.Run(time.Second*5, cancel)
+	}
+
+	if len(config.warmupRequest) > 0 && gate == nil {
+		if err := warmup(makeRequestHandler(&config), config.warmupRequest, config.warmupBody); err != nil {
+			logging.Fatalf("Function failed to warm up: %s", err.Error())
+		}
+	} else if len(config.warmupRequest) > 0 {
+		go gate.RetryWarmup(makeRequestHandler(&config), config.warmupRequest, config.warmupBody, healthcheckInterval, cancel)
+	}
 
-	listenUntilShutdown(s, healthcheckInterval, writeTimeout, config.suppressLock, &httpMetrics)
+	listeners, err := openListeners(&config)
+	if err != nil {
+		logging.Fatalf("Unable to listen: %s", err.Error())
+	}
+
+	var ready <-chan struct{}
+	if gate != nil {
+		ready = gate.Ready()
+	}
+	listenUntilShutdown(s, listeners, healthcheckInterval, writeTimeout, config.suppressLock, ready, consumers, grpc, &httpMetrics)
+
+	if async != nil {
+		async.Wait(writeTimeout)
+	}
+
+	if tracer != nil {
+		if err := tracer.Flush(); err != nil {
+			logging.Errorf("Unable to export spans: %s", err.Error())
+		}
+	}
+
+	if pusher != nil {
+		if err := pusher.Push(); err != nil {
+			logging.Errorf("Unable to push metrics: %s", err.Error())
+		}
+	}
 }
 
 // listenUntilShutdown will listen for HTTP requests until SIGTERM
 // is sent at which point the code will wait `shutdownTimeout` before
 // closing off connections and a futher `shutdownTimeout` before
-// exiting
-func listenUntilShutdown(s *http.Server, healthcheckInterval time.Duration, writeTimeout time.Duration, suppressLock bool, httpMetrics *metrics.Http) {
+// exiting. The consumers are stopped straight away, and their invocations
+// in progress are given the same time as the connections.
+func listenUntilShutdown(s *http.Server, listeners []net.Listener, healthcheckInterval time.Duration, writeTimeout time.Duration, suppressLock bool, ready <-chan struct{}, consumers *consumerGroup, grpc *grpcServer, httpMetrics *metrics.Http) {
 
 	idleConnsClosed := make(chan struct{})
+	var closeOnce sync.Once
+	shutdownStarted := make(chan struct{})
 	go func() {
 		sig := make(chan os.Signal, 1)
 		signal.Notify(sig, syscall.SIGTERM)
 
-		<-sig
-
-		log.Printf("SIGTERM: no new connections in %s\n", healthcheckInterval.String())
+		select {
+		case <-sig:
+			logging.Infof("SIGTERM: no new connections in %s", healthcheckInterval.String())
+		case reason := <-shutdownRequests:
+			logging.Infof("Shutting down, %s: no new connections in %s", reason, healthcheckInterval.String())
+		}
+		close(shutdownStarted)
+		atomic.StoreInt32(&shuttingDown, 1)
+		consumers.Stop()
 
 		if err := markUnhealthy(); err != nil {
-			log.Printf("Unable to mark server as unhealthy: %s\n", err.Error())
+			logging.Errorf("Unable to mark server as unhealthy: %s", err.Error())
 		}
 
 		<-time.Tick(healthcheckInterval)
 
 		connections := int64(testutil.ToFloat64(httpMetrics.InFlight))
-		log.Printf("No new connections allowed, draining: %d requests\n", connections)
+		logging.Infof("No new connections allowed, draining: %d requests", connections)
 
 		// The maximum time to wait for active connections whilst shutting down is
 		// equivalent to the maximum execution time i.e. writeTimeout.
 		ctx, cancel := context.WithTimeout(context.Background(), writeTimeout)
 		defer cancel()
 
+		// gRPC calls drain alongside HTTP requests
+		var grpcDrained sync.WaitGroup
+		if grpc != nil {
+			grpcDrained.Add(1)
+			go func() {
+				defer grpcDrained.Done()
+				if err := grpc.Shutdown(ctx); err != nil {
+					logging.Errorf("Error in gRPC Shutdown: %v", err)
+				}
+			}()
+		}
+
 		if err := s.Shutdown(ctx); err != nil {
-			log.Printf("Error in Shutdown: %v", err)
+			logging.Errorf("Error in Shutdown: %v", err)
+		}
+		grpcDrained.Wait()
+		if !consumers.Wait(ctx) {
+			logging.Warnf("Exiting with consumer invocations in progress")
 		}
 
 		connections = int64(testutil.ToFloat64(httpMetrics.InFlight))
 
-		log.Printf("Exiting. Active connections: %d\n", connections)
+		logging.Infof("Exiting. Active connections: %d", connections)
 
-		close(idleConnsClosed)
+		closeOnce.Do(func() { close(idleConnsClosed) })
 	}()
 
-	// Run the HTTP server in a separate go-routine.
-	go func() {
-		if err := s.ListenAndServe(); err != http.ErrServerClosed {
-			log.Printf("Error ListenAndServe: %v", err)
-			close(idleConnsClosed)
-		}
-	}()
+	// Serve populates TLSConfig when configuring HTTP/2, so check for TLS
+	// before the first listener is started.
+	useTLS := s.TLSConfig != nil
+
+	// Run the HTTP server in a separate go-routine for each listener.
+	for _, ln := range listeners {
+		go func(ln net.Listener) {
+			var err error
+			if useTLS {
+				err = s.ServeTLS(ln, "", "")
+			} else {
+				err = s.Serve(ln)
+			}
+
+			if err != http.ErrServerClosed {
+				logging.Errorf("Error Serve: %v", err)
+				closeOnce.Do(func() { close(idleConnsClosed) })
+			}
+		}(ln)
+	}
+
+	markReady := func() {
+		if suppressLock == false {
+			path, writeErr := createLockFile()
 
-	if suppressLock == false {
-		path, writeErr := createLockFile()
+			if writeErr != nil {
+				log.Panicf("Cannot write %s. To disable lock-file set env suppress_lock=true.\n Error: %s.\n", path, writeErr.Error())
+			}
+		} else {
+			logging.Warnf("Warning: \"suppress_lock\" is enabled. No automated health-checks will be in place for your function.")
 
-		if writeErr != nil {
-			log.Panicf("Cannot write %s. To disable lock-file set env suppress_lock=true.\n Error: %s.\n", path, writeErr.Error())
+			atomic.StoreInt32(&acceptingConnections, 1)
 		}
-	} else {
-		log.Println("Warning: \"suppress_lock\" is enabled. No automated health-checks will be in place for your function.")
+	}
 
-		atomic.StoreInt32(&acceptingConnections, 1)
+	// With ready_after_first_success the replica is only healthy once the
+	// function has completed a request
+	if ready != nil {
+		logging.Infof("Waiting for a successful invocation before writing the lock-file")
+		go func() {
+			select {
+			case <-ready:
+				markReady()
+			case <-shutdownStarted:
+			}
+		}()
+	} else {
+		markReady()
 	}
 
 	<-idleConnsClosed
@@ -176,8 +658,8 @@ func listenUntilShutdown(s *http.Server, healthcheckInterval time.Duration, writ
 func markUnhealthy() error {
 	atomic.StoreInt32(&acceptingConnections, 0)
 
-	path := filepath.Join(os.TempDir(), ".lock")
-	log.Printf("Removing lock-file : %s\n", path)
+	path := lockFile()
+	logging.Infof("Removing lock-file : %s", path)
 	removeErr := os.Remove(path)
 	return removeErr
 }
@@ -188,7 +670,7 @@ func printVersion() {
 		sha = GitCommit
 	}
 
-	log.Printf("Version: %v\tSHA: %v\n", BuildVersion(), sha)
+	logging.Infof("Version: %v\tSHA: %v", BuildVersion(), sha)
 }
 
 func makeJWTAuthHandler(c WatchdogConfig, next http.Handler) (http.Handler, error) {
@@ -208,7 +690,32 @@ func makeJWTAuthHandler(c WatchdogConfig, next http.Handler) (http.Handler, erro
 		Debug:          c.jwtAuthDebug,
 	}
 
-	return auth.NewJWTAuthMiddleware(authOpts, next)
+	return auth.NewJWTAuthMiddleware(authOpts, makeJWTCallerHandler(next))
+}
+
+// serverProtocols gives the protocols for the main listener, HTTP/2 is
+// negotiated over TLS by default and h2c adds HTTP/2 with prior knowledge
+// over plain-text connections.
+func serverProtocols(h2c bool) *http.Protocols {
+	var protocols http.Protocols
+	protocols.SetHTTP1(true)
+	protocols.SetHTTP2(true)
+	protocols.SetUnencryptedHTTP2(h2c)
+	return &protocols
+}
+
+// readSecretValue gives the contents of path with surrounding whitespace
+// removed, or value when path is empty
+func readSecretValue(value string, path string) (string, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
 }
 
 func getFnName() (string, error) {
@@ -0,0 +1,56 @@
+package metrics
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// RequireAuth protects the metrics endpoint, callers must send either
+// basicAuth as "user:password" with HTTP basic authentication, or
+// bearerToken as a bearer token. Either may be empty to disable it. It
+// must be called after Register.
+func (m *MetricsServer) RequireAuth(basicAuth string, bearerToken string) {
+	if len(basicAuth) == 0 && len(bearerToken) == 0 {
+		return
+	}
+
+	m.s.Handler = authHandler(basicAuth, bearerToken, m.s.Handler)
+}
+
+func authHandler(basicAuth string, bearerToken string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if authorized(r, basicAuth, bearerToken) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if len(basicAuth) > 0 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="metrics"`)
+		} else {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+		}
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+	})
+}
+
+func authorized(r *http.Request, basicAuth string, bearerToken string) bool {
+	if len(basicAuth) > 0 {
+		if user, password, ok := r.BasicAuth(); ok && secureCompare(user+":"+password, basicAuth) {
+			return true
+		}
+	}
+
+	if len(bearerToken) > 0 {
+		header := r.Header.Get("Authorization")
+		if token, ok := strings.CutPrefix(header, "Bearer "); ok && secureCompare(token, bearerToken) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func secureCompare(given string, want string) bool {
+	return subtle.ConstantTimeCompare([]byte(given), []byte(want)) == 1
+}
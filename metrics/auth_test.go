@@ -0,0 +1,40 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_AuthHandler(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	handler := authHandler("prom:secret", "token", next)
+
+	cases := []struct {
+		name   string
+		setup  func(r *http.Request)
+		status int
+	}{
+		{"no credentials", func(r *http.Request) {}, http.StatusUnauthorized},
+		{"basic auth", func(r *http.Request) { r.SetBasicAuth("prom", "secret") }, http.StatusOK},
+		{"wrong password", func(r *http.Request) { r.SetBasicAuth("prom", "guess") }, http.StatusUnauthorized},
+		{"bearer token", func(r *http.Request) { r.Header.Set("Authorization", "Bearer token") }, http.StatusOK},
+		{"wrong token", func(r *http.Request) { r.Header.Set("Authorization", "Bearer guess") }, http.StatusUnauthorized},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+			c.setup(req)
+
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			if rr.Code != c.status {
+				t.Errorf("status want: %d, got: %d", c.status, rr.Code)
+			}
+		})
+	}
+}
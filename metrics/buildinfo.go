@@ -0,0 +1,22 @@
+package metrics
+
+import (
+	"runtime"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// RegisterBuildInfo adds a watchdog_build_info gauge with a value of 1,
+// labelled with the version and SHA of the watchdog and the Go runtime it
+// was built with. The Go runtime and process metrics are registered by
+// the Prometheus client by default.
+func RegisterBuildInfo(version string, sha string) {
+	buildInfo := promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "watchdog",
+		Name:      "build_info",
+		Help:      "Build information for the watchdog, the value is always 1.",
+	}, []string{"version", "sha", "goversion", "goos", "goarch"})
+
+	buildInfo.WithLabelValues(version, sha, runtime.Version(), runtime.GOOS, runtime.GOARCH).Set(1)
+}
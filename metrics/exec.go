@@ -0,0 +1,106 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Exec records the time spent in each stage of running the function's
+// process, to separate the watchdog's overhead from the function's runtime
+type Exec struct {
+	ForkDuration       prometheus.Histogram
+	ExecDuration       prometheus.Histogram
+	StdoutCopyDuration prometheus.Histogram
+
+	CPUUserSeconds   prometheus.Histogram
+	CPUSystemSeconds prometheus.Histogram
+	MaxRSSBytes      prometheus.Histogram
+
+	ExecErrors prometheus.Counter
+	Retries    prometheus.Counter
+	Timeouts   prometheus.Counter
+	Exits      *prometheus.CounterVec
+
+	RlimitExceeded *prometheus.CounterVec
+
+	CircuitBreakerTrips prometheus.Counter
+}
+
+// NewExec registers the exec metrics, buckets are used for the child's
+// wall-clock time and stdout copy time, or the Prometheus defaults when
+// empty.
+func NewExec(buckets []float64) Exec {
+	if len(buckets) == 0 {
+		buckets = prometheus.DefBuckets
+	}
+
+	return Exec{
+		ForkDuration: promauto.NewHistogram(prometheus.HistogramOpts{
+			Subsystem: "fprocess",
+			Name:      "fork_duration_seconds",
+			Help:      "Seconds taken to start the function's process.",
+			Buckets:   prometheus.ExponentialBuckets(0.0001, 4, 8),
+		}),
+		ExecDuration: promauto.NewHistogram(prometheus.HistogramOpts{
+			Subsystem: "fprocess",
+			Name:      "exec_duration_seconds",
+			Help:      "Seconds from the function's process starting until it exited.",
+			Buckets:   buckets,
+		}),
+		StdoutCopyDuration: promauto.NewHistogram(prometheus.HistogramOpts{
+			Subsystem: "fprocess",
+			Name:      "stdout_copy_duration_seconds",
+			Help:      "Seconds from the first byte of output until the function closed its output.",
+			Buckets:   buckets,
+		}),
+		CPUUserSeconds: promauto.NewHistogram(prometheus.HistogramOpts{
+			Subsystem: "fprocess",
+			Name:      "cpu_user_seconds",
+			Help:      "User CPU seconds used by the function's process.",
+			Buckets:   buckets,
+		}),
+		CPUSystemSeconds: promauto.NewHistogram(prometheus.HistogramOpts{
+			Subsystem: "fprocess",
+			Name:      "cpu_system_seconds",
+			Help:      "System CPU seconds used by the function's process.",
+			Buckets:   buckets,
+		}),
+		MaxRSSBytes: promauto.NewHistogram(prometheus.HistogramOpts{
+			Subsystem: "fprocess",
+			Name:      "max_rss_bytes",
+			Help:      "Peak resident set size of the function's process in bytes.",
+			// 1MB to 4GB
+			Buckets: prometheus.ExponentialBuckets(1<<20, 4, 7),
+		}),
+		ExecErrors: promauto.NewCounter(prometheus.CounterOpts{
+			Subsystem: "fprocess",
+			Name:      "exec_errors_total",
+			Help:      "Total number of times the function's process could not be started.",
+		}),
+		Retries: promauto.NewCounter(prometheus.CounterOpts{
+			Subsystem: "fprocess",
+			Name:      "retries_total",
+			Help:      "Total number of times the function's process was run again after exec_retry_on matched.",
+		}),
+		Timeouts: promauto.NewCounter(prometheus.CounterOpts{
+			Subsystem: "fprocess",
+			Name:      "timeouts_total",
+			Help:      "Total number of processes killed for exceeding exec_timeout.",
+		}),
+		Exits: promauto.NewCounterVec(prometheus.CounterOpts{
+			Subsystem: "fprocess",
+			Name:      "exits_total",
+			Help:      "Total number of processes which exited, by exit code.",
+		}, []string{"code"}),
+		RlimitExceeded: promauto.NewCounterVec(prometheus.CounterOpts{
+			Subsystem: "fprocess",
+			Name:      "rlimit_exceeded_total",
+			Help:      "Total number of processes killed for exceeding a resource limit, by limit.",
+		}, []string{"limit"}),
+		CircuitBreakerTrips: promauto.NewCounter(prometheus.CounterOpts{
+			Subsystem: "fprocess",
+			Name:      "circuit_breaker_trips_total",
+			Help:      "Total number of times the circuit breaker opened after consecutive failures.",
+		}),
+	}
+}
@@ -11,7 +11,14 @@ type Http struct {
 	InFlight                 prometheus.Gauge
 }
 
-func NewHttp() Http {
+// NewHttp registers the HTTP metrics, buckets are the upper bounds for
+// the request duration histogram in seconds, or the Prometheus defaults
+// when empty.
+func NewHttp(buckets []float64) Http {
+	if len(buckets) == 0 {
+		buckets = prometheus.DefBuckets
+	}
+
 	h := Http{
 		RequestsTotal: promauto.NewCounterVec(prometheus.CounterOpts{
 			Subsystem: "http",
@@ -22,7 +29,7 @@ func NewHttp() Http {
 			Subsystem: "http",
 			Name:      "request_duration_seconds",
 			Help:      "Seconds spent serving HTTP requests.",
-			Buckets:   prometheus.DefBuckets,
+			Buckets:   buckets,
 		}, []string{"code", "method"}),
 		InFlight: promauto.NewGauge(prometheus.GaugeOpts{
 			Subsystem: "http",
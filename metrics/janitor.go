@@ -0,0 +1,28 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Janitor records what was removed from the scratch directory
+type Janitor struct {
+	ReclaimedBytes prometheus.Counter
+	RemovedEntries *prometheus.CounterVec
+}
+
+// NewJanitor registers the janitor metrics
+func NewJanitor() Janitor {
+	return Janitor{
+		ReclaimedBytes: promauto.NewCounter(prometheus.CounterOpts{
+			Subsystem: "janitor",
+			Name:      "reclaimed_bytes_total",
+			Help:      "Total number of bytes removed from scratch_dir.",
+		}),
+		RemovedEntries: promauto.NewCounterVec(prometheus.CounterOpts{
+			Subsystem: "janitor",
+			Name:      "removed_total",
+			Help:      "Total number of files and directories removed from scratch_dir, by the limit which was exceeded.",
+		}, []string{"reason"}),
+	}
+}
@@ -3,21 +3,28 @@ package metrics
 import (
 	"context"
 	"fmt"
-	"log"
 	"net/http"
+	"net/http/pprof"
 	"time"
 
+	"github.com/openfaas/classic-watchdog/logging"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 // MetricsServer provides instrumentation for HTTP calls
 type MetricsServer struct {
 	s    *http.Server
+	mux  *http.ServeMux
 	port int
 }
 
-// Register binds a HTTP server to expose Prometheus metrics
+// Register binds a HTTP server to expose Prometheus metrics on /metrics
 func (m *MetricsServer) Register(metricsPort int) {
+	m.RegisterPath(metricsPort, "/metrics")
+}
+
+// RegisterPath binds a HTTP server to expose Prometheus metrics on path
+func (m *MetricsServer) RegisterPath(metricsPort int, path string) {
 
 	m.port = metricsPort
 
@@ -25,7 +32,8 @@ func (m *MetricsServer) Register(metricsPort int) {
 	writeTimeout := time.Millisecond * 500
 
 	metricsMux := http.NewServeMux()
-	metricsMux.Handle("/metrics", promhttp.Handler())
+	metricsMux.Handle(path, promhttp.Handler())
+	m.mux = metricsMux
 
 	m.s = &http.Server{
 		Addr:           fmt.Sprintf(":%d", metricsPort),
@@ -37,9 +45,29 @@ func (m *MetricsServer) Register(metricsPort int) {
 
 }
 
+// EnablePprof serves the net/http/pprof profiles under /debug/pprof/ so
+// that CPU and heap profiles of the watchdog can be captured. The write
+// timeout is raised to allow for CPU profiles of up to 60 seconds. It must
+// be called after Register.
+func (m *MetricsServer) EnablePprof() {
+	m.mux.HandleFunc("/debug/pprof/", pprof.Index)
+	m.mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	m.mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	m.mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	m.mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	m.s.WriteTimeout = time.Second * 65
+}
+
+// Handle serves handler on path alongside the metrics, behind the same
+// authentication. It must be called after Register.
+func (m *MetricsServer) Handle(path string, handler http.Handler) {
+	m.mux.Handle(path, handler)
+}
+
 // Serve http traffic in go routine, non-blocking
 func (m *MetricsServer) Serve(cancel chan bool) {
-	log.Printf("Metrics listening on port: %d\n", m.port)
+	logging.Infof("Metrics listening on port: %d", m.port)
 
 	go func() {
 		if err := m.s.ListenAndServe(); err != http.ErrServerClosed {
@@ -49,7 +77,7 @@ func (m *MetricsServer) Serve(cancel chan bool) {
 
 	go func() {
 		<-cancel
-		log.Printf("metrics server shutdown\n")
+		logging.Infof("metrics server shutdown")
 		m.s.Shutdown(context.Background())
 	}()
 }
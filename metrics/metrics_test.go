@@ -3,8 +3,12 @@ package metrics
 import (
 	"fmt"
 	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 func Test_Register_ProvidesBytes(t *testing.T) {
@@ -55,3 +59,74 @@ func Test_Register_ProvidesBytes(t *testing.T) {
 	t.Errorf("unable to get expected response from metrics server")
 	t.Fail()
 }
+
+func Test_NewExec_RegistersHistograms(t *testing.T) {
+	m := NewExec(nil)
+
+	m.ForkDuration.Observe(0.001)
+	m.ExecDuration.Observe(0.5)
+	m.StdoutCopyDuration.Observe(0.01)
+
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found := map[string]bool{}
+	for _, family := range families {
+		found[family.GetName()] = true
+	}
+
+	for _, name := range []string{
+		"fprocess_fork_duration_seconds",
+		"fprocess_exec_duration_seconds",
+		"fprocess_stdout_copy_duration_seconds",
+	} {
+		if !found[name] {
+			t.Errorf("metric %s should be registered", name)
+		}
+	}
+}
+
+func Test_RegisterBuildInfo(t *testing.T) {
+	RegisterBuildInfo("0.1.0", "abc123")
+
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, family := range families {
+		if family.GetName() != "watchdog_build_info" {
+			continue
+		}
+
+		labels := map[string]string{}
+		for _, label := range family.GetMetric()[0].GetLabel() {
+			labels[label.GetName()] = label.GetValue()
+		}
+		if labels["version"] != "0.1.0" || labels["sha"] != "abc123" || len(labels["goversion"]) == 0 {
+			t.Errorf("watchdog_build_info has wrong labels: %v", labels)
+		}
+		return
+	}
+
+	t.Errorf("watchdog_build_info should be registered")
+}
+
+func Test_EnablePprof_ServesIndex(t *testing.T) {
+	metricsServer := MetricsServer{}
+	metricsServer.Register(31112)
+	metricsServer.EnablePprof()
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/debug/pprof/", nil)
+	metricsServer.s.Handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status want: %d, got: %d", http.StatusOK, rr.Code)
+	}
+	if !strings.Contains(rr.Body.String(), "heap") {
+		t.Errorf("pprof index should list the heap profile")
+	}
+}
@@ -0,0 +1,95 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/openfaas/classic-watchdog/logging"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+)
+
+// Pusher sends all registered metrics to a Prometheus Pushgateway, for
+// environments where the metrics port cannot be scraped
+type Pusher struct {
+	url      string
+	gatherer prometheus.Gatherer
+	client   *http.Client
+}
+
+// NewPusher creates a Pusher for the gateway at baseURL i.e.
+// http://pushgateway:9091, metrics are grouped by job and instance.
+func NewPusher(baseURL string, job string, instance string) *Pusher {
+	groupURL := fmt.Sprintf("%s/metrics/job/%s", strings.TrimSuffix(baseURL, "/"), url.PathEscape(job))
+	if len(instance) > 0 {
+		groupURL += "/instance/" + url.PathEscape(instance)
+	}
+
+	return &Pusher{
+		url:      groupURL,
+		gatherer: prometheus.DefaultGatherer,
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Run pushes metrics every interval until cancel is closed, at which
+// point the metrics are pushed a final time.
+func (p *Pusher) Run(interval time.Duration, cancel <-chan bool) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-cancel:
+			if err := p.Push(); err != nil {
+				logging.Errorf("Unable to push metrics: %s", err.Error())
+			}
+			return
+		}
+
+		if err := p.Push(); err != nil {
+			logging.Errorf("Unable to push metrics: %s", err.Error())
+		}
+	}
+}
+
+// Push replaces the metrics for the group on the gateway with the
+// current values
+func (p *Pusher) Push() error {
+	families, err := p.gatherer.Gather()
+	if err != nil {
+		return err
+	}
+
+	format := expfmt.NewFormat(expfmt.TypeTextPlain)
+
+	var body bytes.Buffer
+	encoder := expfmt.NewEncoder(&body, format)
+	for _, family := range families {
+		if err := encoder.Encode(family); err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequest(http.MethodPut, p.url, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", string(format))
+
+	res, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("unexpected status from %s: %d", p.url, res.StatusCode)
+	}
+	return nil
+}
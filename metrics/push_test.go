@@ -0,0 +1,45 @@
+package metrics
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func Test_Pusher_PutsTextFormatToGroup(t *testing.T) {
+	var method, path, body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method = r.Method
+		path = r.URL.EscapedPath()
+		data, _ := io.ReadAll(r.Body)
+		body = string(data)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	registry := prometheus.NewRegistry()
+	counter := prometheus.NewCounter(prometheus.CounterOpts{Name: "pushed_total", Help: "test"})
+	registry.MustRegister(counter)
+	counter.Inc()
+
+	p := NewPusher(server.URL+"/", "figlet", "pod 1")
+	p.gatherer = registry
+
+	if err := p.Push(); err != nil {
+		t.Fatal(err)
+	}
+
+	if method != http.MethodPut {
+		t.Errorf("method want: PUT, got: %s", method)
+	}
+	if want := "/metrics/job/figlet/instance/pod%201"; path != want {
+		t.Errorf("path want: %s, got: %s", want, path)
+	}
+	if !strings.Contains(body, "pushed_total 1") {
+		t.Errorf("body should contain the counter, got: %s", body)
+	}
+}
@@ -0,0 +1,96 @@
+package metrics
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// StatsD emits metrics for HTTP requests to a StatsD or DogStatsD
+// server over UDP
+type StatsD struct {
+	conn      net.Conn
+	prefix    string
+	dogStatsD bool
+}
+
+// NewStatsD creates a StatsD client for addr i.e. 127.0.0.1:8125, when
+// dogStatsD is true, metrics are tagged with the method and status code.
+func NewStatsD(addr string, prefix string, dogStatsD bool) (*StatsD, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return &StatsD{
+		conn:      conn,
+		prefix:    prefix,
+		dogStatsD: dogStatsD,
+	}, nil
+}
+
+// Incr increments a counter
+func (s *StatsD) Incr(name string, tags ...string) {
+	s.send(name, "1|c", tags)
+}
+
+// Timing records a duration in milliseconds
+func (s *StatsD) Timing(name string, d time.Duration, tags ...string) {
+	s.send(name, strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', 3, 64)+"|ms", tags)
+}
+
+// send writes a single metric, errors are ignored as delivery over UDP
+// is best-effort.
+func (s *StatsD) send(name string, value string, tags []string) {
+	line := fmt.Sprintf("%s%s:%s", s.prefix, name, value)
+	if s.dogStatsD && len(tags) > 0 {
+		line += "|#" + strings.Join(tags, ",")
+	}
+
+	s.conn.Write([]byte(line))
+}
+
+// InstrumentHandler returns a handler which emits a request count,
+// duration and failure count for each request
+func (s *StatsD) InstrumentHandler(next http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sr := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+		next.ServeHTTP(sr, r)
+
+		tags := []string{
+			"method:" + strings.ToLower(r.Method),
+			"code:" + strconv.Itoa(sr.status),
+		}
+
+		s.Incr("requests", tags...)
+		s.Timing("request_duration", time.Since(start), tags...)
+		if sr.status >= http.StatusInternalServerError {
+			s.Incr("failures", tags...)
+		}
+	}
+}
+
+// statusRecorder captures the status code written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	if !s.wroteHeader {
+		s.status = status
+		s.wroteHeader = true
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+// Unwrap allows http.ResponseController to reach the underlying writer
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
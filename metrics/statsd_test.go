@@ -0,0 +1,52 @@
+package metrics
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_StatsD_InstrumentHandler_EmitsMetrics(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	statsd, err := NewStatsD(conn.LocalAddr().String(), "fwatchdog.", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	statsd.InstrumentHandler(next)(httptest.NewRecorder(), req)
+
+	var lines []string
+	buf := make([]byte, 1024)
+	for i := 0; i < 3; i++ {
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, string(buf[:n]))
+	}
+
+	want := []string{
+		"fwatchdog.requests:1|c|#method:post,code:500",
+		"fwatchdog.request_duration:",
+		"fwatchdog.failures:1|c|#method:post,code:500",
+	}
+	for i, prefix := range want {
+		if !strings.HasPrefix(lines[i], prefix) {
+			t.Errorf("metric %d want prefix: %s, got: %s", i, prefix, lines[i])
+		}
+	}
+}
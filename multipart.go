@@ -0,0 +1,126 @@
+// Copyright (c) OpenFaaS Author(s) 2026. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	// maxFormValues and maxFormValueBytes limit the Http_Form_ fields, which
+	// are held in memory and passed in the environment of fprocess
+	maxFormValues     = 1000
+	maxFormValueBytes = 1 << 20
+)
+
+// errFormTooLarge is given when a form has more fields than maxFormValues
+// or its fields are larger than maxFormValueBytes in total
+var errFormTooLarge = errors.New("multipart form fields exceed the limit")
+
+// isMultipartForm returns true when the request carries a multipart/form-data body.
+func isMultipartForm(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == "multipart/form-data"
+}
+
+// envName converts a form field name into a value which is safe to use as
+// part of an environment variable name.
+func envName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_' {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+// writeMultipartForm streams each part of a multipart/form-data body into
+// dir. File parts are written to disk and exposed as Http_File_<name>, with
+// repeated field names joined by the OS path list separator. Other fields
+// are passed as Http_Form_<name>, up to maxFormValues fields and
+// maxFormValueBytes in total.
+func writeMultipartForm(r *http.Request, dir string) ([]string, error) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+
+	files := map[string][]string{}
+	var order []string
+	var envs []string
+	var valueBytes int64
+
+	for i := 0; ; i++ {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		name := envName(part.FormName())
+		if len(part.FileName()) == 0 {
+			if len(envs) == maxFormValues {
+				part.Close()
+				return nil, errFormTooLarge
+			}
+			value, err := io.ReadAll(io.LimitReader(part, maxFormValueBytes-valueBytes+1))
+			part.Close()
+			if err != nil {
+				return nil, err
+			}
+			if valueBytes += int64(len(value)); valueBytes > maxFormValueBytes {
+				return nil, errFormTooLarge
+			}
+			envs = append(envs, fmt.Sprintf("Http_Form_%s=%s", name, value))
+			continue
+		}
+
+		partDir := filepath.Join(dir, strconv.Itoa(i))
+		if err := os.Mkdir(partDir, 0700); err != nil {
+			part.Close()
+			return nil, err
+		}
+
+		path := filepath.Join(partDir, filepath.Base(part.FileName()))
+		if err := writePart(path, part); err != nil {
+			return nil, err
+		}
+
+		if _, ok := files[name]; !ok {
+			order = append(order, name)
+		}
+		files[name] = append(files[name], path)
+	}
+
+	for _, name := range order {
+		envs = append(envs, fmt.Sprintf("Http_File_%s=%s", name, strings.Join(files[name], string(os.PathListSeparator))))
+	}
+
+	return envs, nil
+}
+
+func writePart(path string, part io.ReadCloser) error {
+	defer part.Close()
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(f, part); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,201 @@
+// Copyright (c) OpenFaaS Author(s) 2026. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/openfaas/classic-watchdog/logging"
+)
+
+const (
+	// natsPullExpires is how long the server holds a pull request open
+	// when the stream is empty
+	natsPullExpires = 30 * time.Second
+
+	// natsProgressInterval is how often a running invocation tells the
+	// server that it is still working, so that the message is not
+	// redelivered after the consumer's AckWait
+	natsProgressInterval = 10 * time.Second
+
+	natsMaxBackoff = 30 * time.Second
+
+	// natsNakDelay is the wait before a message which failed is delivered
+	// again, which doubles with each delivery up to natsMaxBackoff
+	natsNakDelay = time.Second
+)
+
+// natsWorker pulls messages one at a time from a durable JetStream
+// consumer and invokes the function with each one. A 2xx result is acked,
+// a 4xx other than 408 and 429 is terminated so that it is not redelivered,
+// and any other result is nacked for redelivery.
+type natsWorker struct {
+	url      string
+	stream   string
+	consumer string
+	handler  http.Handler
+}
+
+func newNATSWorker(natsURL string, stream string, consumer string, handler http.Handler) *natsWorker {
+	return &natsWorker{
+		url:      natsURL,
+		stream:   stream,
+		consumer: consumer,
+		handler:  handler,
+	}
+}
+
+// Run connects again after an error, with a backoff, until cancel is closed.
+// The invocation in progress when cancel is closed completes and is acked
+// before Run returns.
+func (n *natsWorker) Run(cancel <-chan bool) {
+	stop, stopped := context.WithCancel(context.Background())
+	defer stopped()
+	go func() {
+		select {
+		case <-cancel:
+			stopped()
+		case <-stop.Done():
+		}
+	}()
+
+	backoff := time.Second
+	for {
+		// A user and password, a token and tls:// are read from the URL
+		conn, err := nats.Connect(n.url, nats.Name("fwatchdog"), nats.MaxReconnects(-1))
+		if err == nil {
+			logging.Infof("Consuming from NATS stream: %s consumer: %s", n.stream, n.consumer)
+			backoff = time.Second
+			err = n.consume(stop, conn)
+			conn.Close()
+		}
+		if stop.Err() != nil {
+			return
+		}
+
+		logging.Errorf("NATS error, reconnecting in %s: %s", backoff, err.Error())
+		select {
+		case <-time.After(backoff):
+		case <-stop.Done():
+			return
+		}
+		if backoff *= 2; backoff > natsMaxBackoff {
+			backoff = natsMaxBackoff
+		}
+	}
+}
+
+func (n *natsWorker) consume(stop context.Context, conn *nats.Conn) error {
+	js, err := jetstream.New(conn)
+	if err != nil {
+		return err
+	}
+	consumer, err := js.Consumer(stop, n.stream, n.consumer)
+	if err != nil {
+		return err
+	}
+
+	for {
+		ctx, cancel := context.WithTimeout(stop, natsPullExpires)
+		msg, err := consumer.Next(jetstream.FetchContext(ctx))
+		cancel()
+
+		// A message which arrived is invoked even once stopped, so that it
+		// is not left to be redelivered after AckWait
+		if err == nil {
+			if err := n.invoke(msg); err != nil {
+				return err
+			}
+			continue
+		}
+		if stop.Err() != nil {
+			return nil
+		}
+		if errors.Is(err, nats.ErrTimeout) || errors.Is(err, context.DeadlineExceeded) {
+			continue
+		}
+		return err
+	}
+}
+
+func (n *natsWorker) invoke(msg jetstream.Msg) error {
+	req, err := http.NewRequest(http.MethodPost, "http://127.0.0.1/", bytes.NewReader(msg.Data()))
+	if err != nil {
+		return err
+	}
+	req.RemoteAddr = "127.0.0.1:0"
+	for name, values := range msg.Headers() {
+		for _, value := range values {
+			req.Header.Add(name, value)
+		}
+	}
+	req.Header.Set("X-Nats-Subject", msg.Subject())
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(natsProgressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				msg.InProgress()
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	res := &responseBuffer{header: http.Header{}}
+	n.handler.ServeHTTP(res, req)
+	close(done)
+	if res.status == 0 {
+		res.status = http.StatusOK
+	}
+
+	ack, err := natsAck(msg, res.status, res.header.Get("Retry-After"))
+	logging.Infof("NATS message from %s, status: %d, %s - %s: %s", msg.Subject(), res.status, ack, callIDHeader, req.Header.Get(callIDHeader))
+	return err
+}
+
+// natsAck acknowledges msg according to the status of its invocation,
+// giving the kind of acknowledgement which was sent. A message which is
+// nacked is delivered again after a delay, so that one rejected by
+// max_inflight or an open circuit breaker is not retried straight away.
+func natsAck(msg jetstream.Msg, status int, retryAfter string) (string, error) {
+	switch {
+	case status >= 200 && status <= 299:
+		return "ack", msg.Ack()
+	case status == http.StatusRequestTimeout, status == http.StatusTooManyRequests:
+		// Retried below like a server error
+	case status >= 400 && status <= 499:
+		return "term", msg.Term()
+	}
+
+	delay := natsRedeliveryDelay(msg, retryAfter)
+	return fmt.Sprintf("nak, redelivery in %s", delay), msg.NakWithDelay(delay)
+}
+
+// natsRedeliveryDelay gives the function's Retry-After in seconds when it
+// sent one, or otherwise a backoff on the number of deliveries
+func natsRedeliveryDelay(msg jetstream.Msg, retryAfter string) time.Duration {
+	if seconds, err := strconv.Atoi(retryAfter); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+
+	delay := natsNakDelay
+	if metadata, err := msg.Metadata(); err == nil {
+		for delivered := uint64(1); delivered < metadata.NumDelivered && delay < natsMaxBackoff; delivered++ {
+			delay *= 2
+		}
+	}
+	return min(delay, natsMaxBackoff)
+}
@@ -0,0 +1,147 @@
+// Copyright (c) OpenFaaS Author(s) 2026. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// negotiatedTypeEnv gives the function the media type chosen from the
+// request's Accept header
+const negotiatedTypeEnv = "Http_Negotiated_Type"
+
+// contentTypeMapping is one entry of a content_type such as
+// "application/json=application/json;text/csv=text/csv; charset=utf-8",
+// where mediaType is matched against Accept and contentType is sent.
+type contentTypeMapping struct {
+	mediaType   string
+	contentType string
+}
+
+// isContentTypeMapping reports whether content_type is a mapping rather
+// than a single Content-Type, which has no "=" before its first parameter
+func isContentTypeMapping(value string) bool {
+	first, _, _ := strings.Cut(value, ";")
+	return strings.Contains(first, "=")
+}
+
+// parseContentTypeMapping reads entries separated by ";". A part without a
+// media type for its key, such as " charset=utf-8", is a parameter of the
+// previous entry's Content-Type.
+func parseContentTypeMapping(value string) ([]contentTypeMapping, error) {
+	var mappings []contentTypeMapping
+	for _, part := range strings.Split(value, ";") {
+		key, contentType, hasValue := strings.Cut(part, "=")
+		key = strings.TrimSpace(key)
+		if len(key) == 0 && !hasValue {
+			continue
+		}
+
+		if !strings.Contains(key, "/") {
+			if len(mappings) == 0 || !hasValue {
+				return nil, fmt.Errorf("want <media type>=<Content-Type>, got: %q", part)
+			}
+			last := &mappings[len(mappings)-1]
+			last.contentType += "; " + key + "=" + strings.TrimSpace(contentType)
+			continue
+		}
+
+		contentType = strings.TrimSpace(contentType)
+		if !hasValue || len(contentType) == 0 {
+			return nil, fmt.Errorf("no Content-Type for %s", key)
+		}
+		if mediaType, subtype, _ := strings.Cut(key, "/"); len(mediaType) == 0 || len(subtype) == 0 || strings.Contains(key, "*") {
+			return nil, fmt.Errorf("invalid media type: %q", key)
+		}
+		mappings = append(mappings, contentTypeMapping{mediaType: strings.ToLower(key), contentType: contentType})
+	}
+
+	if len(mappings) == 0 {
+		return nil, fmt.Errorf("no media types")
+	}
+	return mappings, nil
+}
+
+// acceptRange is one media range of an Accept header
+type acceptRange struct {
+	mediaType string
+	q         float64
+}
+
+func parseAccept(header string) []acceptRange {
+	var ranges []acceptRange
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		mediaType := strings.ToLower(strings.TrimSpace(fields[0]))
+		if len(mediaType) == 0 {
+			continue
+		}
+
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if v, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+
+		ranges = append(ranges, acceptRange{mediaType: mediaType, q: q})
+	}
+	return ranges
+}
+
+// acceptQuality gives the q value of the most specific range in ranges
+// which matches mediaType, or -1 when none match
+func acceptQuality(ranges []acceptRange, mediaType string) float64 {
+	majorType, _, _ := strings.Cut(mediaType, "/")
+
+	q, specificity := -1.0, -1
+	for _, r := range ranges {
+		s := -1
+		switch r.mediaType {
+		case mediaType:
+			s = 2
+		case majorType + "/*":
+			s = 1
+		case "*/*", "*":
+			s = 0
+		}
+		if s > specificity {
+			q, specificity = r.q, s
+		}
+	}
+	return q
+}
+
+// negotiateContentType picks the mapping with the highest q value for the
+// Accept header, preferring the earliest mapping on a tie. Without an
+// Accept header the first mapping is used. ok is false when the caller
+// accepts none of the media types.
+func negotiateContentType(mappings []contentTypeMapping, accept string) (contentTypeMapping, bool) {
+	if len(strings.TrimSpace(accept)) == 0 {
+		return mappings[0], true
+	}
+
+	ranges := parseAccept(accept)
+	best, bestQ := contentTypeMapping{}, 0.0
+	for _, mapping := range mappings {
+		if q := acceptQuality(ranges, mapping.mediaType); q > bestQ {
+			best, bestQ = mapping, q
+		}
+	}
+	return best, bestQ > 0
+}
+
+// mappedMediaTypes lists the media types for a 406 response
+func mappedMediaTypes(mappings []contentTypeMapping) string {
+	types := make([]string, 0, len(mappings))
+	for _, mapping := range mappings {
+		types = append(types, mapping.mediaType)
+	}
+	return strings.Join(types, ", ")
+}
@@ -0,0 +1,195 @@
+// Copyright (c) OpenFaaS Author(s) 2026. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/openfaas/classic-watchdog/logging"
+	"github.com/rakutentech/jwk-go/jwk"
+)
+
+// oidcMinRefresh limits how often an unknown key ID can trigger a refresh
+// of the key set
+const oidcMinRefresh = time.Minute
+
+// oidcValidMethods are the asymmetric signing algorithms accepted, so that
+// a public key can never be used as a HMAC secret
+var oidcValidMethods = []string{
+	"RS256", "RS384", "RS512",
+	"PS256", "PS384", "PS512",
+	"ES256", "ES384", "ES512",
+	"EdDSA",
+}
+
+// oidcAuth validates bearer tokens from any OpenID Connect issuer i.e.
+// Auth0 or Keycloak, using the issuer's JSON Web Key Set.
+type oidcAuth struct {
+	issuer   string
+	audience string
+	jwksURL  string
+	client   *http.Client
+
+	lock      sync.RWMutex
+	keys      map[string]interface{}
+	refreshed time.Time
+}
+
+// newOIDCAuth discovers the JWKS URL from the issuer's
+// .well-known/openid-configuration when jwksURL is empty, then fetches
+// the keys.
+func newOIDCAuth(issuer string, jwksURL string, audience string) (*oidcAuth, error) {
+	a := &oidcAuth{
+		issuer:   issuer,
+		audience: audience,
+		jwksURL:  jwksURL,
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}
+
+	if len(a.jwksURL) == 0 {
+		var discovery struct {
+			Issuer  string `json:"issuer"`
+			JWKSURI string `json:"jwks_uri"`
+		}
+		if err := a.getJSON(strings.TrimSuffix(issuer, "/")+"/.well-known/openid-configuration", &discovery); err != nil {
+			return nil, err
+		}
+		if len(discovery.JWKSURI) == 0 {
+			return nil, fmt.Errorf("no jwks_uri in the configuration for %s", issuer)
+		}
+		a.jwksURL = discovery.JWKSURI
+	}
+
+	if err := a.refresh(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Run refreshes the keys every interval until cancel is closed, so that
+// rotated keys are picked up
+func (a *oidcAuth) Run(interval time.Duration, cancel <-chan bool) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-cancel:
+			return
+		}
+
+		if err := a.refresh(); err != nil {
+			logging.Errorf("Unable to refresh JWKS from %s: %s", a.jwksURL, err.Error())
+		}
+	}
+}
+
+func (a *oidcAuth) refresh() error {
+	var set jwk.KeySpecSet
+	if err := a.getJSON(a.jwksURL, &set); err != nil {
+		return err
+	}
+
+	keys := make(map[string]interface{}, len(set.Keys))
+	for _, k := range set.Keys {
+		keys[k.KeyID] = k.Key
+	}
+
+	a.lock.Lock()
+	a.keys = keys
+	a.refreshed = time.Now()
+	a.lock.Unlock()
+	return nil
+}
+
+func (a *oidcAuth) getJSON(url string, v interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", "openfaas-watchdog")
+
+	res, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	body, _ := io.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status from %s: %d", url, res.StatusCode)
+	}
+	return json.Unmarshal(body, v)
+}
+
+// key finds the key for a token, refreshing the key set when the key ID
+// is unknown in case the issuer has rotated its keys
+func (a *oidcAuth) key(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	a.lock.RLock()
+	key, ok := a.keys[kid]
+	stale := time.Since(a.refreshed) > oidcMinRefresh
+	a.lock.RUnlock()
+
+	if !ok && stale {
+		if err := a.refresh(); err != nil {
+			return nil, err
+		}
+		a.lock.RLock()
+		key, ok = a.keys[kid]
+		a.lock.RUnlock()
+	}
+
+	if !ok {
+		return nil, fmt.Errorf("unknown kid: %q", kid)
+	}
+	return key, nil
+}
+
+// Handler requires a valid bearer token from the issuer
+func (a *oidcAuth) Handler(next http.Handler) http.Handler {
+	options := []jwt.ParserOption{
+		jwt.WithIssuer(a.issuer),
+		jwt.WithLeeway(time.Second),
+		jwt.WithValidMethods(oidcValidMethods),
+		jwt.WithExpirationRequired(),
+	}
+	if len(a.audience) > 0 {
+		options = append(options, jwt.WithAudience(a.audience))
+	}
+	parser := jwt.NewParser(options...)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || len(bearer) == 0 {
+			writeOIDCUnauthorized(w, "Bearer must be present in Authorization header")
+			return
+		}
+
+		token, err := parser.Parse(bearer, a.key)
+		if err != nil {
+			logging.Debugf("%s %s - %d ACCESS DENIED - %s", r.Method, r.URL.Path, http.StatusUnauthorized, err.Error())
+			writeOIDCUnauthorized(w, "invalid token")
+			return
+		}
+
+		subject, _ := token.Claims.GetSubject()
+		issuer, _ := token.Claims.GetIssuer()
+		next.ServeHTTP(w, withCaller(r, subject, issuer))
+	})
+}
+
+func writeOIDCUnauthorized(w http.ResponseWriter, message string) {
+	w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+	http.Error(w, message, http.StatusUnauthorized)
+}
@@ -0,0 +1,46 @@
+// Copyright (c) OpenFaaS Author(s) 2026. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+)
+
+// parseOutputHeaders reads a CGI-style header block from the start of the
+// function's output, terminated by a blank line. The special "Status" header
+// sets the HTTP status code and is not passed on to the caller. The bytes
+// following the blank line are returned as the body.
+func parseOutputHeaders(out []byte) (http.Header, int, []byte, error) {
+	reader := bufio.NewReader(bytes.NewReader(out))
+	mimeHeader, err := textproto.NewReader(reader).ReadMIMEHeader()
+	if err != nil {
+		return nil, 0, nil, fmt.Errorf("unable to parse headers from function output: %w", err)
+	}
+
+	header := http.Header(mimeHeader)
+	status := http.StatusOK
+
+	if value := header.Get("Status"); len(value) > 0 {
+		code, err := strconv.Atoi(strings.Fields(value)[0])
+		if err != nil || code < 100 || code > 999 {
+			return nil, 0, nil, fmt.Errorf("invalid Status header in function output: %q", value)
+		}
+		status = code
+		header.Del("Status")
+	}
+
+	body, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+
+	return header, status, body, nil
+}
@@ -0,0 +1,22 @@
+// Copyright (c) OpenFaaS Author(s) 2026. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+//go:build !linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+)
+
+// openPTY is not supported on this platform
+func openPTY() (*os.File, *os.File, error) {
+	return nil, nil, fmt.Errorf("exec_pty is not supported on %s", runtime.GOOS)
+}
+
+func applyPTYAttrs(cmd *exec.Cmd) error {
+	return fmt.Errorf("exec_pty is not supported on %s", runtime.GOOS)
+}
@@ -0,0 +1,122 @@
+// Copyright (c) OpenFaaS Author(s) 2026. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// rateLimitSweep is how often buckets which have refilled are removed, so
+// that memory does not grow with the number of clients seen
+const rateLimitSweep = time.Minute
+
+// tokenBucket holds the tokens left for one client at the time of the
+// last request
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter is a token-bucket rate limiter with one bucket per client,
+// keyed by the client IP or the value of a header such as a tenant ID.
+type rateLimiter struct {
+	rate   float64
+	burst  float64
+	header string
+
+	lock    sync.Mutex
+	buckets map[string]*tokenBucket
+	swept   time.Time
+	now     func() time.Time
+}
+
+func newRateLimiter(rate float64, burst int, header string) *rateLimiter {
+	if burst <= 0 {
+		burst = int(math.Max(1, math.Ceil(rate)))
+	}
+
+	return &rateLimiter{
+		rate:    rate,
+		burst:   float64(burst),
+		header:  header,
+		buckets: make(map[string]*tokenBucket),
+		swept:   time.Now(),
+		now:     time.Now,
+	}
+}
+
+// take removes a token from the bucket for key, or returns how long until
+// a token will be available
+func (l *rateLimiter) take(key string) (bool, time.Duration) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	now := l.now()
+	if now.Sub(l.swept) > rateLimitSweep {
+		l.sweep(now)
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+
+	if b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+		return false, wait
+	}
+
+	b.tokens--
+	return true, 0
+}
+
+func (l *rateLimiter) sweep(now time.Time) {
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+	l.swept = now
+}
+
+func (l *rateLimiter) key(r *http.Request) string {
+	if len(l.header) > 0 {
+		if value := r.Header.Get(l.header); len(value) > 0 {
+			return value
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// Handler rejects requests over the limit with a 429 and a Retry-After
+// header in whole seconds
+func (l *rateLimiter) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ok, wait := l.take(l.key(r))
+		if !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds(wait)))
+			w.Header().Set("Content-Type", "text/plain")
+			w.WriteHeader(http.StatusTooManyRequests)
+			fmt.Fprintf(w, "Rate limit exceeded\n")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -4,8 +4,16 @@
 package main
 
 import (
+	"math"
+	"os"
+	"regexp"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
+
+	"github.com/openfaas/classic-watchdog/logging"
+	"github.com/openfaas/classic-watchdog/tracing"
 )
 
 // HasEnv provides interface for os.Getenv
@@ -13,6 +21,16 @@ type HasEnv interface {
 	Getenv(key string) string
 }
 
+// defaultRedactHeaders are hidden in debug logs unless log_redact_headers
+// is set
+var defaultRedactHeaders = []string{
+	"Authorization",
+	"Proxy-Authorization",
+	"Cookie",
+	"Set-Cookie",
+	"X-Api-Key",
+}
+
 // ReadConfig constitutes config from env variables
 type ReadConfig struct {
 }
@@ -54,6 +72,164 @@ func parseIntValue(val string, fallback int) int {
 	return fallback
 }
 
+// parseSignedIntValue is parseIntValue for settings which can be negative
+func parseSignedIntValue(val string, fallback int) int {
+	if parsedVal, err := strconv.Atoi(val); err == nil {
+		return parsedVal
+	}
+	return fallback
+}
+
+// parseRateValue reads a rate such as "10r/s", "100r/m" or "1000r/h" as
+// requests per second, a number without a unit is per second. It is 0 if
+// the value is invalid.
+func parseRateValue(val string) float64 {
+	per := time.Second
+	if count, unit, ok := strings.Cut(val, "r/"); ok {
+		switch unit {
+		case "s":
+		case "m":
+			per = time.Minute
+		case "h":
+			per = time.Hour
+		default:
+			return 0
+		}
+		val = count
+	}
+
+	rate, err := strconv.ParseFloat(val, 64)
+	if err != nil || rate <= 0 || math.IsInf(rate, 0) {
+		return 0
+	}
+	return rate / per.Seconds()
+}
+
+// parseByteValue reads a size in bytes with an optional suffix of K, M or G
+// for powers of 1000, or Ki, Mi or Gi for powers of 1024, as Kubernetes
+// does. It is 0 if the value is invalid.
+func parseByteValue(val string) int64 {
+	multiplier := int64(1)
+	for _, unit := range []struct {
+		suffix string
+		size   int64
+	}{
+		{"Ki", 1 << 10}, {"Mi", 1 << 20}, {"Gi", 1 << 30},
+		{"K", 1000}, {"M", 1000 * 1000}, {"G", 1000 * 1000 * 1000},
+	} {
+		if trimmed, ok := strings.CutSuffix(val, unit.suffix); ok {
+			val = trimmed
+			multiplier = unit.size
+			break
+		}
+	}
+
+	n, err := strconv.ParseInt(val, 10, 64)
+	if err != nil || n <= 0 || n > math.MaxInt64/multiplier {
+		return 0
+	}
+	return n * multiplier
+}
+
+// parseCPUValue reads a number of CPUs such as "0.5", or millicores such
+// as "500m". It is 0 if the value is invalid.
+func parseCPUValue(val string) float64 {
+	divisor := 1.0
+	if trimmed, ok := strings.CutSuffix(val, "m"); ok {
+		val = trimmed
+		divisor = 1000
+	}
+
+	cpus, err := strconv.ParseFloat(val, 64)
+	if err != nil || cpus <= 0 || math.IsInf(cpus, 0) {
+		return 0
+	}
+	return cpus / divisor
+}
+
+// parseBucketsValue reads a comma-separated list of histogram buckets in
+// seconds, sorted into ascending order. It is empty if any value is invalid.
+func parseBucketsValue(val string) []float64 {
+	var buckets []float64
+	for _, item := range parseListValue(val) {
+		bucket, err := strconv.ParseFloat(item, 64)
+		if err != nil || bucket <= 0 {
+			return nil
+		}
+		buckets = append(buckets, bucket)
+	}
+
+	sort.Float64s(buckets)
+
+	// Prometheus requires each bucket to be strictly greater than the last
+	unique := buckets[:0]
+	for i, bucket := range buckets {
+		if i == 0 || bucket != buckets[i-1] {
+			unique = append(unique, bucket)
+		}
+	}
+	return unique
+}
+
+// parseListValue splits a comma-separated value, trimming whitespace and
+// dropping empty items.
+func parseListValue(val string) []string {
+	var items []string
+	for _, item := range strings.Split(val, ",") {
+		item = strings.TrimSpace(item)
+		if len(item) > 0 {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// parseExitCodeMap parses a comma-separated list of exit=status pairs such
+// as "1=400,2=404", skipping any invalid entries.
+func parseExitCodeMap(val string) map[int]int {
+	exitCodeMap := map[int]int{}
+
+	for _, pair := range strings.Split(val, ",") {
+		exitCode, status, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok {
+			continue
+		}
+
+		parsedExit, exitErr := strconv.Atoi(exitCode)
+		parsedStatus, statusErr := strconv.Atoi(status)
+		if exitErr != nil || statusErr != nil || parsedStatus < 100 || parsedStatus > 599 {
+			continue
+		}
+		exitCodeMap[parsedExit] = parsedStatus
+	}
+
+	return exitCodeMap
+}
+
+const (
+	// stdinModeClose writes the body to stdin then closes it, so that the
+	// process sees EOF
+	stdinModeClose = "close"
+
+	// stdinModeKeep leaves stdin open after the body until the process exits
+	stdinModeKeep = "keep"
+
+	// stdinModeNone never attaches the body, stdin is /dev/null
+	stdinModeNone = "none"
+)
+
+// interpolatePattern only matches the braced form, so that a $1 meant for
+// awk or similar is left alone
+var interpolatePattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// interpolateValue replaces ${VAR} with the value of VAR, or an empty string
+// when it is not set, as a shell would.
+func interpolateValue(val string, hasEnv HasEnv) string {
+	return interpolatePattern.ReplaceAllStringFunc(val, func(match string) string {
+		return hasEnv.Getenv(match[2 : len(match)-1])
+	})
+}
+
 // Read fetches config from environmental variables.
 func (ReadConfig) Read(hasEnv HasEnv) WatchdogConfig {
 	cfg := WatchdogConfig{
@@ -64,16 +240,98 @@ func (ReadConfig) Read(hasEnv HasEnv) WatchdogConfig {
 
 	defaultTimeout := time.Second * 30
 
-	cfg.faasProcess = hasEnv.Getenv("fprocess")
+	cfg.configFile = hasEnv.Getenv("config_file")
+	cfg.faasProcess = interpolateValue(hasEnv.Getenv("fprocess"), hasEnv)
+	cfg.fprocessShell = parseBoolValue(hasEnv.Getenv("fprocess_shell"))
+	cfg.execCwd = hasEnv.Getenv("exec_cwd")
+	switch stdinMode := hasEnv.Getenv("stdin_mode"); stdinMode {
+	case stdinModeKeep, stdinModeNone:
+		cfg.stdinMode = stdinMode
+	default:
+		cfg.stdinMode = stdinModeClose
+	}
+	cfg.execPTY = parseBoolValue(hasEnv.Getenv("exec_pty"))
+	cfg.execTmpdir = parseBoolValue(hasEnv.Getenv("exec_tmpdir"))
+	cfg.execTmpdirRoot = hasEnv.Getenv("exec_tmpdir_root")
+	cfg.execTmpdirKeepFailed = parseBoolValue(hasEnv.Getenv("exec_tmpdir_keep_failed"))
+	cfg.scratchDir = hasEnv.Getenv("scratch_dir")
+	cfg.scratchMaxAge = parseIntOrDurationValue(hasEnv.Getenv("scratch_max_age"), 0)
+	cfg.scratchMaxBytes = parseByteValue(hasEnv.Getenv("scratch_max_bytes"))
+	cfg.scratchInterval = parseIntOrDurationValue(hasEnv.Getenv("scratch_interval"), time.Minute)
+	cfg.execUser = hasEnv.Getenv("exec_user")
+	cfg.execGroup = hasEnv.Getenv("exec_group")
+	cfg.execMemoryLimit = parseByteValue(hasEnv.Getenv("exec_memory_limit"))
+	cfg.execCPULimit = parseCPUValue(hasEnv.Getenv("exec_cpu_limit"))
+	cfg.execNice = parseSignedIntValue(hasEnv.Getenv("exec_nice"), 0)
+	cfg.execOOMScoreAdj = parseSignedIntValue(hasEnv.Getenv("exec_oom_score_adj"), 0)
+	cfg.execRlimitNofile = uint64(parseIntValue(hasEnv.Getenv("exec_rlimit_nofile"), 0))
+	cfg.execRlimitNproc = uint64(parseIntValue(hasEnv.Getenv("exec_rlimit_nproc"), 0))
+	cfg.execRlimitFsize = uint64(parseByteValue(hasEnv.Getenv("exec_rlimit_fsize")))
+	cfg.execNoNewPrivs = parseBoolValue(hasEnv.Getenv("exec_no_new_privs"))
+	cfg.execSeccompProfile = hasEnv.Getenv("exec_seccomp_profile")
+	cfg.routes = parseRoutes(hasEnv.Getenv("routes"))
+	cfg.routesFile = hasEnv.Getenv("routes_file")
 
 	cfg.readTimeout = parseIntOrDurationValue(hasEnv.Getenv("read_timeout"), defaultTimeout)
 	cfg.writeTimeout = parseIntOrDurationValue(hasEnv.Getenv("write_timeout"), defaultTimeout)
 	cfg.healthcheckInterval = parseIntOrDurationValue(hasEnv.Getenv("healthcheck_interval"), cfg.writeTimeout)
+	cfg.healthcheckCommand = hasEnv.Getenv("healthcheck_command")
+	cfg.healthcheckCommandInterval = parseIntOrDurationValue(hasEnv.Getenv("healthcheck_command_interval"), 10*time.Second)
+
+	// A zero idle or read header timeout falls back to the read timeout
+	cfg.idleTimeout = parseIntOrDurationValue(hasEnv.Getenv("idle_timeout"), 0)
+	cfg.readHeaderTimeout = parseIntOrDurationValue(hasEnv.Getenv("read_header_timeout"), 0)
+	cfg.maxHeaderBytes = parseIntValue(hasEnv.Getenv("max_header_bytes"), 1<<20)
 
 	// time.Second * 0 means that there is no hard i.e. "exec" timeout set
 	cfg.execTimeout = parseIntOrDurationValue(hasEnv.Getenv("exec_timeout"), time.Second*0)
 	cfg.port = parseIntValue(hasEnv.Getenv("port"), 8080)
 
+	// Knative and Cloud Run set K_SERVICE, and choose the port with PORT
+	cfg.knative = len(hasEnv.Getenv("K_SERVICE")) > 0
+	if isBoolValueSet(hasEnv.Getenv("knative")) {
+		cfg.knative = parseBoolValue(hasEnv.Getenv("knative"))
+	}
+	if cfg.knative && len(hasEnv.Getenv("port")) == 0 {
+		cfg.port = parseIntValue(hasEnv.Getenv("PORT"), 8080)
+	}
+
+	// LISTEN_PID and LISTEN_FDS are set by systemd for socket activation
+	if hasEnv.Getenv("LISTEN_PID") == strconv.Itoa(os.Getpid()) {
+		cfg.listenFDs = parseIntValue(hasEnv.Getenv("LISTEN_FDS"), 0)
+	}
+
+	// listen_addr may be an IPv6 address in brackets, i.e. [::1]
+	cfg.listenAddr = strings.Trim(hasEnv.Getenv("listen_addr"), "[]")
+	cfg.listenReusePort = parseBoolValue(hasEnv.Getenv("listen_reuseport"))
+	cfg.listenSocket = hasEnv.Getenv("listen_socket")
+	cfg.listenTCP = true
+	if isBoolValueSet(hasEnv.Getenv("listen_tcp")) {
+		cfg.listenTCP = parseBoolValue(hasEnv.Getenv("listen_tcp"))
+	}
+
+	cfg.h2c = parseBoolValue(hasEnv.Getenv("h2c"))
+	cfg.grpcPort = parseIntValue(hasEnv.Getenv("grpc_port"), 0)
+
+	cfg.tlsCert = hasEnv.Getenv("tls_cert")
+	cfg.tlsKey = hasEnv.Getenv("tls_key")
+	cfg.tlsClientCA = hasEnv.Getenv("tls_client_ca")
+	cfg.tlsClientAuth = hasEnv.Getenv("tls_client_auth")
+	cfg.tlsReloadInterval = parseIntOrDurationValue(hasEnv.Getenv("tls_reload_interval"), time.Second*30)
+	if cfg.tlsReloadInterval <= 0 {
+		cfg.tlsReloadInterval = time.Second * 30
+	}
+
+	cfg.logFormat = hasEnv.Getenv("log_format")
+	if len(cfg.logFormat) == 0 {
+		cfg.logFormat = "text"
+	}
+
+	cfg.accessLog = hasEnv.Getenv("access_log")
+	cfg.auditLog = hasEnv.Getenv("audit_log")
+	cfg.captureDir = hasEnv.Getenv("capture_dir")
+	cfg.lastInvocations = parseIntValue(hasEnv.Getenv("last_invocations"), 0)
+
 	writeDebugEnv := hasEnv.Getenv("write_debug")
 	if isBoolValueSet(writeDebugEnv) {
 		cfg.writeDebug = parseBoolValue(writeDebugEnv)
@@ -84,29 +342,267 @@ func (ReadConfig) Read(hasEnv HasEnv) WatchdogConfig {
 		cfg.cgiHeaders = parseBoolValue(cgiHeadersEnv)
 	}
 
+	cfg.cgiQueryParams = parseBoolValue(hasEnv.Getenv("cgi_query_params"))
+	cfg.cgiQueryPrefix = hasEnv.Getenv("cgi_query_prefix")
+	if len(cfg.cgiQueryPrefix) == 0 {
+		cfg.cgiQueryPrefix = "Http_Query_"
+	}
+	cfg.cgiQueryRaw = parseBoolValue(hasEnv.Getenv("cgi_query_raw"))
+	cfg.cgiHeadersAllow = parseListValue(hasEnv.Getenv("cgi_headers_allow"))
+	cfg.cgiHeadersDeny = parseListValue(hasEnv.Getenv("cgi_headers_deny"))
+
 	cfg.marshalRequest = parseBoolValue(hasEnv.Getenv("marshal_request"))
+	cfg.marshalRequestV2 = hasEnv.Getenv("marshal_request") == "v2"
+	cfg.marshalResponse = parseBoolValue(hasEnv.Getenv("marshal_response"))
+	cfg.bodyViaFile = parseBoolValue(hasEnv.Getenv("body_via_file"))
+	cfg.responseFromFile = parseBoolValue(hasEnv.Getenv("response_from_file"))
+	cfg.responseFileMaxBytes = parseByteValue(hasEnv.Getenv("response_file_max_bytes"))
+	if cfg.responseFileMaxBytes == 0 {
+		cfg.responseFileMaxBytes = 64 << 20
+	}
+	cfg.contentTypeSniff = parseBoolValue(hasEnv.Getenv("content_type_sniff"))
+	cfg.etag = parseBoolValue(hasEnv.Getenv("etag"))
+
+	cfg.cacheTTL = parseIntOrDurationValue(hasEnv.Getenv("cache_ttl"), 0)
+	cfg.cacheMaxBytes = parseByteValue(hasEnv.Getenv("cache_max_bytes"))
+	if cfg.cacheMaxBytes == 0 {
+		cfg.cacheMaxBytes = 64 << 20
+	}
+	cfg.idempotencyTTL = parseIntOrDurationValue(hasEnv.Getenv("idempotency_ttl"), 0)
+	cfg.idempotencyMaxBytes = parseByteValue(hasEnv.Getenv("idempotency_max_bytes"))
+	if cfg.idempotencyMaxBytes == 0 {
+		cfg.idempotencyMaxBytes = 64 << 20
+	}
+	cfg.natsURL = hasEnv.Getenv("nats_url")
+	cfg.natsStream = hasEnv.Getenv("nats_stream")
+	cfg.natsConsumer = hasEnv.Getenv("nats_consumer")
+	cfg.cronSchedule = hasEnv.Getenv("cron_schedule")
+	cfg.cronPayload = hasEnv.Getenv("cron_payload")
+	cfg.kafkaBrokers = parseKafkaBrokers(hasEnv.Getenv("kafka_brokers"))
+	cfg.kafkaTopic = hasEnv.Getenv("kafka_topic")
+	cfg.kafkaGroup = hasEnv.Getenv("kafka_group")
+	cfg.kafkaMaxRetries = parseIntValue(hasEnv.Getenv("kafka_max_retries"), 5)
+	cfg.kafkaDeadLetterTopic = hasEnv.Getenv("kafka_dead_letter_topic")
+	cfg.kafkaSkipFailed = parseBoolValue(hasEnv.Getenv("kafka_skip_failed"))
+	cfg.kafkaTLS = parseBoolValue(hasEnv.Getenv("kafka_tls"))
+	cfg.kafkaSASLMechanism = hasEnv.Getenv("kafka_sasl_mechanism")
+	cfg.kafkaSASLUsername = hasEnv.Getenv("kafka_sasl_username")
+	cfg.kafkaSASLPassword = hasEnv.Getenv("kafka_sasl_password")
+	cfg.kafkaSASLPasswordFile = hasEnv.Getenv("kafka_sasl_password_file")
+	cfg.asyncCallback = parseBoolValue(hasEnv.Getenv("async_callback"))
+	cfg.asyncCallbackAllow = parseListValue(hasEnv.Getenv("async_callback_allow"))
+	cfg.asyncMaxJobs = parseIntValue(hasEnv.Getenv("async_max_jobs"), 1000)
+	cfg.websocket = parseBoolValue(hasEnv.Getenv("websocket"))
+	cfg.sseOutput = parseBoolValue(hasEnv.Getenv("sse_output"))
+	cfg.cloudEvents = parseBoolValue(hasEnv.Getenv("cloudevents"))
+	cfg.cloudEventsResponseType = hasEnv.Getenv("cloudevents_response_type")
+	cfg.cloudEventsResponseSource = hasEnv.Getenv("cloudevents_response_source")
+	if len(cfg.cloudEventsResponseSource) == 0 {
+		cfg.cloudEventsResponseSource = hasEnv.Getenv("OPENFAAS_NAME")
+	}
+	if len(cfg.cloudEventsResponseSource) == 0 {
+		cfg.cloudEventsResponseSource = "fwatchdog"
+	}
 	cfg.debugHeaders = parseBoolValue(hasEnv.Getenv("debug_headers"))
 
+	cfg.logRedactHeaders = defaultRedactHeaders
+	if redact := parseListValue(hasEnv.Getenv("log_redact_headers")); len(redact) > 0 {
+		cfg.logRedactHeaders = redact
+	}
+
+	// write_debug and debug_headers are retained for compatibility and
+	// enable the debug level unless log_level is given.
+	cfg.logLevel = logging.LevelInfo
+	if cfg.writeDebug || cfg.debugHeaders {
+		cfg.logLevel = logging.LevelDebug
+	}
+	if level, err := logging.ParseLevel(hasEnv.Getenv("log_level")); err == nil {
+		cfg.logLevel = level
+	}
+
 	cfg.suppressLock = parseBoolValue(hasEnv.Getenv("suppress_lock"))
+	cfg.lockFilePath = hasEnv.Getenv("lock_file_path")
 
 	cfg.contentType = hasEnv.Getenv("content_type")
+	cfg.parseOutputHeaders = parseBoolValue(hasEnv.Getenv("parse_output_headers"))
+	cfg.exitCodeMap = parseExitCodeMap(hasEnv.Getenv("exit_code_map"))
+
+	cfg.execRetries = parseIntValue(hasEnv.Getenv("exec_retries"), 0)
+	cfg.execRetryExecError = true
+	if retryOn := parseListValue(hasEnv.Getenv("exec_retry_on")); len(retryOn) > 0 {
+		cfg.execRetryExecError = false
+		for _, value := range retryOn {
+			if value == "exec_error" {
+				cfg.execRetryExecError = true
+			} else if code, err := strconv.Atoi(value); err == nil {
+				cfg.execRetryExitCodes = append(cfg.execRetryExitCodes, code)
+			}
+		}
+	}
 
 	if isBoolValueSet(hasEnv.Getenv("combine_output")) {
 		cfg.combineOutput = parseBoolValue(hasEnv.Getenv("combine_output"))
 	}
 
+	cfg.functionName = hasEnv.Getenv("OPENFAAS_NAME")
+	cfg.stderrStream = parseBoolValue(hasEnv.Getenv("stderr_stream"))
+	cfg.maxLogBytes = parseIntValue(hasEnv.Getenv("max_log_bytes"), 0)
+	switch stderrResponse := hasEnv.Getenv("stderr_response"); stderrResponse {
+	case "header", "trailer":
+		cfg.stderrResponse = stderrResponse
+	}
+	cfg.stderrResponseMaxBytes = parseIntValue(hasEnv.Getenv("stderr_response_max_bytes"), 4096)
+	cfg.stderrPrefix = parseBoolValue(hasEnv.Getenv("stderr_prefix"))
+	cfg.stderrPrefixName = parseBoolValue(hasEnv.Getenv("stderr_prefix_name"))
+
+	cfg.secretMountPath = hasEnv.Getenv("secret_mount_path")
+	if len(cfg.secretMountPath) == 0 {
+		cfg.secretMountPath = "/var/openfaas/secrets"
+	}
+	cfg.envFile = hasEnv.Getenv("env_file")
+	cfg.secretEnv = parseBoolValue(hasEnv.Getenv("secret_env"))
+	cfg.secretEnvAllow = parseListValue(hasEnv.Getenv("secret_env_allow"))
+	cfg.allowCIDRs = parseListValue(hasEnv.Getenv("allow_cidrs"))
+	cfg.denyCIDRs = parseListValue(hasEnv.Getenv("deny_cidrs"))
+
+	cfg.basicAuth = parseBoolValue(hasEnv.Getenv("basic_auth"))
+
+	cfg.hmacAuth = parseBoolValue(hasEnv.Getenv("hmac_auth"))
+	cfg.hmacHeader = hasEnv.Getenv("hmac_header")
+	if len(cfg.hmacHeader) == 0 {
+		cfg.hmacHeader = "X-Hub-Signature-256"
+	}
+	cfg.hmacSecret = hasEnv.Getenv("hmac_secret")
+	if len(cfg.hmacSecret) == 0 {
+		cfg.hmacSecret = "webhook-secret"
+	}
+
+	cfg.apiKeyAuth = parseBoolValue(hasEnv.Getenv("api_key_auth"))
+	cfg.apiKeyHeader = hasEnv.Getenv("api_key_header")
+	if len(cfg.apiKeyHeader) == 0 {
+		cfg.apiKeyHeader = "X-Api-Key"
+	}
+	cfg.apiKeySecrets = parseListValue(hasEnv.Getenv("api_key_secrets"))
+	if len(cfg.apiKeySecrets) == 0 {
+		cfg.apiKeySecrets = []string{"api-key"}
+	}
+
 	cfg.jwtAuthentication = parseBoolValue(hasEnv.Getenv("jwt_auth"))
+	cfg.jwtIssuer = hasEnv.Getenv("jwt_issuer")
+	cfg.jwtJWKSURL = hasEnv.Getenv("jwt_jwks_url")
+	cfg.jwtAudience = hasEnv.Getenv("jwt_audience")
+	cfg.jwtJWKSRefresh = parseIntOrDurationValue(hasEnv.Getenv("jwt_jwks_refresh"), time.Hour)
+	if cfg.jwtJWKSRefresh <= 0 {
+		cfg.jwtJWKSRefresh = time.Hour
+	}
+
+	cfg.jwtClaims = parseListValue(hasEnv.Getenv("jwt_claims"))
+	if len(cfg.jwtClaims) == 0 {
+		cfg.jwtClaims = []string{"sub", "aud"}
+	}
 	cfg.jwtAuthDebug = parseBoolValue(hasEnv.Getenv("jwt_auth_debug"))
 	cfg.jwtAuthLocal = parseBoolValue(hasEnv.Getenv("jwt_auth_local"))
 
-	cfg.metricsPort = 8081
+	cfg.metricsPort = parseIntValue(hasEnv.Getenv("metrics_port"), 8081)
+	cfg.metricsPath = hasEnv.Getenv("metrics_path")
+	if len(cfg.metricsPath) == 0 {
+		cfg.metricsPath = "/metrics"
+	} else if !strings.HasPrefix(cfg.metricsPath, "/") {
+		cfg.metricsPath = "/" + cfg.metricsPath
+	}
+
+	cfg.metricsBasicAuth = hasEnv.Getenv("metrics_basic_auth")
+	cfg.metricsBasicAuthFile = hasEnv.Getenv("metrics_basic_auth_file")
+	cfg.metricsBearerToken = hasEnv.Getenv("metrics_bearer_token")
+	cfg.metricsBearerTokenFile = hasEnv.Getenv("metrics_bearer_token_file")
+
+	cfg.metricsPprof = parseBoolValue(hasEnv.Getenv("metrics_pprof"))
+
+	cfg.pushgatewayURL = hasEnv.Getenv("pushgateway_url")
+	cfg.pushgatewayJob = hasEnv.Getenv("pushgateway_job")
+	if len(cfg.pushgatewayJob) == 0 {
+		cfg.pushgatewayJob = hasEnv.Getenv("OPENFAAS_NAME")
+	}
+	if len(cfg.pushgatewayJob) == 0 {
+		cfg.pushgatewayJob = "fwatchdog"
+	}
+	cfg.pushgatewayInterval = parseIntOrDurationValue(hasEnv.Getenv("pushgateway_interval"), time.Second*15)
+
+	cfg.metricsEnabled = true
+	if isBoolValueSet(hasEnv.Getenv("metrics_enabled")) {
+		cfg.metricsEnabled = parseBoolValue(hasEnv.Getenv("metrics_enabled"))
+	}
+	cfg.metricsBuckets = parseBucketsValue(hasEnv.Getenv("metrics_buckets"))
+	cfg.execRusageHeader = parseBoolValue(hasEnv.Getenv("exec_rusage_header"))
+
+	cfg.statsdAddr = hasEnv.Getenv("statsd_addr")
+	cfg.statsdPrefix = hasEnv.Getenv("statsd_prefix")
+	if len(cfg.statsdPrefix) == 0 {
+		cfg.statsdPrefix = "fwatchdog."
+	}
+	cfg.statsdDogStatsD = parseBoolValue(hasEnv.Getenv("statsd_dogstatsd"))
 	cfg.maxInflight = parseIntValue(hasEnv.Getenv("max_inflight"), 0)
+	if cfg.knative && len(hasEnv.Getenv("max_inflight")) == 0 {
+		cfg.maxInflight = parseIntValue(hasEnv.Getenv("CONTAINER_CONCURRENCY"), 0)
+	}
+	cfg.maxRequests = int64(parseIntValue(hasEnv.Getenv("max_requests"), 0))
+	cfg.idleShutdown = parseIntOrDurationValue(hasEnv.Getenv("idle_shutdown"), 0)
+	cfg.warmupRequest = hasEnv.Getenv("warmup_request")
+	cfg.warmupBody = hasEnv.Getenv("warmup_body")
+	cfg.readyAfterFirstSuccess = parseBoolValue(hasEnv.Getenv("ready_after_first_success"))
+	cfg.maxInflightQueue = parseIntValue(hasEnv.Getenv("max_inflight_queue"), 0)
+	cfg.maxQueueWait = parseIntOrDurationValue(hasEnv.Getenv("max_queue_wait"), cfg.writeTimeout)
+	cfg.priorityHeader = hasEnv.Getenv("priority_header")
+	cfg.priorityClasses = parseListValue(hasEnv.Getenv("priority_classes"))
+	if len(cfg.priorityClasses) == 0 {
+		cfg.priorityClasses = []string{"high", "low"}
+	}
+	if retryAfter := hasEnv.Getenv("max_inflight_retry_after"); retryAfter == "auto" {
+		cfg.maxInflightRetryAfterAuto = true
+	} else {
+		cfg.maxInflightRetryAfter = parseIntOrDurationValue(retryAfter, 0)
+	}
+	cfg.maxInflightBody = hasEnv.Getenv("max_inflight_body")
+	cfg.circuitBreakerFailures = parseIntValue(hasEnv.Getenv("circuit_breaker_failures"), 0)
+	cfg.circuitBreakerCoolDown = parseIntOrDurationValue(hasEnv.Getenv("circuit_breaker_cooldown"), 30*time.Second)
+	cfg.shedMemoryPercent = parseIntValue(hasEnv.Getenv("shed_memory_percent"), 0)
+	cfg.shedCPUPercent = parseIntValue(hasEnv.Getenv("shed_cpu_percent"), 0)
+	cfg.shedInterval = parseIntOrDurationValue(hasEnv.Getenv("shed_interval"), time.Second)
+	if cfg.shedInterval <= 0 {
+		cfg.shedInterval = time.Second
+	}
+	cfg.rateLimit = parseRateValue(hasEnv.Getenv("rate_limit"))
+	cfg.rateLimitBurst = parseIntValue(hasEnv.Getenv("rate_limit_burst"), 0)
+	cfg.rateLimitHeader = hasEnv.Getenv("rate_limit_header")
+	cfg.allowedMethods = parseListValue(strings.ToUpper(hasEnv.Getenv("allowed_methods")))
+
+	cfg.otlpTracesEndpoint = tracing.TracesEndpoint(hasEnv.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"),
+		hasEnv.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT"))
+	cfg.otelServiceName = hasEnv.Getenv("OTEL_SERVICE_NAME")
+	if len(cfg.otelServiceName) == 0 {
+		cfg.otelServiceName = hasEnv.Getenv("OPENFAAS_NAME")
+	}
+	if len(cfg.otelServiceName) == 0 {
+		cfg.otelServiceName = "fwatchdog"
+	}
+
+	cfg.corsAllowOrigins = parseListValue(hasEnv.Getenv("cors_allow_origins"))
+	cfg.corsAllowHeaders = parseListValue(hasEnv.Getenv("cors_allow_headers"))
+
+	cfg.compressResponse = parseBoolValue(hasEnv.Getenv("compress_response"))
+	cfg.compressMinBytes = parseIntValue(hasEnv.Getenv("compress_min_bytes"), 1024)
+
+	cfg.multipartForm = parseBoolValue(hasEnv.Getenv("multipart_form"))
+	cfg.maxRequestBytes = int64(parseIntValue(hasEnv.Getenv("max_request_bytes"), 0))
 
 	return cfg
 }
 
 // WatchdogConfig for the process.
 type WatchdogConfig struct {
+	// configFile is the file the configuration was read from, along with
+	// the environment
+	configFile string
 
 	// HTTP read timeout
 	readTimeout time.Duration
@@ -118,43 +614,431 @@ type WatchdogConfig struct {
 	// detect health and remove the watchdog from its pool of endpoints
 	healthcheckInterval time.Duration
 
+	// healthcheckCommand is run to check the function's dependencies, the
+	// lock file is removed while it exits non-zero
+	healthcheckCommand string
+
+	// healthcheckCommandInterval is how often healthcheckCommand is run,
+	// when 0 it is run for each request to /_/health and /_/ready
+	healthcheckCommandInterval time.Duration
+
 	// faasProcess is the process to exec
 	faasProcess string
 
+	// execCwd is the working directory of the process, when empty it is
+	// the watchdog's own
+	execCwd string
+
+	// stdinMode is one of stdinModeClose, stdinModeKeep or stdinModeNone
+	stdinMode string
+
+	// execPTY writes the output of the process to a pseudo-terminal
+	// instead of a pipe
+	execPTY bool
+
+	// execTmpdir gives each invocation its own TMPDIR under execTmpdirRoot,
+	// which is removed afterwards unless it failed and execTmpdirKeepFailed
+	// is set
+	execTmpdir           bool
+	execTmpdirRoot       string
+	execTmpdirKeepFailed bool
+
+	// scratchDir is cleaned every scratchInterval, removing entries older
+	// than scratchMaxAge and then the oldest while it is over
+	// scratchMaxBytes
+	scratchDir      string
+	scratchMaxAge   time.Duration
+	scratchMaxBytes int64
+	scratchInterval time.Duration
+
+	// execUser and execGroup are the user and group to run the process as,
+	// by name or ID, which are resolved into execCredential at startup
+	execUser       string
+	execGroup      string
+	execCredential *processCredential
+
+	// execMemoryLimit and execCPULimit are applied to a cgroup created for
+	// each process, in bytes and CPUs
+	execMemoryLimit int64
+	execCPULimit    float64
+
+	// execNice lowers the scheduling priority of the process, and
+	// execOOMScoreAdj makes it the OOM killer's preferred victim when
+	// positive. Neither is changed when 0.
+	execNice        int
+	execOOMScoreAdj int
+
+	// execRlimitNofile, execRlimitNproc and execRlimitFsize are the soft
+	// and hard limits set on the process, unchanged when 0
+	execRlimitNofile uint64
+	execRlimitNproc  uint64
+	execRlimitFsize  uint64
+
+	// execNoNewPrivs stops the process gaining privileges through setuid
+	// binaries, and execSeccompProfile restricts the syscalls it can make
+	execNoNewPrivs     bool
+	execSeccompProfile string
+
+	// fprocessShell runs faasProcess and the routes with /bin/sh -c
+	fprocessShell bool
+
+	// routes map URL path prefixes to processes which are used in place
+	// of faasProcess, the longest matching prefix wins.
+	routes []route
+
+	// routesFile is a path to a file of additional routes, one per line.
+	routesFile string
+
+	// idleTimeout is how long to keep idle keep-alive connections open
+	idleTimeout time.Duration
+
+	// readHeaderTimeout is the time allowed to read the request headers
+	readHeaderTimeout time.Duration
+
+	// maxHeaderBytes is the maximum size of the request headers
+	maxHeaderBytes int
+
 	// duration until faasProcess is killed, set to time.Second * 0 to disable
 	execTimeout time.Duration
 
+	// listenFDs is the number of sockets passed by systemd socket
+	// activation, which are used instead of port and listenSocket
+	listenFDs int
+
+	// listenAddr is the host or IP to bind port to, all interfaces are
+	// used when empty
+	listenAddr string
+
+	// listenReusePort binds port with SO_REUSEPORT
+	listenReusePort bool
+
+	// listenSocket is a path to serve on a Unix domain socket
+	listenSocket string
+
+	// listenTCP serves on port, it can be disabled to only use listenSocket
+	listenTCP bool
+
+	// h2c accepts HTTP/2 without TLS from clients with prior knowledge
+	h2c bool
+
+	// knative takes the port from PORT and max_inflight from
+	// CONTAINER_CONCURRENCY, and answers Knative's readiness probes
+	knative bool
+
+	// grpcPort serves invocations over gRPC when set
+	grpcPort int
+
+	// tlsCert and tlsKey are paths to a PEM certificate and key to serve
+	// HTTPS on port, plain HTTP is served when empty
+	tlsCert string
+	tlsKey  string
+
+	// tlsClientCA is a path to a PEM bundle of CAs to verify client
+	// certificates with, client certificates are not requested when empty
+	tlsClientCA string
+
+	// tlsClientAuth is "require" or "optional" for client certificates
+	tlsClientAuth string
+
+	// tlsReloadInterval is how often to check tlsCert and tlsKey for changes
+	tlsReloadInterval time.Duration
+
 	// writeDebug write console stdout statements to the container
 	writeDebug bool
 
+	// logFormat is either "text" or "json" for structured logs
+	logFormat string
+
+	// logLevel is the minimum level of log lines to write
+	logLevel logging.Level
+
+	// accessLog is "stdout", "stderr" or a file path to write an access
+	// log to in the Combined Log Format, disabled when empty
+	accessLog string
+
+	// auditLog is "stdout", "stderr", a file path or an http(s) endpoint
+	// for a hash chained record of each invocation, disabled when empty
+	auditLog string
+
+	// captureDir is a directory to write each request to for debugging,
+	// which "fwatchdog replay" runs again, disabled when empty
+	captureDir string
+
+	// lastInvocations is how many invocations /_/last gives on the metrics
+	// port, disabled when 0
+	lastInvocations int
+
 	// marshal header and body via JSON
 	marshalRequest bool
 
+	// marshalRequestV2 marshals the method, path, query, headers and body
+	marshalRequestV2 bool
+
+	// sseOutput streams each line of stdout as a Server-Sent Event
+	sseOutput bool
+
+	// natsURL consumes messages from the durable JetStream consumer
+	// natsConsumer of natsStream when set
+	natsURL      string
+	natsStream   string
+	natsConsumer string
+
+	// cronSchedule invokes the function with cronPayload on a schedule
+	cronSchedule string
+	cronPayload  string
+
+	// kafkaBrokers consumes kafkaTopic when set, as a member of kafkaGroup
+	kafkaBrokers []string
+	kafkaTopic   string
+	kafkaGroup   string
+
+	// kafkaMaxRetries is how many times a failed record is retried before
+	// it is sent to kafkaDeadLetterTopic, or skipped with kafkaSkipFailed.
+	// With neither, it is retried until it succeeds.
+	kafkaMaxRetries      int
+	kafkaDeadLetterTopic string
+	kafkaSkipFailed      bool
+
+	// kafkaTLS connects to the brokers with TLS
+	kafkaTLS bool
+
+	// kafkaSASLMechanism authenticates with kafkaSASLUsername and
+	// kafkaSASLPassword, or a password read from kafkaSASLPasswordFile
+	kafkaSASLMechanism    string
+	kafkaSASLUsername     string
+	kafkaSASLPassword     string
+	kafkaSASLPasswordFile string
+
+	// asyncCallback runs requests with an X-Callback-Url in the background
+	// and posts the result to the callback, which must be one of
+	// asyncCallbackAllow when set, with up to asyncMaxJobs in the background
+	asyncCallback      bool
+	asyncCallbackAllow []string
+	asyncMaxJobs       int
+
+	// websocket runs fprocess for the lifetime of an upgraded WebSocket
+	websocket bool
+
+	// cloudEvents reads each request as a CloudEvent, and wraps successful
+	// responses in one when cloudEventsResponseType is set
+	cloudEvents               bool
+	cloudEventsResponseType   string
+	cloudEventsResponseSource string
+
+	// bodyViaFile writes the request body to a file named by Http_Body_File
+	// instead of stdin
+	bodyViaFile bool
+
+	// contentTypeSniff detects the Content-Type of the response from its
+	// first bytes, when neither content_type nor the function set one
+	contentTypeSniff bool
+
+	// etag sets a strong ETag over the response body and answers a GET or
+	// HEAD with a matching If-None-Match with 304 Not Modified
+	etag bool
+
+	// cacheTTL keeps 200 responses in memory for this long, so that repeated
+	// requests are answered without forking the function
+	cacheTTL time.Duration
+
+	// cacheMaxBytes is the total size of the bodies kept in the cache
+	cacheMaxBytes int64
+
+	// idempotencyTTL is how long the result for an Idempotency-Key is given
+	// to requests which repeat it, instead of running the function again
+	idempotencyTTL time.Duration
+
+	// idempotencyMaxBytes is the total size of the bodies kept for
+	// Idempotency-Keys, larger results are not kept
+	idempotencyMaxBytes int64
+
+	// responseFromFile sends the file named by Http_Response_File as the
+	// response instead of stdout
+	responseFromFile bool
+
+	// responseFileMaxBytes is the largest response file which is sent
+	responseFileMaxBytes int64
+
+	// marshalResponse reads the status, headers and body from a JSON
+	// envelope written by the function
+	marshalResponse bool
+
 	// cgiHeaders will make environmental variables available with all the HTTP headers.
 	cgiHeaders bool
 
+	// cgiQueryParams adds a variable for each query string parameter
+	// alongside Http_Query, requires cgiHeaders.
+	cgiQueryParams bool
+
+	// cgiQueryPrefix is prepended to the name of each query parameter.
+	cgiQueryPrefix string
+
+	// cgiQueryRaw leaves query parameter values URL-encoded.
+	cgiQueryRaw bool
+
+	// cgiHeadersAllow limits the headers passed by cgiHeaders to those
+	// listed, all headers are passed when empty.
+	cgiHeadersAllow []string
+
+	// cgiHeadersDeny lists headers which are never passed by cgiHeaders.
+	cgiHeadersDeny []string
+
 	// prints out all incoming and out-going HTTP headers
 	debugHeaders bool
 
+	// logRedactHeaders are patterns for headers whose values are hidden
+	// when headers are written to the debug logs
+	logRedactHeaders []string
+
 	// Don't write a lock file to /tmp/
 	suppressLock bool
 
-	// contentType forces a specific pre-defined value for all responses
+	// lockFilePath is where the lock file is written, .lock in the
+	// temporary directory when empty
+	lockFilePath string
+
+	// contentType forces a specific pre-defined value for all responses, or
+	// maps media types from the Accept header to a Content-Type
 	contentType string
 
+	// parseOutputHeaders reads a CGI-style block of headers, including an
+	// optional "Status", from the start of the function's stdout.
+	parseOutputHeaders bool
+
+	// exitCodeMap maps non-zero exit codes from faasProcess to HTTP status
+	// codes, any exit code not present gives a 500.
+	exitCodeMap map[int]int
+
+	// execRetries is the number of times to run faasProcess again when it
+	// cannot be started and execRetryExecError is set, or exits with one of
+	// execRetryExitCodes
+	execRetries        int
+	execRetryExecError bool
+	execRetryExitCodes []int
+
 	// port for HTTP server
 	port int
 
 	// combineOutput combines stderr and stdout in response
 	combineOutput bool
 
+	// functionName is the name of the function from OPENFAAS_NAME
+	functionName string
+
+	// stderrStream writes stderr to the logs line-by-line as the
+	// process runs, rather than after it exits
+	stderrStream bool
+
+	// maxLogBytes caps the stderr collected and the output written to
+	// the logs for each invocation, there is no cap when 0
+	maxLogBytes int
+
+	// stderrResponse is "header" or "trailer" to return stderr to the
+	// caller in X-Function-Stderr, disabled when empty
+	stderrResponse string
+
+	// stderrResponseMaxBytes truncates the value of X-Function-Stderr
+	stderrResponseMaxBytes int
+
+	// stderrPrefix prefixes each line of stderr with the call ID
+	stderrPrefix bool
+
+	// stderrPrefixName adds the function name to the stderr prefix
+	stderrPrefixName bool
+
 	// metricsPort is the HTTP port to serve metrics on
 	metricsPort int
 
+	// metricsPath is the HTTP path to serve metrics on
+	metricsPath string
+
+	// metricsEnabled starts the metrics server on metricsPort
+	metricsEnabled bool
+
+	// metricsPprof serves pprof profiles on the metrics server
+	metricsPprof bool
+
+	// pushgatewayURL is the base URL of a Prometheus Pushgateway to push
+	// metrics to every pushgatewayInterval, disabled when empty
+	pushgatewayURL      string
+	pushgatewayJob      string
+	pushgatewayInterval time.Duration
+
+	// metricsBasicAuth is "user:password" for basic authentication on
+	// the metrics server, or metricsBasicAuthFile is a path to read it from
+	metricsBasicAuth     string
+	metricsBasicAuthFile string
+
+	// metricsBearerToken is a bearer token for the metrics server, or
+	// metricsBearerTokenFile is a path to read it from
+	metricsBearerToken     string
+	metricsBearerTokenFile string
+
+	// metricsBuckets are the buckets in seconds for the request duration
+	// histogram, the Prometheus defaults are used when empty
+	metricsBuckets []float64
+
+	// execRusageHeader returns the resource usage of the process to the
+	// caller in X-Exec-Rusage
+	execRusageHeader bool
+
+	// statsdAddr is the host:port of a StatsD server to send metrics to
+	statsdAddr string
+
+	// statsdPrefix is prepended to the name of each StatsD metric
+	statsdPrefix string
+
+	// statsdDogStatsD adds DogStatsD tags for the method and status code
+	statsdDogStatsD bool
+
+	// secretMountPath is the directory that secrets are mounted into
+	secretMountPath string
+
+	// envFile is a file of KEY=VALUE lines passed to the function, read
+	// again when it changes
+	envFile string
+
+	// secretEnv exposes the secrets to the function as SECRET_<name>
+	// variables, limited to secretEnvAllow when it is set
+	secretEnv      bool
+	secretEnvAllow []string
+
+	// allowCIDRs and denyCIDRs filter requests by their source address
+	allowCIDRs []string
+	denyCIDRs  []string
+
+	// basicAuth requires HTTP basic authentication with the credentials
+	// from the basic-auth-user and basic-auth-password secrets
+	basicAuth bool
+
+	// hmacAuth requires a HMAC-SHA256 signature of the body in hmacHeader
+	// made with the hmacSecret secret
+	hmacAuth   bool
+	hmacHeader string
+	hmacSecret string
+
+	// apiKeyAuth requires one of the keys from apiKeySecrets to be sent
+	// in the apiKeyHeader
+	apiKeyAuth    bool
+	apiKeyHeader  string
+	apiKeySecrets []string
+
 	// jwtAuthentication enables JWT authentication for the watchdog
 	// using the OpenFaaS gateway as the issuer.
 	jwtAuthentication bool
 
+	// jwtIssuer validates JWTs from any OpenID Connect issuer instead of
+	// the OpenFaaS gateway, the keys are read from jwtJWKSURL or discovered
+	// from the issuer, and refreshed every jwtJWKSRefresh
+	jwtIssuer      string
+	jwtJWKSURL     string
+	jwtAudience    string
+	jwtJWKSRefresh time.Duration
+
+	// jwtClaims are passed to the function as Jwt_<claim> when
+	// jwtAuthentication or jwtIssuer is enabled
+	jwtClaims []string
+
 	// jwtAuthDebug enables debug logging for the JWT authentication middleware.
 	jwtAuthDebug bool
 
@@ -165,6 +1049,95 @@ type WatchdogConfig struct {
 	// maxInflight limits the number of simultaneous
 	// requests that the watchdog allows concurrently.
 	// Any request which exceeds this limit will
-	// have an immediate response of 429.
+	// have an immediate response of 429, unless
+	// there is room in the queue.
 	maxInflight int
+
+	// maxRequests is the number of requests to serve before shutting down
+	// gracefully, so that the watchdog is replaced. Disabled when 0.
+	maxRequests int64
+
+	// idleShutdown shuts down gracefully when no requests have arrived
+	// for the duration, for scale to zero. Disabled when 0.
+	idleShutdown time.Duration
+
+	// warmupRequest is a path to POST warmupBody to once at startup,
+	// before the lock file is written
+	warmupRequest string
+	warmupBody    string
+
+	// readyAfterFirstSuccess only writes the lock file once the function has
+	// completed a request with a 2xx, retrying warmupRequest until it does
+	readyAfterFirstSuccess bool
+
+	// maxInflightQueue is the number of requests which can wait for one
+	// of the maxInflight slots, for up to maxQueueWait
+	maxInflightQueue int
+	maxQueueWait     time.Duration
+
+	// priorityHeader gives the class of a request from priorityClasses,
+	// highest first, to order the maxInflightQueue
+	priorityHeader  string
+	priorityClasses []string
+
+	// maxInflightRetryAfter is sent as a Retry-After header with a 429 for
+	// maxInflight, or the average time taken by the function when
+	// maxInflightRetryAfterAuto is set. maxInflightBody replaces the body.
+	maxInflightRetryAfter     time.Duration
+	maxInflightRetryAfterAuto bool
+	maxInflightBody           string
+
+	// circuitBreakerFailures is the number of consecutive exec errors or
+	// non-zero exits after which requests get a 503 for
+	// circuitBreakerCoolDown. Disabled when 0.
+	circuitBreakerFailures int
+	circuitBreakerCoolDown time.Duration
+
+	// shedMemoryPercent and shedCPUPercent reject requests with a 503
+	// while the cgroup's usage is over the percentage of its limit,
+	// sampled every shedInterval. Disabled when 0.
+	shedMemoryPercent int
+	shedCPUPercent    int
+	shedInterval      time.Duration
+
+	// rateLimit is the number of requests per second allowed for each
+	// client, keyed by IP or rateLimitHeader, with bursts of up to
+	// rateLimitBurst. Disabled when 0.
+	rateLimit       float64
+	rateLimitBurst  int
+	rateLimitHeader string
+
+	// allowedMethods restricts the HTTP methods which will fork the
+	// process, others are rejected with a 405.
+	allowedMethods []string
+
+	// otlpTracesEndpoint enables OpenTelemetry tracing, with spans sent
+	// to this URL using OTLP over HTTP.
+	otlpTracesEndpoint string
+
+	// otelServiceName is the service.name given to exported spans
+	otelServiceName string
+
+	// corsAllowOrigins enables CORS for the listed origins, or all
+	// origins with "*".
+	corsAllowOrigins []string
+
+	// corsAllowHeaders are the request headers permitted in preflight
+	// responses, the requested headers are reflected when empty.
+	corsAllowHeaders []string
+
+	// compressResponse enables gzip or zstd encoding of the function's
+	// output when the caller sends a matching Accept-Encoding header.
+	compressResponse bool
+
+	// compressMinBytes is the smallest response that will be compressed.
+	compressMinBytes int
+
+	// multipartForm writes the parts of multipart/form-data requests to a
+	// temporary directory and passes their paths to the function via env-vars.
+	multipartForm bool
+
+	// maxRequestBytes rejects request bodies larger than this value with
+	// a 413, set to 0 to disable.
+	maxRequestBytes int64
 }
@@ -4,8 +4,16 @@
 package main
 
 import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/openfaas/classic-watchdog/logging"
 )
 
 type EnvBucket struct {
@@ -257,3 +265,589 @@ func TestRead_MetricsPort(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestRead_CompressResponse_Defaults(t *testing.T) {
+	defaults := NewEnvBucket()
+
+	readConfig := ReadConfig{}
+	config := readConfig.Read(defaults)
+
+	if config.compressResponse != false {
+		t.Fatalf("compressResponse want: %v, got: %v", false, config.compressResponse)
+	}
+
+	want := 1024
+	if config.compressMinBytes != want {
+		t.Fatalf("compressMinBytes want: %d, got: %d", want, config.compressMinBytes)
+	}
+}
+
+func TestRead_ExitCodeMap(t *testing.T) {
+	defaults := NewEnvBucket()
+	defaults.Setenv("exit_code_map", "1=400, 2=404,124=504,bad,3=700")
+
+	readConfig := ReadConfig{}
+	config := readConfig.Read(defaults)
+
+	want := map[int]int{1: 400, 2: 404, 124: 504}
+	if len(config.exitCodeMap) != len(want) {
+		t.Fatalf("exitCodeMap want: %v, got: %v", want, config.exitCodeMap)
+	}
+	for exitCode, status := range want {
+		if config.exitCodeMap[exitCode] != status {
+			t.Errorf("exitCodeMap[%d] want: %d, got: %d", exitCode, status, config.exitCodeMap[exitCode])
+		}
+	}
+}
+
+func TestRead_Routes(t *testing.T) {
+	defaults := NewEnvBucket()
+	defaults.Setenv("routes", "/convert=convert.sh; /resize = python resize.py;invalid=x;/empty=")
+
+	readConfig := ReadConfig{}
+	config := readConfig.Read(defaults)
+
+	want := []route{
+		{prefix: "/convert", process: "convert.sh"},
+		{prefix: "/resize", process: "python resize.py"},
+	}
+
+	if len(config.routes) != len(want) {
+		t.Fatalf("routes want: %v, got: %v", want, config.routes)
+	}
+	for i := range want {
+		if config.routes[i] != want[i] {
+			t.Errorf("route %d want: %v, got: %v", i, want[i], config.routes[i])
+		}
+	}
+}
+
+func TestRead_AllowedMethods(t *testing.T) {
+	defaults := NewEnvBucket()
+	defaults.Setenv("allowed_methods", "post, put,")
+
+	readConfig := ReadConfig{}
+	config := readConfig.Read(defaults)
+
+	want := []string{"POST", "PUT"}
+	if strings.Join(config.allowedMethods, ",") != strings.Join(want, ",") {
+		t.Fatalf("allowedMethods want: %v, got: %v", want, config.allowedMethods)
+	}
+}
+
+func TestRead_CgiQueryPrefix_Default(t *testing.T) {
+	defaults := NewEnvBucket()
+
+	readConfig := ReadConfig{}
+	config := readConfig.Read(defaults)
+
+	want := "Http_Query_"
+	if config.cgiQueryPrefix != want {
+		t.Fatalf("cgiQueryPrefix want: %s, got: %s", want, config.cgiQueryPrefix)
+	}
+}
+
+func TestRead_LogFormat_DefaultText(t *testing.T) {
+	defaults := NewEnvBucket()
+
+	readConfig := ReadConfig{}
+	config := readConfig.Read(defaults)
+
+	want := "text"
+	if config.logFormat != want {
+		t.Fatalf("logFormat want: %s, got: %s", want, config.logFormat)
+	}
+}
+
+func TestRead_LogLevel_WriteDebugEnablesDebug(t *testing.T) {
+	defaults := NewEnvBucket()
+	defaults.Setenv("write_debug", "true")
+
+	readConfig := ReadConfig{}
+	config := readConfig.Read(defaults)
+
+	if config.logLevel != logging.LevelDebug {
+		t.Fatalf("logLevel want: %s, got: %s", logging.LevelDebug, config.logLevel)
+	}
+}
+
+func TestRead_LogLevel_OverridesWriteDebug(t *testing.T) {
+	defaults := NewEnvBucket()
+	defaults.Setenv("write_debug", "true")
+	defaults.Setenv("log_level", "warn")
+
+	readConfig := ReadConfig{}
+	config := readConfig.Read(defaults)
+
+	if config.logLevel != logging.LevelWarn {
+		t.Fatalf("logLevel want: %s, got: %s", logging.LevelWarn, config.logLevel)
+	}
+}
+
+func TestRead_LogRedactHeaders_Default(t *testing.T) {
+	defaults := NewEnvBucket()
+
+	readConfig := ReadConfig{}
+	config := readConfig.Read(defaults)
+
+	if !matchHeaderPattern(config.logRedactHeaders, "authorization") {
+		t.Errorf("Authorization should be redacted by default, got: %v", config.logRedactHeaders)
+	}
+}
+
+func TestRead_MetricsBuckets(t *testing.T) {
+	defaults := NewEnvBucket()
+	defaults.Setenv("metrics_buckets", "1, 0.1,0.5,0.1")
+
+	readConfig := ReadConfig{}
+	config := readConfig.Read(defaults)
+
+	want := []float64{0.1, 0.5, 1}
+	if fmt.Sprint(config.metricsBuckets) != fmt.Sprint(want) {
+		t.Fatalf("metricsBuckets want: %v, got: %v", want, config.metricsBuckets)
+	}
+}
+
+func TestRead_MetricsBuckets_InvalidUsesDefault(t *testing.T) {
+	defaults := NewEnvBucket()
+	defaults.Setenv("metrics_buckets", "0.1,fast")
+
+	readConfig := ReadConfig{}
+	config := readConfig.Read(defaults)
+
+	if len(config.metricsBuckets) != 0 {
+		t.Fatalf("metricsBuckets want: default, got: %v", config.metricsBuckets)
+	}
+}
+
+func TestRead_Metrics_Defaults(t *testing.T) {
+	defaults := NewEnvBucket()
+
+	readConfig := ReadConfig{}
+	config := readConfig.Read(defaults)
+
+	if config.metricsPort != 8081 || config.metricsPath != "/metrics" || !config.metricsEnabled {
+		t.Fatalf("want metrics enabled on 8081 at /metrics, got: %t %d %s", config.metricsEnabled, config.metricsPort, config.metricsPath)
+	}
+}
+
+func TestRead_Metrics_Override(t *testing.T) {
+	defaults := NewEnvBucket()
+	defaults.Setenv("metrics_port", "9102")
+	defaults.Setenv("metrics_path", "prometheus")
+	defaults.Setenv("metrics_enabled", "false")
+
+	readConfig := ReadConfig{}
+	config := readConfig.Read(defaults)
+
+	if config.metricsPort != 9102 {
+		t.Errorf("metricsPort want: 9102, got: %d", config.metricsPort)
+	}
+	if config.metricsPath != "/prometheus" {
+		t.Errorf("metricsPath want: /prometheus, got: %s", config.metricsPath)
+	}
+	if config.metricsEnabled {
+		t.Errorf("metricsEnabled want: false")
+	}
+}
+
+func TestRead_ListenFDs_OnlyForThisProcess(t *testing.T) {
+	defaults := NewEnvBucket()
+	defaults.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	defaults.Setenv("LISTEN_FDS", "2")
+
+	readConfig := ReadConfig{}
+	config := readConfig.Read(defaults)
+
+	if config.listenFDs != 2 {
+		t.Errorf("listenFDs want: 2, got: %d", config.listenFDs)
+	}
+
+	defaults.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()+1))
+	config = readConfig.Read(defaults)
+
+	if config.listenFDs != 0 {
+		t.Errorf("listenFDs should be ignored for another process, got: %d", config.listenFDs)
+	}
+}
+
+func TestRead_ListenAddr(t *testing.T) {
+	cases := map[string]string{
+		"":          ":8080",
+		"127.0.0.1": "127.0.0.1:8080",
+		"::1":       "[::1]:8080",
+		"[::1]":     "[::1]:8080",
+	}
+
+	for value, want := range cases {
+		defaults := NewEnvBucket()
+		defaults.Setenv("listen_addr", value)
+
+		readConfig := ReadConfig{}
+		config := readConfig.Read(defaults)
+
+		if got := listenAddress(&config); got != want {
+			t.Errorf("listen_addr %q want: %s, got: %s", value, want, got)
+		}
+	}
+}
+
+func TestRead_ServerTunables(t *testing.T) {
+	defaults := NewEnvBucket()
+	defaults.Setenv("idle_timeout", "90s")
+	defaults.Setenv("read_header_timeout", "5")
+	defaults.Setenv("max_header_bytes", "65536")
+
+	readConfig := ReadConfig{}
+	config := readConfig.Read(defaults)
+
+	if config.idleTimeout != time.Second*90 {
+		t.Errorf("idleTimeout want: 90s, got: %s", config.idleTimeout)
+	}
+	if config.readHeaderTimeout != time.Second*5 {
+		t.Errorf("readHeaderTimeout want: 5s, got: %s", config.readHeaderTimeout)
+	}
+	if config.maxHeaderBytes != 65536 {
+		t.Errorf("maxHeaderBytes want: 65536, got: %d", config.maxHeaderBytes)
+	}
+}
+
+func TestRead_ServerTunables_Defaults(t *testing.T) {
+	readConfig := ReadConfig{}
+	config := readConfig.Read(NewEnvBucket())
+
+	if config.maxHeaderBytes != 1<<20 {
+		t.Errorf("maxHeaderBytes want: %d, got: %d", 1<<20, config.maxHeaderBytes)
+	}
+	if config.idleTimeout != 0 || config.readHeaderTimeout != 0 {
+		t.Errorf("idle and read header timeouts should default to read_timeout")
+	}
+}
+
+func TestRead_RateLimit(t *testing.T) {
+	cases := map[string]float64{
+		"10r/s":   10,
+		"120r/m":  2,
+		"3600r/h": 1,
+		"5":       5,
+		"10r/d":   0,
+		"fast":    0,
+		"":        0,
+	}
+
+	for value, want := range cases {
+		if got := parseRateValue(value); got != want {
+			t.Errorf("%q - want: %f, got: %f", value, want, got)
+		}
+	}
+}
+
+func TestRead_ExecRetryOn(t *testing.T) {
+	defaults := NewEnvBucket()
+	defaults.Setenv("exec_retries", "2")
+	readConfig := ReadConfig{}
+
+	config := readConfig.Read(defaults)
+	if config.execRetries != 2 || !config.execRetryExecError || len(config.execRetryExitCodes) != 0 {
+		t.Errorf("exec errors should be retried by default, got: %v %v", config.execRetryExecError, config.execRetryExitCodes)
+	}
+
+	defaults.Setenv("exec_retry_on", "75, 111")
+	config = readConfig.Read(defaults)
+	if config.execRetryExecError {
+		t.Errorf("exec errors should not be retried when not listed")
+	}
+	if fmt.Sprint(config.execRetryExitCodes) != "[75 111]" {
+		t.Errorf("want: [75 111], got: %v", config.execRetryExitCodes)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	validate := func(items map[string]string, out *bytes.Buffer) int {
+		env := NewEnvBucket()
+		var names []string
+		for k, v := range items {
+			env.Setenv(k, v)
+			names = append(names, k)
+		}
+		return runValidate(env, names, out)
+	}
+
+	var out bytes.Buffer
+	code := validate(map[string]string{"fprocess": "cat", "PATH": os.Getenv("PATH"), "HOME": "/root"}, &out)
+	if code != 0 {
+		t.Errorf("exit code - want: 0, got: %d, output: %s", code, out.String())
+	}
+	if !strings.Contains(out.String(), "faasProcess: cat") || !strings.Contains(out.String(), "writeTimeout: 30s") {
+		t.Errorf("the effective configuration should be printed, got: %s", out.String())
+	}
+
+	out.Reset()
+	code = validate(map[string]string{
+		"fprocess":             "cat",
+		"max_inflght":          "2",
+		"exec_timeout":         "60s",
+		"write_timeout":        "10s",
+		"tls_cert":             "/etc/tls.crt",
+		"metrics_bearer_token": "s3cr3t-token",
+	}, &out)
+	if code != 1 {
+		t.Errorf("exit code - want: 1, got: %d", code)
+	}
+	for _, want := range []string{
+		"unknown variable: max_inflght, did you mean max_inflight?",
+		"exec_timeout (1m0s) is longer than write_timeout (10s)",
+		"tls_cert and tls_key must be set together",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("want: %q in output, got: %s", want, out.String())
+		}
+	}
+	if strings.Contains(out.String(), "s3cr3t-token") {
+		t.Errorf("credentials should not be printed, got: %s", out.String())
+	}
+}
+
+func TestReadConfigFile_YAML(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "watchdog.yaml")
+	data := `# Defaults for the image
+fprocess: "python3 index.py"
+write_timeout: 10s   # seconds
+content_type: 'application/json'
+allowed_methods:
+  - POST
+  - GET
+cors_allow_origins: [https://a.example.com, "https://b.example.com"]
+routes: |
+  /api=python3 api.py
+  /admin=python3 admin.py
+`
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	values, err := readConfigFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		"fprocess":           "python3 index.py",
+		"write_timeout":      "10s",
+		"content_type":       "application/json",
+		"allowed_methods":    "POST,GET",
+		"cors_allow_origins": "https://a.example.com,https://b.example.com",
+		"routes":             "/api=python3 api.py\n/admin=python3 admin.py",
+	}
+	for k, v := range want {
+		if values[k] != v {
+			t.Errorf("%s - want: %q, got: %q", k, v, values[k])
+		}
+	}
+}
+
+func TestReadConfigFile_TOML(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "watchdog.toml")
+	data := `fprocess = "python3 index.py"
+max_inflight = 10 # per replica
+allowed_methods = [
+  "POST",
+  "GET",
+]
+routes = """
+/api=python3 api.py
+/admin=python3 admin.py
+"""
+`
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	values, err := readConfigFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		"fprocess":        "python3 index.py",
+		"max_inflight":    "10",
+		"allowed_methods": "POST,GET",
+		"routes":          "/api=python3 api.py\n/admin=python3 admin.py",
+	}
+	for k, v := range want {
+		if values[k] != v {
+			t.Errorf("%s - want: %q, got: %q", k, v, values[k])
+		}
+	}
+
+	if _, err := parseTOMLConfig("[server]\nport = 8080\n"); err == nil {
+		t.Errorf("tables should give an error")
+	}
+}
+
+func TestRead_ConfigPrecedence(t *testing.T) {
+	env := NewEnvBucket()
+	env.Setenv("write_timeout", "20s")
+	env.Setenv("read_timeout", "20s")
+
+	layered := layeredEnv{
+		flags: map[string]string{"write_timeout": "30s"},
+		env:   env,
+		file:  map[string]string{"write_timeout": "10s", "read_timeout": "10s", "exec_timeout": "10s"},
+	}
+	config := ReadConfig{}.Read(layered)
+
+	if config.writeTimeout != 30*time.Second {
+		t.Errorf("flags should override env, got: %s", config.writeTimeout)
+	}
+	if config.readTimeout != 20*time.Second {
+		t.Errorf("env should override the file, got: %s", config.readTimeout)
+	}
+	if config.execTimeout != 10*time.Second {
+		t.Errorf("the file should be used when not overridden, got: %s", config.execTimeout)
+	}
+}
+
+func TestRead_FprocessInterpolation(t *testing.T) {
+	defaults := NewEnvBucket()
+	defaults.Setenv("fprocess", "python ${HANDLER_FILE} ${MISSING}-x awk $1")
+	defaults.Setenv("HANDLER_FILE", "index.py")
+	readConfig := ReadConfig{}
+
+	config := readConfig.Read(defaults)
+	if want := "python index.py -x awk $1"; config.faasProcess != want {
+		t.Errorf("want: %q, got: %q", want, config.faasProcess)
+	}
+}
+
+func TestRead_ExecLimits(t *testing.T) {
+	bytes := map[string]int64{
+		"1048576": 1 << 20,
+		"128Mi":   128 << 20,
+		"1G":      1000 * 1000 * 1000,
+		"2Ki":     2048,
+		"-1":      0,
+		"lots":    0,
+		"":        0,
+	}
+	for value, want := range bytes {
+		if got := parseByteValue(value); got != want {
+			t.Errorf("%q - want: %d, got: %d", value, want, got)
+		}
+	}
+
+	cpus := map[string]float64{
+		"0.5":  0.5,
+		"2":    2,
+		"250m": 0.25,
+		"0":    0,
+		"fast": 0,
+	}
+	for value, want := range cpus {
+		if got := parseCPUValue(value); got != want {
+			t.Errorf("%q - want: %f, got: %f", value, want, got)
+		}
+	}
+}
+
+func TestRead_Knative(t *testing.T) {
+	defaults := NewEnvBucket()
+	defaults.Setenv("K_SERVICE", "hello")
+	defaults.Setenv("PORT", "9090")
+	defaults.Setenv("CONTAINER_CONCURRENCY", "4")
+	readConfig := ReadConfig{}
+
+	config := readConfig.Read(defaults)
+	if !config.knative {
+		t.Errorf("want knative mode when K_SERVICE is set")
+	}
+	if config.port != 9090 {
+		t.Errorf("port - want: 9090, got: %d", config.port)
+	}
+	if config.maxInflight != 4 {
+		t.Errorf("max_inflight - want: 4, got: %d", config.maxInflight)
+	}
+
+	defaults.Setenv("port", "8082")
+	defaults.Setenv("max_inflight", "1")
+	config = readConfig.Read(defaults)
+	if config.port != 8082 || config.maxInflight != 1 {
+		t.Errorf("explicit settings should win, got port: %d max_inflight: %d", config.port, config.maxInflight)
+	}
+
+	defaults = NewEnvBucket()
+	defaults.Setenv("PORT", "9090")
+	config = readConfig.Read(defaults)
+	if config.knative || config.port != 8080 {
+		t.Errorf("PORT should be ignored outside of knative mode, got port: %d", config.port)
+	}
+}
+
+func TestRead_CronSchedule(t *testing.T) {
+	from := time.Date(2026, time.January, 30, 10, 7, 30, 0, time.UTC)
+	cases := map[string]time.Time{
+		"*/15 * * * *":     time.Date(2026, time.January, 30, 10, 15, 0, 0, time.UTC),
+		"0 9 * * mon-fri":  time.Date(2026, time.February, 2, 9, 0, 0, 0, time.UTC),
+		"@daily":           time.Date(2026, time.January, 31, 0, 0, 0, 0, time.UTC),
+		"0 0 1 * 7":        time.Date(2026, time.February, 1, 0, 0, 0, 0, time.UTC),
+		"30 2 29 feb *":    time.Date(2028, time.February, 29, 2, 30, 0, 0, time.UTC),
+		"@every 90s":       from.Add(90 * time.Second),
+		"5,10 10-11 * * *": time.Date(2026, time.January, 30, 10, 10, 0, 0, time.UTC),
+	}
+	for spec, want := range cases {
+		schedule, err := parseCronSchedule(spec)
+		if err != nil {
+			t.Errorf("%q: %s", spec, err)
+			continue
+		}
+		if got := schedule.next(from); !got.Equal(want) {
+			t.Errorf("%q - want: %s, got: %s", spec, want, got)
+		}
+	}
+
+	for _, spec := range []string{"* * * *", "60 * * * *", "* * * smarch *", "*/0 * * * *", "@every 1ms"} {
+		if _, err := parseCronSchedule(spec); err == nil {
+			t.Errorf("%q should be invalid", spec)
+		}
+	}
+
+	if got := (&cronSchedule{}).next(from); !got.IsZero() {
+		t.Errorf("a schedule which never matches should give a zero time, got: %s", got)
+	}
+}
+
+func TestRead_Kafka(t *testing.T) {
+	env := NewEnvBucket()
+	env.Setenv("fprocess", "cat")
+	env.Setenv("kafka_brokers", "kafka-0,kafka://kafka-1:9093")
+	env.Setenv("kafka_topic", "orders")
+	env.Setenv("kafka_group", "fn-orders")
+	config := ReadConfig{}.Read(env)
+
+	if len(config.kafkaBrokers) != 2 || config.kafkaBrokers[0] != "kafka-0:9092" || config.kafkaBrokers[1] != "kafka-1:9093" {
+		t.Errorf("kafkaBrokers - got: %v", config.kafkaBrokers)
+	}
+	if config.kafkaMaxRetries != 5 {
+		t.Errorf("kafkaMaxRetries - want: 5, got: %d", config.kafkaMaxRetries)
+	}
+	if problems := configConflicts(&config); len(problems) != 0 {
+		t.Errorf("want no problems, got: %v", problems)
+	}
+
+	env.Setenv("kafka_max_retries", "0")
+	env.Setenv("kafka_sasl_mechanism", "GSSAPI")
+	env.Setenv("kafka_sasl_password", "s3cr3t-password")
+	config = ReadConfig{}.Read(env)
+	if config.kafkaMaxRetries != 0 {
+		t.Errorf("kafkaMaxRetries - want: 0, got: %d", config.kafkaMaxRetries)
+	}
+	problems := configConflicts(&config)
+	if len(problems) != 1 || !strings.Contains(problems[0], "kafka_sasl_mechanism must be") {
+		t.Errorf("want the mechanism to be rejected, got: %v", problems)
+	}
+	for _, field := range configFields(&config) {
+		if field.value == "s3cr3t-password" {
+			t.Errorf("%s should be redacted", field.name)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+// Copyright (c) OpenFaaS Author(s) 2026. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/openfaas/classic-watchdog/logging"
+)
+
+// readyGate is closed by the first invocation with a 2xx, which is when the
+// lock file is written with ready_after_first_success
+type readyGate struct {
+	ready chan struct{}
+	once  sync.Once
+}
+
+func newReadyGate() *readyGate {
+	return &readyGate{ready: make(chan struct{})}
+}
+
+// Ready is closed after the first success
+func (g *readyGate) Ready() <-chan struct{} {
+	return g.ready
+}
+
+func (g *readyGate) succeeded() {
+	g.once.Do(func() {
+		logging.Infof("The function completed successfully, marking the replica ready")
+		close(g.ready)
+	})
+}
+
+func (g *readyGate) isReady() bool {
+	select {
+	case <-g.ready:
+		return true
+	default:
+		return false
+	}
+}
+
+// Handler opens the gate on the first response with a 2xx status
+func (g *readyGate) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if g.isReady() {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		rec := &accessLogRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		if rec.status >= 200 && rec.status <= 299 {
+			g.succeeded()
+		}
+	})
+}
+
+// RetryWarmup sends warmup_request every interval until it succeeds, so
+// that a replica becomes ready once the function's dependencies are
+func (g *readyGate) RetryWarmup(handler http.Handler, path string, body string, interval time.Duration, cancel <-chan bool) {
+	for {
+		err := warmup(handler, path, body)
+		if err == nil {
+			g.succeeded()
+			return
+		}
+		logging.Warnf("Function failed to warm up, retrying in %s: %s", interval, err.Error())
+
+		select {
+		case <-time.After(interval):
+		case <-cancel:
+			return
+		}
+	}
+}
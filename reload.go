@@ -0,0 +1,99 @@
+// Copyright (c) OpenFaaS Author(s) 2026. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/openfaas/classic-watchdog/logging"
+)
+
+// configLock guards the settings which can be changed by a reload while
+// requests are running
+var configLock sync.RWMutex
+
+// configReloader applies exec_timeout, log_level, max_inflight and
+// content_type from a fresh read of the configuration, so that they can be
+// tuned without restarting the watchdog. Other settings such as ports and
+// server timeouts are fixed at start-up.
+type configReloader struct {
+	config   *WatchdogConfig
+	inflight *inflightLimiter
+	load     func() (WatchdogConfig, error)
+}
+
+func newConfigReloader(config *WatchdogConfig, inflight *inflightLimiter, load func() (WatchdogConfig, error)) *configReloader {
+	return &configReloader{
+		config:   config,
+		inflight: inflight,
+		load:     load,
+	}
+}
+
+// Reload leaves the configuration unchanged when it can not be read
+func (c *configReloader) Reload() error {
+	next, err := c.load()
+	if err != nil {
+		return err
+	}
+
+	configLock.Lock()
+	c.config.execTimeout = next.execTimeout
+	c.config.logLevel = next.logLevel
+	c.config.maxInflight = next.maxInflight
+	c.config.contentType = next.contentType
+	configLock.Unlock()
+
+	logging.SetLevel(next.logLevel)
+	if c.inflight != nil {
+		c.inflight.setMax(next.maxInflight)
+	}
+
+	logging.Infof("Reloaded configuration, exec_timeout: %s log_level: %s max_inflight: %d content_type: %q",
+		next.execTimeout, next.logLevel, next.maxInflight, next.contentType)
+	return nil
+}
+
+// Run reloads the configuration on each SIGHUP
+func (c *configReloader) Run(cancel <-chan bool) {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGHUP)
+	defer signal.Stop(sig)
+
+	for {
+		select {
+		case <-sig:
+		case <-cancel:
+			return
+		}
+
+		if err := c.Reload(); err != nil {
+			logging.Errorf("Unable to reload configuration: %s", err.Error())
+		}
+	}
+}
+
+// Handler reloads the configuration on POST
+func (c *configReloader) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		if err := c.Reload(); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprintf(w, "Unable to reload configuration: %s\n", err.Error())
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
@@ -4,17 +4,51 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
+	"log/slog"
+	"math/big"
+	"mime/multipart"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"runtime"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/openfaas/classic-watchdog/logging"
+	"github.com/openfaas/classic-watchdog/tracing"
+	"github.com/openfaas/classic-watchdog/types"
+	"github.com/rakutentech/jwk-go/jwk"
+	"github.com/twmb/franz-go/pkg/kgo"
 )
 
 func TestHandler_make(t *testing.T) {
@@ -103,7 +137,7 @@ func TestHandler_HasCustomHeaderInFunction_WithCgi_Mode(t *testing.T) {
 
 	seconds := rr.Header().Get("X-Duration-Seconds")
 	if len(seconds) == 0 {
-		t.Errorf(config.faasProcess + " should have given a duration as an X-Duration-Seconds header\n")
+		t.Errorf("%s should have given a duration as an X-Duration-Seconds header\n", config.faasProcess)
 	}
 }
 
@@ -361,7 +395,7 @@ func TestHandler_HasXDurationSecondsHeader(t *testing.T) {
 
 	seconds := rr.Header().Get("X-Duration-Seconds")
 	if len(seconds) == 0 {
-		t.Errorf("Exec of " + config.faasProcess + " should have given a duration as an X-Duration-Seconds header")
+		t.Errorf("Exec of %s should have given a duration as an X-Duration-Seconds header", config.faasProcess)
 	}
 }
 
@@ -572,9 +606,4146 @@ func TestHandler_HasFullPathAndQueryInFunction_WithCgi_Mode(t *testing.T) {
 	}
 }
 
-func removeLockFile() error {
-	path := filepath.Join(os.TempDir(), ".lock")
-	log.Printf("Removing lock-file : %s\n", path)
-	removeErr := os.Remove(path)
-	return removeErr
+func TestHandler_CompressResponse_GzipWhenAccepted(t *testing.T) {
+	rr := httptest.NewRecorder()
+
+	body := strings.Repeat("hello world ", 200)
+	req, err := http.NewRequest(http.MethodPost, "/", bytes.NewBufferString(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Accept-Encoding", "gzip")
+
+	config := WatchdogConfig{
+		faasProcess:      "cat",
+		compressResponse: true,
+		compressMinBytes: 1024,
+	}
+	handler := makeRequestHandler(&config)
+	handler.ServeHTTP(rr, req)
+
+	if got := rr.Header().Get("Content-Encoding"); got != "gzip" {
+		t.Fatalf("Content-Encoding want: %s, got: %s", "gzip", got)
+	}
+
+	gr, err := gzip.NewReader(rr.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	read, _ := ioutil.ReadAll(gr)
+	if string(read) != body {
+		t.Errorf("decompressed body did not match input, got %d bytes", len(read))
+	}
+}
+
+func TestHandler_CompressResponse_SkippedBelowMinBytes(t *testing.T) {
+	rr := httptest.NewRecorder()
+
+	body := "hello"
+	req, err := http.NewRequest(http.MethodPost, "/", bytes.NewBufferString(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Accept-Encoding", "gzip, zstd")
+
+	config := WatchdogConfig{
+		faasProcess:      "cat",
+		compressResponse: true,
+		compressMinBytes: 1024,
+	}
+	handler := makeRequestHandler(&config)
+	handler.ServeHTTP(rr, req)
+
+	if got := rr.Header().Get("Content-Encoding"); got != "" {
+		t.Fatalf("Content-Encoding should not be set, got: %s", got)
+	}
+
+	if val := rr.Body.String(); val != body {
+		t.Errorf("body want: %s, got: %s", body, val)
+	}
+}
+
+func TestNegotiateEncoding(t *testing.T) {
+	cases := []struct {
+		header          string
+		want            string
+		identityRefused bool
+	}{
+		{header: "", want: ""},
+		{header: "gzip", want: "gzip"},
+		{header: "gzip, zstd", want: "zstd"},
+		{header: "gzip;q=1, zstd;q=0.1", want: "gzip"},
+		{header: "gzip;q=0.5, zstd;q=0.8", want: "zstd"},
+		{header: "zstd;q=0, gzip", want: "gzip"},
+		{header: "GZIP;Q=0.5", want: "gzip"},
+		{header: "*", want: "zstd"},
+		{header: "*;q=0.5, zstd;q=0", want: "gzip"},
+		{header: "identity, gzip;q=0.5", want: ""},
+		{header: "br", want: ""},
+		{header: "identity;q=0, gzip", want: "gzip", identityRefused: true},
+		{header: "*;q=0, gzip", want: "gzip", identityRefused: true},
+		{header: "*;q=0, identity;q=0.1, gzip;q=0.2", want: "gzip"},
+		{header: "identity;q=0", want: "", identityRefused: true},
+	}
+
+	for _, c := range cases {
+		got, identityRefused := negotiateEncoding(c.header)
+		if got != c.want || identityRefused != c.identityRefused {
+			t.Errorf("%q want: %q %v, got: %q %v", c.header, c.want, c.identityRefused, got, identityRefused)
+		}
+	}
+}
+
+func TestHandler_CompressResponse_IdentityRefused(t *testing.T) {
+	rr := httptest.NewRecorder()
+
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("hello"))
+	req.Header.Set("Accept-Encoding", "identity;q=0, gzip")
+
+	config := WatchdogConfig{
+		faasProcess:      "cat",
+		compressResponse: true,
+		compressMinBytes: 1024,
+	}
+	handler := makeRequestHandler(&config)
+	handler.ServeHTTP(rr, req)
+
+	if got := rr.Header().Get("Content-Encoding"); got != "gzip" {
+		t.Fatalf("Content-Encoding want: %s below compress_min_bytes when identity is refused, got: %s", "gzip", got)
+	}
+	gr, err := gzip.NewReader(rr.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read, _ := ioutil.ReadAll(gr); string(read) != "hello" {
+		t.Errorf("body want: %s, got: %s", "hello", read)
+	}
+}
+
+func TestHandler_MultipartForm_FilesPassedAsPaths(t *testing.T) {
+	rr := httptest.NewRecorder()
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, err := mw.CreateFormFile("upload", "image.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw.Write([]byte("png-data"))
+	mw.WriteField("caption", "holiday")
+	mw.Close()
+
+	req, err := http.NewRequest(http.MethodPost, "/", &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+
+	config := WatchdogConfig{
+		faasProcess:   "env",
+		multipartForm: true,
+	}
+	handler := makeRequestHandler(&config)
+	handler.ServeHTTP(rr, req)
+
+	required := http.StatusOK
+	if status := rr.Code; status != required {
+		t.Fatalf("handler returned wrong status code - got: %v, want: %v", status, required)
+	}
+
+	val := rr.Body.String()
+	if !strings.Contains(val, "Http_Form_caption=holiday") {
+		t.Errorf("'env' should print: Http_Form_caption=holiday, got: %s", val)
+	}
+
+	var path string
+	for _, line := range strings.Split(val, "\n") {
+		if strings.HasPrefix(line, "Http_File_upload=") {
+			path = strings.TrimPrefix(line, "Http_File_upload=")
+		}
+	}
+
+	if filepath.Base(path) != "image.png" {
+		t.Fatalf("Http_File_upload should point to image.png, got: %q", path)
+	}
+
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("uploaded file should be removed after the invocation: %s", path)
+	}
+}
+
+func TestHandler_MultipartForm_LimitsFields(t *testing.T) {
+	cases := []struct {
+		name  string
+		write func(mw *multipart.Writer)
+	}{
+		{
+			name: "too many fields",
+			write: func(mw *multipart.Writer) {
+				for i := 0; i <= maxFormValues; i++ {
+					mw.WriteField("f", "1")
+				}
+			},
+		},
+		{
+			name: "fields too large",
+			write: func(mw *multipart.Writer) {
+				mw.WriteField("a", strings.Repeat("a", maxFormValueBytes/2))
+				mw.WriteField("b", strings.Repeat("b", maxFormValueBytes/2+1))
+			},
+		},
+	}
+
+	config := WatchdogConfig{
+		faasProcess:   "env",
+		multipartForm: true,
+	}
+	handler := makeRequestHandler(&config)
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var body bytes.Buffer
+			mw := multipart.NewWriter(&body)
+			c.write(mw)
+			mw.Close()
+
+			req := httptest.NewRequest(http.MethodPost, "/", &body)
+			req.Header.Set("Content-Type", mw.FormDataContentType())
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			if rr.Code != http.StatusRequestEntityTooLarge {
+				t.Errorf("want: %d, got: %d", http.StatusRequestEntityTooLarge, rr.Code)
+			}
+		})
+	}
+}
+
+func TestHandler_MaxRequestBytes_RejectsBeforeReadingBody(t *testing.T) {
+	rr := httptest.NewRecorder()
+
+	body := &countingReader{r: strings.NewReader(strings.Repeat("a", 2048))}
+	req, err := http.NewRequest(http.MethodPost, "/", body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.ContentLength = 2048
+	req.Header.Set("Expect", "100-continue")
+
+	config := WatchdogConfig{
+		faasProcess:     "cat",
+		maxRequestBytes: 1024,
+	}
+	handler := makeRequestHandler(&config)
+	handler.ServeHTTP(rr, req)
+
+	required := http.StatusRequestEntityTooLarge
+	if status := rr.Code; status != required {
+		t.Errorf("handler returned wrong status code - got: %v, want: %v", status, required)
+	}
+
+	if body.n != 0 {
+		t.Errorf("request body should not have been read, read %d bytes", body.n)
+	}
+}
+
+func TestHandler_MaxRequestBytes_ChunkedBodyOverLimit(t *testing.T) {
+	rr := httptest.NewRecorder()
+
+	req, err := http.NewRequest(http.MethodPost, "/", strings.NewReader(strings.Repeat("a", 2048)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.ContentLength = -1
+
+	config := WatchdogConfig{
+		faasProcess:     "cat",
+		maxRequestBytes: 1024,
+	}
+	handler := makeRequestHandler(&config)
+	handler.ServeHTTP(rr, req)
+
+	required := http.StatusRequestEntityTooLarge
+	if status := rr.Code; status != required {
+		t.Errorf("handler returned wrong status code - got: %v, want: %v", status, required)
+	}
+}
+
+func TestHandler_ParseOutputHeaders_StatusAndHeadersApplied(t *testing.T) {
+	rr := httptest.NewRecorder()
+
+	body := "Status: 404 Not Found\r\nContent-Type: text/html\r\nX-Custom: 1\r\n\r\n<h1>Not here</h1>"
+	req, err := http.NewRequest(http.MethodPost, "/", bytes.NewBufferString(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "text/plain")
+
+	config := WatchdogConfig{
+		faasProcess:        "cat",
+		parseOutputHeaders: true,
+	}
+	handler := makeRequestHandler(&config)
+	handler.ServeHTTP(rr, req)
+
+	required := http.StatusNotFound
+	if status := rr.Code; status != required {
+		t.Errorf("handler returned wrong status code - got: %v, want: %v", status, required)
+	}
+
+	if got := rr.Header().Get("Content-Type"); got != "text/html" {
+		t.Errorf("Content-Type want: %s, got: %s", "text/html", got)
+	}
+	if got := rr.Header().Get("X-Custom"); got != "1" {
+		t.Errorf("X-Custom want: %s, got: %s", "1", got)
+	}
+	if got := rr.Header().Get("Status"); got != "" {
+		t.Errorf("Status should not be passed on as a header, got: %s", got)
+	}
+
+	if val := rr.Body.String(); val != "<h1>Not here</h1>" {
+		t.Errorf("body want: %s, got: %s", "<h1>Not here</h1>", val)
+	}
+}
+
+func TestHandler_ParseOutputHeaders_MalformedOutput(t *testing.T) {
+	rr := httptest.NewRecorder()
+
+	req, err := http.NewRequest(http.MethodPost, "/", bytes.NewBufferString("no headers here"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := WatchdogConfig{
+		faasProcess:        "cat",
+		parseOutputHeaders: true,
+	}
+	handler := makeRequestHandler(&config)
+	handler.ServeHTTP(rr, req)
+
+	required := http.StatusInternalServerError
+	if status := rr.Code; status != required {
+		t.Errorf("handler returned wrong status code - got: %v, want: %v", status, required)
+	}
+}
+
+func TestHandler_ExitCodeMap_MapsNonZeroExit(t *testing.T) {
+	rr := httptest.NewRecorder()
+
+	req, err := http.NewRequest(http.MethodPost, "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := WatchdogConfig{
+		faasProcess: "false",
+		exitCodeMap: map[int]int{1: http.StatusBadRequest},
+	}
+	handler := makeRequestHandler(&config)
+	handler.ServeHTTP(rr, req)
+
+	required := http.StatusBadRequest
+	if status := rr.Code; status != required {
+		t.Errorf("handler returned wrong status code - got: %v, want: %v", status, required)
+	}
+}
+
+func TestHandler_Routes_LongestPrefixSelectsProcess(t *testing.T) {
+	config := WatchdogConfig{
+		faasProcess: "echo default",
+		routes: []route{
+			{prefix: "/convert", process: "echo convert"},
+			{prefix: "/convert/png", process: "echo png"},
+		},
+	}
+	handler := makeRequestHandler(&config)
+
+	cases := map[string]string{
+		"/":                 "default\n",
+		"/converter":        "default\n",
+		"/convert":          "convert\n",
+		"/convert/jpg":      "convert\n",
+		"/convert/png/file": "png\n",
+	}
+
+	for path, want := range cases {
+		rr := httptest.NewRecorder()
+		req, err := http.NewRequest(http.MethodPost, path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		handler.ServeHTTP(rr, req)
+
+		if val := rr.Body.String(); val != want {
+			t.Errorf("path %s want: %q, got: %q", path, want, val)
+		}
+	}
+}
+
+func TestHandler_Routes_NotFoundWithoutFprocess(t *testing.T) {
+	rr := httptest.NewRecorder()
+
+	req, err := http.NewRequest(http.MethodPost, "/unknown", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := WatchdogConfig{
+		routes: []route{{prefix: "/convert", process: "cat"}},
+	}
+	handler := makeRequestHandler(&config)
+	handler.ServeHTTP(rr, req)
+
+	required := http.StatusNotFound
+	if status := rr.Code; status != required {
+		t.Errorf("handler returned wrong status code - got: %v, want: %v", status, required)
+	}
+}
+
+func TestHandler_AllowedMethods_RejectsOtherVerbs(t *testing.T) {
+	rr := httptest.NewRecorder()
+
+	req, err := http.NewRequest(http.MethodGet, "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := WatchdogConfig{
+		faasProcess:    "cat",
+		allowedMethods: []string{http.MethodPost, http.MethodPut},
+	}
+	handler := makeRequestHandler(&config)
+	handler.ServeHTTP(rr, req)
+
+	required := http.StatusMethodNotAllowed
+	if status := rr.Code; status != required {
+		t.Errorf("handler returned wrong status code - got: %v, want: %v", status, required)
+	}
+
+	want := "POST, PUT"
+	if got := rr.Header().Get("Allow"); got != want {
+		t.Errorf("Allow header want: %s, got: %s", want, got)
+	}
+}
+
+func TestCORSHandler_PreflightAnsweredWithoutInvoking(t *testing.T) {
+	rr := httptest.NewRecorder()
+
+	req, err := http.NewRequest(http.MethodOptions, "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Origin", "https://app.example.com")
+	req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+	req.Header.Set("Access-Control-Request-Headers", "X-Custom")
+
+	config := WatchdogConfig{
+		corsAllowOrigins: []string{"https://app.example.com"},
+		corsAllowHeaders: []string{"Content-Type", "Authorization"},
+		allowedMethods:   []string{http.MethodPost},
+	}
+
+	invoked := false
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		invoked = true
+	})
+
+	handler := makeCORSHandler(&config, next)
+	handler.ServeHTTP(rr, req)
+
+	if invoked {
+		t.Errorf("preflight request should not have invoked the function")
+	}
+
+	required := http.StatusNoContent
+	if status := rr.Code; status != required {
+		t.Errorf("handler returned wrong status code - got: %v, want: %v", status, required)
+	}
+
+	wantHeaders := map[string]string{
+		"Access-Control-Allow-Origin":  "https://app.example.com",
+		"Access-Control-Allow-Methods": "POST",
+		"Access-Control-Allow-Headers": "Content-Type, Authorization",
+	}
+	for k, want := range wantHeaders {
+		if got := rr.Header().Get(k); got != want {
+			t.Errorf("%s want: %s, got: %s", k, want, got)
+		}
+	}
+}
+
+func TestCORSHandler_UnknownOriginHasNoAllowOrigin(t *testing.T) {
+	rr := httptest.NewRecorder()
+
+	req, err := http.NewRequest(http.MethodPost, "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Origin", "https://evil.example.com")
+
+	config := WatchdogConfig{
+		faasProcess:      "cat",
+		corsAllowOrigins: []string{"https://app.example.com"},
+	}
+
+	handler := makeCORSHandler(&config, makeRequestHandler(&config))
+	handler.ServeHTTP(rr, req)
+
+	required := http.StatusOK
+	if status := rr.Code; status != required {
+		t.Errorf("handler returned wrong status code - got: %v, want: %v", status, required)
+	}
+
+	if got := rr.Header().Get("Access-Control-Allow-Origin"); got != "" {
+		t.Errorf("Access-Control-Allow-Origin should not be set, got: %s", got)
+	}
+}
+
+func TestHandler_CgiQueryParams_IndividualVariables(t *testing.T) {
+	rr := httptest.NewRecorder()
+
+	req, err := http.NewRequest(http.MethodGet, "/?user=alex%20ellis&tag=a&tag=b&page-size=10", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := WatchdogConfig{
+		faasProcess:    "env",
+		cgiHeaders:     true,
+		cgiQueryParams: true,
+		cgiQueryPrefix: "Http_Query_",
+	}
+	handler := makeRequestHandler(&config)
+	handler.ServeHTTP(rr, req)
+
+	val := rr.Body.String()
+	for _, want := range []string{
+		"Http_Query_user=alex ellis",
+		"Http_Query_tag=a,b",
+		"Http_Query_page_size=10",
+		"Http_Query=user=alex%20ellis&tag=a&tag=b&page-size=10",
+	} {
+		if !strings.Contains(val, want) {
+			t.Errorf("'env' should print: %s, got: %s", want, val)
+		}
+	}
+}
+
+func TestHandler_CgiHeadersAllowAndDeny(t *testing.T) {
+	rr := httptest.NewRecorder()
+
+	req, err := http.NewRequest(http.MethodPost, "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer secret")
+	req.Header.Set("Cookie", "session=secret")
+	req.Header.Set("X-Forwarded-For", "10.0.0.1")
+	req.Header.Set("X-Forwarded-Token", "secret")
+	req.Header.Set("X-Custom", "value")
+
+	config := WatchdogConfig{
+		faasProcess:     "env",
+		cgiHeaders:      true,
+		cgiHeadersAllow: []string{"x-forwarded-*", "Authorization", "X-Custom"},
+		cgiHeadersDeny:  []string{"Authorization", "X-Forwarded-Token"},
+	}
+	handler := makeRequestHandler(&config)
+	handler.ServeHTTP(rr, req)
+
+	val := rr.Body.String()
+	for _, want := range []string{"Http_X_Forwarded_For=10.0.0.1", "Http_X_Custom=value", "Http_Method=POST"} {
+		if !strings.Contains(val, want) {
+			t.Errorf("'env' should print: %s, got: %s", want, val)
+		}
+	}
+
+	if strings.Contains(val, "secret") {
+		t.Errorf("'env' should not have printed denied or unlisted headers, got: %s", val)
+	}
+}
+
+func TestHandler_CallID_GeneratedWhenAbsent(t *testing.T) {
+	rr := httptest.NewRecorder()
+
+	req, err := http.NewRequest(http.MethodPost, "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := WatchdogConfig{
+		faasProcess: "env",
+		cgiHeaders:  true,
+	}
+	handler := makeRequestHandler(&config)
+	handler.ServeHTTP(rr, req)
+
+	callID := rr.Header().Get("X-Call-Id")
+	if len(callID) != 36 {
+		t.Fatalf("X-Call-Id should be a generated UUID, got: %q", callID)
+	}
+
+	val := rr.Body.String()
+	if !strings.Contains(val, "Http_X_Call_Id="+callID) {
+		t.Errorf("'env' should print: Http_X_Call_Id=%s, got: %s", callID, val)
+	}
+}
+
+func TestHandler_CallID_PropagatedFromCaller(t *testing.T) {
+	rr := httptest.NewRecorder()
+
+	req, err := http.NewRequest(http.MethodPost, "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-Call-Id", "gateway-id")
+
+	config := WatchdogConfig{
+		faasProcess: "cat",
+	}
+	handler := makeRequestHandler(&config)
+	handler.ServeHTTP(rr, req)
+
+	if got := rr.Header().Get("X-Call-Id"); got != "gateway-id" {
+		t.Errorf("X-Call-Id want: %s, got: %s", "gateway-id", got)
+	}
+}
+
+func TestHandler_TraceHeaders_PassedWithoutCgiHeaders(t *testing.T) {
+	rr := httptest.NewRecorder()
+
+	traceparent := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	req, err := http.NewRequest(http.MethodPost, "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("traceparent", traceparent)
+	req.Header.Set("X-B3-Sampled", "1")
+
+	config := WatchdogConfig{
+		faasProcess: "env",
+		cgiHeaders:  false,
+	}
+	handler := makeRequestHandler(&config)
+	handler.ServeHTTP(rr, req)
+
+	val := rr.Body.String()
+	for _, want := range []string{"TRACEPARENT=" + traceparent, "X_B3_SAMPLED=1"} {
+		if !strings.Contains(val, want) {
+			t.Errorf("'env' should print: %s, got: %s", want, val)
+		}
+	}
+
+	if got := rr.Header().Get("traceparent"); got != traceparent {
+		t.Errorf("traceparent should be echoed in the response, got: %s", got)
+	}
+}
+
+func TestHandler_Tracing_FunctionGivenExecSpanAsParent(t *testing.T) {
+	tracer = tracing.NewExporter("http://127.0.0.1:0", "test")
+	defer func() {
+		tracer = nil
+	}()
+
+	rr := httptest.NewRecorder()
+
+	req, err := http.NewRequest(http.MethodPost, "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+
+	config := WatchdogConfig{
+		faasProcess: "env",
+	}
+	handler := makeRequestHandler(&config)
+	handler.ServeHTTP(rr, req)
+
+	val := rr.Body.String()
+	want := "TRACEPARENT=00-4bf92f3577b34da6a3ce929d0e0e4736-"
+	if !strings.Contains(val, want) || strings.Contains(val, "TRACEPARENT=00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01") {
+		t.Errorf("'env' should print a TRACEPARENT for the exec span, got: %s", val)
+	}
+}
+
+func TestHandler_LogFormatJSON_InvocationHasFields(t *testing.T) {
+	b := bytes.NewBuffer([]byte{})
+	previous := slog.Default()
+	logging.SetOutput(b)
+	logging.Configure("json", logging.LevelInfo)
+	defer func() {
+		logging.Configure("text", logging.LevelInfo)
+		slog.SetDefault(previous)
+		logging.SetOutput(os.Stderr)
+		log.SetFlags(log.LstdFlags)
+	}()
+
+	rr := httptest.NewRecorder()
+
+	req, err := http.NewRequest(http.MethodPost, "/", bytes.NewBufferString("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-Call-Id", "call-1")
+
+	config := WatchdogConfig{
+		faasProcess: "cat",
+	}
+	handler := makeRequestHandler(&config)
+	handler.ServeHTTP(rr, req)
+
+	var entry map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(b.String()), "\n") {
+		if strings.Contains(line, "Invocation complete") {
+			if err := json.Unmarshal([]byte(line), &entry); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	if entry == nil {
+		t.Fatalf("no JSON log line for the invocation, got: %s", b.String())
+	}
+	if entry["call_id"] != "call-1" || entry["level"] != "INFO" || entry["bytes"] != float64(5) {
+		t.Errorf("unexpected log entry: %v", entry)
+	}
+	if _, ok := entry["duration_seconds"]; !ok {
+		t.Errorf("log entry should have a duration_seconds field: %v", entry)
+	}
+}
+
+type countingReader struct {
+	r io.Reader
+	n int
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += n
+	return n, err
+}
+
+func removeLockFile() error {
+	path := lockFile()
+	log.Printf("Removing lock-file : %s\n", path)
+	removeErr := os.Remove(path)
+	return removeErr
+}
+
+func TestHandler_AccessLog_CombinedFormat(t *testing.T) {
+	rr := httptest.NewRecorder()
+
+	req := httptest.NewRequest(http.MethodPost, "/resize?size=10", strings.NewReader("hello"))
+	req.RemoteAddr = "10.0.0.1:41234"
+	req.Header.Set("User-Agent", "curl/8.0")
+
+	config := WatchdogConfig{
+		faasProcess: "cat",
+	}
+
+	var b bytes.Buffer
+	handler := makeAccessLogHandler(&b, makeRequestHandler(&config))
+	handler.ServeHTTP(rr, req)
+
+	line := b.String()
+	if !strings.HasPrefix(line, "10.0.0.1 - - [") {
+		t.Errorf("access log should start with the caller's IP, got: %q", line)
+	}
+
+	want := `"POST /resize?size=10 HTTP/1.1" 200 5 "-" "curl/8.0" `
+	if !strings.Contains(line, want) {
+		t.Errorf("access log want: %q, got: %q", want, line)
+	}
+}
+
+func TestHandler_StderrPrefix_AddsCallIDAndName(t *testing.T) {
+	rr := httptest.NewRecorder()
+
+	b := bytes.NewBuffer([]byte{})
+	log.SetOutput(b)
+	defer log.SetOutput(os.Stderr)
+
+	req, err := http.NewRequest(http.MethodPost, "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-Call-Id", "call-1")
+
+	config := WatchdogConfig{
+		faasProcess:      "stat x y",
+		combineOutput:    false,
+		functionName:     "figlet",
+		stderrPrefix:     true,
+		stderrPrefixName: true,
+	}
+
+	handler := makeRequestHandler(&config)
+	handler.ServeHTTP(rr, req)
+
+	lines := 0
+	for _, line := range strings.Split(b.String(), "\n") {
+		if strings.Contains(line, "No such file or directory") {
+			lines++
+			if !strings.Contains(line, "[figlet call-1] stderr: ") {
+				t.Errorf("stderr line should be prefixed, got: %q", line)
+			}
+		}
+	}
+
+	if lines != 2 {
+		t.Errorf("want 2 prefixed stderr lines, got: %d in %s", lines, b.String())
+	}
+}
+
+func TestHandler_DebugHeaders_RedactsSecrets(t *testing.T) {
+	rr := httptest.NewRecorder()
+
+	b := bytes.NewBuffer([]byte{})
+	log.SetOutput(b)
+	defer log.SetOutput(os.Stderr)
+
+	logging.SetLevel(logging.LevelDebug)
+	defer logging.SetLevel(logging.LevelInfo)
+
+	req, err := http.NewRequest(http.MethodPost, "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer secret-token")
+	req.Header.Set("X-Secret-Key", "secret-key")
+	req.Header.Set("X-Served-By", "gateway")
+
+	config := WatchdogConfig{
+		faasProcess:      "cat",
+		logRedactHeaders: []string{"Authorization", "X-Secret-*"},
+	}
+
+	handler := makeRequestHandler(&config)
+	handler.ServeHTTP(rr, req)
+
+	out := b.String()
+	if strings.Contains(out, "secret-token") || strings.Contains(out, "secret-key") {
+		t.Errorf("debug headers should be redacted, got: %s", out)
+	}
+	if !strings.Contains(out, "[in] Authorization=[[redacted]]") {
+		t.Errorf("debug headers should show the redacted Authorization header, got: %s", out)
+	}
+	if !strings.Contains(out, "[in] X-Served-By=[gateway]") {
+		t.Errorf("debug headers should include X-Served-By, got: %s", out)
+	}
+}
+
+func TestHandler_StderrStream_WritesLinesWhileRunning(t *testing.T) {
+	rr := httptest.NewRecorder()
+
+	b := &syncBuffer{}
+	log.SetOutput(b)
+	defer log.SetOutput(os.Stderr)
+
+	req, err := http.NewRequest(http.MethodPost, "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	script := filepath.Join(t.TempDir(), "stream.sh")
+	if err := os.WriteFile(script, []byte("echo started >&2\nsleep 0.5\nprintf done >&2\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	config := WatchdogConfig{
+		faasProcess:   "sh " + script,
+		combineOutput: false,
+		stderrStream:  true,
+	}
+
+	liveOutput := make(chan string)
+	go func() {
+		time.Sleep(time.Millisecond * 250)
+		liveOutput <- b.String()
+	}()
+
+	handler := makeRequestHandler(&config)
+	handler.ServeHTTP(rr, req)
+
+	if live := <-liveOutput; !strings.Contains(live, "stderr: started") {
+		t.Errorf("stderr should be logged before the process exits, got: %s", live)
+	}
+
+	if !strings.Contains(b.String(), "stderr: done") {
+		t.Errorf("stderr should include the final unterminated line, got: %s", b.String())
+	}
+}
+
+// syncBuffer is a bytes.Buffer which is safe to read while being written
+type syncBuffer struct {
+	lock sync.Mutex
+	buf  bytes.Buffer
+}
+
+func (s *syncBuffer) Write(p []byte) (int, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.buf.Write(p)
+}
+
+func (s *syncBuffer) String() string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.buf.String()
+}
+
+func TestHandler_StderrResponse_Header(t *testing.T) {
+	rr := httptest.NewRecorder()
+
+	req, err := http.NewRequest(http.MethodPost, "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := WatchdogConfig{
+		faasProcess:            "stat x y",
+		combineOutput:          false,
+		stderrResponse:         "header",
+		stderrResponseMaxBytes: 4096,
+	}
+
+	handler := makeRequestHandler(&config)
+	handler.ServeHTTP(rr, req)
+
+	val := rr.Header().Get("X-Function-Stderr")
+	if !strings.Contains(val, "No such file or directory") || !strings.Contains(val, `\n`) {
+		t.Errorf("X-Function-Stderr should contain both escaped lines of stderr, got: %q", val)
+	}
+	if strings.Contains(rr.Body.String(), "No such file or directory") {
+		t.Errorf("stderr should not be written to the body, got: %q", rr.Body.String())
+	}
+}
+
+func TestHandler_StderrResponse_TrailerTruncated(t *testing.T) {
+	rr := httptest.NewRecorder()
+
+	req, err := http.NewRequest(http.MethodPost, "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := WatchdogConfig{
+		faasProcess:            "stat x",
+		combineOutput:          false,
+		stderrResponse:         "trailer",
+		stderrResponseMaxBytes: 5,
+	}
+
+	handler := makeRequestHandler(&config)
+	handler.ServeHTTP(rr, req)
+
+	res := rr.Result()
+	if len(res.Header.Get("X-Function-Stderr")) > 0 {
+		t.Errorf("X-Function-Stderr should not be sent as a header")
+	}
+
+	want := "stat:..."
+	if val := res.Trailer.Get("X-Function-Stderr"); val != want {
+		t.Errorf("X-Function-Stderr trailer want: %q, got: %q", want, val)
+	}
+}
+
+func TestHandler_MaxLogBytes_TruncatesStderr(t *testing.T) {
+	rr := httptest.NewRecorder()
+
+	b := bytes.NewBuffer([]byte{})
+	log.SetOutput(b)
+	defer log.SetOutput(os.Stderr)
+
+	req, err := http.NewRequest(http.MethodPost, "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := WatchdogConfig{
+		faasProcess:   "stat x",
+		combineOutput: false,
+		maxLogBytes:   5,
+	}
+
+	handler := makeRequestHandler(&config)
+	handler.ServeHTTP(rr, req)
+
+	out := b.String()
+	if strings.Contains(out, "No such file or directory") {
+		t.Errorf("stderr should be truncated, got: %s", out)
+	}
+	if !strings.Contains(out, "stderr: stat:") || !strings.Contains(out, "[truncated ") {
+		t.Errorf("stderr should be truncated with a marker, got: %s", out)
+	}
+}
+
+func TestStderrLineWriter_MaxBytes(t *testing.T) {
+	b := bytes.NewBuffer([]byte{})
+	log.SetOutput(b)
+	defer log.SetOutput(os.Stderr)
+
+	w := &stderrLineWriter{max: 8}
+	w.Write([]byte("line1\nline2\nline3\n"))
+	w.Flush()
+
+	out := b.String()
+	if !strings.Contains(out, "stderr: line1") || !strings.Contains(out, "stderr: lin\n") {
+		t.Errorf("want the first 8 bytes of stderr, got: %s", out)
+	}
+	if strings.Contains(out, "line3") {
+		t.Errorf("line3 should be dropped, got: %s", out)
+	}
+	if !strings.Contains(out, "[truncated 7 bytes]") {
+		t.Errorf("want a truncation marker, got: %s", out)
+	}
+}
+
+func TestHandler_ExecRusageHeader(t *testing.T) {
+	rr := httptest.NewRecorder()
+
+	req, err := http.NewRequest(http.MethodPost, "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := WatchdogConfig{
+		faasProcess:      "cat",
+		execRusageHeader: true,
+	}
+
+	handler := makeRequestHandler(&config)
+	handler.ServeHTTP(rr, req)
+
+	val := rr.Header().Get("X-Exec-Rusage")
+	if !strings.HasPrefix(val, "maxrss=") || !strings.Contains(val, ";utime=") || !strings.Contains(val, ";stime=") {
+		t.Fatalf("X-Exec-Rusage should give maxrss, utime and stime, got: %q", val)
+	}
+	if runtime.GOOS == "linux" && strings.HasPrefix(val, "maxrss=0;") {
+		t.Errorf("maxrss should be reported on linux, got: %q", val)
+	}
+}
+
+func TestExitCode_SignalledProcess(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("signals are not supported on windows")
+	}
+
+	cmd := exec.Command("sh", "-c", "kill -9 $$")
+	cmd.Stdin = strings.NewReader("")
+	res, err := runProcess(cmd, true, nil, os.Pipe, nil)
+	if err == nil {
+		t.Fatal("process killed by a signal should give an error")
+	}
+
+	if res.exitCode != 137 {
+		t.Errorf("exitCode want: 137, got: %d", res.exitCode)
+	}
+}
+
+func TestCertReloader_ReloadsChangedCertificate(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "tls.crt")
+	keyFile := filepath.Join(dir, "tls.key")
+
+	writeTestCertificate(t, certFile, keyFile, "first")
+
+	reloader, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cancel := make(chan bool)
+	defer close(cancel)
+	go reloader.watch(time.Millisecond*10, cancel)
+
+	writeTestCertificate(t, certFile, keyFile, "second")
+	later := time.Now().Add(time.Second)
+	os.Chtimes(certFile, later, later)
+
+	for i := 0; i < 100; i++ {
+		cert, _ := reloader.GetCertificate(nil)
+		if cert.Leaf != nil && cert.Leaf.Subject.CommonName == "second" {
+			return
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	t.Fatalf("certificate should have been reloaded")
+}
+
+func writeTestCertificate(t *testing.T, certFile string, keyFile string, commonName string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHandler_ClientCertificate_PassedToFunction(t *testing.T) {
+	rr := httptest.NewRecorder()
+
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	req.TLS = &tls.ConnectionState{
+		PeerCertificates: []*x509.Certificate{{
+			Subject:      pkix.Name{CommonName: "billing", Organization: []string{"acme"}},
+			Issuer:       pkix.Name{CommonName: "acme-ca"},
+			SerialNumber: big.NewInt(255),
+			DNSNames:     []string{"billing.acme.local"},
+		}},
+	}
+
+	config := WatchdogConfig{
+		faasProcess: "env",
+	}
+
+	handler := makeRequestHandler(&config)
+	handler.ServeHTTP(rr, req)
+
+	val := rr.Body.String()
+	for _, want := range []string{
+		"SSL_CLIENT_VERIFY=SUCCESS",
+		"SSL_CLIENT_S_DN=CN=billing,O=acme",
+		"SSL_CLIENT_S_DN_CN=billing",
+		"SSL_CLIENT_I_DN=CN=acme-ca",
+		"SSL_CLIENT_M_SERIAL=FF",
+		"SSL_CLIENT_SAN_DNS=billing.acme.local",
+	} {
+		if !strings.Contains(val, want) {
+			t.Errorf("'env' should print: %s, got: %s", want, val)
+		}
+	}
+}
+
+func TestClientAuthType(t *testing.T) {
+	if mode, _ := clientAuthType(""); mode != tls.RequireAndVerifyClientCert {
+		t.Errorf("default should require client certificates, got: %v", mode)
+	}
+	if mode, _ := clientAuthType("optional"); mode != tls.VerifyClientCertIfGiven {
+		t.Errorf("optional should verify certificates if given, got: %v", mode)
+	}
+	if _, err := clientAuthType("sometimes"); err == nil {
+		t.Errorf("unknown modes should give an error")
+	}
+}
+
+func TestServerProtocols_H2C(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s", r.Proto)
+	}))
+	server.Config.Protocols = serverProtocols(true)
+	server.Start()
+	defer server.Close()
+
+	var protocols http.Protocols
+	protocols.SetUnencryptedHTTP2(true)
+	client := &http.Client{Transport: &http.Transport{Protocols: &protocols}}
+
+	res, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	body, _ := io.ReadAll(res.Body)
+	if string(body) != "HTTP/2.0" {
+		t.Errorf("want: HTTP/2.0, got: %s", body)
+	}
+}
+
+func TestOpenListeners_UnixSocket(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("unix sockets are not tested on windows")
+	}
+
+	path := filepath.Join(t.TempDir(), "fwatchdog.sock")
+
+	// A socket left from a previous run should be replaced
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	config := WatchdogConfig{
+		listenSocket: path,
+		faasProcess:  "cat",
+	}
+
+	listeners, err := openListeners(&config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeListeners(listeners)
+
+	if len(listeners) != 1 {
+		t.Fatalf("want 1 listener, got: %d", len(listeners))
+	}
+
+	s := &http.Server{Handler: makeRequestHandler(&config)}
+	go s.Serve(listeners[0])
+	defer s.Close()
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", path)
+		},
+	}}
+
+	res, err := client.Post("http://fwatchdog/", "text/plain", strings.NewReader("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	body, _ := io.ReadAll(res.Body)
+	if string(body) != "hello" {
+		t.Errorf("want: hello, got: %s", body)
+	}
+}
+
+func TestOpenListeners_NoneConfigured(t *testing.T) {
+	config := WatchdogConfig{}
+
+	if _, err := openListeners(&config); err == nil {
+		t.Errorf("an error should be given when there are no listeners")
+	}
+}
+
+func TestOpenListeners_ReusePort(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("SO_REUSEPORT is only tested on linux")
+	}
+
+	config := WatchdogConfig{
+		listenAddr:      "127.0.0.1",
+		listenTCP:       true,
+		listenReusePort: true,
+	}
+
+	first, err := openListeners(&config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeListeners(first)
+
+	_, port, _ := net.SplitHostPort(first[0].Addr().String())
+	config.port, _ = strconv.Atoi(port)
+
+	second, err := openListeners(&config)
+	if err != nil {
+		t.Fatalf("a second listener should bind the same port: %s", err)
+	}
+	closeListeners(second)
+}
+
+func TestHandler_BasicAuth(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "basic-auth-user"), []byte("admin\n"), 0600)
+	os.WriteFile(filepath.Join(dir, "basic-auth-password"), []byte("secret\n"), 0600)
+
+	config := WatchdogConfig{
+		faasProcess:     "cat",
+		secretMountPath: dir,
+		basicAuth:       true,
+	}
+
+	handler, err := makeBasicAuthHandler(config, makeRequestHandler(&config))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		user, password string
+		status         int
+	}{
+		{"admin", "secret", http.StatusOK},
+		{"admin", "guess", http.StatusUnauthorized},
+		{"", "", http.StatusUnauthorized},
+	}
+
+	for _, c := range cases {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("hello"))
+		if len(c.user) > 0 {
+			req.SetBasicAuth(c.user, c.password)
+		}
+
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != c.status {
+			t.Errorf("user %q status want: %d, got: %d", c.user, c.status, rr.Code)
+		}
+	}
+}
+
+func TestMakeBasicAuthHandler_MissingSecret(t *testing.T) {
+	config := WatchdogConfig{
+		secretMountPath: t.TempDir(),
+	}
+
+	if _, err := makeBasicAuthHandler(config, http.NotFoundHandler()); err == nil {
+		t.Errorf("an error should be given when the secrets are missing")
+	}
+}
+
+func TestHandler_APIKeyAuth(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "api-key"), []byte("key-1\nkey-2\n"), 0600)
+	os.WriteFile(filepath.Join(dir, "partner-key"), []byte("key-3"), 0600)
+
+	config := WatchdogConfig{
+		faasProcess:     "env",
+		cgiHeaders:      true,
+		secretMountPath: dir,
+		apiKeyAuth:      true,
+		apiKeyHeader:    "X-Webhook-Key",
+		apiKeySecrets:   []string{"api-key", "partner-key"},
+	}
+
+	handler, err := makeAPIKeyHandler(config, makeRequestHandler(&config))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[string]int{
+		"key-1": http.StatusOK,
+		"key-2": http.StatusOK,
+		"key-3": http.StatusOK,
+		"key-4": http.StatusUnauthorized,
+		"":      http.StatusUnauthorized,
+	}
+
+	for key, status := range cases {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/", nil)
+		req.Header.Set("X-Webhook-Key", key)
+
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != status {
+			t.Errorf("key %q status want: %d, got: %d", key, status, rr.Code)
+		}
+		if status == http.StatusOK && strings.Contains(rr.Body.String(), key) {
+			t.Errorf("the API key should not be passed to the function")
+		}
+	}
+}
+
+func TestHandler_HMACAuth(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "webhook-secret"), []byte("s3cr3t"), 0600)
+
+	config := WatchdogConfig{
+		faasProcess:     "cat",
+		secretMountPath: dir,
+		hmacAuth:        true,
+		hmacHeader:      "X-Hub-Signature-256",
+		hmacSecret:      "webhook-secret",
+	}
+
+	handler, err := makeHMACHandler(config, makeRequestHandler(&config))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := `{"action":"opened"}`
+	mac := hmac.New(sha256.New, []byte("s3cr3t"))
+	mac.Write([]byte(body))
+	valid := hex.EncodeToString(mac.Sum(nil))
+
+	cases := map[string]int{
+		"sha256=" + valid: http.StatusOK,
+		valid:             http.StatusOK,
+		"sha256=00ff":     http.StatusUnauthorized,
+		"not-hex":         http.StatusUnauthorized,
+		"":                http.StatusUnauthorized,
+	}
+
+	for signature, status := range cases {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		req.Header.Set("X-Hub-Signature-256", signature)
+
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != status {
+			t.Errorf("signature %q status want: %d, got: %d", signature, status, rr.Code)
+		}
+		if status == http.StatusOK && rr.Body.String() != body {
+			t.Errorf("the body should be passed to the function, got: %q", rr.Body.String())
+		}
+	}
+}
+
+func TestHandler_JWTClaims_PassedToFunction(t *testing.T) {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"user-1","aud":["fn","gateway"],"tenant-id":"acme","roles":{"admin":true},"email":"a@b.c"}`))
+	token := "eyJhbGciOiJSUzI1NiJ9." + payload + ".c2lnbmF0dXJl"
+
+	config := WatchdogConfig{
+		faasProcess: "env",
+	}
+
+	handler := makeJWTClaimsHandler([]string{"sub", "aud", "tenant-id", "roles"}, makeRequestHandler(&config))
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	handler.ServeHTTP(rr, req)
+
+	val := rr.Body.String()
+	for _, want := range []string{
+		"Jwt_sub=user-1",
+		"Jwt_aud=fn,gateway",
+		"Jwt_tenant_id=acme",
+		`Jwt_roles={"admin":true}`,
+	} {
+		if !strings.Contains(val, want) {
+			t.Errorf("'env' should print: %s, got: %s", want, val)
+		}
+	}
+	if strings.Contains(val, "Jwt_email") {
+		t.Errorf("claims which are not listed should not be passed, got: %s", val)
+	}
+}
+
+func TestHandler_OIDCAuth(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwks, err := (&jwk.KeySpecSet{Keys: []jwk.KeySpec{*jwk.NewSpecWithID("key-1", key)}}).MarshalPublicJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var issuer string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			fmt.Fprintf(w, `{"issuer":%q,"jwks_uri":%q}`, issuer, issuer+"/keys")
+		case "/keys":
+			w.Write(jwks)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+	issuer = srv.URL
+
+	oidc, err := newOIDCAuth(issuer, "", "fn")
+	if err != nil {
+		t.Fatalf("discovery should succeed, got: %s", err)
+	}
+
+	config := WatchdogConfig{
+		faasProcess: "env",
+	}
+	handler := oidc.Handler(makeJWTClaimsHandler([]string{"sub"}, makeRequestHandler(&config)))
+
+	sign := func(claims jwt.MapClaims, kid string) string {
+		token := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+		token.Header["kid"] = kid
+		signed, err := token.SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+	exp := time.Now().Add(time.Minute).Unix()
+
+	cases := []struct {
+		name  string
+		token string
+		want  int
+	}{
+		{"valid", sign(jwt.MapClaims{"iss": issuer, "aud": "fn", "sub": "user-1", "exp": exp}, "key-1"), http.StatusOK},
+		{"no token", "", http.StatusUnauthorized},
+		{"wrong issuer", sign(jwt.MapClaims{"iss": "https://other", "aud": "fn", "exp": exp}, "key-1"), http.StatusUnauthorized},
+		{"wrong audience", sign(jwt.MapClaims{"iss": issuer, "aud": "other", "exp": exp}, "key-1"), http.StatusUnauthorized},
+		{"expired", sign(jwt.MapClaims{"iss": issuer, "aud": "fn", "exp": time.Now().Add(-time.Minute).Unix()}, "key-1"), http.StatusUnauthorized},
+		{"no expiry", sign(jwt.MapClaims{"iss": issuer, "aud": "fn"}, "key-1"), http.StatusUnauthorized},
+		{"unknown kid", sign(jwt.MapClaims{"iss": issuer, "aud": "fn", "exp": exp}, "key-2"), http.StatusUnauthorized},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/", nil)
+			if len(c.token) > 0 {
+				req.Header.Set("Authorization", "Bearer "+c.token)
+			}
+			handler.ServeHTTP(rr, req)
+
+			if rr.Code != c.want {
+				t.Fatalf("status code - want: %d, got: %d, body: %s", c.want, rr.Code, rr.Body.String())
+			}
+			if c.want == http.StatusOK && !strings.Contains(rr.Body.String(), "Jwt_sub=user-1") {
+				t.Errorf("claims should be passed to the function, got: %s", rr.Body.String())
+			}
+			if c.want == http.StatusUnauthorized && len(rr.Header().Get("WWW-Authenticate")) == 0 {
+				t.Errorf("WWW-Authenticate should be set")
+			}
+		})
+	}
+}
+
+func TestHandler_IPFilter(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	handler, err := makeIPFilterHandler([]string{"10.0.0.0/8", "2001:db8::/32"}, []string{"10.0.0.5"}, next)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		remoteAddr string
+		want       int
+	}{
+		{"10.1.2.3:1234", http.StatusOK},
+		{"[2001:db8::1]:1234", http.StatusOK},
+		{"[::ffff:10.1.2.3]:1234", http.StatusOK},
+		{"10.0.0.5:1234", http.StatusForbidden},
+		{"192.168.0.1:1234", http.StatusForbidden},
+		{"@", http.StatusForbidden},
+	}
+
+	for _, c := range cases {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/", nil)
+		req.RemoteAddr = c.remoteAddr
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != c.want {
+			t.Errorf("%s - want: %d, got: %d", c.remoteAddr, c.want, rr.Code)
+		}
+	}
+
+	if _, err := makeIPFilterHandler([]string{"10.0.0.0/33"}, nil, next); err == nil {
+		t.Errorf("an invalid CIDR should give an error")
+	}
+}
+
+func TestHandler_RateLimit(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	now := time.Now()
+	l := newRateLimiter(1, 2, "X-Tenant")
+	l.now = func() time.Time { return now }
+	handler := l.Handler(next)
+
+	do := func(remoteAddr string, tenant string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/", nil)
+		req.RemoteAddr = remoteAddr
+		if len(tenant) > 0 {
+			req.Header.Set("X-Tenant", tenant)
+		}
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	for i := 0; i < 2; i++ {
+		if rr := do("10.0.0.1:1234", ""); rr.Code != http.StatusOK {
+			t.Fatalf("request %d within the burst - want: %d, got: %d", i, http.StatusOK, rr.Code)
+		}
+	}
+
+	rr := do("10.0.0.1:5678", "")
+	if rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("want: %d, got: %d", http.StatusTooManyRequests, rr.Code)
+	}
+	if got := rr.Header().Get("Retry-After"); got != "1" {
+		t.Errorf("Retry-After - want: 1, got: %s", got)
+	}
+
+	if rr := do("10.0.0.2:1234", ""); rr.Code != http.StatusOK {
+		t.Errorf("another client should have its own bucket, got: %d", rr.Code)
+	}
+	if rr := do("10.0.0.1:1234", "acme"); rr.Code != http.StatusOK {
+		t.Errorf("requests should be keyed by the header when present, got: %d", rr.Code)
+	}
+
+	now = now.Add(time.Second)
+	if rr := do("10.0.0.1:1234", ""); rr.Code != http.StatusOK {
+		t.Errorf("a token should be refilled after 1s, got: %d", rr.Code)
+	}
+}
+
+func TestHandler_MaxInflight_Queue(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{}, 3)
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+		w.WriteHeader(http.StatusOK)
+	})
+
+	handler := newInflightLimiter(next, 1, 1, 5*time.Second)
+
+	codes := make(chan int, 3)
+	do := func() {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/", nil))
+		codes <- rr.Code
+	}
+
+	go do()
+	<-started
+
+	// The second request waits in the queue, the third finds it full
+	go do()
+	for handler.queued() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	do()
+	if code := <-codes; code != http.StatusTooManyRequests {
+		t.Fatalf("when the queue is full - want: %d, got: %d", http.StatusTooManyRequests, code)
+	}
+
+	close(release)
+	for i := 0; i < 2; i++ {
+		if code := <-codes; code != http.StatusOK {
+			t.Errorf("want: %d, got: %d", http.StatusOK, code)
+		}
+	}
+}
+
+func TestHandler_MaxInflight_QueueWait(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	started := make(chan struct{})
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	})
+
+	handler := newInflightLimiter(next, 1, 1, 10*time.Millisecond)
+	go handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/", nil))
+	<-started
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/", nil))
+	if rr.Code != http.StatusTooManyRequests {
+		t.Errorf("after max_queue_wait - want: %d, got: %d", http.StatusTooManyRequests, rr.Code)
+	}
+}
+
+func TestHandler_MaxInflight_RejectResponse(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	started := make(chan struct{})
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	})
+
+	handler := newInflightLimiter(next, 1, 0, 0)
+	handler.retryAfter = 1500 * time.Millisecond
+	handler.setRejectBody(`{"error":"busy"}`)
+
+	go handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/", nil))
+	<-started
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/", nil))
+
+	if rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("want: %d, got: %d", http.StatusTooManyRequests, rr.Code)
+	}
+	if got := rr.Header().Get("Retry-After"); got != "2" {
+		t.Errorf("Retry-After should be rounded up - want: 2, got: %s", got)
+	}
+	if got := rr.Header().Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type - want: application/json, got: %s", got)
+	}
+	if got := rr.Body.String(); got != `{"error":"busy"}` {
+		t.Errorf("body - want: %s, got: %s", `{"error":"busy"}`, got)
+	}
+}
+
+func TestHandler_MaxInflight_RetryAfterAuto(t *testing.T) {
+	handler := newInflightLimiter(http.NotFoundHandler(), 1, 0, 0)
+	handler.retryAfterAuto = true
+
+	handler.observe(4 * time.Second)
+	handler.observe(9 * time.Second)
+
+	if got := handler.retryAfterSeconds(); got != 5 {
+		t.Errorf("want the moving average: 5, got: %d", got)
+	}
+}
+
+func TestHandler_MaxInflight_Priority(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{}, 4)
+	var order []string
+	var lock sync.Mutex
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		order = append(order, r.Header.Get("X-Priority"))
+		lock.Unlock()
+		started <- struct{}{}
+		<-release
+	})
+
+	handler := newInflightLimiter(next, 1, 2, 5*time.Second)
+	handler.priorityHeader = "X-Priority"
+	handler.priorityClasses = []string{"high", "normal", "low"}
+
+	codes := make(chan int, 4)
+	do := func(priority string) {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/", nil)
+		req.Header.Set("X-Priority", priority)
+		handler.ServeHTTP(rr, req)
+		codes <- rr.Code
+	}
+
+	go do("running")
+	<-started
+
+	go do("low")
+	for handler.queued() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	go do("normal")
+	for handler.queued() != 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// The queue is full, so the low priority request loses its place
+	go do("high")
+	if code := <-codes; code != http.StatusTooManyRequests {
+		t.Fatalf("the evicted request - want: %d, got: %d", http.StatusTooManyRequests, code)
+	}
+
+	close(release)
+	for i := 0; i < 3; i++ {
+		<-codes
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	want := []string{"running", "high", "normal"}
+	if strings.Join(order, ",") != strings.Join(want, ",") {
+		t.Errorf("order - want: %v, got: %v", want, order)
+	}
+}
+
+func TestHandler_LoadShedding(t *testing.T) {
+	root := t.TempDir()
+	files := map[string]string{
+		"cgroup.controllers": "cpu memory\n",
+		"memory.current":     "900\n",
+		"memory.max":         "1000\n",
+		"memory.stat":        "anon 500\ninactive_file 200\n",
+		"cpu.stat":           "usage_usec 0\nuser_usec 0\n",
+		"cpu.max":            "50000 100000\n",
+	}
+	for name, value := range files {
+		if err := os.WriteFile(filepath.Join(root, name), []byte(value), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	shedder, err := newLoadShedder(root, 80, 90)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := shedder.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	do := func() int {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/", nil))
+		return rr.Code
+	}
+
+	sample := func(usageUsec int) {
+		os.WriteFile(filepath.Join(root, "cpu.stat"), []byte(fmt.Sprintf("usage_usec %d\n", usageUsec)), 0600)
+		usage, err := shedder.read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		shedder.update(usage, shedder.lastTime.Add(time.Second))
+	}
+
+	// 700 of 1000 bytes and 0.4 of 0.5 CPUs
+	sample(400000)
+	if code := do(); code != http.StatusOK {
+		t.Fatalf("under the limits - want: %d, got: %d", http.StatusOK, code)
+	}
+
+	// 0.45 of 0.5 CPUs
+	sample(850000)
+	if code := do(); code != http.StatusServiceUnavailable {
+		t.Fatalf("over the CPU limit - want: %d, got: %d", http.StatusServiceUnavailable, code)
+	}
+
+	os.WriteFile(filepath.Join(root, "memory.stat"), []byte("inactive_file 50\n"), 0600)
+	sample(850000)
+	if code := do(); code != http.StatusServiceUnavailable {
+		t.Fatalf("over the memory limit - want: %d, got: %d", http.StatusServiceUnavailable, code)
+	}
+
+	os.WriteFile(filepath.Join(root, "memory.stat"), []byte("inactive_file 200\n"), 0600)
+	sample(850000)
+	if code := do(); code != http.StatusOK {
+		t.Fatalf("after pressure subsides - want: %d, got: %d", http.StatusOK, code)
+	}
+}
+
+func TestHandler_CircuitBreaker(t *testing.T) {
+	now := time.Now()
+	breaker = newCircuitBreaker(2, 10*time.Second)
+	breaker.now = func() time.Time { return now }
+	defer func() {
+		breaker = nil
+	}()
+
+	config := WatchdogConfig{
+		faasProcess: "false",
+	}
+	handler := makeRequestHandler(&config)
+
+	do := func() *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/", nil))
+		return rr
+	}
+
+	for i := 0; i < 2; i++ {
+		if rr := do(); rr.Code != http.StatusInternalServerError {
+			t.Fatalf("failure %d - want: %d, got: %d", i, http.StatusInternalServerError, rr.Code)
+		}
+	}
+
+	rr := do()
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("when open - want: %d, got: %d", http.StatusServiceUnavailable, rr.Code)
+	}
+	if got := rr.Header().Get("Retry-After"); got != "10" {
+		t.Errorf("Retry-After - want: 10, got: %s", got)
+	}
+
+	// After the cool-down a single failure opens it again
+	now = now.Add(10 * time.Second)
+	do()
+	if rr := do(); rr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("after another failure - want: %d, got: %d", http.StatusServiceUnavailable, rr.Code)
+	}
+
+	now = now.Add(10 * time.Second)
+	config.faasProcess = "true"
+	do()
+	config.faasProcess = "false"
+	if rr := do(); rr.Code != http.StatusInternalServerError {
+		t.Fatalf("a success should close the breaker - want: %d, got: %d", http.StatusInternalServerError, rr.Code)
+	}
+}
+
+func TestHandler_ExecRetries(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "flaky.sh")
+	body := fmt.Sprintf("#!/bin/sh\nif [ -f %[1]s/ran ]; then cat; exit 0; fi\ntouch %[1]s/ran\nexit 75\n", dir)
+	if err := os.WriteFile(script, []byte(body), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	config := WatchdogConfig{
+		faasProcess:        script,
+		execRetries:        1,
+		execRetryExitCodes: []int{75},
+	}
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("hello"))
+	makeRequestHandler(&config).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("want: %d, got: %d, body: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	if got := rr.Body.String(); got != "hello" {
+		t.Errorf("the retry should be given the body - want: hello, got: %s", got)
+	}
+}
+
+func TestHandler_ExecRetries_NotRetriedForOtherExitCodes(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "failing.sh")
+	body := fmt.Sprintf("#!/bin/sh\necho run >> %s/runs\nexit 1\n", dir)
+	if err := os.WriteFile(script, []byte(body), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	config := WatchdogConfig{
+		faasProcess:        script,
+		execRetries:        2,
+		execRetryExecError: true,
+		execRetryExitCodes: []int{75},
+	}
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	makeRequestHandler(&config).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusInternalServerError {
+		t.Fatalf("want: %d, got: %d", http.StatusInternalServerError, rr.Code)
+	}
+	runs, _ := os.ReadFile(filepath.Join(dir, "runs"))
+	if got := strings.Count(string(runs), "run"); got != 1 {
+		t.Errorf("want 1 run, got: %d", got)
+	}
+}
+
+func TestHandler_MaxRequests_RequestsShutdown(t *testing.T) {
+	handler := makeMaxRequestsHandler(2, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/", nil))
+	select {
+	case reason := <-shutdownRequests:
+		t.Fatalf("shutdown should not be requested before max_requests, got: %s", reason)
+	default:
+	}
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/", nil))
+	select {
+	case reason := <-shutdownRequests:
+		if !strings.Contains(reason, "max_requests") {
+			t.Errorf("reason should mention max_requests, got: %s", reason)
+		}
+	default:
+		t.Fatalf("shutdown should be requested after max_requests")
+	}
+}
+
+func TestHandler_IdleShutdown(t *testing.T) {
+	now := time.Now()
+	idle := newIdleTracker()
+	idle.now = func() time.Time { return now }
+	idle.last.Store(now.UnixNano())
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	handler := idle.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	}))
+
+	done := make(chan struct{})
+	go func() {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/", nil))
+		close(done)
+	}()
+	<-started
+
+	now = now.Add(time.Minute)
+	if idle.idle(time.Second) {
+		t.Errorf("should not be idle while a request is running")
+	}
+
+	close(release)
+	<-done
+	if idle.idle(time.Second) {
+		t.Errorf("should not be idle just after a request")
+	}
+
+	now = now.Add(time.Second)
+	if !idle.idle(time.Second) {
+		t.Errorf("should be idle after the timeout")
+	}
+}
+
+func TestWarmup(t *testing.T) {
+	config := WatchdogConfig{
+		faasProcess: "cat",
+	}
+	if err := warmup(makeRequestHandler(&config), "warmup", "ping"); err != nil {
+		t.Errorf("want no error, got: %s", err)
+	}
+
+	config.faasProcess = "false"
+	err := warmup(makeRequestHandler(&config), "/warmup", "")
+	if err == nil || !strings.Contains(err.Error(), "500") {
+		t.Errorf("a failed warm-up should give an error with the status, got: %v", err)
+	}
+}
+
+func TestValidateProcesses(t *testing.T) {
+	config := WatchdogConfig{
+		faasProcess: "cat -n",
+		routes:      []route{{prefix: "/env", process: "env"}},
+	}
+	if err := validateProcesses(&config); err != nil {
+		t.Errorf("want no error, got: %s", err)
+	}
+
+	config.routes = append(config.routes, route{prefix: "/typo", process: "catt"})
+	err := validateProcesses(&config)
+	if err == nil || !strings.Contains(err.Error(), "/typo") || !strings.Contains(err.Error(), "catt") {
+		t.Errorf("want an error naming the route and process, got: %v", err)
+	}
+
+	dir := t.TempDir()
+	notExecutable := filepath.Join(dir, "handler.sh")
+	os.WriteFile(notExecutable, []byte("#!/bin/sh\n"), 0600)
+	config = WatchdogConfig{faasProcess: notExecutable}
+	if err := validateProcesses(&config); err == nil {
+		t.Errorf("want an error for a file which is not executable")
+	}
+}
+
+func TestSelfTest(t *testing.T) {
+	config := WatchdogConfig{
+		faasProcess: "cat",
+	}
+
+	var out bytes.Buffer
+	code := runSelfTest(&config, []string{"-body", "hello"}, &out)
+	if code != 0 {
+		t.Errorf("exit code - want: 0, got: %d, output: %s", code, out.String())
+	}
+	for _, want := range []string{"Status: 200", "Exit code: 0", "Output:\nhello"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("want: %q in output, got: %s", want, out.String())
+		}
+	}
+
+	config.faasProcess = "false"
+	out.Reset()
+	if code := runSelfTest(&config, nil, &out); code != 1 {
+		t.Errorf("exit code - want: 1, got: %d", code)
+	}
+	if !strings.Contains(out.String(), "Exit code: 1") {
+		t.Errorf("the exit code of the process should be printed, got: %s", out.String())
+	}
+}
+
+func TestHandler_Config(t *testing.T) {
+	config := WatchdogConfig{
+		faasProcess:        "cat",
+		writeTimeout:       10 * time.Second,
+		routes:             []route{{prefix: "/api", process: "env"}},
+		metricsBearerToken: "s3cr3t-token",
+	}
+
+	rr := httptest.NewRecorder()
+	makeConfigHandler(&config).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/_/config", nil))
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("want: %d, got: %d", http.StatusOK, rr.Code)
+	}
+	if got := rr.Header().Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type - want: application/json, got: %s", got)
+	}
+
+	var values map[string]interface{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &values); err != nil {
+		t.Fatalf("invalid JSON: %s", err)
+	}
+	if values["faasProcess"] != "cat" || values["writeTimeout"] != "10s" {
+		t.Errorf("want the effective configuration, got: %s", rr.Body.String())
+	}
+	if values["metricsBearerToken"] != redactedValue {
+		t.Errorf("credentials should be redacted, got: %v", values["metricsBearerToken"])
+	}
+	if !strings.Contains(rr.Body.String(), `"prefix": "/api"`) {
+		t.Errorf("routes should be included, got: %s", rr.Body.String())
+	}
+}
+
+func TestHandler_Reload(t *testing.T) {
+	config := WatchdogConfig{
+		faasProcess:  "cat",
+		writeTimeout: 10 * time.Second,
+		maxInflight:  1,
+	}
+	handler, inflight := newRequestHandler(&config)
+
+	reloader := newConfigReloader(&config, inflight, func() (WatchdogConfig, error) {
+		return WatchdogConfig{
+			execTimeout: 5 * time.Second,
+			logLevel:    logging.LevelDebug,
+			maxInflight: 2,
+			contentType: "application/json",
+			// Settings which are fixed at start-up are not applied
+			faasProcess: "env",
+		}, nil
+	})
+	defer logging.SetLevel(logging.LevelInfo)
+
+	rr := httptest.NewRecorder()
+	reloader.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/_/reload", nil))
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("want: %d, got: %d, %s", http.StatusNoContent, rr.Code, rr.Body.String())
+	}
+
+	if config.execTimeout != 5*time.Second || config.maxInflight != 2 || config.contentType != "application/json" {
+		t.Errorf("reloadable settings were not applied: %+v", config)
+	}
+	if config.faasProcess != "cat" {
+		t.Errorf("fprocess should not be reloaded, got: %s", config.faasProcess)
+	}
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/", strings.NewReader("{}")))
+	if got := rr.Header().Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type - want: application/json, got: %s", got)
+	}
+}
+
+func TestHandler_Reload_KeepsConfigOnError(t *testing.T) {
+	config := WatchdogConfig{maxInflight: 1}
+	reloader := newConfigReloader(&config, nil, func() (WatchdogConfig, error) {
+		return WatchdogConfig{}, fmt.Errorf("bad config_file")
+	})
+
+	rr := httptest.NewRecorder()
+	reloader.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/_/reload", nil))
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("want: %d, got: %d", http.StatusInternalServerError, rr.Code)
+	}
+	if config.maxInflight != 1 {
+		t.Errorf("max_inflight should be unchanged, got: %d", config.maxInflight)
+	}
+}
+
+func TestHandler_MaxInflight_Raised(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	started := make(chan struct{}, 2)
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+	})
+
+	handler := newInflightLimiter(next, 1, 1, 5*time.Second)
+	go handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/", nil))
+	<-started
+
+	go handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/", nil))
+	for handler.queued() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// Raising the limit admits the queued request straight away
+	handler.setMax(2)
+	select {
+	case <-started:
+	case <-time.After(time.Second):
+		t.Fatal("the queued request was not admitted")
+	}
+}
+
+func TestHandler_SecretEnv(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "db-password"), []byte("hunter2\n"), 0600)
+	os.WriteFile(filepath.Join(dir, "api-key"), []byte("abc"), 0600)
+	os.Mkdir(filepath.Join(dir, "..data"), 0700)
+
+	config := WatchdogConfig{
+		faasProcess:     "env",
+		secretMountPath: dir,
+		secretEnv:       true,
+	}
+
+	rr := httptest.NewRecorder()
+	makeRequestHandler(&config).ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("want: %d, got: %d", http.StatusOK, rr.Code)
+	}
+	for _, want := range []string{"SECRET_DB_PASSWORD=hunter2\n", "SECRET_API_KEY=abc\n"} {
+		if !strings.Contains(rr.Body.String(), want) {
+			t.Errorf("want: %q, got: %s", want, rr.Body.String())
+		}
+	}
+
+	config.secretEnvAllow = []string{"api-key"}
+	rr = httptest.NewRecorder()
+	makeRequestHandler(&config).ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/", nil))
+	if strings.Contains(rr.Body.String(), "SECRET_DB_PASSWORD") {
+		t.Errorf("secrets outside secret_env_allow should not be passed, got: %s", rr.Body.String())
+	}
+	if !strings.Contains(rr.Body.String(), "SECRET_API_KEY=abc\n") {
+		t.Errorf("want: SECRET_API_KEY, got: %s", rr.Body.String())
+	}
+
+	config.secretEnvAllow = []string{"missing"}
+	rr = httptest.NewRecorder()
+	makeRequestHandler(&config).ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/", nil))
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("for a missing secret - want: %d, got: %d", http.StatusInternalServerError, rr.Code)
+	}
+}
+
+func TestHandler_EnvFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "function.env")
+	os.WriteFile(path, []byte("# credentials\nexport TOKEN=\"one\"\n\nREGION=eu\n"), 0600)
+
+	config := WatchdogConfig{
+		faasProcess: "env",
+		envFile:     path,
+	}
+	handler := makeRequestHandler(&config)
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/", nil))
+	for _, want := range []string{"TOKEN=one\n", "REGION=eu\n"} {
+		if !strings.Contains(rr.Body.String(), want) {
+			t.Errorf("want: %q, got: %s", want, rr.Body.String())
+		}
+	}
+
+	// A rotated value is picked up without a new handler
+	os.WriteFile(path, []byte("TOKEN=two-rotated\n"), 0600)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/", nil))
+	if !strings.Contains(rr.Body.String(), "TOKEN=two-rotated\n") {
+		t.Errorf("want the rotated TOKEN, got: %s", rr.Body.String())
+	}
+
+	os.Remove(path)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/", nil))
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("for a missing env_file - want: %d, got: %d", http.StatusInternalServerError, rr.Code)
+	}
+}
+
+func TestParseEnvFile_Invalid(t *testing.T) {
+	if _, err := parseEnvFile("TOKEN=one\nnot a variable\n"); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("want an error for line 2, got: %v", err)
+	}
+}
+
+func TestHandler_FprocessShell(t *testing.T) {
+	config := WatchdogConfig{
+		faasProcess:   "tr a-z A-Z | rev",
+		fprocessShell: true,
+	}
+
+	rr := httptest.NewRecorder()
+	makeRequestHandler(&config).ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/", strings.NewReader("hello")))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("want: %d, got: %d", http.StatusOK, rr.Code)
+	}
+	if got := rr.Body.String(); got != "OLLEH" {
+		t.Errorf("want: OLLEH, got: %q", got)
+	}
+	if err := validateProcesses(&config); err != nil {
+		t.Errorf("the shell should be validated instead of the first word, got: %s", err)
+	}
+}
+
+func TestHandler_ExecCwd(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "handler.sh"), []byte("#!/bin/sh\npwd\n"), 0700)
+
+	config := WatchdogConfig{
+		faasProcess: "./handler.sh",
+		execCwd:     dir,
+	}
+	if err := validateProcesses(&config); err != nil {
+		t.Fatalf("./handler.sh should be found in exec_cwd, got: %s", err)
+	}
+
+	rr := httptest.NewRecorder()
+	makeRequestHandler(&config).ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("want: %d, got: %d, %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	want, _ := filepath.EvalSymlinks(dir)
+	if got := strings.TrimSpace(rr.Body.String()); got != dir && got != want {
+		t.Errorf("want: %s, got: %s", dir, got)
+	}
+
+	config.execCwd = filepath.Join(dir, "missing")
+	if err := validateProcesses(&config); err == nil || !strings.Contains(err.Error(), "exec_cwd") {
+		t.Errorf("want an error for a missing exec_cwd, got: %v", err)
+	}
+}
+
+func TestHandler_ExecUser(t *testing.T) {
+	if runtime.GOOS == "windows" || os.Getuid() != 0 {
+		t.Skip("switching user needs root")
+	}
+
+	cred, err := lookupCredential("nobody", "")
+	if err != nil {
+		t.Skipf("no nobody user: %s", err)
+	}
+
+	config := WatchdogConfig{
+		faasProcess:    "id -u",
+		execCredential: cred,
+	}
+
+	rr := httptest.NewRecorder()
+	makeRequestHandler(&config).ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("want: %d, got: %d, %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	if got, want := strings.TrimSpace(rr.Body.String()), strconv.Itoa(int(cred.uid)); got != want {
+		t.Errorf("uid - want: %s, got: %s", want, got)
+	}
+}
+
+func TestLookupCredential(t *testing.T) {
+	cred, err := lookupCredential("0", "0")
+	if err != nil {
+		t.Skipf("no root user: %s", err)
+	}
+	if cred.uid != 0 || cred.gid != 0 || len(cred.groups) != 0 {
+		t.Errorf("want uid 0, gid 0 and no supplementary groups, got: %+v", cred)
+	}
+
+	if _, err := lookupCredential("no-such-user", ""); err == nil || !strings.Contains(err.Error(), "exec_user") {
+		t.Errorf("want an exec_user error, got: %v", err)
+	}
+}
+
+// TestMain lets the test binary stand in for the watchdog when it is started
+// as the sandbox for fprocess
+func TestMain(m *testing.M) {
+	if len(os.Args) > 1 && os.Args[1] == sandboxCommand {
+		os.Exit(runSandbox(os.Args[2:], os.Stderr))
+	}
+	os.Exit(m.Run())
+}
+
+func TestHandler_ExecSandbox(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("the sandbox is only supported on linux")
+	}
+
+	profile := filepath.Join(t.TempDir(), "seccomp.json")
+	os.WriteFile(profile, []byte(`{
+  "defaultAction": "SCMP_ACT_ALLOW",
+  "syscalls": [{"names": ["mkdir", "mkdirat"], "action": "SCMP_ACT_ERRNO"}]
+}`), 0600)
+
+	config := WatchdogConfig{
+		faasProcess:        "grep NoNewPrivs /proc/self/status; mkdir " + filepath.Join(t.TempDir(), "denied") + " || echo denied",
+		fprocessShell:      true,
+		execNoNewPrivs:     true,
+		execSeccompProfile: profile,
+	}
+	if err := validateSandbox(&config); err != nil {
+		t.Skipf("seccomp is not supported here: %s", err)
+	}
+
+	rr := httptest.NewRecorder()
+	makeRequestHandler(&config).ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("want: %d, got: %d, %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	if !strings.Contains(rr.Body.String(), "NoNewPrivs:\t1") {
+		t.Errorf("want no_new_privs to be set, got: %s", rr.Body.String())
+	}
+	if !strings.Contains(rr.Body.String(), "denied") {
+		t.Errorf("want mkdir to be denied by the profile, got: %s", rr.Body.String())
+	}
+}
+
+func TestValidateSandbox_UnsupportedRule(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("the sandbox is only supported on linux")
+	}
+
+	profile := filepath.Join(t.TempDir(), "seccomp.json")
+	os.WriteFile(profile, []byte(`{
+  "defaultAction": "SCMP_ACT_ERRNO",
+  "syscalls": [{"names": ["personality"], "action": "SCMP_ACT_ALLOW", "args": [{"index": 0, "value": 0, "op": "SCMP_CMP_EQ"}]}]
+}`), 0600)
+
+	config := WatchdogConfig{execSeccompProfile: profile}
+	if err := validateSandbox(&config); err == nil || !strings.Contains(err.Error(), "not supported") {
+		t.Errorf("want an error for conditions on args, got: %v", err)
+	}
+}
+
+func TestInvocationCgroups(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("cgroups are only supported on linux")
+	}
+
+	// A directory stands in for a delegated cgroup v2 hierarchy
+	root := t.TempDir()
+	path, err := ownCgroupPath("/proc/self/cgroup")
+	if err != nil {
+		t.Skipf("no cgroup v2 entry: %s", err)
+	}
+	dir := filepath.Join(root, path)
+	os.MkdirAll(dir, 0755)
+	os.WriteFile(filepath.Join(dir, "cgroup.controllers"), []byte("cpu memory"), 0644)
+	os.WriteFile(filepath.Join(dir, "cgroup.procs"), []byte("10\n11\n"), 0644)
+
+	cgroups, err := newInvocationCgroups(root, 64<<20, 0.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "cgroup.subtree_control")); string(data) != "+memory +cpu" {
+		t.Errorf("subtree_control - want: +memory +cpu, got: %q", data)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "watchdog", "cgroup.procs")); err != nil {
+		t.Errorf("processes should be moved to the watchdog cgroup: %s", err)
+	}
+
+	cmd := exec.Command("true")
+	release, err := cgroups.apply(cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+
+	if cmd.SysProcAttr == nil {
+		t.Errorf("the process should be started in its cgroup")
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "fprocess-1", "memory.max")); string(data) != "67108864" {
+		t.Errorf("memory.max - want: 67108864, got: %q", data)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "fprocess-1", "cpu.max")); string(data) != "50000 100000" {
+		t.Errorf("cpu.max - want: 50000 100000, got: %q", data)
+	}
+}
+
+func TestHandler_ExecNiceAndOOMScoreAdj(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("exec_nice is only supported on linux")
+	}
+
+	config := WatchdogConfig{
+		faasProcess:     "cat /proc/self/oom_score_adj /proc/self/stat",
+		fprocessShell:   true,
+		execNice:        5,
+		execOOMScoreAdj: 900,
+	}
+	if err := validateSandbox(&config); err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	makeRequestHandler(&config).ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("want: %d, got: %d, %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+
+	lines := strings.SplitN(rr.Body.String(), "\n", 2)
+	if lines[0] != "900" {
+		t.Errorf("oom_score_adj - want: 900, got: %s", lines[0])
+	}
+	// The 19th field of /proc/<pid>/stat is the nice value, after the name
+	// in brackets
+	_, stat, _ := strings.Cut(lines[1], ") ")
+	if fields := strings.Fields(stat); len(fields) < 17 || fields[16] != "5" {
+		t.Errorf("nice - want: 5, got: %s", lines[1])
+	}
+
+	config.execNice = 20
+	if err := validateSandbox(&config); err == nil {
+		t.Errorf("want an error for exec_nice out of range")
+	}
+}
+
+func TestHandler_ExecNiceWithExecUser(t *testing.T) {
+	if runtime.GOOS != "linux" || os.Getuid() != 0 {
+		t.Skip("switching user needs root on linux")
+	}
+
+	cred, err := lookupCredential("nobody", "")
+	if err != nil {
+		t.Skipf("no nobody user: %s", err)
+	}
+
+	// A negative exec_nice needs privileges which exec_user does not have
+	config := WatchdogConfig{
+		faasProcess:     "id -u; cat /proc/self/oom_score_adj /proc/self/stat",
+		fprocessShell:   true,
+		execCredential:  cred,
+		execNice:        -5,
+		execOOMScoreAdj: 500,
+	}
+
+	rr := httptest.NewRecorder()
+	makeRequestHandler(&config).ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("want: %d, got: %d, %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+
+	lines := strings.SplitN(rr.Body.String(), "\n", 3)
+	if want := strconv.Itoa(int(cred.uid)); lines[0] != want {
+		t.Errorf("uid - want: %s, got: %s", want, lines[0])
+	}
+	if lines[1] != "500" {
+		t.Errorf("oom_score_adj - want: 500, got: %s", lines[1])
+	}
+	_, stat, _ := strings.Cut(lines[2], ") ")
+	if fields := strings.Fields(stat); len(fields) < 17 || fields[16] != "-5" {
+		t.Errorf("nice - want: -5, got: %s", lines[2])
+	}
+}
+
+func TestHandler_ExecRlimits(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("rlimits are only supported on linux")
+	}
+
+	config := WatchdogConfig{
+		faasProcess:      "ulimit -n",
+		fprocessShell:    true,
+		execRlimitNofile: 32,
+	}
+
+	rr := httptest.NewRecorder()
+	makeRequestHandler(&config).ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/", nil))
+	if rr.Body.String() != "32\n" {
+		t.Errorf("ulimit -n - want: 32, got: %s", rr.Body.String())
+	}
+
+	// dd is killed with SIGXFSZ itself, where a shell would exit with 153
+	path := filepath.Join(t.TempDir(), "out")
+	config = WatchdogConfig{
+		faasProcess:     "dd if=/dev/zero of=" + path + " bs=4096 count=1",
+		execRlimitFsize: 1024,
+	}
+
+	var res execResult
+	rr = httptest.NewRecorder()
+	makeRequestHandler(&config).ServeHTTP(rr, withExecResult(httptest.NewRequest(http.MethodPost, "/", nil), &res))
+
+	if info, err := os.Stat(path); err != nil || info.Size() > 1024 {
+		t.Errorf("the file should be cut off at 1024 bytes, got: %v %v", info, err)
+	}
+	if !res.fileSizeExceeded {
+		t.Errorf("want fileSizeExceeded, got exit code: %d", res.exitCode)
+	}
+}
+
+func TestHandler_ExecTmpdir(t *testing.T) {
+	root := t.TempDir()
+	config := WatchdogConfig{
+		faasProcess:    "touch $TMPDIR/scratch; echo $TMPDIR",
+		fprocessShell:  true,
+		execTmpdir:     true,
+		execTmpdirRoot: root,
+	}
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	req.Header.Set(callIDHeader, "abc-123")
+	makeRequestHandler(&config).ServeHTTP(rr, req)
+
+	dir := strings.TrimSpace(rr.Body.String())
+	if filepath.Dir(dir) != root || !strings.HasPrefix(filepath.Base(dir), "fprocess-abc-123-") {
+		t.Errorf("want a directory for the call under %s, got: %s", root, dir)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("TMPDIR should be removed after the invocation: %s", dir)
+	}
+
+	config.faasProcess = "touch $TMPDIR/scratch; exit 1"
+	config.execTmpdirKeepFailed = true
+	makeRequestHandler(&config).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/", nil))
+
+	kept, _ := filepath.Glob(filepath.Join(root, "fprocess-*", "scratch"))
+	if len(kept) != 1 {
+		t.Errorf("TMPDIR of a failed invocation should be kept, got: %v", kept)
+	}
+}
+
+func TestJanitor(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+
+	write := func(name string, size int, age time.Duration) {
+		path := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		os.WriteFile(path, make([]byte, size), 0644)
+		os.Chtimes(path, now.Add(-age), now.Add(-age))
+		os.Chtimes(filepath.Dir(path), now.Add(-age), now.Add(-age))
+	}
+	write("stale.bin", 100, 2*time.Hour)
+	write("old/data.bin", 300, 30*time.Minute)
+	write("recent/data.bin", 300, 10*time.Minute)
+	write("new.bin", 100, time.Minute)
+
+	j := newJanitor(dir, time.Hour, 500, nil)
+	j.now = func() time.Time { return now }
+	if err := j.sweep(); err != nil {
+		t.Fatal(err)
+	}
+
+	// stale.bin is over the age, then old is the oldest while over the size
+	for name, want := range map[string]bool{"stale.bin": false, "old": false, "recent": true, "new.bin": true} {
+		_, err := os.Stat(filepath.Join(dir, name))
+		if got := err == nil; got != want {
+			t.Errorf("%s - want kept: %v, got: %v", name, want, got)
+		}
+	}
+}
+
+func TestHandler_ExecPTY(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("exec_pty is only supported on linux")
+	}
+
+	config := WatchdogConfig{
+		faasProcess:   "if [ -t 1 ]; then echo tty; fi; stty size < /dev/tty; cat",
+		fprocessShell: true,
+		execPTY:       true,
+	}
+
+	rr := httptest.NewRecorder()
+	makeRequestHandler(&config).ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/", strings.NewReader("a\nb\n")))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("want: %d, got: %d, %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+
+	// Without output processing new-lines are not turned into "\r\n"
+	if want := "tty\n24 80\na\nb\n"; rr.Body.String() != want {
+		t.Errorf("want: %q, got: %q", want, rr.Body.String())
+	}
+}
+
+func TestHandler_StdinMode(t *testing.T) {
+	cases := map[string]string{
+		stdinModeClose: "hello0\n",
+		// stdin is still open, so cat is stopped by the timeout
+		stdinModeKeep: "hello124\n",
+		stdinModeNone: "0\n",
+	}
+
+	for mode, want := range cases {
+		config := WatchdogConfig{
+			faasProcess:   "timeout 0.5 cat; echo $?",
+			fprocessShell: true,
+			stdinMode:     mode,
+		}
+
+		rr := httptest.NewRecorder()
+		makeRequestHandler(&config).ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/", strings.NewReader("hello")))
+		if rr.Body.String() != want {
+			t.Errorf("stdin_mode=%s - want: %q, got: %q", mode, want, rr.Body.String())
+		}
+	}
+}
+
+func TestHandler_MarshalRequestV2(t *testing.T) {
+	config := WatchdogConfig{
+		faasProcess:      "cat",
+		marshalRequestV2: true,
+	}
+
+	body := []byte{0xff, 0x00, 0x01}
+	req := httptest.NewRequest(http.MethodPut, "/items/1?tag=a&tag=b", bytes.NewReader(body))
+	req.Header.Set("X-Custom", "value")
+
+	rr := httptest.NewRecorder()
+	makeRequestHandler(&config).ServeHTTP(rr, req)
+
+	var envelope types.MarshalReqV2
+	if err := json.Unmarshal(rr.Body.Bytes(), &envelope); err != nil {
+		t.Fatalf("invalid envelope: %s, %s", err, rr.Body.String())
+	}
+	if envelope.Version != 2 || envelope.Method != http.MethodPut || envelope.Path != "/items/1" {
+		t.Errorf("want version, method and path, got: %+v", envelope)
+	}
+	if got := strings.Join(envelope.Query["tag"], ","); got != "a,b" {
+		t.Errorf("query tag - want: a,b, got: %s", got)
+	}
+	if envelope.Header.Get("X-Custom") != "value" {
+		t.Errorf("want the X-Custom header, got: %v", envelope.Header)
+	}
+	if !envelope.IsBase64Encoded || envelope.Body != base64.StdEncoding.EncodeToString(body) {
+		t.Errorf("a binary body should be base64 encoded, got: %q %v", envelope.Body, envelope.IsBase64Encoded)
+	}
+}
+
+func TestHandler_MarshalResponse(t *testing.T) {
+	config := WatchdogConfig{
+		faasProcess:     `printf {"statusCode":201,"header":{"x-result":["done"]},"body":"aGVsbG8=","isBase64Encoded":true}`,
+		marshalResponse: true,
+	}
+
+	rr := httptest.NewRecorder()
+	makeRequestHandler(&config).ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/", nil))
+	if rr.Code != http.StatusCreated {
+		t.Errorf("want: %d, got: %d", http.StatusCreated, rr.Code)
+	}
+	if got := rr.Header().Get("X-Result"); got != "done" {
+		t.Errorf("X-Result - want: done, got: %s", got)
+	}
+	if got := rr.Body.String(); got != "hello" {
+		t.Errorf("want: hello, got: %q", got)
+	}
+
+	config.faasProcess = "echo not-json"
+	rr = httptest.NewRecorder()
+	makeRequestHandler(&config).ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/", nil))
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("for an invalid envelope - want: %d, got: %d", http.StatusInternalServerError, rr.Code)
+	}
+}
+
+func TestHandler_CloudEvents(t *testing.T) {
+	config := WatchdogConfig{
+		faasProcess:               `printf "%s %s " "$CE_TYPE" "$CE_SOURCE"; cat`,
+		fprocessShell:             true,
+		cloudEvents:               true,
+		cloudEventsResponseType:   "com.example.result",
+		cloudEventsResponseSource: "fn",
+	}
+
+	event := `{"specversion":"1.0","id":"1","source":"/orders","type":"com.example.order","datacontenttype":"text/plain","data":"hello"}`
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(event))
+	req.Header.Set("Content-Type", "application/cloudevents+json")
+
+	rr := httptest.NewRecorder()
+	makeRequestHandler(&config).ServeHTTP(rr, req)
+	if got := rr.Body.String(); got != "com.example.order /orders hello" {
+		t.Errorf("structured - want: %q, got: %q", "com.example.order /orders hello", got)
+	}
+	if rr.Header().Get("Ce-Type") != "com.example.result" || rr.Header().Get("Ce-Source") != "fn" || len(rr.Header().Get("Ce-Id")) == 0 {
+		t.Errorf("want the response as a CloudEvent, got: %v", rr.Header())
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/", strings.NewReader("binary"))
+	req.Header.Set("Ce-Specversion", "1.0")
+	req.Header.Set("Ce-Id", "2")
+	req.Header.Set("Ce-Source", "%2Fqueue")
+	req.Header.Set("Ce-Type", "com.example.item")
+
+	rr = httptest.NewRecorder()
+	makeRequestHandler(&config).ServeHTTP(rr, req)
+	if got := rr.Body.String(); got != "com.example.item /queue binary" {
+		t.Errorf("binary - want: %q, got: %q", "com.example.item /queue binary", got)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/", strings.NewReader("plain"))
+	rr = httptest.NewRecorder()
+	makeRequestHandler(&config).ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("for a request which is not an event - want: %d, got: %d", http.StatusBadRequest, rr.Code)
+	}
+}
+
+func TestHandler_GRPCInvoke(t *testing.T) {
+	config := WatchdogConfig{
+		faasProcess: "cat",
+	}
+
+	frame := func(value string) []byte {
+		message := append([]byte{0x0a, byte(len(value))}, value...)
+		return append([]byte{0, 0, 0, 0, byte(len(message))}, message...)
+	}
+
+	body := append(frame("hello "), frame("world")...)
+	req := httptest.NewRequest(http.MethodPost, grpcInvokePath, bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/grpc")
+
+	rr := httptest.NewRecorder()
+	makeGRPCHandler(makeRequestHandler(&config)).ServeHTTP(rr, req)
+
+	res := rr.Result()
+	if got := res.Trailer.Get("Grpc-Status"); got != "0" {
+		t.Fatalf("grpc-status - want: 0, got: %q %s", got, rr.Body.String())
+	}
+	if got := rr.Body.Bytes(); !bytes.Equal(got, frame("hello world")) {
+		t.Errorf("want the output as a message, got: %q", got)
+	}
+
+	config.faasProcess = "false"
+	req = httptest.NewRequest(http.MethodPost, grpcInvokePath, bytes.NewReader(frame("x")))
+	req.Header.Set("Content-Type", "application/grpc")
+
+	rr = httptest.NewRecorder()
+	makeGRPCHandler(makeRequestHandler(&config)).ServeHTTP(rr, req)
+	if got := rr.Result().Trailer.Get("Grpc-Status"); got != strconv.Itoa(grpcUnknown) {
+		t.Errorf("for a failed invocation grpc-status - want: %d, got: %q", grpcUnknown, got)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/other.Service/Call", nil)
+	req.Header.Set("Content-Type", "application/grpc")
+
+	rr = httptest.NewRecorder()
+	makeGRPCHandler(makeRequestHandler(&config)).ServeHTTP(rr, req)
+	if got := rr.Result().Trailer.Get("Grpc-Status"); got != strconv.Itoa(grpcUnimplemented) {
+		t.Errorf("for an unknown method grpc-status - want: %d, got: %q", grpcUnimplemented, got)
+	}
+}
+
+func TestGRPCServer_TLS(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	writeTestCertificate(t, certFile, keyFile, "localhost")
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+	ln.Close()
+
+	config := WatchdogConfig{faasProcess: "cat", listenAddr: "127.0.0.1", grpcPort: port}
+	server := newGRPCServer(&config, &tls.Config{Certificates: []tls.Certificate{cert}}, makeRequestHandler(&config))
+	server.Serve()
+	defer server.Shutdown(context.Background())
+
+	var protocols http.Protocols
+	protocols.SetHTTP2(true)
+	client := &http.Client{Transport: &http.Transport{
+		Protocols:       &protocols,
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	}}
+
+	body := []byte{0, 0, 0, 0, 4, 0x0a, 2, 'h', 'i'}
+	var res *http.Response
+	for try := 0; try < 50; try++ {
+		if res, err = client.Post("https://"+net.JoinHostPort("127.0.0.1", strconv.Itoa(port))+grpcInvokePath, "application/grpc", bytes.NewReader(body)); err == nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+
+	if res.ProtoMajor != 2 || res.Trailer.Get("Grpc-Status") != "0" {
+		t.Errorf("want HTTP/2 with grpc-status 0, got: %s %q", res.Proto, res.Trailer.Get("Grpc-Status"))
+	}
+	if !bytes.Equal(out, body) {
+		t.Errorf("want the input echoed as a message, got: %q", out)
+	}
+
+	if err := server.Shutdown(context.Background()); err != nil {
+		t.Errorf("Shutdown: %s", err)
+	}
+}
+
+func TestHandler_WebSocket(t *testing.T) {
+	config := WatchdogConfig{
+		faasProcess:   `echo "$TMPDIR"; while read line; do echo "$line!"; done`,
+		fprocessShell: true,
+		websocket:     true,
+		execTmpdir:    true,
+	}
+
+	server := httptest.NewServer(makeRequestHandler(&config))
+	defer server.Close()
+
+	// A browser on another site is refused
+	req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+	for name, value := range map[string]string{
+		"Upgrade":               "websocket",
+		"Connection":            "Upgrade",
+		"Sec-WebSocket-Key":     "dGhlIHNhbXBsZSBub25jZQ==",
+		"Sec-WebSocket-Version": "13",
+		"Origin":                "https://attacker.example",
+	} {
+		req.Header.Set(name, value)
+	}
+	if res, err := http.DefaultClient.Do(req); err != nil {
+		t.Fatal(err)
+	} else if res.Body.Close(); res.StatusCode != http.StatusForbidden {
+		t.Errorf("for another origin - want: %d, got: %d", http.StatusForbidden, res.StatusCode)
+	}
+
+	conn, err := net.Dial("tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	fmt.Fprintf(conn, "GET / HTTP/1.1\r\nHost: localhost\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+		"Origin: http://localhost\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n")
+
+	reader := bufio.NewReader(conn)
+	res, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("want: %d, got: %d", http.StatusSwitchingProtocols, res.StatusCode)
+	}
+	if got := res.Header.Get("Sec-WebSocket-Accept"); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("Sec-WebSocket-Accept - want: s3pPLMBiTxaQ9kYGzzhZRbK+xOo=, got: %s", got)
+	}
+
+	writeFragment := func(opcode byte, fin bool, payload []byte) {
+		mask := []byte{1, 2, 3, 4}
+		head := opcode
+		if fin {
+			head |= 0x80
+		}
+		frame := append([]byte{head, 0x80 | byte(len(payload))}, mask...)
+		for i, b := range payload {
+			frame = append(frame, b^mask[i%4])
+		}
+		conn.Write(frame)
+	}
+	writeFrame := func(opcode byte, payload []byte) {
+		writeFragment(opcode, true, payload)
+	}
+	readFrame := func() (byte, string) {
+		head := make([]byte, 2)
+		if _, err := io.ReadFull(reader, head); err != nil {
+			t.Fatal(err)
+		}
+		payload := make([]byte, head[1]&0x7F)
+		io.ReadFull(reader, payload)
+		return head[0] & 0x0F, string(payload)
+	}
+
+	if _, tmpDir := readFrame(); !strings.Contains(tmpDir, "fprocess-") {
+		t.Errorf("want TMPDIR set by exec_tmpdir, got: %q", tmpDir)
+	}
+
+	// Each message is a line, including one sent in fragments
+	writeFrame(websocketText, []byte("hello"))
+	writeFragment(websocketText, false, []byte("wor"))
+	writeFragment(websocketContinuation, true, []byte("ld"))
+	for _, want := range []string{"hello!", "world!"} {
+		if opcode, got := readFrame(); opcode != websocketText || got != want {
+			t.Errorf("want text: %q, got: %d %q", want, opcode, got)
+		}
+	}
+
+	writeFrame(websocketClose, []byte{0x03, 0xE8})
+	opcode, payload := readFrame()
+	if opcode != websocketClose || payload != "\x03\xe8" {
+		t.Errorf("want a normal close once the process exits, got: %d %q", opcode, payload)
+	}
+}
+
+func TestHandler_SSEOutput(t *testing.T) {
+	config := WatchdogConfig{
+		faasProcess:   `printf "one\ntwo\nthree"`,
+		fprocessShell: true,
+		sseOutput:     true,
+	}
+
+	rr := httptest.NewRecorder()
+	makeRequestHandler(&config).ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/", nil))
+	if got := rr.Header().Get("Content-Type"); got != "text/event-stream" {
+		t.Errorf("Content-Type - want: text/event-stream, got: %s", got)
+	}
+	if got, want := rr.Body.String(), "data: one\n\ndata: two\n\ndata: three\n\n"; got != want {
+		t.Errorf("want: %q, got: %q", want, got)
+	}
+
+	config.faasProcess = "echo one; exit 3"
+	rr = httptest.NewRecorder()
+	makeRequestHandler(&config).ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/", nil))
+	if got, want := rr.Body.String(), "data: one\n\nevent: error\ndata: exit status 3\n\n"; got != want {
+		t.Errorf("for a failure after output - want: %q, got: %q", want, got)
+	}
+
+	config.faasProcess = "exit 3"
+	rr = httptest.NewRecorder()
+	makeRequestHandler(&config).ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/", nil))
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("for a failure without output - want: %d, got: %d", http.StatusInternalServerError, rr.Code)
+	}
+}
+
+func TestHandler_KnativeProbe(t *testing.T) {
+	invoked := false
+	handler := makeKnativeProbeHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		invoked = true
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("K-Network-Probe", "queue")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if invoked {
+		t.Errorf("a probe should not invoke the function")
+	}
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	if !invoked {
+		t.Errorf("want the function to be invoked without a probe header")
+	}
+}
+
+func TestHandler_AsyncCallback(t *testing.T) {
+	results := make(chan *http.Request, 1)
+	bodies := make(chan string, 1)
+	callback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		results <- r
+		bodies <- string(body)
+	}))
+	defer callback.Close()
+
+	config := WatchdogConfig{
+		faasProcess: "cat",
+	}
+	async := newAsyncInvoker(makeRequestHandler(&config), []string{"127.0.0.1"}, 0, 0)
+
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("hello"))
+	req.Header.Set(callbackURLHeader, callback.URL)
+	rr := httptest.NewRecorder()
+	async.ServeHTTP(rr, req)
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("want: %d, got: %d", http.StatusAccepted, rr.Code)
+	}
+	callID := rr.Header().Get(callIDHeader)
+
+	select {
+	case result := <-results:
+		if got := result.Header.Get(functionStatusHeader); got != "200" {
+			t.Errorf("%s - want: 200, got: %s", functionStatusHeader, got)
+		}
+		if got := result.Header.Get(callIDHeader); got != callID || len(got) == 0 {
+			t.Errorf("%s - want: %s, got: %s", callIDHeader, callID, got)
+		}
+		if got := <-bodies; got != "hello" {
+			t.Errorf("want: hello, got: %q", got)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for the callback")
+	}
+
+	async = newAsyncInvoker(makeRequestHandler(&config), []string{"results.internal"}, 0, 0)
+	req = httptest.NewRequest(http.MethodPost, "/", strings.NewReader("hello"))
+	req.Header.Set(callbackURLHeader, callback.URL)
+	rr = httptest.NewRecorder()
+	async.ServeHTTP(rr, req)
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("for a host which is not allowed - want: %d, got: %d", http.StatusForbidden, rr.Code)
+	}
+
+	// No host is allowed by default, and "*" only allows public addresses
+	for _, allow := range [][]string{nil, {"*"}} {
+		async = newAsyncInvoker(makeRequestHandler(&config), allow, 0, 0)
+		for _, callback := range []string{callback.URL, "http://169.254.169.254/latest", "http://[::ffff:10.0.0.1]/"} {
+			req = httptest.NewRequest(http.MethodPost, "/", nil)
+			req.Header.Set(callbackURLHeader, callback)
+			rr = httptest.NewRecorder()
+			async.ServeHTTP(rr, req)
+			if rr.Code != http.StatusForbidden {
+				t.Errorf("allow %v, callback %s - want: %d, got: %d", allow, callback, http.StatusForbidden, rr.Code)
+			}
+		}
+	}
+}
+
+func TestAsyncInvoker_DialPublicOnly(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer target.Close()
+	_, port, _ := net.SplitHostPort(target.Listener.Addr().String())
+
+	// localhost is allowed by "*" as a name, but resolves to loopback
+	async := newAsyncInvoker(http.NotFoundHandler(), []string{"*"}, 0, 0)
+	if _, err := async.client.Post("http://localhost:"+port+"/", "text/plain", nil); err == nil || !strings.Contains(err.Error(), "not allowed") {
+		t.Errorf("want a name which resolves to loopback to be refused, got: %v", err)
+	}
+
+	async = newAsyncInvoker(http.NotFoundHandler(), []string{"*", "localhost"}, 0, 0)
+	res, err := async.client.Post("http://localhost:"+port+"/", "text/plain", nil)
+	if err != nil {
+		t.Fatalf("want a host listed by name to be reached, got: %s", err)
+	}
+	res.Body.Close()
+}
+
+func TestHandler_AsyncCallbackRedirect(t *testing.T) {
+	var redirected int32
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.StoreInt32(&redirected, 1)
+	}))
+	defer target.Close()
+
+	// The target is reached through localhost, which is not on the list
+	_, port, _ := net.SplitHostPort(target.Listener.Addr().String())
+	callback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "http://localhost:"+port+"/", http.StatusTemporaryRedirect)
+	}))
+	defer callback.Close()
+
+	config := WatchdogConfig{
+		faasProcess: "cat",
+	}
+	async := newAsyncInvoker(makeRequestHandler(&config), []string{"127.0.0.1"}, 0, 0)
+
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("hello"))
+	req.Header.Set(callbackURLHeader, callback.URL)
+	rr := httptest.NewRecorder()
+	async.ServeHTTP(rr, req)
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("want: %d, got: %d", http.StatusAccepted, rr.Code)
+	}
+
+	async.Wait(10 * time.Second)
+	if atomic.LoadInt32(&redirected) != 0 {
+		t.Errorf("want the redirect to a host which is not allowed to be refused")
+	}
+}
+
+func TestHandler_AsyncMaxJobs(t *testing.T) {
+	callback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer callback.Close()
+
+	config := WatchdogConfig{
+		faasProcess: "sleep 1",
+	}
+	async := newAsyncInvoker(makeRequestHandler(&config), []string{"127.0.0.1"}, 0, 1)
+
+	codes := []int{}
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest(http.MethodPost, "/", nil)
+		req.Header.Set(callbackURLHeader, callback.URL)
+		rr := httptest.NewRecorder()
+		async.ServeHTTP(rr, req)
+		codes = append(codes, rr.Code)
+	}
+	if codes[0] != http.StatusAccepted || codes[1] != http.StatusTooManyRequests {
+		t.Errorf("want: [%d %d], got: %v", http.StatusAccepted, http.StatusTooManyRequests, codes)
+	}
+
+	async.Wait(10 * time.Second)
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	req.Header.Set(callbackURLHeader, callback.URL)
+	rr := httptest.NewRecorder()
+	async.ServeHTTP(rr, req)
+	if rr.Code != http.StatusAccepted {
+		t.Errorf("once the job has completed - want: %d, got: %d", http.StatusAccepted, rr.Code)
+	}
+	async.Wait(10 * time.Second)
+}
+
+func TestConsumerGroup_Wait(t *testing.T) {
+	consumers := newConsumerGroup()
+	finished := make(chan struct{})
+	consumers.Go(func(cancel <-chan bool) {
+		<-cancel
+		time.Sleep(50 * time.Millisecond)
+		close(finished)
+	})
+
+	consumers.Stop()
+	if !consumers.Wait(context.Background()) {
+		t.Fatalf("want the worker to return once stopped")
+	}
+	select {
+	case <-finished:
+	default:
+		t.Errorf("want Wait to return after the worker has finished")
+	}
+
+	consumers = newConsumerGroup()
+	consumers.Go(func(cancel <-chan bool) {
+		time.Sleep(time.Second)
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if consumers.Wait(ctx) {
+		t.Errorf("want false when the worker outlasts the context")
+	}
+}
+
+func TestCronRunner_WaitsOnCancel(t *testing.T) {
+	schedule, err := parseCronSchedule("@every 1s")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	runner := newCronRunner(schedule, "@every 1s", "", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+	}))
+
+	cancel := make(chan bool)
+	returned := make(chan struct{})
+	go func() {
+		runner.Run(cancel)
+		close(returned)
+	}()
+
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the scheduled invocation")
+	}
+	close(cancel)
+
+	select {
+	case <-returned:
+		t.Fatal("want Run to wait for the invocation in progress")
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(release)
+	select {
+	case <-returned:
+	case <-time.After(5 * time.Second):
+		t.Fatal("want Run to return once the invocation has completed")
+	}
+}
+
+// natsTestMsg records how a JetStream message was acknowledged
+type natsTestMsg struct {
+	jetstream.Msg
+	data      string
+	headers   nats.Header
+	delivered uint64
+	acks      []string
+}
+
+func (m *natsTestMsg) Data() []byte         { return []byte(m.data) }
+func (m *natsTestMsg) Headers() nats.Header { return m.headers }
+func (m *natsTestMsg) Subject() string      { return "orders.new" }
+func (m *natsTestMsg) InProgress() error    { return nil }
+func (m *natsTestMsg) Ack() error           { m.acks = append(m.acks, "ack"); return nil }
+func (m *natsTestMsg) Nak() error           { m.acks = append(m.acks, "nak"); return nil }
+func (m *natsTestMsg) NakWithDelay(delay time.Duration) error {
+	m.acks = append(m.acks, "nak "+delay.String())
+	return nil
+}
+func (m *natsTestMsg) Metadata() (*jetstream.MsgMetadata, error) {
+	return &jetstream.MsgMetadata{NumDelivered: m.delivered}, nil
+}
+func (m *natsTestMsg) Term() error { m.acks = append(m.acks, "term"); return nil }
+
+func TestNATSWorker_AcksByStatus(t *testing.T) {
+	config := WatchdogConfig{
+		faasProcess:   `test "$Http_X_Order" = 7 && test "$Http_X_Nats_Subject" = orders.new`,
+		fprocessShell: true,
+		cgiHeaders:    true,
+		exitCodeMap:   map[int]int{1: http.StatusBadRequest},
+	}
+	worker := newNATSWorker("nats://127.0.0.1:4222", "orders", "fn", makeRequestHandler(&config))
+
+	cases := []struct {
+		headers nats.Header
+		want    string
+	}{
+		{headers: nats.Header{"x-order": []string{"7"}}, want: "ack"},
+		{headers: nats.Header{}, want: "term"},
+	}
+	for _, c := range cases {
+		msg := &natsTestMsg{data: "ok", headers: c.headers}
+		if err := worker.invoke(msg); err != nil {
+			t.Fatal(err)
+		}
+		if len(msg.acks) != 1 || msg.acks[0] != c.want {
+			t.Errorf("want: [%s], got: %v", c.want, msg.acks)
+		}
+	}
+
+	// A nak is delivered again after the Retry-After, or a backoff on the
+	// number of deliveries
+	naks := []struct {
+		status     int
+		retryAfter string
+		delivered  uint64
+		want       string
+	}{
+		{status: http.StatusTooManyRequests, retryAfter: "7", delivered: 1, want: "nak 7s"},
+		{status: http.StatusServiceUnavailable, delivered: 1, want: "nak 1s"},
+		{status: http.StatusServiceUnavailable, delivered: 3, want: "nak 4s"},
+		{status: http.StatusBadGateway, delivered: 20, want: "nak 30s"},
+	}
+	for _, c := range naks {
+		msg := &natsTestMsg{delivered: c.delivered}
+		natsAck(msg, c.status, c.retryAfter)
+		if len(msg.acks) != 1 || msg.acks[0] != c.want {
+			t.Errorf("status %d - want: [%s], got: %v", c.status, c.want, msg.acks)
+		}
+	}
+}
+
+func TestKafkaConsumer_Process(t *testing.T) {
+	var calls int32
+	failing := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		if r.Header.Get("X-Kafka-Offset") != "42" || r.Header.Get("X-Kafka-Key") != "k1" || r.Header.Get("Trace") != "abc" {
+			t.Errorf("unexpected headers: %v", r.Header)
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	record := &kgo.Record{
+		Topic:     "orders",
+		Partition: 3,
+		Offset:    42,
+		Key:       []byte("k1"),
+		Value:     []byte("one"),
+		Headers:   []kgo.RecordHeader{{Key: "Trace", Value: []byte("abc")}},
+	}
+
+	var sent []*kgo.Record
+	k := &kafkaConsumer{
+		handler:         failing,
+		maxRetries:      2,
+		deadLetterTopic: "orders-failed",
+		retryBackoff:    time.Millisecond,
+		produce: func(ctx context.Context, record *kgo.Record) error {
+			sent = append(sent, record)
+			return nil
+		},
+	}
+	if !k.process(context.Background(), record) {
+		t.Fatal("want the record to be done with")
+	}
+	if got := atomic.LoadInt32(&calls); got != 3 {
+		t.Errorf("want 3 invocations, got: %d", got)
+	}
+	if len(sent) != 1 {
+		t.Fatalf("want 1 dead letter, got: %d", len(sent))
+	}
+	headers := map[string]string{}
+	for _, header := range sent[0].Headers {
+		headers[header.Key] = string(header.Value)
+	}
+	if sent[0].Topic != "orders-failed" || string(sent[0].Value) != "one" || string(sent[0].Key) != "k1" {
+		t.Errorf("dead letter - got: %+v", sent[0])
+	}
+	if headers["Trace"] != "abc" || headers["X-Kafka-Topic"] != "orders" || headers["X-Kafka-Partition"] != "3" || headers["X-Kafka-Offset"] != "42" || headers[functionStatusHeader] != "500" {
+		t.Errorf("dead letter headers - got: %v", headers)
+	}
+
+	// kafka_skip_failed skips the record without a dead letter topic
+	atomic.StoreInt32(&calls, 0)
+	k.deadLetterTopic = ""
+	k.skipFailed = true
+	sent = nil
+	if !k.process(context.Background(), record) || len(sent) != 0 || atomic.LoadInt32(&calls) != 3 {
+		t.Errorf("want the record skipped after 3 invocations, got: %d invocations, %d dead letters", atomic.LoadInt32(&calls), len(sent))
+	}
+
+	// With neither, the record is retried past kafka_max_retries until it
+	// succeeds, letting a rebalance go ahead between retries
+	k.skipFailed = false
+	rebalances := 0
+	k.allowRebalance = func() { rebalances++ }
+	invocations := 0
+	k.handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if invocations++; invocations < 7 {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	})
+	if !k.process(context.Background(), record) || invocations != 7 || rebalances != 6 {
+		t.Errorf("want the record done with after 7 invocations and 6 rebalances allowed, got: %d, %d", invocations, rebalances)
+	}
+
+	// A record whose partition is taken away while it is retried is left
+	// to the partition's new owner
+	k.revoked = map[int32]uint64{}
+	k.handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		k.onRevoked(context.Background(), nil, map[string][]int32{"orders": {3}})
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	k.topic = "orders"
+	if k.process(context.Background(), record) {
+		t.Error("want the record not to be done with once its partition was revoked")
+	}
+
+	// A record is not done with when stopped between retries
+	stop, cancel := context.WithCancel(context.Background())
+	k.retryBackoff = time.Hour
+	k.handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cancel()
+		w.WriteHeader(http.StatusBadGateway)
+	})
+	if k.process(stop, record) {
+		t.Error("want the record not to be done with once stopped")
+	}
+
+	// A success is done with at once
+	k.handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	if !k.process(context.Background(), record) {
+		t.Error("want a success to be done with")
+	}
+}
+
+func TestHandler_BodyViaFile(t *testing.T) {
+	config := WatchdogConfig{
+		faasProcess:   `wc -c < "$Http_Body_File"; cat; echo "$Http_Body_File" > ` + filepath.Join(t.TempDir(), "path"),
+		fprocessShell: true,
+		bodyViaFile:   true,
+	}
+
+	rr := httptest.NewRecorder()
+	makeRequestHandler(&config).ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/", strings.NewReader("hello")))
+	if got := strings.TrimSpace(rr.Body.String()); got != "5" {
+		t.Errorf("want the body in the file and stdin empty, got: %q", got)
+	}
+
+	path := strings.Fields(config.faasProcess)[len(strings.Fields(config.faasProcess))-1]
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(strings.TrimSpace(string(data))); !os.IsNotExist(err) {
+		t.Errorf("want the body file removed after the invocation, got: %v", err)
+	}
+}
+
+func TestHandler_ResponseFromFile(t *testing.T) {
+	config := WatchdogConfig{
+		faasProcess:      `echo chatty; printf "<html><body>ok</body></html>" > "$Http_Response_File"`,
+		fprocessShell:    true,
+		responseFromFile: true,
+	}
+
+	rr := httptest.NewRecorder()
+	makeRequestHandler(&config).ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/", nil))
+	if got := rr.Body.String(); got != "<html><body>ok</body></html>" {
+		t.Errorf("want the file as the response, got: %q", got)
+	}
+	if got := rr.Header().Get("Content-Type"); got != "text/html; charset=utf-8" {
+		t.Errorf("want a sniffed Content-Type, got: %s", got)
+	}
+}
+
+func TestHandler_ResponseFromFile_Rejected(t *testing.T) {
+	secret := filepath.Join(t.TempDir(), "secret")
+	os.WriteFile(secret, []byte("secret"), 0600)
+
+	cases := []struct {
+		name     string
+		process  string
+		maxBytes int64
+	}{
+		{
+			name:    "symlink",
+			process: `rm "$Http_Response_File"; ln -s ` + secret + ` "$Http_Response_File"`,
+		},
+		{
+			name:     "too large",
+			process:  `printf "0123456789" > "$Http_Response_File"`,
+			maxBytes: 4,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			config := WatchdogConfig{
+				faasProcess:          c.process,
+				fprocessShell:        true,
+				responseFromFile:     true,
+				responseFileMaxBytes: c.maxBytes,
+			}
+
+			rr := httptest.NewRecorder()
+			makeRequestHandler(&config).ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/", nil))
+			if rr.Code != http.StatusInternalServerError {
+				t.Errorf("want: %d, got: %d", http.StatusInternalServerError, rr.Code)
+			}
+			if body := rr.Body.String(); strings.Contains(body, "secret") || strings.Contains(body, "0123") {
+				t.Errorf("want the file not to be sent, got: %q", body)
+			}
+		})
+	}
+}
+
+func TestHandler_ContentTypeSniff(t *testing.T) {
+	config := WatchdogConfig{
+		faasProcess:      `printf "%%PDF-1.7"`,
+		fprocessShell:    true,
+		contentTypeSniff: true,
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	makeRequestHandler(&config).ServeHTTP(rr, req)
+	if got := rr.Header().Get("Content-Type"); got != "application/pdf" {
+		t.Errorf("want: application/pdf, got: %s", got)
+	}
+
+	config.faasProcess = `printf "Content-Type: text/csv\r\n\r\n%%PDF-1.7"`
+	config.parseOutputHeaders = true
+	rr = httptest.NewRecorder()
+	makeRequestHandler(&config).ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/", nil))
+	if got := rr.Header().Get("Content-Type"); got != "text/csv" {
+		t.Errorf("want the type set by the function, got: %s", got)
+	}
+}
+
+func TestHandler_ContentNegotiation(t *testing.T) {
+	config := WatchdogConfig{
+		faasProcess:   `printf "$Http_Negotiated_Type"`,
+		fprocessShell: true,
+		contentType:   "application/json=application/json;text/csv=text/csv; charset=utf-8",
+	}
+	handler := makeRequestHandler(&config)
+
+	cases := []struct {
+		accept      string
+		status      int
+		contentType string
+		body        string
+	}{
+		{"", http.StatusOK, "application/json", "application/json"},
+		{"text/csv", http.StatusOK, "text/csv; charset=utf-8", "text/csv"},
+		{"application/json;q=0.5, text/*", http.StatusOK, "text/csv; charset=utf-8", "text/csv"},
+		{"*/*", http.StatusOK, "application/json", "application/json"},
+		{"text/html", http.StatusNotAcceptable, "", ""},
+	}
+
+	for _, c := range cases {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if len(c.accept) > 0 {
+			req.Header.Set("Accept", c.accept)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != c.status {
+			t.Errorf("Accept: %q want status: %d, got: %d", c.accept, c.status, rr.Code)
+			continue
+		}
+		if c.status != http.StatusOK {
+			continue
+		}
+		if got := rr.Header().Get("Content-Type"); got != c.contentType {
+			t.Errorf("Accept: %q want Content-Type: %q, got: %q", c.accept, c.contentType, got)
+		}
+		if got := rr.Body.String(); got != c.body {
+			t.Errorf("Accept: %q want body: %q, got: %q", c.accept, c.body, got)
+		}
+		if got := rr.Header().Get("Vary"); got != "Accept" {
+			t.Errorf("want Vary: Accept, got: %q", got)
+		}
+	}
+}
+
+func TestHandler_ETag(t *testing.T) {
+	config := WatchdogConfig{
+		faasProcess: "cat",
+		etag:        true,
+	}
+	handler := makeRequestHandler(&config)
+
+	req := httptest.NewRequest(http.MethodGet, "/", strings.NewReader("rendered"))
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	etag := rr.Header().Get("ETag")
+	if rr.Code != http.StatusOK || len(etag) == 0 {
+		t.Fatalf("want 200 with an ETag, got: %d %q", rr.Code, etag)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/", strings.NewReader("rendered"))
+	req.Header.Set("If-None-Match", `"other", W/`+etag)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotModified {
+		t.Errorf("want: %d, got: %d", http.StatusNotModified, rr.Code)
+	}
+	if rr.Body.Len() != 0 {
+		t.Errorf("want no body for a 304, got: %q", rr.Body.String())
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/", strings.NewReader("changed"))
+	req.Header.Set("If-None-Match", etag)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK || rr.Body.String() != "changed" {
+		t.Errorf("want 200 with the new body, got: %d %q", rr.Code, rr.Body.String())
+	}
+}
+
+func TestHandler_ResponseCache(t *testing.T) {
+	counter := filepath.Join(t.TempDir(), "runs")
+	config := WatchdogConfig{
+		faasProcess:   `echo run >> ` + counter + `; cat`,
+		fprocessShell: true,
+		cacheTTL:      time.Minute,
+		cacheMaxBytes: 1 << 20,
+	}
+	handler := makeRequestHandler(&config)
+
+	invoke := func(body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/report", strings.NewReader(body)))
+		return rr
+	}
+
+	first := invoke("q1")
+	second := invoke("q1")
+	if first.Header().Get("X-Cache") != "MISS" || second.Header().Get("X-Cache") != "HIT" {
+		t.Errorf("want MISS then HIT, got: %q %q", first.Header().Get("X-Cache"), second.Header().Get("X-Cache"))
+	}
+	if second.Body.String() != "q1" {
+		t.Errorf("want the cached body, got: %q", second.Body.String())
+	}
+	if first.Header().Get(callIDHeader) == second.Header().Get(callIDHeader) {
+		t.Errorf("want each call to keep its own %s", callIDHeader)
+	}
+
+	if other := invoke("q2"); other.Header().Get("X-Cache") != "MISS" || other.Body.String() != "q2" {
+		t.Errorf("want a different body to miss, got: %q %q", other.Header().Get("X-Cache"), other.Body.String())
+	}
+
+	runs, err := os.ReadFile(counter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Count(string(runs), "run"); got != 2 {
+		t.Errorf("want the function to run 2 times, got: %d", got)
+	}
+}
+
+func TestResponseCache_Callers(t *testing.T) {
+	runs := 0
+	cache := newResponseCache(time.Minute, 1<<20, 0)
+	handler := apiKeyHandler("X-Api-Key", []string{"key-a", "key-b"}, cache.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		runs++
+		w.Header().Set("Vary", "X-Tenant")
+		fmt.Fprintf(w, "%s %s %d", callerOf(r).subject, r.Header.Get("X-Tenant"), runs)
+	})))
+
+	invoke := func(header ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/report", nil)
+		for i := 0; i < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	a := invoke("X-Api-Key", "key-a")
+	if again := invoke("X-Api-Key", "key-a"); again.Header().Get("X-Cache") != "HIT" || again.Body.String() != a.Body.String() {
+		t.Errorf("want the same caller to hit, got: %q %q", again.Header().Get("X-Cache"), again.Body.String())
+	}
+	if b := invoke("X-Api-Key", "key-b"); b.Header().Get("X-Cache") != "MISS" || b.Body.String() == a.Body.String() {
+		t.Errorf("want another caller to miss, got: %q %q", b.Header().Get("X-Cache"), b.Body.String())
+	}
+
+	// The response varies on X-Tenant
+	if tenant := invoke("X-Api-Key", "key-a", "X-Tenant", "t1"); tenant.Header().Get("X-Cache") != "MISS" {
+		t.Errorf("want a different X-Tenant to miss, got: %q", tenant.Header().Get("X-Cache"))
+	}
+
+	// Cookies are not checked by the authentication, so are never cached
+	for i := 0; i < 2; i++ {
+		if rr := invoke("X-Api-Key", "key-a", "X-Tenant", "t1", "Cookie", "session=1"); len(rr.Header().Get("X-Cache")) > 0 {
+			t.Errorf("want a request with a cookie to skip the cache, got: %q", rr.Header().Get("X-Cache"))
+		}
+	}
+	if runs != 5 {
+		t.Errorf("want the function to run 5 times, got: %d", runs)
+	}
+}
+
+func TestHandler_IdempotencyKey(t *testing.T) {
+	counter := filepath.Join(t.TempDir(), "runs")
+	config := WatchdogConfig{
+		faasProcess:         `echo run >> ` + counter + `; sleep 0.2; cat`,
+		fprocessShell:       true,
+		idempotencyTTL:      time.Minute,
+		idempotencyMaxBytes: 1 << 20,
+	}
+	handler := makeRequestHandler(&config)
+
+	invoke := func(key string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/charge", strings.NewReader(body))
+		req.Header.Set("Idempotency-Key", key)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	results := make([]*httptest.ResponseRecorder, 3)
+	var wg sync.WaitGroup
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = invoke("k1", "100")
+		}(i)
+	}
+	wg.Wait()
+
+	replayed := 0
+	for _, rr := range results {
+		if rr.Code != http.StatusOK || rr.Body.String() != "100" {
+			t.Errorf("want 200 with the original body, got: %d %q", rr.Code, rr.Body.String())
+		}
+		if rr.Header().Get("Idempotent-Replayed") == "true" {
+			replayed++
+		}
+	}
+	if replayed != 2 {
+		t.Errorf("want 2 replayed responses, got: %d", replayed)
+	}
+
+	if rr := invoke("k1", "200"); rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("want: %d for a reused key, got: %d", http.StatusUnprocessableEntity, rr.Code)
+	}
+
+	runs, err := os.ReadFile(counter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Count(string(runs), "run"); got != 1 {
+		t.Errorf("want the function to run once, got: %d", got)
+	}
+}
+
+func TestIdempotencyStore_Limits(t *testing.T) {
+	runs := 0
+	store := newIdempotencyStore(time.Minute, 8, 16)
+	handler := store.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		runs++
+		io.Copy(w, r.Body)
+	}))
+
+	invoke := func(key string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		req.Header.Set(idempotencyKeyHeader, key)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	invoke("small", "1234")
+	if rr := invoke("small", "1234"); rr.Header().Get(idempotentReplayedHeader) != "true" {
+		t.Errorf("want a result within the limit to be replayed")
+	}
+
+	// A result which does not fit is not kept
+	invoke("large", "123456789")
+	if rr := invoke("large", "123456789"); rr.Header().Get(idempotentReplayedHeader) == "true" {
+		t.Errorf("want a result over the limit not to be replayed")
+	}
+	if runs != 3 {
+		t.Errorf("want 3 runs, got: %d", runs)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(strings.Repeat("a", 32)))
+	req.Header.Set(idempotencyKeyHeader, "too-large")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("want: %d, got: %d", http.StatusRequestEntityTooLarge, rr.Code)
+	}
+}
+
+func TestIdempotencyStore_Callers(t *testing.T) {
+	runs := 0
+	store := newIdempotencyStore(time.Minute, 1<<20, 0)
+	handler := apiKeyHandler("X-Api-Key", []string{"key-a", "key-b"}, store.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		runs++
+		fmt.Fprintf(w, "%s %d", callerOf(r).subject, runs)
+	})))
+
+	invoke := func(apiKey string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("order"))
+		req.Header.Set("X-Api-Key", apiKey)
+		req.Header.Set(idempotencyKeyHeader, "k1")
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	a := invoke("key-a")
+	if again := invoke("key-a"); again.Header().Get(idempotentReplayedHeader) != "true" || again.Body.String() != a.Body.String() {
+		t.Errorf("want the same caller to be replayed, got: %q", again.Body.String())
+	}
+	if b := invoke("key-b"); b.Header().Get(idempotentReplayedHeader) == "true" || b.Body.String() == a.Body.String() {
+		t.Errorf("want another caller with the same key to run the function, got: %q", b.Body.String())
+	}
+	if runs != 2 {
+		t.Errorf("want 2 runs, got: %d", runs)
+	}
+}
+
+func TestIdempotencyStore_Panic(t *testing.T) {
+	runs := 0
+	store := newIdempotencyStore(time.Minute, 1<<20, 0)
+	handler := store.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		runs++
+		if runs == 1 {
+			panic(http.ErrAbortHandler)
+		}
+		w.Write([]byte("done"))
+	}))
+
+	invoke := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/", nil)
+		req.Header.Set(idempotencyKeyHeader, "k1")
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	func() {
+		defer func() { recover() }()
+		invoke()
+	}()
+
+	done := make(chan *httptest.ResponseRecorder, 1)
+	go func() { done <- invoke() }()
+	select {
+	case rr := <-done:
+		if rr.Body.String() != "done" || runs != 2 {
+			t.Errorf("want the function to run again after a panic, got: %q after %d runs", rr.Body.String(), runs)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the key to be released")
+	}
+}
+
+func TestAuditLog_HashChain(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	config := WatchdogConfig{faasProcess: "cat"}
+
+	invoke := func(audit *auditLog, body string) {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		req.SetBasicAuth("alice", "secret")
+		audit.Handler(basicAuthHandler("alice", "secret", makeRequestHandler(&config))).ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	audit, err := openAuditLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	invoke(audit, "one")
+	invoke(audit, "two")
+	audit.out.(*os.File).Close()
+
+	// The chain continues after a restart
+	audit, err = openAuditLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	invoke(audit, "three")
+	audit.out.(*os.File).Close()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	count, err := verifyAuditLog(bytes.NewReader(data))
+	if err != nil || count != 3 {
+		t.Fatalf("want 3 intact records, got: %d %v", count, err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	record := auditRecord{}
+	if err := json.Unmarshal([]byte(lines[0]), &record); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256([]byte("one"))
+	if record.Subject != "alice" || record.Status != http.StatusOK || record.RequestSHA256 != hex.EncodeToString(sum[:]) {
+		t.Errorf("unexpected record: %+v", record)
+	}
+
+	tampered := strings.Replace(string(data), `"status":200`, `"status":500`, 1)
+	if _, err := verifyAuditLog(strings.NewReader(tampered)); err == nil {
+		t.Errorf("want an error for an edited record")
+	}
+	removed := lines[0] + "\n" + lines[2] + "\n"
+	if _, err := verifyAuditLog(strings.NewReader(removed)); err == nil {
+		t.Errorf("want an error for a removed record")
+	}
+	truncated := lines[1] + "\n" + lines[2] + "\n"
+	if _, err := verifyAuditLog(strings.NewReader(truncated)); err == nil {
+		t.Errorf("want an error for a log with its first record removed")
+	}
+}
+
+func TestAuditLog_UnverifiedIdentity(t *testing.T) {
+	var out bytes.Buffer
+	audit := &auditLog{out: &out, prevHash: auditGenesisHash}
+	config := WatchdogConfig{faasProcess: "cat"}
+
+	// Neither a forged token nor credentials which fail basic auth are
+	// recorded as the caller
+	claims := base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"admin","iss":"https://idp.example.com"}`))
+	forged := httptest.NewRequest(http.MethodPost, "/", nil)
+	forged.Header.Set("Authorization", "Bearer e30."+claims+".c2ln")
+	audit.Handler(makeRequestHandler(&config)).ServeHTTP(httptest.NewRecorder(), forged)
+
+	wrong := httptest.NewRequest(http.MethodPost, "/", nil)
+	wrong.SetBasicAuth("alice", "guess")
+	audit.Handler(basicAuthHandler("alice", "secret", makeRequestHandler(&config))).ServeHTTP(httptest.NewRecorder(), wrong)
+
+	for i, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		record := auditRecord{}
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatal(err)
+		}
+		if len(record.Subject) > 0 || len(record.Issuer) > 0 {
+			t.Errorf("record %d - want no caller, got: %q %q", i, record.Subject, record.Issuer)
+		}
+	}
+}
+
+func TestCapture_Replay(t *testing.T) {
+	dir := t.TempDir()
+	config := WatchdogConfig{
+		faasProcess:      `printf "$Http_Path $Http_X_Order $Http_Authorization "; cat`,
+		fprocessShell:    true,
+		cgiHeaders:       true,
+		logRedactHeaders: []string{"Authorization"},
+	}
+
+	handler := makeCaptureHandler(dir, config.logRedactHeaders, 0, makeRequestHandler(&config))
+	req := httptest.NewRequest(http.MethodPost, "/orders?id=1", strings.NewReader("payload"))
+	req.Header.Set("X-Order", "42")
+	req.Header.Set("Authorization", "Bearer secret")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Body.String() != "/orders 42 Bearer secret payload" {
+		t.Fatalf("want the request passed through, got: %q", rr.Body.String())
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.http"))
+	if err != nil || len(files) != 1 {
+		t.Fatalf("want one capture, got: %v %v", files, err)
+	}
+
+	out := &bytes.Buffer{}
+	if code := runReplay(&config, files, out); code != 0 {
+		t.Fatalf("want exit code 0, got: %d\n%s", code, out.String())
+	}
+	if !strings.Contains(out.String(), "/orders 42 [redacted] payload") {
+		t.Errorf("want the captured request replayed, got: %s", out.String())
+	}
+}
+
+func TestInvocationRing_Last(t *testing.T) {
+	config := WatchdogConfig{
+		faasProcess:   `echo "failed: $Http_Path" >&2; exit 3`,
+		fprocessShell: true,
+		cgiHeaders:    true,
+	}
+	ring := newInvocationRing(2)
+	handler := ring.Record(makeRequestHandler(&config))
+
+	for _, path := range []string{"/a", "/b", "/c"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, path, nil))
+	}
+
+	rr := httptest.NewRecorder()
+	ring.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/_/last", nil))
+
+	var last []invocationSummary
+	if err := json.Unmarshal(rr.Body.Bytes(), &last); err != nil {
+		t.Fatal(err)
+	}
+	if len(last) != 2 || last[0].Path != "/c" || last[1].Path != "/b" {
+		t.Fatalf("want /c then /b, got: %+v", last)
+	}
+	if last[0].Status != http.StatusInternalServerError || last[0].ExitCode == nil || *last[0].ExitCode != 3 {
+		t.Errorf("want status 500 with exit code 3, got: %+v", last[0])
+	}
+	if !strings.Contains(last[0].Stderr, "failed: /c") {
+		t.Errorf("want stderr, got: %q", last[0].Stderr)
+	}
+	if len(last[0].CallID) == 0 {
+		t.Errorf("want a call ID")
+	}
+}
+
+func TestDrainHandler(t *testing.T) {
+	defer atomic.StoreInt32(&draining, 0)
+	defer atomic.StoreInt32(&acceptingConnections, 0)
+
+	config := WatchdogConfig{faasProcess: "cat"}
+	handler := makeRequestHandler(&config)
+	drain := makeDrainHandler(true)
+	atomic.StoreInt32(&acceptingConnections, 1)
+
+	rr := httptest.NewRecorder()
+	drain.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/_/drain", nil))
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"draining":true`) {
+		t.Fatalf("want draining, got: %d %s", rr.Code, rr.Body.String())
+	}
+	if atomic.LoadInt32(&acceptingConnections) != 0 {
+		t.Errorf("want the health check to fail while draining")
+	}
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/", strings.NewReader("hi")))
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("want: %d while draining, got: %d", http.StatusServiceUnavailable, rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	drain.ServeHTTP(rr, httptest.NewRequest(http.MethodDelete, "/_/drain", nil))
+	if !strings.Contains(rr.Body.String(), `"draining":false`) || atomic.LoadInt32(&acceptingConnections) != 1 {
+		t.Errorf("want the replica back in rotation, got: %s", rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/", strings.NewReader("hi")))
+	if rr.Code != http.StatusOK || rr.Body.String() != "hi" {
+		t.Errorf("want: 200 after draining ends, got: %d %q", rr.Code, rr.Body.String())
+	}
+}
+
+func TestReadyHandler_BusyWhenInflightFull(t *testing.T) {
+	if !lockFilePresent() {
+		if _, err := createLockFile(); err != nil {
+			t.Fatal(err)
+		}
+		defer removeLockFile()
+	}
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	inflight := newInflightLimiter(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	}), 1, 0, 0)
+	ready := makeReadyHandler(inflight)
+
+	status := func() int {
+		rr := httptest.NewRecorder()
+		ready(rr, httptest.NewRequest(http.MethodGet, "/_/ready", nil))
+		return rr.Code
+	}
+
+	if got := status(); got != http.StatusOK {
+		t.Fatalf("want: %d when idle, got: %d", http.StatusOK, got)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		inflight.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/", nil))
+		close(done)
+	}()
+	<-started
+
+	if got := status(); got != http.StatusServiceUnavailable {
+		t.Errorf("want: %d while busy, got: %d", http.StatusServiceUnavailable, got)
+	}
+
+	close(release)
+	<-done
+	if got := status(); got != http.StatusOK {
+		t.Errorf("want: %d once a slot is free, got: %d", http.StatusOK, got)
+	}
+}
+
+func TestReadyGate_OpensOnFirstSuccess(t *testing.T) {
+	marker := filepath.Join(t.TempDir(), "up")
+	config := WatchdogConfig{
+		faasProcess:   `test -f ` + marker + ` && echo ok`,
+		fprocessShell: true,
+	}
+	gate := newReadyGate()
+	handler := gate.Handler(makeRequestHandler(&config))
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/", nil))
+	if gate.isReady() {
+		t.Fatalf("want the gate closed after a failure")
+	}
+
+	cancel := make(chan bool)
+	defer close(cancel)
+	go gate.RetryWarmup(makeRequestHandler(&config), "/", "", 10*time.Millisecond, cancel)
+
+	if err := os.WriteFile(marker, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-gate.Ready():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("want the gate open once the warm-up succeeds")
+	}
+}
+
+func TestHealthCommand_MarksUnhealthy(t *testing.T) {
+	defer atomic.StoreInt32(&acceptingConnections, 0)
+	atomic.StoreInt32(&acceptingConnections, 1)
+
+	marker := filepath.Join(t.TempDir(), "db-up")
+	config := WatchdogConfig{
+		healthcheckCommand: `test -f ` + marker,
+		fprocessShell:      true,
+		suppressLock:       true,
+	}
+	check := newHealthCommand(&config)
+	health := check.Handler(makeHealthHandler())
+
+	status := func() int {
+		rr := httptest.NewRecorder()
+		health(rr, httptest.NewRequest(http.MethodGet, "/_/health", nil))
+		return rr.Code
+	}
+
+	if got := status(); got != http.StatusServiceUnavailable {
+		t.Errorf("want: %d while the command fails, got: %d", http.StatusServiceUnavailable, got)
+	}
+	if atomic.LoadInt32(&acceptingConnections) != 0 {
+		t.Errorf("want the replica marked unhealthy")
+	}
+
+	if err := os.WriteFile(marker, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	check.update()
+	if atomic.LoadInt32(&acceptingConnections) != 1 {
+		t.Errorf("want the replica healthy again once the command passes")
+	}
+}
+
+func TestLockFilePath(t *testing.T) {
+	defer func() { lockFilePath = "" }()
+	defer atomic.StoreInt32(&acceptingConnections, 0)
+
+	lockFilePath = filepath.Join(t.TempDir(), "fwatchdog.lock")
+	path, err := createLockFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path != lockFilePath {
+		t.Errorf("want the lock file at: %s, got: %s", lockFilePath, path)
+	}
+	if _, err := os.Stat(lockFilePath); err != nil || !lockFilePresent() {
+		t.Errorf("want the lock file present, got: %v", err)
+	}
+
+	if err := markUnhealthy(); err != nil {
+		t.Fatal(err)
+	}
+	if lockFilePresent() {
+		t.Errorf("want the lock file removed")
+	}
 }
@@ -0,0 +1,25 @@
+// Copyright (c) OpenFaaS Author(s) 2026. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+//go:build !linux && !darwin && !freebsd
+
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// openResponseFile opens the response file, which must be a regular file.
+// exec_user is not supported on this platform, so the function cannot read
+// more than the watchdog.
+func openResponseFile(config *WatchdogConfig, path string) (*os.File, error) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.Mode().IsRegular() {
+		return nil, fmt.Errorf("%s is not a regular file", path)
+	}
+	return os.Open(path)
+}
@@ -0,0 +1,38 @@
+// Copyright (c) OpenFaaS Author(s) 2026. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+//go:build linux || darwin || freebsd
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// openResponseFile opens the response file without following a symlink,
+// and checks that it is a regular file owned by exec_user, so that the
+// function cannot have the watchdog read a file which it has no access to
+func openResponseFile(config *WatchdogConfig, path string) (*os.File, error) {
+	// O_NONBLOCK stops a FIFO in place of the file blocking the open
+	f, err := os.OpenFile(path, os.O_RDONLY|syscall.O_NOFOLLOW|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := f.Stat()
+	if err == nil && !info.Mode().IsRegular() {
+		err = fmt.Errorf("%s is not a regular file", path)
+	}
+	if cred := config.execCredential; err == nil && cred != nil {
+		if stat, ok := info.Sys().(*syscall.Stat_t); !ok || stat.Uid != cred.uid {
+			err = fmt.Errorf("%s is not owned by exec_user", path)
+		}
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
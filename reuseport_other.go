@@ -0,0 +1,17 @@
+// Copyright (c) OpenFaaS Author(s) 2026. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+//go:build !linux && !darwin && !freebsd
+
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"syscall"
+)
+
+// reusePortControl is not supported on this platform
+func reusePortControl(network, address string, c syscall.RawConn) error {
+	return fmt.Errorf("listen_reuseport is not supported on %s", runtime.GOOS)
+}
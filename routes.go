@@ -0,0 +1,122 @@
+// Copyright (c) OpenFaaS Author(s) 2026. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// route maps requests under a path prefix to a process
+type route struct {
+	prefix  string
+	process string
+}
+
+// parseRoutes reads entries of prefix=process separated by new-lines or
+// semi-colons. Blank lines, comments starting with # and entries without
+// a leading / are skipped.
+func parseRoutes(val string) []route {
+	var routes []route
+
+	entries := strings.FieldsFunc(val, func(r rune) bool {
+		return r == '\n' || r == ';'
+	})
+
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if len(entry) == 0 || strings.HasPrefix(entry, "#") {
+			continue
+		}
+
+		prefix, process, ok := strings.Cut(entry, "=")
+		prefix = strings.TrimSpace(prefix)
+		process = strings.TrimSpace(process)
+		if !ok || !strings.HasPrefix(prefix, "/") || len(process) == 0 {
+			continue
+		}
+
+		routes = append(routes, route{prefix: prefix, process: process})
+	}
+
+	return routes
+}
+
+// readRoutesFile loads routes from a file in the same format as the
+// routes environment variable, with one entry per line.
+func readRoutesFile(path string) ([]route, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseRoutes(string(data)), nil
+}
+
+// resolveProcess finds the process for a request path using the longest
+// matching route prefix, falling back to fprocess.
+func resolveProcess(config *WatchdogConfig, path string) string {
+	process := config.faasProcess
+	matched := 0
+
+	for _, rt := range config.routes {
+		prefix := strings.TrimSuffix(rt.prefix, "/")
+		if path == rt.prefix || path == prefix || strings.HasPrefix(path, prefix+"/") {
+			if len(prefix) >= matched {
+				matched = len(prefix)
+				process = rt.process
+			}
+		}
+	}
+
+	return process
+}
+
+// validateProcesses checks that fprocess and the process of each route can
+// be found in PATH and are executable, so that a typo fails at startup
+// rather than with a 500 for every request.
+func validateProcesses(config *WatchdogConfig) error {
+	if len(config.execCwd) > 0 {
+		info, err := os.Stat(config.execCwd)
+		if err != nil {
+			return fmt.Errorf("exec_cwd: %w", err)
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("exec_cwd: %s is not a directory", config.execCwd)
+		}
+	}
+
+	if config.fprocessShell {
+		if err := validateProcess(shellPath, ""); err != nil {
+			return fmt.Errorf("fprocess_shell: %w", err)
+		}
+		return nil
+	}
+
+	if len(config.faasProcess) > 0 {
+		if err := validateProcess(config.faasProcess, config.execCwd); err != nil {
+			return fmt.Errorf("fprocess: %w", err)
+		}
+	}
+
+	for _, rt := range config.routes {
+		if err := validateProcess(rt.process, config.execCwd); err != nil {
+			return fmt.Errorf("route %s: %w", rt.prefix, err)
+		}
+	}
+	return nil
+}
+
+// validateProcess resolves a relative path such as ./handler from dir, as
+// it will be when the process is started
+func validateProcess(process string, dir string) error {
+	name, _, _ := strings.Cut(process, " ")
+	if len(dir) > 0 && strings.ContainsRune(name, os.PathSeparator) && !filepath.IsAbs(name) {
+		name = filepath.Join(dir, name)
+	}
+	_, err := exec.LookPath(name)
+	return err
+}
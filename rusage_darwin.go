@@ -0,0 +1,19 @@
+// Copyright (c) OpenFaaS Author(s) 2026. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// maxRSSBytes gives the peak resident set size of an exited process, which
+// is reported in bytes on darwin.
+func maxRSSBytes(state *os.ProcessState) (int64, bool) {
+	ru, ok := state.SysUsage().(*syscall.Rusage)
+	if !ok || ru == nil {
+		return 0, false
+	}
+	return int64(ru.Maxrss), true
+}
@@ -0,0 +1,13 @@
+// Copyright (c) OpenFaaS Author(s) 2026. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+//go:build !linux && !darwin
+
+package main
+
+import "os"
+
+// maxRSSBytes is not available on this platform
+func maxRSSBytes(state *os.ProcessState) (int64, bool) {
+	return 0, false
+}
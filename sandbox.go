@@ -0,0 +1,82 @@
+// Copyright (c) OpenFaaS Author(s) 2026. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// sandboxCommand is the hidden subcommand which restricts itself and then
+// execs the function. Go cannot run code between fork and exec, so the
+// watchdog starts a copy of itself to apply settings such as
+// exec_no_new_privs before the function's code runs.
+const sandboxCommand = "exec-sandbox"
+
+// sandboxed is true when a setting needs sandboxCommand
+func sandboxed(config *WatchdogConfig) bool {
+	return config.execNoNewPrivs ||
+		len(config.execSeccompProfile) > 0 ||
+		config.execNice != 0 ||
+		config.execOOMScoreAdj != 0 ||
+		config.execRlimitNofile > 0 ||
+		config.execRlimitNproc > 0 ||
+		config.execRlimitFsize > 0
+}
+
+// applySandbox runs cmd through sandboxCommand when a restriction is set.
+// A command which was not found is left as it is, so that starting it
+// reports the error. sandboxCommand starts as the watchdog's user and
+// switches to exec_user itself, after the settings which need privileges.
+func applySandbox(config *WatchdogConfig, cmd *exec.Cmd) error {
+	if !sandboxed(config) {
+		return nil
+	}
+	if cmd.Err != nil {
+		return nil
+	}
+
+	self, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	args := []string{self, sandboxCommand}
+	if config.execNoNewPrivs {
+		args = append(args, "-no-new-privs")
+	}
+	if len(config.execSeccompProfile) > 0 {
+		args = append(args, "-seccomp-profile", config.execSeccompProfile)
+	}
+	if config.execNice != 0 {
+		args = append(args, "-nice", strconv.Itoa(config.execNice))
+	}
+	if config.execOOMScoreAdj != 0 {
+		args = append(args, "-oom-score-adj", strconv.Itoa(config.execOOMScoreAdj))
+	}
+	if cred := config.execCredential; cred != nil {
+		groups := make([]string, 0, len(cred.groups))
+		for _, group := range cred.groups {
+			groups = append(groups, strconv.FormatUint(uint64(group), 10))
+		}
+		args = append(args, "-uid", strconv.FormatUint(uint64(cred.uid), 10), "-gid", strconv.FormatUint(uint64(cred.gid), 10),
+			"-groups", strings.Join(groups, ","))
+	}
+	if config.execRlimitNofile > 0 {
+		args = append(args, "-rlimit-nofile", strconv.FormatUint(config.execRlimitNofile, 10))
+	}
+	if config.execRlimitNproc > 0 {
+		args = append(args, "-rlimit-nproc", strconv.FormatUint(config.execRlimitNproc, 10))
+	}
+	if config.execRlimitFsize > 0 {
+		args = append(args, "-rlimit-fsize", strconv.FormatUint(config.execRlimitFsize, 10))
+	}
+	args = append(args, "--", cmd.Path)
+
+	cmd.Path = self
+	cmd.Args = append(args, cmd.Args...)
+	return nil
+}
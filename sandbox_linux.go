@@ -0,0 +1,153 @@
+// Copyright (c) OpenFaaS Author(s) 2026. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// validateSandbox checks the ranges and compiles the seccomp profile, so
+// that a mistake is found at startup rather than on the first request
+func validateSandbox(config *WatchdogConfig) error {
+	if config.execNice < -20 || config.execNice > 19 {
+		return fmt.Errorf("exec_nice must be between -20 and 19")
+	}
+	if config.execOOMScoreAdj < -1000 || config.execOOMScoreAdj > 1000 {
+		return fmt.Errorf("exec_oom_score_adj must be between -1000 and 1000")
+	}
+
+	if len(config.execSeccompProfile) == 0 {
+		return nil
+	}
+
+	profile, err := loadSeccompProfile(config.execSeccompProfile)
+	if err != nil {
+		return fmt.Errorf("exec_seccomp_profile: %w", err)
+	}
+	if _, err := profile.compile(); err != nil {
+		return fmt.Errorf("exec_seccomp_profile: %w", err)
+	}
+	return nil
+}
+
+// runSandbox applies the restrictions to its own thread, which then execs
+// the function with args of: path argv0 argv1...
+func runSandbox(args []string, stderr io.Writer) int {
+	flags := flag.NewFlagSet(sandboxCommand, flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	noNewPrivs := flags.Bool("no-new-privs", false, "set PR_SET_NO_NEW_PRIVS")
+	profilePath := flags.String("seccomp-profile", "", "a seccomp profile in JSON")
+	nice := flags.Int("nice", 0, "the niceness to run with")
+	oomScoreAdj := flags.Int("oom-score-adj", 0, "the value for /proc/self/oom_score_adj")
+	uid := flags.Int("uid", -1, "the user to switch to")
+	gid := flags.Int("gid", -1, "the group to switch to")
+	groups := flags.String("groups", "", "the supplementary groups, separated by commas")
+	rlimits := map[int]*uint64{
+		unix.RLIMIT_NOFILE: flags.Uint64("rlimit-nofile", 0, "the maximum number of open files"),
+		unix.RLIMIT_NPROC:  flags.Uint64("rlimit-nproc", 0, "the maximum number of processes for the user"),
+		unix.RLIMIT_FSIZE:  flags.Uint64("rlimit-fsize", 0, "the maximum size of a file in bytes"),
+	}
+	if err := flags.Parse(args); err != nil || flags.NArg() < 2 {
+		fmt.Fprintf(stderr, "usage: %s [-no-new-privs] [-seccomp-profile path] [-nice n] [-oom-score-adj n] [-uid n -gid n [-groups list]] [-rlimit-nofile n] [-rlimit-nproc n] [-rlimit-fsize n] -- path argv0 [args]\n", sandboxCommand)
+		return 126
+	}
+
+	// The restrictions belong to this thread, which must be the one to exec
+	runtime.LockOSThread()
+
+	// These are set before the seccomp profile, which could deny them
+	for resource, limit := range rlimits {
+		if *limit == 0 {
+			continue
+		}
+		// unix.Setrlimit stops syscall.Exec restoring Go's default for
+		// RLIMIT_NOFILE
+		if err := unix.Setrlimit(resource, &unix.Rlimit{Cur: *limit, Max: *limit}); err != nil {
+			fmt.Fprintf(stderr, "Unable to set rlimit %d: %s\n", resource, err)
+			return 126
+		}
+	}
+	if *nice != 0 {
+		if err := unix.Setpriority(unix.PRIO_PROCESS, 0, *nice); err != nil {
+			fmt.Fprintf(stderr, "Unable to set exec_nice: %s\n", err)
+			return 126
+		}
+	}
+	if *oomScoreAdj != 0 {
+		if err := os.WriteFile("/proc/self/oom_score_adj", []byte(strconv.Itoa(*oomScoreAdj)), 0644); err != nil {
+			fmt.Fprintf(stderr, "Unable to set exec_oom_score_adj: %s\n", err)
+			return 126
+		}
+	}
+
+	// The user is switched after the settings which need root, such as a
+	// negative exec_nice, and before the seccomp profile
+	if *uid >= 0 {
+		if err := switchUser(*uid, *gid, *groups); err != nil {
+			fmt.Fprintf(stderr, "Unable to switch to exec_user: %s\n", err)
+			return 126
+		}
+	}
+
+	if *noNewPrivs || len(*profilePath) > 0 {
+		if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+			fmt.Fprintf(stderr, "Unable to set no_new_privs: %s\n", err)
+			return 126
+		}
+	}
+
+	if len(*profilePath) > 0 {
+		profile, err := loadSeccompProfile(*profilePath)
+		if err != nil {
+			fmt.Fprintf(stderr, "Unable to read exec_seccomp_profile: %s\n", err)
+			return 126
+		}
+		filter, err := profile.compile()
+		if err == nil {
+			err = installSeccompFilter(filter)
+		}
+		if err != nil {
+			fmt.Fprintf(stderr, "Unable to apply exec_seccomp_profile: %s\n", err)
+			return 126
+		}
+	}
+
+	path := flags.Arg(0)
+	err := syscall.Exec(path, flags.Args()[1:], os.Environ())
+	fmt.Fprintf(stderr, "Unable to exec %s: %s\n", path, err)
+	return 127
+}
+
+// switchUser sets the groups before the user, which gives up the right to
+// change them
+func switchUser(uid int, gid int, groups string) error {
+	var gids []int
+	for _, group := range strings.Split(groups, ",") {
+		if len(group) == 0 {
+			continue
+		}
+		id, err := strconv.Atoi(group)
+		if err != nil {
+			return err
+		}
+		gids = append(gids, id)
+	}
+
+	if err := syscall.Setgroups(gids); err != nil {
+		return err
+	}
+	if err := syscall.Setgid(gid); err != nil {
+		return err
+	}
+	return syscall.Setuid(uid)
+}
@@ -0,0 +1,38 @@
+// Copyright (c) OpenFaaS Author(s) 2026. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+//go:build !linux
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+)
+
+// validateSandbox reports restrictions which are not supported on this
+// platform
+func validateSandbox(config *WatchdogConfig) error {
+	if config.execNoNewPrivs {
+		return fmt.Errorf("exec_no_new_privs is not supported on %s", runtime.GOOS)
+	}
+	if len(config.execSeccompProfile) > 0 {
+		return fmt.Errorf("exec_seccomp_profile is not supported on %s", runtime.GOOS)
+	}
+	if config.execNice != 0 {
+		return fmt.Errorf("exec_nice is not supported on %s", runtime.GOOS)
+	}
+	if config.execOOMScoreAdj != 0 {
+		return fmt.Errorf("exec_oom_score_adj is not supported on %s", runtime.GOOS)
+	}
+	if config.execRlimitNofile > 0 || config.execRlimitNproc > 0 || config.execRlimitFsize > 0 {
+		return fmt.Errorf("exec_rlimit_nofile, exec_rlimit_nproc and exec_rlimit_fsize are not supported on %s", runtime.GOOS)
+	}
+	return nil
+}
+
+func runSandbox(args []string, stderr io.Writer) int {
+	fmt.Fprintf(stderr, "%s is not supported on %s\n", sandboxCommand, runtime.GOOS)
+	return 126
+}
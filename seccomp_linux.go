@@ -0,0 +1,145 @@
+// Copyright (c) OpenFaaS Author(s) 2026. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"runtime"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// seccompProfile is the subset of the Docker and OCI seccomp profile format
+// which can be compiled without libseccomp: a default action and rules which
+// match on the syscall name alone.
+type seccompProfile struct {
+	DefaultAction   string        `json:"defaultAction"`
+	DefaultErrnoRet *uint32       `json:"defaultErrnoRet"`
+	Syscalls        []seccompRule `json:"syscalls"`
+}
+
+type seccompRule struct {
+	Names    []string          `json:"names"`
+	Name     string            `json:"name"`
+	Action   string            `json:"action"`
+	ErrnoRet *uint32           `json:"errnoRet"`
+	Args     []json.RawMessage `json:"args"`
+	Includes json.RawMessage   `json:"includes"`
+	Excludes json.RawMessage   `json:"excludes"`
+}
+
+// x32SyscallBit marks syscalls of the x32 ABI, which share the x86_64 arch
+// value and would otherwise get around a filter
+const x32SyscallBit = 0x40000000
+
+func loadSeccompProfile(path string) (*seccompProfile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	profile := &seccompProfile{}
+	if err := json.Unmarshal(data, profile); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return profile, nil
+}
+
+// compile gives a BPF program which checks the architecture, then returns
+// the action of the first rule naming the syscall, or the default action.
+// Names which do not exist on this architecture are skipped, as libseccomp
+// does.
+func (p *seccompProfile) compile() ([]unix.SockFilter, error) {
+	if seccompArch == 0 {
+		return nil, fmt.Errorf("seccomp profiles are not supported on %s", runtime.GOARCH)
+	}
+
+	defaultAction, err := seccompAction(p.DefaultAction, p.DefaultErrnoRet)
+	if err != nil {
+		return nil, fmt.Errorf("defaultAction: %w", err)
+	}
+
+	filter := []unix.SockFilter{
+		{Code: unix.BPF_LD | unix.BPF_W | unix.BPF_ABS, K: 4},
+		{Code: unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K, Jt: 1, K: seccompArch},
+		{Code: unix.BPF_RET | unix.BPF_K, K: unix.SECCOMP_RET_KILL_PROCESS},
+		{Code: unix.BPF_LD | unix.BPF_W | unix.BPF_ABS, K: 0},
+	}
+	if seccompArch == unix.AUDIT_ARCH_X86_64 {
+		filter = append(filter,
+			unix.SockFilter{Code: unix.BPF_JMP | unix.BPF_JGE | unix.BPF_K, Jf: 1, K: x32SyscallBit},
+			unix.SockFilter{Code: unix.BPF_RET | unix.BPF_K, K: unix.SECCOMP_RET_KILL_PROCESS},
+		)
+	}
+
+	for i, rule := range p.Syscalls {
+		if len(rule.Args) > 0 || len(rule.Includes) > 0 || len(rule.Excludes) > 0 {
+			return nil, fmt.Errorf("syscalls[%d]: conditions on args, includes and excludes are not supported", i)
+		}
+
+		action, err := seccompAction(rule.Action, rule.ErrnoRet)
+		if err != nil {
+			return nil, fmt.Errorf("syscalls[%d]: %w", i, err)
+		}
+
+		names := rule.Names
+		if len(rule.Name) > 0 {
+			names = append(names, rule.Name)
+		}
+		for _, name := range names {
+			nr, ok := seccompSyscalls[name]
+			if !ok {
+				continue
+			}
+			filter = append(filter,
+				unix.SockFilter{Code: unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K, Jf: 1, K: nr},
+				unix.SockFilter{Code: unix.BPF_RET | unix.BPF_K, K: action},
+			)
+		}
+	}
+
+	filter = append(filter, unix.SockFilter{Code: unix.BPF_RET | unix.BPF_K, K: defaultAction})
+	if len(filter) > unix.BPF_MAXINSNS {
+		return nil, fmt.Errorf("the profile has too many rules")
+	}
+	return filter, nil
+}
+
+// seccompAction maps the SCMP_ACT_ names of a profile to return values,
+// where errors default to EPERM
+func seccompAction(name string, errnoRet *uint32) (uint32, error) {
+	switch name {
+	case "SCMP_ACT_ALLOW":
+		return unix.SECCOMP_RET_ALLOW, nil
+	case "SCMP_ACT_ERRNO":
+		errno := uint32(unix.EPERM)
+		if errnoRet != nil {
+			errno = *errnoRet
+		}
+		return unix.SECCOMP_RET_ERRNO | (errno & unix.SECCOMP_RET_DATA), nil
+	case "SCMP_ACT_KILL", "SCMP_ACT_KILL_THREAD":
+		return unix.SECCOMP_RET_KILL_THREAD, nil
+	case "SCMP_ACT_KILL_PROCESS":
+		return unix.SECCOMP_RET_KILL_PROCESS, nil
+	case "SCMP_ACT_TRAP":
+		return unix.SECCOMP_RET_TRAP, nil
+	case "SCMP_ACT_LOG":
+		return unix.SECCOMP_RET_LOG, nil
+	}
+	return 0, fmt.Errorf("unsupported action: %q", name)
+}
+
+// installSeccompFilter applies to the calling thread only, so it must be
+// locked to the thread which then calls exec. no_new_privs must already be
+// set unless the process has CAP_SYS_ADMIN.
+func installSeccompFilter(filter []unix.SockFilter) error {
+	prog := unix.SockFprog{
+		Len:    uint16(len(filter)),
+		Filter: &filter[0],
+	}
+	return unix.Prctl(unix.PR_SET_SECCOMP, unix.SECCOMP_MODE_FILTER, uintptr(unsafe.Pointer(&prog)), 0, 0)
+}
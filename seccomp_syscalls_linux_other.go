@@ -0,0 +1,11 @@
+// Copyright (c) OpenFaaS Author(s) 2026. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+//go:build linux && !amd64 && !arm64
+
+package main
+
+// seccompArch is zero where profiles are not supported
+const seccompArch = 0
+
+var seccompSyscalls map[string]uint32
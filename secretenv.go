@@ -0,0 +1,64 @@
+// Copyright (c) OpenFaaS Author(s) 2026. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// readSecretEnvs exposes the secrets in dir as SECRET_<name> variables, so
+// that scripts do not need to read the files themselves. The secrets are read
+// for each request so that rotated values are picked up. When allow is empty
+// every secret is exposed, otherwise only the names listed, and a missing
+// name is an error.
+func readSecretEnvs(dir string, allow []string) ([]string, error) {
+	names := allow
+	if len(names) == 0 {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil, nil
+			}
+			return nil, err
+		}
+
+		for _, entry := range entries {
+			// Kubernetes mounts secrets through hidden ..data links
+			if strings.HasPrefix(entry.Name(), ".") {
+				continue
+			}
+			if info, err := os.Stat(filepath.Join(dir, entry.Name())); err != nil || !info.Mode().IsRegular() {
+				continue
+			}
+			names = append(names, entry.Name())
+		}
+	}
+
+	envs := make([]string, 0, len(names))
+	for _, name := range names {
+		value, err := readSecretValue("", filepath.Join(dir, filepath.Base(name)))
+		if err != nil {
+			return nil, fmt.Errorf("unable to read secret %s: %w", name, err)
+		}
+		envs = append(envs, fmt.Sprintf("%s=%s", secretEnvName(name), value))
+	}
+	return envs, nil
+}
+
+// secretEnvName upper-cases the name and replaces characters which are not
+// valid in a variable name, i.e. db-password becomes SECRET_DB_PASSWORD
+func secretEnvName(name string) string {
+	return "SECRET_" + strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		}
+		return '_'
+	}, name)
+}
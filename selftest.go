@@ -0,0 +1,94 @@
+// Copyright (c) OpenFaaS Author(s) 2026. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// execResultKey stores a pointer on the request context which pipeRequest
+// fills in with the result of running the process
+type execResultKey struct{}
+
+func withExecResult(r *http.Request, res *execResult) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), execResultKey{}, res))
+}
+
+func execResultFrom(r *http.Request) *execResult {
+	res, _ := r.Context().Value(execResultKey{}).(*execResult)
+	return res
+}
+
+// runSelfTest runs the function once with the same configuration as the
+// server, without listening, and prints the response. It returns the exit
+// code for the watchdog, 0 when the function responded with a 2xx.
+func runSelfTest(config *WatchdogConfig, args []string, out io.Writer) int {
+	flags := flag.NewFlagSet("self-test", flag.ContinueOnError)
+	flags.SetOutput(out)
+	method := flags.String("method", http.MethodPost, "The HTTP method of the request")
+	path := flags.String("path", "/", "The path of the request")
+	body := flags.String("body", "", "The body of the request")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	req, err := http.NewRequest(strings.ToUpper(*method), "http://127.0.0.1"+*path, strings.NewReader(*body))
+	if err != nil {
+		fmt.Fprintf(out, "Invalid request: %s\n", err.Error())
+		return 2
+	}
+	req.RemoteAddr = "127.0.0.1:0"
+
+	return runOnce(config, req, out)
+}
+
+// runOnce runs the function for req and prints the response, giving 0
+// when the function responded with a 2xx
+func runOnce(config *WatchdogConfig, req *http.Request, out io.Writer) int {
+	res := &execResult{}
+	req = withExecResult(req, res)
+
+	w := &responseBuffer{header: http.Header{}}
+	start := time.Now()
+	makeRequestHandler(config).ServeHTTP(w, req)
+	duration := time.Since(start)
+
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+
+	fmt.Fprintf(out, "Status: %d\n", w.status)
+	if !res.exited.IsZero() {
+		fmt.Fprintf(out, "Exit code: %d\n", res.exitCode)
+	} else {
+		fmt.Fprintf(out, "Exit code: process did not run\n")
+	}
+	fmt.Fprintf(out, "Duration: %s\n", duration.Round(time.Millisecond))
+
+	keys := make([]string, 0, len(w.header))
+	for k := range w.header {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(out, "%s: %s\n", k, strings.Join(w.header[k], ", "))
+	}
+
+	if len(res.stderr) > 0 {
+		fmt.Fprintf(out, "\nStderr:\n%s\n", strings.TrimRight(string(res.stderr), "\n"))
+	}
+	fmt.Fprintf(out, "\nOutput:\n%s\n", strings.TrimRight(w.body.String(), "\n"))
+
+	if w.status < 200 || w.status > 299 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,108 @@
+// Copyright (c) OpenFaaS Author(s) 2026. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// sseWriter sends each line of the function's output as a Server-Sent
+// Events data event as soon as it is written. The response starts with the
+// first event, so that a process which fails without output still gets an
+// error status.
+type sseWriter struct {
+	w http.ResponseWriter
+
+	lock    sync.Mutex
+	pending []byte
+	started bool
+	failed  bool
+	events  int
+}
+
+func newSSEWriter(w http.ResponseWriter) *sseWriter {
+	return &sseWriter{w: w}
+}
+
+func (s *sseWriter) Write(p []byte) (int, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.pending = append(s.pending, p...)
+	for {
+		i := bytes.IndexByte(s.pending, '\n')
+		if i < 0 {
+			break
+		}
+		s.writeEvent("", string(bytes.TrimSuffix(s.pending[:i], []byte("\r"))))
+		s.pending = s.pending[i+1:]
+	}
+	http.NewResponseController(s.w).Flush()
+	return len(p), nil
+}
+
+// Started reports whether the response has been sent
+func (s *sseWriter) Started() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.started
+}
+
+// fail sends an error event, which is given once per response
+func (s *sseWriter) fail(message string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.failed {
+		return
+	}
+	s.failed = true
+	s.writeEvent("error", message)
+	http.NewResponseController(s.w).Flush()
+}
+
+// finish sends a last line without a newline, and an error event when err
+// is set. It reports whether the response was started by the function's
+// output, otherwise nothing is written.
+func (s *sseWriter) finish(err error) bool {
+	s.lock.Lock()
+	if len(s.pending) > 0 {
+		s.writeEvent("", string(s.pending))
+		s.pending = nil
+	}
+	started := s.started
+	s.lock.Unlock()
+
+	if started && err != nil {
+		s.fail(err.Error())
+	}
+	return started
+}
+
+// writeEvent must be called with the lock held, data with line breaks is
+// sent as several data fields
+func (s *sseWriter) writeEvent(event string, data string) {
+	if !s.started {
+		s.started = true
+		header := s.w.Header()
+		header.Set("Content-Type", "text/event-stream")
+		header.Set("Cache-Control", "no-cache")
+		header.Set("X-Accel-Buffering", "no")
+		header.Del("Content-Length")
+		s.w.WriteHeader(http.StatusOK)
+	}
+
+	if len(event) > 0 {
+		fmt.Fprintf(s.w, "event: %s\n", event)
+	}
+	for _, line := range strings.Split(strings.ReplaceAll(data, "\r", "\n"), "\n") {
+		fmt.Fprintf(s.w, "data: %s\n", line)
+	}
+	s.w.Write([]byte("\n"))
+	s.events++
+}
@@ -0,0 +1,175 @@
+// Copyright (c) OpenFaaS Author(s) 2026. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/openfaas/classic-watchdog/logging"
+)
+
+// stderrPrefix gives the prefix for lines the function writes to stderr,
+// i.e. "[figlet 0c1f0b3e-...]", so that lines from concurrent invocations
+// can be told apart. It is empty when stderr_prefix is disabled.
+func stderrPrefix(config *WatchdogConfig, r *http.Request) string {
+	if !config.stderrPrefix {
+		return ""
+	}
+
+	callID := r.Header.Get(callIDHeader)
+	if config.stderrPrefixName && len(config.functionName) > 0 {
+		return "[" + config.functionName + " " + callID + "] "
+	}
+	return "[" + callID + "] "
+}
+
+// stderrResponseHeader carries the stderr of the function to the caller
+// when stderr_response is set
+const stderrResponseHeader = "X-Function-Stderr"
+
+// setStderrResponseHeader returns stderr to the caller in a header, or in a
+// trailer which is sent after the body. Newlines are escaped as \n and the
+// value is truncated to stderr_response_max_bytes.
+func setStderrResponseHeader(config *WatchdogConfig, w http.ResponseWriter, stderr []byte) {
+	if len(stderr) == 0 {
+		return
+	}
+
+	value := strings.TrimRight(string(stderr), "\r\n")
+	if max := config.stderrResponseMaxBytes; max > 0 && len(value) > max {
+		value = value[:max] + "..."
+	}
+	value = strings.NewReplacer("\r", "", "\n", `\n`).Replace(value)
+
+	name := stderrResponseHeader
+	if config.stderrResponse == "trailer" {
+		name = http.TrailerPrefix + stderrResponseHeader
+	}
+	w.Header().Set(name, value)
+}
+
+// logStderr writes the stderr of a function to the logs, with prefix added
+// to each line.
+func logStderr(prefix string, stderr []byte) {
+	if len(prefix) == 0 {
+		logging.Infof("stderr: %s", stderr)
+		return
+	}
+
+	for _, line := range bytes.Split(bytes.TrimRight(stderr, "\n"), []byte("\n")) {
+		logging.Infof("%sstderr: %s", prefix, line)
+	}
+}
+
+// stderrLineWriter writes each complete line of stderr to the logs as soon
+// as it is received, so that long-running functions produce live logs.
+// When max is set, no more than max bytes are logged in total.
+type stderrLineWriter struct {
+	prefix  string
+	pending []byte
+
+	max     int
+	written int
+	dropped int
+}
+
+func (s *stderrLineWriter) Write(p []byte) (int, error) {
+	if s.max > 0 && s.written+len(s.pending) >= s.max {
+		s.dropped += len(p)
+		return len(p), nil
+	}
+
+	s.pending = append(s.pending, p...)
+
+	for {
+		i := bytes.IndexByte(s.pending, '\n')
+		if i < 0 {
+			break
+		}
+		s.writeLine(s.pending[:i])
+		s.pending = s.pending[i+1:]
+	}
+
+	// A long line without a newline is logged once it reaches the cap
+	if s.max > 0 && s.written+len(s.pending) >= s.max {
+		s.writeLine(s.pending)
+		s.pending = nil
+	}
+
+	return len(p), nil
+}
+
+func (s *stderrLineWriter) writeLine(line []byte) {
+	if s.max > 0 {
+		remaining := s.max - s.written
+		if remaining <= 0 {
+			s.dropped += len(line)
+			return
+		}
+		if len(line) > remaining {
+			s.dropped += len(line) - remaining
+			line = line[:remaining]
+		}
+	}
+	s.written += len(line)
+	logging.Infof("%sstderr: %s", s.prefix, line)
+}
+
+// Flush writes any final line which was not terminated by a newline, and
+// a marker when output was dropped.
+func (s *stderrLineWriter) Flush() {
+	if len(s.pending) > 0 {
+		s.writeLine(s.pending)
+		s.pending = nil
+	}
+	if s.dropped > 0 {
+		logging.Warnf("%sstderr: %s", s.prefix, truncatedMarker(s.dropped))
+	}
+}
+
+// cappedBuffer collects up to max bytes and counts the rest, so that a
+// function which writes a large amount of stderr cannot exhaust memory.
+type cappedBuffer struct {
+	buf     bytes.Buffer
+	max     int
+	dropped int
+}
+
+func (c *cappedBuffer) Write(p []byte) (int, error) {
+	if remaining := c.max - c.buf.Len(); len(p) > remaining {
+		c.dropped += len(p) - remaining
+		c.buf.Write(p[:remaining])
+	} else {
+		c.buf.Write(p)
+	}
+	return len(p), nil
+}
+
+// Bytes gives the collected output, followed by a marker when output
+// was dropped.
+func (c *cappedBuffer) Bytes() []byte {
+	if c.dropped == 0 {
+		return c.buf.Bytes()
+	}
+	return append(c.buf.Bytes(), []byte("\n"+truncatedMarker(c.dropped))...)
+}
+
+// truncateLog caps output written to the logs to max bytes, adding a
+// marker when it was truncated. There is no cap when max is 0.
+func truncateLog(out []byte, max int) []byte {
+	if max <= 0 || len(out) <= max {
+		return out
+	}
+
+	truncated := make([]byte, 0, max+64)
+	truncated = append(truncated, out[:max]...)
+	return append(truncated, []byte("\n"+truncatedMarker(len(out)-max)+"\n")...)
+}
+
+func truncatedMarker(dropped int) string {
+	return fmt.Sprintf("[truncated %d bytes]", dropped)
+}
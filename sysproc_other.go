@@ -0,0 +1,26 @@
+// Copyright (c) OpenFaaS Author(s) 2026. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+//go:build !linux && !darwin && !freebsd
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+)
+
+// applyProcessAttrs is not supported on this platform
+func applyProcessAttrs(config *WatchdogConfig, cmd *exec.Cmd) error {
+	if config.execCredential != nil {
+		return fmt.Errorf("exec_user is not supported on %s", runtime.GOOS)
+	}
+	return nil
+}
+
+// exceededFileSize is not reported on this platform
+func exceededFileSize(state *os.ProcessState) bool {
+	return false
+}
@@ -0,0 +1,35 @@
+// Copyright (c) OpenFaaS Author(s) 2026. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+//go:build linux || darwin || freebsd
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// applyProcessAttrs sets the attributes of the process which must be given
+// before it is started. A sandboxed process switches to exec_user itself.
+func applyProcessAttrs(config *WatchdogConfig, cmd *exec.Cmd) error {
+	if cred := config.execCredential; cred != nil && !sandboxed(config) {
+		if cmd.SysProcAttr == nil {
+			cmd.SysProcAttr = &syscall.SysProcAttr{}
+		}
+		cmd.SysProcAttr.Credential = &syscall.Credential{
+			Uid:    cred.uid,
+			Gid:    cred.gid,
+			Groups: cred.groups,
+		}
+	}
+	return nil
+}
+
+// exceededFileSize is true when the process was killed for writing past
+// RLIMIT_FSIZE
+func exceededFileSize(state *os.ProcessState) bool {
+	status, ok := state.Sys().(syscall.WaitStatus)
+	return ok && status.Signaled() && status.Signal() == syscall.SIGXFSZ
+}
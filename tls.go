@@ -0,0 +1,153 @@
+// Copyright (c) OpenFaaS Author(s) 2026. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/openfaas/classic-watchdog/logging"
+)
+
+// certReloader serves a certificate and key pair from disk, reloading them
+// when either file changes so that rotated certificates i.e. from
+// cert-manager are picked up without a restart.
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	lock    sync.RWMutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+func newCertReloader(certFile string, keyFile string) (*certReloader, error) {
+	c := &certReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+	}
+
+	if err := c.reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// GetCertificate is used by tls.Config to give the current certificate
+func (c *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.cert, nil
+}
+
+// watch checks the files every interval until cancel is closed
+func (c *certReloader) watch(interval time.Duration, cancel <-chan bool) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-cancel:
+			return
+		}
+
+		c.lock.RLock()
+		loaded := c.modTime
+		c.lock.RUnlock()
+
+		if modTime, err := c.latestModTime(); err == nil && !modTime.Equal(loaded) {
+			if err := c.reload(); err != nil {
+				logging.Errorf("Unable to reload TLS certificate: %s", err.Error())
+				continue
+			}
+			logging.Infof("Reloaded TLS certificate: %s", c.certFile)
+		}
+	}
+}
+
+func (c *certReloader) reload() error {
+	modTime, err := c.latestModTime()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return err
+	}
+
+	c.lock.Lock()
+	c.cert = &cert
+	c.modTime = modTime
+	c.lock.Unlock()
+	return nil
+}
+
+// latestModTime gives the most recent modification time of the files,
+// os.Stat follows the symlinks which Kubernetes updates for secrets.
+func (c *certReloader) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, name := range []string{c.certFile, c.keyFile} {
+		info, err := os.Stat(name)
+		if err != nil {
+			return latest, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// clientAuthType gives the tls.ClientAuthType for tls_client_auth, which
+// is either "require" or "optional" when a CA bundle is configured.
+func clientAuthType(mode string) (tls.ClientAuthType, error) {
+	switch mode {
+	case "", "require":
+		return tls.RequireAndVerifyClientCert, nil
+	case "optional":
+		return tls.VerifyClientCertIfGiven, nil
+	}
+	return tls.NoClientCert, fmt.Errorf("unknown tls_client_auth: %q, use require or optional", mode)
+}
+
+// loadClientCAs reads a PEM bundle of CAs used to verify client certificates
+func loadClientCAs(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in %s", path)
+	}
+	return pool, nil
+}
+
+// getClientCertEnvs describes a verified client certificate to the function
+// using the variable names of Apache's mod_ssl i.e. SSL_CLIENT_S_DN
+func getClientCertEnvs(r *http.Request) []string {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return nil
+	}
+
+	cert := r.TLS.PeerCertificates[0]
+	return []string{
+		"SSL_CLIENT_VERIFY=SUCCESS",
+		"SSL_CLIENT_S_DN=" + cert.Subject.String(),
+		"SSL_CLIENT_S_DN_CN=" + cert.Subject.CommonName,
+		"SSL_CLIENT_I_DN=" + cert.Issuer.String(),
+		"SSL_CLIENT_M_SERIAL=" + strings.ToUpper(cert.SerialNumber.Text(16)),
+		"SSL_CLIENT_SAN_DNS=" + strings.Join(cert.DNSNames, ","),
+		"SSL_CLIENT_V_END=" + cert.NotAfter.UTC().Format(time.RFC3339),
+	}
+}
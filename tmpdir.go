@@ -0,0 +1,50 @@
+// Copyright (c) OpenFaaS Author(s) 2026. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package main
+
+import (
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/openfaas/classic-watchdog/logging"
+)
+
+// makeInvocationTempDir creates a directory for one invocation, named after
+// its call ID so that a directory kept after a failure can be found from the
+// logs
+func makeInvocationTempDir(config *WatchdogConfig, r *http.Request) (string, error) {
+	callID := strings.Map(func(c rune) rune {
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' {
+			return c
+		}
+		return -1
+	}, r.Header.Get(callIDHeader))
+
+	dir, err := os.MkdirTemp(config.execTmpdirRoot, "fprocess-"+callID+"-")
+	if err != nil {
+		return "", err
+	}
+
+	if config.execCredential != nil {
+		if err := config.execCredential.chownTree(dir); err != nil {
+			os.RemoveAll(dir)
+			return "", err
+		}
+	}
+	return dir, nil
+}
+
+// removeInvocationTempDir removes dir and anything the function left in it,
+// unless the invocation failed and exec_tmpdir_keep_failed is set
+func removeInvocationTempDir(config *WatchdogConfig, dir string, failed bool) {
+	if failed && config.execTmpdirKeepFailed {
+		logging.Infof("Keeping TMPDIR of failed invocation: %s", dir)
+		return
+	}
+
+	if err := os.RemoveAll(dir); err != nil {
+		logging.Errorf("Unable to remove TMPDIR %s: %s", dir, err.Error())
+	}
+}
@@ -0,0 +1,126 @@
+// Copyright (c) OpenFaaS Author(s) 2026. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package main
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/openfaas/classic-watchdog/tracing"
+)
+
+// traceHeaders are the W3C Trace Context and B3 propagation headers
+var traceHeaders = []string{
+	"traceparent",
+	"tracestate",
+	"b3",
+	"X-B3-TraceId",
+	"X-B3-SpanId",
+	"X-B3-ParentSpanId",
+	"X-B3-Sampled",
+	"X-B3-Flags",
+}
+
+// propagateTraceHeaders echoes any trace headers from the request in the
+// response, and returns them as environmental variables named after the
+// header in upper-case i.e. TRACEPARENT and X_B3_TRACEID. These are passed
+// regardless of cgi_headers so that functions can always join a trace.
+func propagateTraceHeaders(w http.ResponseWriter, r *http.Request) []string {
+	var envs []string
+
+	for _, name := range traceHeaders {
+		value := r.Header.Get(name)
+		if len(value) == 0 {
+			continue
+		}
+
+		w.Header().Set(name, value)
+		envs = append(envs, strings.ToUpper(strings.ReplaceAll(name, "-", "_"))+"="+value)
+	}
+
+	return envs
+}
+
+// tracer exports spans for each invocation when OpenTelemetry is enabled
+var tracer *tracing.Exporter
+
+// invocationTrace holds the span IDs for an invocation, which are created
+// before the process is forked so that the function can be handed its
+// parent span via TRACEPARENT.
+type invocationTrace struct {
+	traceID   tracing.TraceID
+	parentID  tracing.SpanID
+	requestID tracing.SpanID
+	execID    tracing.SpanID
+}
+
+// newInvocationTrace continues the trace from the request's traceparent
+// header, or starts a new trace.
+func newInvocationTrace(r *http.Request) *invocationTrace {
+	traceID, parentID, err := tracing.ParseTraceparent(r.Header.Get("traceparent"))
+	if err != nil {
+		traceID = tracing.NewTraceID()
+		parentID = tracing.SpanID{}
+	}
+
+	return &invocationTrace{
+		traceID:   traceID,
+		parentID:  parentID,
+		requestID: tracing.NewSpanID(),
+		execID:    tracing.NewSpanID(),
+	}
+}
+
+// traceparent is passed to the function so that its spans are children
+// of the exec span.
+func (t *invocationTrace) traceparent() string {
+	return "TRACEPARENT=" + tracing.FormatTraceparent(t.traceID, t.execID)
+}
+
+// record sends the spans for an invocation to the exporter, stages which
+// did not happen, i.e. the response write after a failure, are skipped.
+func (t *invocationTrace) record(exporter *tracing.Exporter, r *http.Request, process string, start time.Time, res execResult, writeStart time.Time, execErr error) {
+	end := time.Now()
+
+	spans := []tracing.Span{{
+		TraceID:      t.traceID,
+		SpanID:       t.requestID,
+		ParentSpanID: t.parentID,
+		Name:         "request",
+		Kind:         tracing.SpanKindServer,
+		Start:        start,
+		End:          end,
+		Error:        execErr != nil,
+		Attributes: map[string]string{
+			"http.request.method": r.Method,
+			"url.path":            r.URL.Path,
+			"process":             process,
+			"call_id":             r.Header.Get(callIDHeader),
+		},
+	}}
+
+	child := func(id tracing.SpanID, name string, from, to time.Time, failed bool) {
+		if from.IsZero() || to.IsZero() {
+			return
+		}
+		spans = append(spans, tracing.Span{
+			TraceID:      t.traceID,
+			SpanID:       id,
+			ParentSpanID: t.requestID,
+			Name:         name,
+			Kind:         tracing.SpanKindInternal,
+			Start:        from,
+			End:          to,
+			Error:        failed,
+		})
+	}
+
+	child(tracing.NewSpanID(), "fork", res.started, res.forked, execErr != nil && res.forked.IsZero())
+	child(t.execID, "exec", res.forked, res.exited, execErr != nil)
+	child(tracing.NewSpanID(), "stdout copy", res.firstByte, res.outputClosed, false)
+	child(tracing.NewSpanID(), "response write", writeStart, end, false)
+
+	exporter.Record(spans...)
+}
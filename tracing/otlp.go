@@ -0,0 +1,217 @@
+package tracing
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/openfaas/classic-watchdog/logging"
+)
+
+// maxBatchSize is the number of spans which triggers an early flush
+const maxBatchSize = 512
+
+// Exporter batches spans in memory and sends them to an OTLP/HTTP
+// endpoint such as http://otel-collector:4318/v1/traces
+type Exporter struct {
+	endpoint    string
+	serviceName string
+	client      *http.Client
+
+	lock  sync.Mutex
+	spans []Span
+	flush chan struct{}
+}
+
+// NewExporter creates an Exporter for the traces endpoint of a collector.
+func NewExporter(endpoint string, serviceName string) *Exporter {
+	return &Exporter{
+		endpoint:    endpoint,
+		serviceName: serviceName,
+		client:      &http.Client{Timeout: 10 * time.Second},
+		flush:       make(chan struct{}, 1),
+	}
+}
+
+// TracesEndpoint gives the URL for traces, following the OpenTelemetry
+// convention where a base endpoint has /v1/traces appended to it.
+func TracesEndpoint(baseEndpoint string, tracesEndpoint string) string {
+	if len(tracesEndpoint) > 0 {
+		return tracesEndpoint
+	}
+	if len(baseEndpoint) == 0 {
+		return ""
+	}
+	return strings.TrimSuffix(baseEndpoint, "/") + "/v1/traces"
+}
+
+// Record queues spans for export
+func (e *Exporter) Record(spans ...Span) {
+	e.lock.Lock()
+	e.spans = append(e.spans, spans...)
+	full := len(e.spans) >= maxBatchSize
+	e.lock.Unlock()
+
+	if full {
+		select {
+		case e.flush <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Run exports queued spans every interval until cancel is closed, at
+// which point any remaining spans are exported.
+func (e *Exporter) Run(interval time.Duration, cancel <-chan bool) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-e.flush:
+		case <-cancel:
+			if err := e.Flush(); err != nil {
+				logging.Errorf("Unable to export spans: %s", err.Error())
+			}
+			return
+		}
+
+		if err := e.Flush(); err != nil {
+			logging.Errorf("Unable to export spans: %s", err.Error())
+		}
+	}
+}
+
+// Flush exports all queued spans
+func (e *Exporter) Flush() error {
+	e.lock.Lock()
+	spans := e.spans
+	e.spans = nil
+	e.lock.Unlock()
+
+	if len(spans) == 0 {
+		return nil
+	}
+
+	body, err := json.Marshal(e.request(spans))
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("unexpected status from %s: %d", e.endpoint, res.StatusCode)
+	}
+	return nil
+}
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            otlpStatus      `json:"status"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpStatus struct {
+	Code int `json:"code"`
+}
+
+func (e *Exporter) request(spans []Span) otlpRequest {
+	converted := make([]otlpSpan, 0, len(spans))
+
+	for _, s := range spans {
+		span := otlpSpan{
+			TraceID:           s.TraceID.String(),
+			SpanID:            s.SpanID.String(),
+			Name:              s.Name,
+			Kind:              s.Kind,
+			StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.End.UnixNano(), 10),
+			Attributes:        attributes(s.Attributes),
+		}
+
+		if s.ParentSpanID.IsValid() {
+			span.ParentSpanID = s.ParentSpanID.String()
+		}
+
+		// Status codes: 0 unset, 2 error
+		if s.Error {
+			span.Status.Code = 2
+		}
+
+		converted = append(converted, span)
+	}
+
+	return otlpRequest{
+		ResourceSpans: []otlpResourceSpans{{
+			Resource: otlpResource{
+				Attributes: attributes(map[string]string{"service.name": e.serviceName}),
+			},
+			ScopeSpans: []otlpScopeSpans{{
+				Scope: otlpScope{Name: "github.com/openfaas/classic-watchdog"},
+				Spans: converted,
+			}},
+		}},
+	}
+}
+
+func attributes(values map[string]string) []otlpAttribute {
+	attrs := make([]otlpAttribute, 0, len(values))
+	for k, v := range values {
+		attrs = append(attrs, otlpAttribute{Key: k, Value: otlpValue{StringValue: v}})
+	}
+	return attrs
+}
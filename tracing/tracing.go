@@ -0,0 +1,107 @@
+// Package tracing records spans for invocations and exports them to an
+// OpenTelemetry collector using OTLP over HTTP with the JSON encoding.
+package tracing
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// TraceID identifies a trace
+type TraceID [16]byte
+
+// SpanID identifies a span within a trace
+type SpanID [8]byte
+
+// SpanKind values from the OTLP specification
+const (
+	SpanKindInternal = 1
+	SpanKindServer   = 2
+)
+
+// Span is a completed unit of work within a trace
+type Span struct {
+	TraceID      TraceID
+	SpanID       SpanID
+	ParentSpanID SpanID
+	Name         string
+	Kind         int
+	Start        time.Time
+	End          time.Time
+	Attributes   map[string]string
+	Error        bool
+}
+
+// NewTraceID returns a random TraceID
+func NewTraceID() TraceID {
+	var id TraceID
+	rand.Read(id[:])
+	return id
+}
+
+// NewSpanID returns a random SpanID
+func NewSpanID() SpanID {
+	var id SpanID
+	rand.Read(id[:])
+	return id
+}
+
+// String gives the ID in lower-case hex
+func (t TraceID) String() string {
+	return hex.EncodeToString(t[:])
+}
+
+// IsValid is false for the all-zero ID
+func (t TraceID) IsValid() bool {
+	return t != TraceID{}
+}
+
+// String gives the ID in lower-case hex
+func (s SpanID) String() string {
+	return hex.EncodeToString(s[:])
+}
+
+// IsValid is false for the all-zero ID
+func (s SpanID) IsValid() bool {
+	return s != SpanID{}
+}
+
+// ParseTraceparent reads the trace and parent span from a W3C traceparent
+// header, i.e. 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01
+func ParseTraceparent(value string) (TraceID, SpanID, error) {
+	var traceID TraceID
+	var spanID SpanID
+
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+		return traceID, spanID, fmt.Errorf("invalid traceparent: %q", value)
+	}
+
+	if len(parts[1]) != 32 {
+		return traceID, spanID, fmt.Errorf("invalid trace-id in traceparent: %q", value)
+	}
+	if _, err := hex.Decode(traceID[:], []byte(parts[1])); err != nil {
+		return traceID, spanID, fmt.Errorf("invalid trace-id in traceparent: %q", value)
+	}
+
+	if len(parts[2]) != 16 {
+		return traceID, spanID, fmt.Errorf("invalid parent-id in traceparent: %q", value)
+	}
+	if _, err := hex.Decode(spanID[:], []byte(parts[2])); err != nil {
+		return traceID, spanID, fmt.Errorf("invalid parent-id in traceparent: %q", value)
+	}
+
+	if !traceID.IsValid() || !spanID.IsValid() {
+		return traceID, spanID, fmt.Errorf("invalid traceparent: %q", value)
+	}
+
+	return traceID, spanID, nil
+}
+
+// FormatTraceparent gives a sampled W3C traceparent header for the span
+func FormatTraceparent(traceID TraceID, spanID SpanID) string {
+	return fmt.Sprintf("00-%s-%s-01", traceID, spanID)
+}
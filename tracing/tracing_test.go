@@ -0,0 +1,100 @@
+package tracing
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func Test_ParseTraceparent_Valid(t *testing.T) {
+	traceID, spanID, err := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := traceID.String(); got != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("trace-id want: %s, got: %s", "4bf92f3577b34da6a3ce929d0e0e4736", got)
+	}
+	if got := spanID.String(); got != "00f067aa0ba902b7" {
+		t.Errorf("parent-id want: %s, got: %s", "00f067aa0ba902b7", got)
+	}
+}
+
+func Test_ParseTraceparent_Invalid(t *testing.T) {
+	values := []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736aa-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-zzf067aa0ba902b7-01",
+	}
+
+	for _, value := range values {
+		if _, _, err := ParseTraceparent(value); err == nil {
+			t.Errorf("traceparent %q should have been rejected", value)
+		}
+	}
+}
+
+func Test_TracesEndpoint(t *testing.T) {
+	if got := TracesEndpoint("http://collector:4318/", ""); got != "http://collector:4318/v1/traces" {
+		t.Errorf("want: %s, got: %s", "http://collector:4318/v1/traces", got)
+	}
+	if got := TracesEndpoint("http://collector:4318", "http://other/traces"); got != "http://other/traces" {
+		t.Errorf("want: %s, got: %s", "http://other/traces", got)
+	}
+	if got := TracesEndpoint("", ""); got != "" {
+		t.Errorf("want empty endpoint, got: %s", got)
+	}
+}
+
+func Test_Exporter_Flush_SendsOTLPJSON(t *testing.T) {
+	var got otlpRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ct := r.Header.Get("Content-Type"); ct != "application/json" {
+			t.Errorf("Content-Type want: application/json, got: %s", ct)
+		}
+		body, _ := io.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &got); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer server.Close()
+
+	exporter := NewExporter(server.URL, "figlet")
+
+	start := time.Now()
+	exporter.Record(Span{
+		TraceID: NewTraceID(),
+		SpanID:  NewSpanID(),
+		Name:    "request",
+		Kind:    SpanKindServer,
+		Start:   start,
+		End:     start.Add(time.Second),
+		Error:   true,
+	})
+
+	if err := exporter.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got.ResourceSpans) != 1 || len(got.ResourceSpans[0].ScopeSpans) != 1 {
+		t.Fatalf("unexpected request: %+v", got)
+	}
+
+	resource := got.ResourceSpans[0].Resource
+	if len(resource.Attributes) != 1 || resource.Attributes[0].Value.StringValue != "figlet" {
+		t.Errorf("service.name should be figlet, got: %+v", resource.Attributes)
+	}
+
+	spans := got.ResourceSpans[0].ScopeSpans[0].Spans
+	if len(spans) != 1 {
+		t.Fatalf("want 1 span, got: %d", len(spans))
+	}
+	if spans[0].Name != "request" || spans[0].Status.Code != 2 || len(spans[0].ParentSpanID) != 0 {
+		t.Errorf("unexpected span: %+v", spans[0])
+	}
+}
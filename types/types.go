@@ -4,9 +4,12 @@
 package types
 
 import (
+	"encoding/base64"
 	"encoding/json"
 	"net/http"
+	"net/url"
 	"os"
+	"unicode/utf8"
 )
 
 // OsEnv implements interface to wrap os.Getenv
@@ -44,3 +47,59 @@ func MarshalRequest(data []byte, header *http.Header) ([]byte, error) {
 	res, marshalErr := json.Marshal(&req)
 	return res, marshalErr
 }
+
+// MarshalReqV2 describes the whole request. The body is text when it is
+// valid UTF-8, otherwise it is base64 encoded and IsBase64Encoded is set.
+type MarshalReqV2 struct {
+	Version         int                 `json:"version"`
+	Method          string              `json:"method"`
+	Path            string              `json:"path"`
+	Query           map[string][]string `json:"query"`
+	Header          http.Header         `json:"header"`
+	Body            string              `json:"body"`
+	IsBase64Encoded bool                `json:"isBase64Encoded"`
+}
+
+// MarshalResV2 is written by the function to set the status, headers and
+// body of the response, with the body encoded as for MarshalReqV2
+type MarshalResV2 struct {
+	StatusCode      int         `json:"statusCode"`
+	Header          http.Header `json:"header"`
+	Body            string      `json:"body"`
+	IsBase64Encoded bool        `json:"isBase64Encoded"`
+}
+
+func MarshalRequestV2(data []byte, method string, u *url.URL, header http.Header) ([]byte, error) {
+	body, isBase64 := encodeBody(data)
+	req := MarshalReqV2{
+		Version:         2,
+		Method:          method,
+		Path:            u.Path,
+		Query:           u.Query(),
+		Header:          header,
+		Body:            body,
+		IsBase64Encoded: isBase64,
+	}
+
+	return json.Marshal(&req)
+}
+
+func UnmarshalResponseV2(data []byte) (*MarshalResV2, []byte, error) {
+	response := MarshalResV2{}
+	if err := json.Unmarshal(data, &response); err != nil {
+		return nil, nil, err
+	}
+
+	if !response.IsBase64Encoded {
+		return &response, []byte(response.Body), nil
+	}
+	body, err := base64.StdEncoding.DecodeString(response.Body)
+	return &response, body, err
+}
+
+func encodeBody(data []byte) (string, bool) {
+	if utf8.Valid(data) {
+		return string(data), false
+	}
+	return base64.StdEncoding.EncodeToString(data), true
+}
@@ -0,0 +1,233 @@
+// Copyright (c) OpenFaaS Author(s) 2026. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+)
+
+// recordingEnv records each variable the configuration reads, so that any
+// other variable can be reported as unknown
+type recordingEnv struct {
+	env  HasEnv
+	read map[string]bool
+}
+
+func (e *recordingEnv) Getenv(key string) string {
+	e.read[key] = true
+	return e.env.Getenv(key)
+}
+
+// runValidate checks the configuration from env and prints the effective
+// configuration along with any problems, names are the variables set in
+// the environment, config file and flags. It returns the exit code for the
+// watchdog, 1 when there are problems.
+func runValidate(env HasEnv, names []string, out io.Writer) int {
+	recorder := &recordingEnv{env: env, read: make(map[string]bool)}
+	config := ReadConfig{}.Read(recorder)
+
+	problems := unknownVariables(names, recorder.read)
+	problems = append(problems, configConflicts(&config)...)
+
+	fmt.Fprintf(out, "Effective configuration:\n")
+	printConfig(&config, out)
+
+	if len(problems) == 0 {
+		fmt.Fprintf(out, "\nNo problems found.\n")
+		return 0
+	}
+
+	fmt.Fprintf(out, "\nProblems:\n")
+	for _, problem := range problems {
+		fmt.Fprintf(out, "- %s\n", problem)
+	}
+	return 1
+}
+
+// optionName matches the names of the watchdog's own options, which are
+// all lower-case
+var optionName = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// unknownVariables reports variables named like options which are not read
+func unknownVariables(names []string, read map[string]bool) []string {
+	var known []string
+	for k := range read {
+		known = append(known, k)
+	}
+
+	var problems []string
+	seen := make(map[string]bool)
+	for _, k := range names {
+		if read[k] || seen[k] || !optionName.MatchString(k) {
+			continue
+		}
+
+		seen[k] = true
+
+		problem := fmt.Sprintf("unknown variable: %s", k)
+		if suggestion := closestName(k, known); len(suggestion) > 0 {
+			problem += fmt.Sprintf(", did you mean %s?", suggestion)
+		}
+		problems = append(problems, problem)
+	}
+
+	sort.Strings(problems)
+	return problems
+}
+
+// closestName finds a name within an edit distance of 2
+func closestName(name string, names []string) string {
+	closest := ""
+	best := 3
+	for _, candidate := range names {
+		if d := editDistance(name, candidate); d < best || (d == best && candidate < closest) {
+			closest = candidate
+			best = d
+		}
+	}
+	return closest
+}
+
+func editDistance(a string, b string) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
+
+// configConflicts reports options which are missing or cannot be used
+// together
+func configConflicts(config *WatchdogConfig) []string {
+	var problems []string
+
+	if len(config.routesFile) > 0 {
+		routes, err := readRoutesFile(config.routesFile)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("routes_file: %s", err.Error()))
+		}
+		config.routes = append(config.routes, routes...)
+	}
+
+	if len(config.envFile) > 0 {
+		if _, err := newEnvFile(config.envFile).Envs(); err != nil {
+			problems = append(problems, fmt.Sprintf("env_file: %s", err.Error()))
+		}
+	}
+
+	if len(config.faasProcess) == 0 && len(config.routes) == 0 {
+		problems = append(problems, "fprocess or routes must be set")
+	} else if err := validateProcesses(config); err != nil {
+		problems = append(problems, err.Error())
+	}
+
+	if len(config.execUser) > 0 {
+		if _, err := lookupCredential(config.execUser, config.execGroup); err != nil {
+			problems = append(problems, err.Error())
+		}
+	} else if len(config.execGroup) > 0 {
+		problems = append(problems, "exec_group needs exec_user")
+	}
+
+	if err := validateSandbox(config); err != nil {
+		problems = append(problems, err.Error())
+	}
+
+	if config.execTimeout > 0 && config.writeTimeout > 0 && config.execTimeout > config.writeTimeout {
+		problems = append(problems, fmt.Sprintf("exec_timeout (%s) is longer than write_timeout (%s), the response would be cut off", config.execTimeout, config.writeTimeout))
+	}
+	if (len(config.tlsCert) > 0) != (len(config.tlsKey) > 0) {
+		problems = append(problems, "tls_cert and tls_key must be set together")
+	}
+	if len(config.tlsClientCA) > 0 && len(config.tlsCert) == 0 {
+		problems = append(problems, "tls_client_ca needs tls_cert and tls_key")
+	}
+	if config.h2c && len(config.tlsCert) > 0 {
+		problems = append(problems, "h2c cannot be used with tls_cert, HTTP/2 is negotiated over TLS")
+	}
+	if config.jwtAuthentication && len(config.jwtIssuer) > 0 {
+		problems = append(problems, "jwt_auth and jwt_issuer cannot be used together")
+	}
+	if config.maxInflightQueue > 0 && config.maxInflight == 0 {
+		problems = append(problems, "max_inflight_queue needs max_inflight")
+	}
+	if len(config.lockFilePath) > 0 && !config.suppressLock {
+		if info, err := os.Stat(filepath.Dir(config.lockFilePath)); err != nil || !info.IsDir() {
+			problems = append(problems, fmt.Sprintf("lock_file_path: %s is not a directory", filepath.Dir(config.lockFilePath)))
+		}
+	}
+	if len(config.priorityHeader) > 0 && config.maxInflightQueue == 0 {
+		problems = append(problems, "priority_header needs max_inflight_queue")
+	}
+	if config.bodyViaFile && (config.marshalRequest || config.marshalRequestV2 || config.multipartForm) {
+		problems = append(problems, "body_via_file cannot be used with marshal_request or multipart_form, which read the body")
+	}
+	if config.responseFromFile && config.sseOutput {
+		problems = append(problems, "response_from_file cannot be used with sse_output, which streams stdout")
+	}
+	if len(config.secretEnvAllow) > 0 && !config.secretEnv {
+		problems = append(problems, "secret_env_allow needs secret_env")
+	}
+	if len(config.natsURL) > 0 && (len(config.natsStream) == 0 || len(config.natsConsumer) == 0) {
+		problems = append(problems, "nats_url needs nats_stream and nats_consumer")
+	}
+	if len(config.cronSchedule) > 0 {
+		if _, err := parseCronSchedule(config.cronSchedule); err != nil {
+			problems = append(problems, fmt.Sprintf("cron_schedule: %s", err.Error()))
+		}
+	}
+	if isContentTypeMapping(config.contentType) {
+		if _, err := parseContentTypeMapping(config.contentType); err != nil {
+			problems = append(problems, fmt.Sprintf("content_type: %s", err.Error()))
+		}
+	}
+	if len(config.kafkaBrokers) > 0 && (len(config.kafkaTopic) == 0 || len(config.kafkaGroup) == 0) {
+		problems = append(problems, "kafka_brokers needs kafka_topic and kafka_group")
+	}
+	if len(config.kafkaBrokers) > 0 {
+		if _, err := kafkaClientOpts(config); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	if config.asyncCallback && len(config.asyncCallbackAllow) == 0 {
+		problems = append(problems, "async_callback needs async_callback_allow, or \"*\" for any public host")
+	}
+	if config.metricsEnabled && config.metricsPort == config.port {
+		problems = append(problems, fmt.Sprintf("metrics_port and port are both %d", config.port))
+	}
+	if !config.listenTCP && len(config.listenSocket) == 0 && config.listenFDs == 0 {
+		problems = append(problems, "listen_tcp is false without listen_socket or socket activation, nothing would be listening")
+	}
+	if config.shedMemoryPercent > 100 || config.shedCPUPercent > 100 {
+		problems = append(problems, "shed_memory_percent and shed_cpu_percent cannot be over 100")
+	}
+
+	return problems
+}
+
+// printConfig writes each field of the configuration, with credentials
+// hidden
+func printConfig(config *WatchdogConfig, out io.Writer) {
+	for _, field := range configFields(config) {
+		fmt.Fprintf(out, "  %s: %v\n", field.name, field.value)
+	}
+}
@@ -0,0 +1,65 @@
+// Copyright (c) OpenFaaS Author(s) 2026. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/openfaas/classic-watchdog/logging"
+)
+
+// warmup runs the function once with a POST to path before traffic is
+// accepted, so that the first real request does not pay for imports or
+// JIT compilation. A non-2xx response is an error.
+func warmup(handler http.Handler, path string, body string) error {
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+
+	req, err := http.NewRequest(http.MethodPost, "http://127.0.0.1"+path, strings.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.RemoteAddr = "127.0.0.1:0"
+
+	start := time.Now()
+	w := &responseBuffer{header: http.Header{}}
+	handler.ServeHTTP(w, req)
+
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if w.status < 200 || w.status > 299 {
+		return fmt.Errorf("warm-up request to %s gave status %d: %s", path, w.status, strings.TrimSpace(w.body.String()))
+	}
+
+	logging.Infof("Warm-up request to %s took %s", path, time.Since(start).Round(time.Millisecond))
+	return nil
+}
+
+// responseBuffer keeps a response for requests made by the watchdog itself
+type responseBuffer struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (w *responseBuffer) Header() http.Header {
+	return w.header
+}
+
+func (w *responseBuffer) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *responseBuffer) Write(p []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	return w.body.Write(p)
+}
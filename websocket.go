@@ -0,0 +1,386 @@
+// Copyright (c) OpenFaaS Author(s) 2026. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/openfaas/classic-watchdog/logging"
+)
+
+// websocketGUID is appended to the client's key for Sec-WebSocket-Accept
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// websocketMaxMessageBytes limits a frame from a client when
+// max_request_bytes is not set
+const websocketMaxMessageBytes = 1 << 20
+
+// WebSocket opcodes and close codes from RFC 6455
+const (
+	websocketContinuation = 0x0
+	websocketText         = 0x1
+	websocketBinary       = 0x2
+	websocketClose        = 0x8
+	websocketPing         = 0x9
+	websocketPong         = 0xA
+
+	websocketCloseNormal   = 1000
+	websocketCloseProtocol = 1002
+	websocketCloseTooBig   = 1009
+	websocketCloseError    = 1011
+)
+
+var errWebSocketProtocol = errors.New("websocket protocol error")
+
+// isWebSocketUpgrade reports whether the request asks to upgrade to a
+// WebSocket
+func isWebSocketUpgrade(r *http.Request) bool {
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		return false
+	}
+	for _, value := range r.Header.Values("Connection") {
+		for _, token := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// serveWebSocket runs fprocess for the lifetime of a WebSocket, where each
+// message received is written to stdin followed by a newline and each line
+// of stdout is sent as a message. Lines which are not valid UTF-8 are sent as binary messages. A
+// close from the client closes stdin, and the socket is closed with the
+// exit status once the process exits.
+func serveWebSocket(config *WatchdogConfig, w http.ResponseWriter, r *http.Request) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if r.Method != http.MethodGet || len(key) == 0 || r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "Invalid WebSocket handshake\n")
+		return
+	}
+
+	if !websocketOriginAllowed(config, r) {
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprintf(w, "Origin not allowed: %s\n", r.Header.Get("Origin"))
+		return
+	}
+
+	process := resolveProcess(config, r.URL.Path)
+	if len(process) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "No process configured for path: %s\n", r.URL.Path)
+		return
+	}
+
+	cmd, release, err := websocketCommand(config, w, r, process)
+	if err != nil {
+		logging.Errorf("Unable to start fprocess: %s", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	failed := true
+	defer func() {
+		release(failed)
+	}()
+
+	stdin, _ := cmd.StdinPipe()
+	stdout, _ := cmd.StdoutPipe()
+	stderr := &stderrLineWriter{prefix: stderrPrefix(config, r), max: config.maxLogBytes}
+	cmd.Stderr = stderr
+
+	if err := cmd.Start(); err != nil {
+		logging.Errorf("Unable to start fprocess: %s", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	conn, rw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		logging.Errorf("Unable to upgrade to a WebSocket: %s", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	defer conn.Close()
+
+	// The server's read and write timeouts would end a long-lived session
+	conn.SetDeadline(time.Time{})
+
+	logging.Infof("Forking fprocess for WebSocket.")
+	startTime := time.Now()
+
+	sum := sha1.Sum([]byte(key + websocketGUID))
+	fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n",
+		base64.StdEncoding.EncodeToString(sum[:]))
+	if err := rw.Flush(); err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return
+	}
+
+	ws := &websocketConn{w: rw.Writer}
+
+	var timer *time.Timer
+	if config.execTimeout > 0 {
+		timer = time.AfterFunc(config.execTimeout, func() {
+			logging.Warnf("Killing process: %s", process)
+			cmd.Process.Kill()
+		})
+	}
+
+	maxMessage := int64(websocketMaxMessageBytes)
+	if config.maxRequestBytes > 0 {
+		maxMessage = config.maxRequestBytes
+	}
+
+	// The process is killed when the client goes away without a close
+	go func() {
+		closeCode, readErr := ws.copyMessages(stdin, rw.Reader, maxMessage)
+		stdin.Close()
+		if readErr != nil {
+			ws.close(closeCode, "")
+			cmd.Process.Kill()
+		}
+	}()
+
+	out := bufio.NewReader(stdout)
+	for {
+		line, readErr := out.ReadBytes('\n')
+		if len(line) > 0 {
+			line = bytes.TrimSuffix(line, []byte("\n"))
+			opcode := byte(websocketText)
+			if !utf8.Valid(line) {
+				opcode = websocketBinary
+			}
+			if err := ws.writeFrame(opcode, line); err != nil {
+				cmd.Process.Kill()
+				break
+			}
+		}
+		if readErr != nil {
+			break
+		}
+	}
+
+	err = cmd.Wait()
+	if timer != nil {
+		timer.Stop()
+	}
+	stderr.Flush()
+
+	failed = err != nil
+	if err != nil {
+		ws.close(websocketCloseError, err.Error())
+	} else {
+		ws.close(websocketCloseNormal, "")
+	}
+	logging.Infof("WebSocket closed - Duration: %fs - %s: %s", time.Since(startTime).Seconds(), callIDHeader, r.Header.Get(callIDHeader))
+}
+
+// websocketCommand prepares fprocess with the same settings as an HTTP
+// invocation, the release func must be called once it has exited
+func websocketCommand(config *WatchdogConfig, w http.ResponseWriter, r *http.Request, process string) (*exec.Cmd, func(failed bool), error) {
+	cmd, releaseCgroup, err := newProcessCommand(config, process, false)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	envs := getAdditionalEnvs(config, r, r.Method)
+	if config.secretEnv {
+		secretEnvs, err := readSecretEnvs(config.secretMountPath, config.secretEnvAllow)
+		if err != nil {
+			releaseCgroup()
+			return nil, nil, fmt.Errorf("unable to read secrets: %w", err)
+		}
+		envs = appendEnvs(envs, secretEnvs)
+	}
+
+	var tmpDir string
+	if config.execTmpdir {
+		if tmpDir, err = makeInvocationTempDir(config, r); err != nil {
+			releaseCgroup()
+			return nil, nil, fmt.Errorf("unable to create TMPDIR: %w", err)
+		}
+		envs = appendEnvs(envs, []string{"TMPDIR=" + tmpDir})
+	}
+
+	envs = appendEnvs(envs, invocationEnvs(w, r, nil))
+	if len(envs) > 0 {
+		cmd.Env = envs
+	}
+
+	release := func(failed bool) {
+		releaseCgroup()
+		if len(tmpDir) > 0 {
+			removeInvocationTempDir(config, tmpDir, failed)
+		}
+	}
+	return cmd, release, nil
+}
+
+// websocketOriginAllowed rejects browsers on other sites, which could open
+// a socket with the user's cookies, unless their origin is in
+// cors_allow_origins. Clients other than browsers do not send an Origin.
+func websocketOriginAllowed(config *WatchdogConfig, r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if len(origin) == 0 {
+		return true
+	}
+	if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	_, ok := corsAllowOrigin(config.corsAllowOrigins, origin)
+	return ok
+}
+
+// websocketConn writes server frames, which may come from the reader of
+// stdout and from replies to pings and closes at the same time
+type websocketConn struct {
+	w *bufio.Writer
+
+	lock   sync.Mutex
+	closed bool
+}
+
+func (c *websocketConn) writeFrame(opcode byte, payload []byte) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.closed {
+		return io.ErrClosedPipe
+	}
+	if opcode == websocketClose {
+		c.closed = true
+	}
+
+	header := []byte{0x80 | opcode}
+	switch {
+	case len(payload) < 126:
+		header = append(header, byte(len(payload)))
+	case len(payload) <= 0xFFFF:
+		header = append(header, 126)
+		header = binary.BigEndian.AppendUint16(header, uint16(len(payload)))
+	default:
+		header = append(header, 127)
+		header = binary.BigEndian.AppendUint64(header, uint64(len(payload)))
+	}
+
+	c.w.Write(header)
+	c.w.Write(payload)
+	return c.w.Flush()
+}
+
+// close sends a close frame, a reason is cut to fit a control frame
+func (c *websocketConn) close(code int, reason string) {
+	if len(reason) > 123 {
+		reason = reason[:123]
+	}
+	payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+	c.writeFrame(websocketClose, append(payload, reason...))
+}
+
+// copyMessages writes the payload of data frames to dst until the client
+// sends a close, and answers pings. Each message ends with a newline, so
+// that a function which reads lines sees one line per message. On a
+// failure it gives the close code to send.
+func (c *websocketConn) copyMessages(dst io.Writer, src *bufio.Reader, maxMessage int64) (int, error) {
+	for {
+		opcode, fin, payload, err := readWebSocketFrame(src, maxMessage)
+		if err != nil {
+			var maxErr *http.MaxBytesError
+			switch {
+			case errors.As(err, &maxErr):
+				return websocketCloseTooBig, err
+			case errors.Is(err, errWebSocketProtocol):
+				return websocketCloseProtocol, err
+			}
+			return websocketCloseError, err
+		}
+
+		switch opcode {
+		case websocketText, websocketBinary, websocketContinuation:
+			// Writes fail once the process has exited, later messages are
+			// dropped until the socket is closed
+			if fin {
+				payload = append(payload, '\n')
+			}
+			dst.Write(payload)
+		case websocketPing:
+			c.writeFrame(websocketPong, payload)
+		case websocketPong:
+		case websocketClose:
+			return websocketCloseNormal, nil
+		default:
+			return websocketCloseProtocol, errWebSocketProtocol
+		}
+	}
+}
+
+// readWebSocketFrame reads one frame from a client, which must be masked,
+// and whether it is the last frame of its message
+func readWebSocketFrame(r *bufio.Reader, maxMessage int64) (byte, bool, []byte, error) {
+	var head [2]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return 0, false, nil, err
+	}
+
+	opcode := head[0] & 0x0F
+	if head[0]&0x70 != 0 || head[1]&0x80 == 0 {
+		return 0, false, nil, errWebSocketProtocol
+	}
+
+	size := uint64(head[1] & 0x7F)
+	switch size {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return 0, false, nil, err
+		}
+		size = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return 0, false, nil, err
+		}
+		size = binary.BigEndian.Uint64(ext[:])
+	}
+	if opcode >= websocketClose && (size > 125 || head[0]&0x80 == 0) {
+		return 0, false, nil, errWebSocketProtocol
+	}
+	if size > uint64(maxMessage) {
+		return 0, false, nil, &http.MaxBytesError{Limit: maxMessage}
+	}
+
+	var mask [4]byte
+	if _, err := io.ReadFull(r, mask[:]); err != nil {
+		return 0, false, nil, err
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, false, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return opcode, head[0]&0x80 != 0, payload, nil
+}